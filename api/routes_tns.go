@@ -3,10 +3,12 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/RTradeLtd/Temporal/eh"
 
 	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/tns"
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,7 +18,7 @@ func (api *API) performZoneRequest(c *gin.Context) {
 	if len(forms) == 0 {
 		return
 	}
-	zone, err := api.zm.FindZoneByNameAndUser(forms["zone_name"], forms["user_name"])
+	zone, err := api.zm.FindZoneByNameAndUser(tns.NormalizeName(forms["zone_name"]), forms["user_name"])
 	if err != nil {
 		api.LogError(err, eh.ZoneSearchError)(c, http.StatusBadRequest)
 		return
@@ -30,7 +32,9 @@ func (api *API) performRecordRequest(c *gin.Context) {
 	if len(forms) == 0 {
 		return
 	}
-	record, err := api.rm.FindRecordByNameAndUser(forms["user_name"], forms["record_name"])
+	// records are stored by name and type, with the type being optional
+	key := tns.RecordKey(tns.NormalizeName(forms["record_name"]), c.PostForm("record_type"))
+	record, err := api.rm.FindRecordByNameAndUser(forms["user_name"], key)
 	if err != nil {
 		api.LogError(err, eh.RecordSearchError)(c, http.StatusBadRequest)
 		return
@@ -59,12 +63,30 @@ func (api *API) addRecordToZone(c *gin.Context) {
 			return
 		}
 	}
+	// the record type, value and ttl are optional, with the ttl given as a duration string
+	var ttl time.Duration
+	if ttlForm, exists := c.GetPostForm("ttl"); exists {
+		var err error
+		if ttl, err = time.ParseDuration(ttlForm); err != nil {
+			Fail(c, err, http.StatusBadRequest)
+			return
+		}
+	}
+	// the zone name is normalized, as names are case insensitive, while the record
+	// name is normalized by the consumer, which keeps its case for display
 	req := queue.RecordCreation{
-		ZoneName:      forms["zone_name"],
+		ZoneName:      tns.NormalizeName(forms["zone_name"]),
 		RecordName:    forms["record_name"],
 		RecordKeyName: forms["record_key_name"],
 		UserName:      username,
 		MetaData:      intf,
+		RecordType:    c.PostForm("record_type"),
+		Value:         c.PostForm("value"),
+		TTL:           queue.Duration(ttl),
+	}
+	if err := req.Validate(); err != nil {
+		Fail(c, err, http.StatusBadRequest)
+		return
 	}
 	mqURL := api.cfg.RabbitMQ.URL
 	qm, err := queue.Initialize(queue.RecordCreationQueue, mqURL, true, false)
@@ -86,6 +108,13 @@ func (api *API) CreateZone(c *gin.Context) {
 	if len(forms) == 0 {
 		return
 	}
+	// zones act as dns zones, so their names must be valid dns names, which like
+	// dns names are case insensitive
+	forms["zone_name"] = tns.NormalizeName(forms["zone_name"])
+	if err := queue.ValidateZoneName(forms["zone_name"]); err != nil {
+		Fail(c, err, http.StatusBadRequest)
+		return
+	}
 	valid, err := api.um.CheckIfKeyOwnedByUser(username, forms["zone_mananger_key_name"])
 	if err != nil {
 		api.LogError(err, eh.KeySearchError)(c, http.StatusBadRequest)
@@ -292,9 +292,13 @@ var commands = map[string]cmd.Cmd{
 		Blurb:       "run database migrations",
 		Description: "Runs our initial database migrations, creating missing tables, etc..",
 		Action: func(cfg config.TemporalConfig, args map[string]string) {
-			if _, err := database.Initialize(&cfg, database.Options{
+			dbm, err := database.Initialize(&cfg, database.Options{
 				RunMigrations: true,
-			}); err != nil {
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err = queue.MigrateUploads(dbm.DB); err != nil {
 				log.Fatal(err)
 			}
 		},
@@ -304,10 +308,14 @@ var commands = map[string]cmd.Cmd{
 		Blurb:       "run database migrations without SSL",
 		Description: "Runs our initial database migrations, creating missing tables, etc.. without SSL",
 		Action: func(cfg config.TemporalConfig, args map[string]string) {
-			if _, err := database.Initialize(&cfg, database.Options{
+			dbm, err := database.Initialize(&cfg, database.Options{
 				RunMigrations:  true,
 				SSLModeDisable: true,
-			}); err != nil {
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err = queue.MigrateUploads(dbm.DB); err != nil {
 				log.Fatal(err)
 			}
 		},
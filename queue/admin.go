@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultAdminNotifyInterval is the minimum time between admin notifications with
+// the same subject, so that a flapping dependency doesn't flood the admin's inbox
+const DefaultAdminNotifyInterval = 15 * time.Minute
+
+// AdminFailureThreshold is the number of consecutive messages a consumer fails to
+// process before the admin is notified
+const AdminFailureThreshold = 10
+
+// adminLimiter is used to rate limit admin notifications by subject, and to count
+// a consumer's consecutive failures. Its zero value is ready to use
+type adminLimiter struct {
+	mu sync.Mutex
+	// last is when a notification with each subject was last sent
+	last map[string]time.Time
+	// suppressed is the number of notifications with each subject skipped since
+	// the last was sent
+	suppressed map[string]int
+	failures   int
+}
+
+// allow is used to check whether a notification with subject may be sent, returning
+// the number of notifications with it which were suppressed in the meantime
+func (l *adminLimiter) allow(subject string, interval time.Duration) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last == nil {
+		l.last = make(map[string]time.Time)
+		l.suppressed = make(map[string]int)
+	}
+	if last, ok := l.last[subject]; ok && time.Since(last) < interval {
+		l.suppressed[subject]++
+		return false, 0
+	}
+	suppressed := l.suppressed[subject]
+	l.last[subject] = time.Now()
+	delete(l.suppressed, subject)
+	return true, suppressed
+}
+
+// failed is used to record the outcome of processing a message, reporting whether
+// the consumer has just reached another AdminFailureThreshold consecutive failures
+func (l *adminLimiter) failed(failed bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !failed {
+		l.failures = 0
+		return false
+	}
+	l.failures++
+	return l.failures%AdminFailureThreshold == 0
+}
+
+// NotifyAdmin is used to email AdminEmail about a critical error. Notifications are
+// rate limited by subject to one every AdminNotifyInterval, with those skipped being
+// counted in the next to be sent
+func (qm *Manager) NotifyAdmin(subject, content string) error {
+	return qm.notifyAdmin(context.Background(), subject, content)
+}
+
+// notifyAdmin is used to notify the admin as part of processing a message, carrying
+// on its correlation id and trace
+func (qm *Manager) notifyAdmin(ctx context.Context, subject, content string) error {
+	ok, suppressed := qm.admin.allow(subject, qm.adminNotifyInterval())
+	if !ok {
+		qm.LogEntry(ctx).WithField("subject", subject).Info("suppressing admin notification")
+		return nil
+	}
+	if suppressed > 0 {
+		content += fmt.Sprintf("\n\n%v similar notifications were suppressed since the last", suppressed)
+	}
+	email := EmailSend{
+		Subject:     subject,
+		Content:     content,
+		ContentType: "text/plain",
+		Emails:      []string{AdminEmail},
+	}
+	if err := qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); err != nil {
+		qm.logError(ctx, err, "failed to publish admin notification")
+		return err
+	}
+	return nil
+}
+
+// adminNotifyInterval is used to get the minimum time between admin notifications
+// with the same subject, which defaults to DefaultAdminNotifyInterval
+func (qm *Manager) adminNotifyInterval() time.Duration {
+	if qm.AdminNotifyInterval <= 0 {
+		return DefaultAdminNotifyInterval
+	}
+	return qm.AdminNotifyInterval
+}
+
+// notifyIPFSFailure is used to notify the admin that a consumer failed to connect
+// to ipfs
+func (qm *Manager) notifyIPFSFailure(err error) {
+	qm.NotifyAdmin(IpfsInitializationFailedSubject, fmt.Sprintf(
+		"Consumer of queue %s of service %s failed to connect to ipfs: %s",
+		qm.QueueName, qm.Service, err,
+	))
+}
@@ -0,0 +1,101 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// newAdminBroker is used to create a broker with an email queue to receive admin
+// notifications
+func newAdminBroker(t *testing.T) *queue.MemoryBroker {
+	t.Helper()
+	broker := queue.NewMemoryBroker()
+	if err := broker.DeclareQueue(queue.EmailSendQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return broker
+}
+
+func TestNotifyAdmin(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithAdminNotifyInterval(50*time.Millisecond))
+	for i := 0; i < 3; i++ {
+		if err := qm.NotifyAdmin(queue.IpfsInitializationFailedSubject, "connection refused"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// notifications with other subjects are limited separately
+	if err := qm.NotifyAdmin(queue.ConnectionLostSubject, "connection reset"); err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.Len(queue.EmailSendQueue); n != 2 {
+		t.Fatalf("expected 2 notifications, got %v", n)
+	}
+	d, _ := broker.Get(queue.EmailSendQueue)
+	email, err := queue.DecodeDelivery[queue.EmailSend](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(email.Emails) != 1 || email.Emails[0] != queue.AdminEmail || email.Content != "connection refused" {
+		t.Fatalf("unexpected email %+v", email)
+	}
+	broker.Get(queue.EmailSendQueue)
+	// once the interval has passed the next notification is sent, counting those
+	// suppressed in the meantime
+	time.Sleep(50 * time.Millisecond)
+	if err = qm.NotifyAdmin(queue.IpfsInitializationFailedSubject, "connection refused"); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := broker.Get(queue.EmailSendQueue)
+	if !ok {
+		t.Fatal("expected a notification once the interval passed")
+	}
+	if email, err = queue.DecodeDelivery[queue.EmailSend](d); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(email.Content, "2 similar notifications were suppressed") {
+		t.Fatalf("expected suppressed notifications to be counted, got %s", email.Content)
+	}
+}
+
+func TestNotifyAdmin_RepeatedFailures(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	total := queue.AdminFailureThreshold + 1
+	for i := 0; i < total; i++ {
+		if err := qm.PublishMessageContext(ctx, testPin("alice")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var handled int
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		if handled++; handled == total {
+			cancel()
+		}
+		return errors.New("ipfs unavailable")
+	})
+	d, ok := broker.Get(queue.EmailSendQueue)
+	if !ok {
+		t.Fatal("expected the admin to be notified of the repeated failures")
+	}
+	email, err := queue.DecodeDelivery[queue.EmailSend](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != queue.HandlerFailingSubject || !strings.Contains(email.Content, "ipfs unavailable") {
+		t.Fatalf("unexpected email %+v", email)
+	}
+	if broker.Len(queue.EmailSendQueue) != 0 {
+		t.Fatal("expected a single notification")
+	}
+}
@@ -0,0 +1,169 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// AuditQueue is the name of both the topic exchange managers with auditing enabled
+// copy the messages they publish to, routed by the queue they were published to,
+// and the queue AuditLog consumes them from
+const AuditQueue = "audit-log"
+
+// DefaultAuditLogPath is the file audit records are appended to when no sink is
+// configured
+const DefaultAuditLogPath = "audit.jsonl"
+
+// DefaultAuditedQueues are the queues whose messages are audited when no queues are
+// configured, being those of credit bearing actions
+var DefaultAuditedQueues = DefaultBilledQueues
+
+// AuditRecord is the record of a message kept by AuditLog
+type AuditRecord struct {
+	// Queue is the queue the message was published to
+	Queue string `json:"queue"`
+	// Timestamp is when the message was published
+	Timestamp     time.Time `json:"timestamp"`
+	UserName      string    `json:"user_name,omitempty"`
+	CreditCost    float64   `json:"credit_cost,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Type          string    `json:"type,omitempty"`
+	// Message is the message as published, decoded according to its queue's message
+	// type, with Body holding the raw body of messages which couldn't be decoded
+	Message interface{} `json:"message,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// AuditSink is used to store audit records. Records must only ever be appended, and
+// a record must be durably stored once Append returns without error, as its message
+// is then acknowledged. Implementations must be safe for concurrent use.
+type AuditSink interface {
+	Append(ctx context.Context, record AuditRecord) error
+}
+
+// FileAuditSink is an AuditSink appending records to a file as json lines
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditSink is used to open a file to append audit records to, creating it
+// if it doesn't exist
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+// Append is used to append a record to the file as a line of json, syncing the file
+// so that the record survives a crash
+func (s *FileAuditSink) Append(ctx context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close is used to close the file
+func (s *FileAuditSink) Close() error {
+	return s.file.Close()
+}
+
+// AuditOpts is used to control what AuditLog records and where
+type AuditOpts struct {
+	// Queues are the queues whose messages are audited, defaulting to
+	// DefaultAuditedQueues
+	Queues []string
+	// Sink is where records are appended, defaulting to a FileAuditSink appending
+	// to Path
+	Sink AuditSink
+	// Path is the file records are appended to when no sink is given, defaulting
+	// to DefaultAuditLogPath
+	Path string
+}
+
+// AuditLog is used to keep an append only record of credit bearing actions. It
+// consumes the copies of messages published by managers with auditing enabled (see
+// WithAudit) from the manager's queue, which should be AuditQueue, appending a
+// record of each message of the audited queues to the sink. As it only sees
+// copies, it runs alongside the queues' consumers without affecting how their
+// messages are processed. Copies are only acknowledged once their record has been
+// appended, with those the sink fails to append being retried later, so that no
+// record is lost. It runs until ctx is cancelled, returning ctx.Err().
+func (qm *Manager) AuditLog(ctx context.Context, opts AuditOpts) error {
+	if len(opts.Queues) == 0 {
+		opts.Queues = DefaultAuditedQueues
+	}
+	if opts.Sink == nil {
+		if opts.Path == "" {
+			opts.Path = DefaultAuditLogPath
+		}
+		sink, err := NewFileAuditSink(opts.Path)
+		if err != nil {
+			return err
+		}
+		defer sink.Close()
+		opts.Sink = sink
+	}
+	if qm.Broker == nil {
+		if err := qm.bindCopies(AuditQueue, opts.Queues); err != nil {
+			return err
+		}
+	}
+	audited := make(map[string]bool)
+	for _, name := range opts.Queues {
+		audited[name] = true
+	}
+	return qm.ConsumeMessageContext(ctx, "", func(ctx context.Context, d amqp.Delivery) error {
+		if !audited[copiedFrom(d)] {
+			return nil
+		}
+		if err := opts.Sink.Append(ctx, auditRecord(ctx, d)); err != nil {
+			qm.logError(ctx, err, "failed to append audit record")
+			return RetryLater(err)
+		}
+		return nil
+	})
+}
+
+// copiedFrom is used to get the queue a copy of a message was published to, which
+// copies postponed to be retried later keep in their headers
+func copiedFrom(d amqp.Delivery) string {
+	if routingKey, ok := d.Headers[HeaderOriginalRoutingKey].(string); ok {
+		return routingKey
+	}
+	return d.RoutingKey
+}
+
+// auditRecord is used to get the record of a copy of a message
+func auditRecord(ctx context.Context, d amqp.Delivery) AuditRecord {
+	record := AuditRecord{
+		Queue:         copiedFrom(d),
+		Timestamp:     d.Timestamp,
+		CorrelationID: CorrelationID(ctx),
+		Type:          d.Type,
+	}
+	var msg chargedMessage
+	if err := peek(d, &msg); err == nil {
+		record.UserName, record.CreditCost = msg.UserName, msg.CreditCost
+	}
+	if inspected := inspect(copiedFrom(d), d); inspected.Message != nil {
+		record.Message = inspected.Message
+	} else {
+		record.Body = inspected.Body
+	}
+	return record
+}
@@ -0,0 +1,130 @@
+package queue_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestAuditLog(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.AuditQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	pins := newMemoryManager(t, broker, queue.WithAudit())
+	keys := newMemoryManager(t, broker, queue.WithQueue(queue.IpfsKeyCreationQueue), queue.WithAudit())
+	auditor := newMemoryManager(t, broker, queue.WithQueue(queue.AuditQueue), queue.WithRetryLaterDelay(10*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pin := testPin("alice")
+	pin.CreditCost = 2
+	if err := pins.PublishMessageContext(queue.WithCorrelationID(ctx, "correlation"), pin); err != nil {
+		t.Fatal(err)
+	}
+	// messages of queues which aren't audited are ignored
+	if err := keys.PublishMessageContext(ctx, queue.IPFSKeyCreation{
+		UserName: "carol", Name: "key", Type: queue.KeyTypeED25519, NetworkName: "public", CreditCost: 10,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	done := make(chan error, 1)
+	go func() {
+		done <- auditor.AuditLog(ctx, queue.AuditOpts{Queues: []string{queue.IpfsPinQueue}, Path: path})
+	}()
+	for broker.Len(queue.AuditQueue)+broker.Unacked() != 0 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	// auditing doesn't take messages from their queues
+	if broker.Len(queue.IpfsPinQueue) != 1 {
+		t.Fatal("expected the pin to remain on its queue")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var records []queue.AuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record queue.AuditRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %v", len(records))
+	}
+	record := records[0]
+	if record.Queue != queue.IpfsPinQueue || record.UserName != "alice" || record.CreditCost != 2 ||
+		record.CorrelationID != "correlation" || record.Timestamp.IsZero() || record.Message == nil {
+		t.Fatalf("unexpected record %+v", record)
+	}
+}
+
+// failingSink fails to append its first record
+type failingSink struct {
+	mu      sync.Mutex
+	failed  bool
+	records []queue.AuditRecord
+}
+
+func (s *failingSink) Append(ctx context.Context, record queue.AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.failed {
+		s.failed = true
+		return errors.New("sink unavailable")
+	}
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestAuditLog_SinkFailure(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.AuditQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	pins := newMemoryManager(t, broker, queue.WithAudit())
+	auditor := newMemoryManager(t, broker, queue.WithQueue(queue.AuditQueue), queue.WithRetryLaterDelay(10*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := pins.PublishMessageContext(ctx, testPin("alice")); err != nil {
+		t.Fatal(err)
+	}
+	sink := &failingSink{}
+	done := make(chan error, 1)
+	go func() {
+		done <- auditor.AuditLog(ctx, queue.AuditOpts{Sink: sink})
+	}()
+	// the record the sink failed to append is retried rather than lost
+	for ctx.Err() == nil {
+		sink.mu.Lock()
+		n := len(sink.records)
+		sink.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if len(sink.records) != 1 || sink.records[0].UserName != "alice" {
+		t.Fatalf("unexpected records %+v", sink.records)
+	}
+}
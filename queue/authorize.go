@@ -0,0 +1,77 @@
+package queue
+
+import (
+	"context"
+	"errors"
+
+	"github.com/streadway/amqp"
+)
+
+// PublicNetwork is the name of the public ipfs network, which every user may use
+const PublicNetwork = "public"
+
+// ErrNetworkUnauthorized is the reason messages are refused when their user isn't
+// authorized to use their network
+var ErrNetworkUnauthorized = errors.New("user is not authorized to use network")
+
+// NetworkAuthorizer is used to check whether a user may use an ipfs network
+type NetworkAuthorizer interface {
+	Authorize(ctx context.Context, userName, networkName string) (bool, error)
+}
+
+// NetworkAllowlist is a NetworkAuthorizer which allows every user to use the public
+// network, and maps private networks to the users allowed to use them. Its zero
+// value only allows the public network.
+type NetworkAllowlist map[string][]string
+
+// Authorize is used to check whether the user may use the network
+func (a NetworkAllowlist) Authorize(ctx context.Context, userName, networkName string) (bool, error) {
+	if networkName == PublicNetwork {
+		return true, nil
+	}
+	for _, user := range a[networkName] {
+		if user == userName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// authorize is used to check a delivery's user may use its network, returning false
+// if the delivery was settled because they may not, or we couldn't tell. refused
+// messages are rejected and the user notified, while messages we couldn't check
+// are requeued. messages which don't name a network are always authorized
+func (qm *Manager) authorize(ctx context.Context, d amqp.Delivery) bool {
+	if qm.Authorizer == nil {
+		return true
+	}
+	var msg struct {
+		UserName    string `json:"user_name"`
+		NetworkName string `json:"network_name"`
+	}
+	if peek(d, &msg) != nil || msg.NetworkName == "" {
+		return true
+	}
+	ok, err := qm.Authorizer.Authorize(ctx, msg.UserName, msg.NetworkName)
+	if err != nil {
+		qm.logError(ctx, err, "failed to check network authorization")
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+		if err = d.Nack(false, true); err != nil {
+			qm.logError(ctx, err, "failed to requeue message")
+		}
+		return false
+	}
+	if ok {
+		return true
+	}
+	qm.LogEntry(ctx).WithField("network_name", msg.NetworkName).Warn("rejecting message for unauthorized network")
+	qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+	if err = qm.reject(d, ErrNetworkUnauthorized); err != nil {
+		qm.logError(ctx, err, "failed to reject message")
+	}
+	email := NewNetworkUnauthorizedEmail(msg.NetworkName, []string{msg.UserName})
+	if err = qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); err != nil {
+		qm.logError(ctx, err, "failed to publish unauthorized network email")
+	}
+	return false
+}
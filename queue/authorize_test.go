@@ -0,0 +1,37 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestNetworkAllowlist(t *testing.T) {
+	allowlist := queue.NetworkAllowlist{"private": {"alice", "bob"}}
+	var tests = []struct {
+		name      string
+		authz     queue.NetworkAuthorizer
+		user      string
+		network   string
+		wantAllow bool
+	}{
+		{"Public", allowlist, "mallory", queue.PublicNetwork, true},
+		{"Allowed", allowlist, "bob", "private", true},
+		{"NotAllowed", allowlist, "mallory", "private", false},
+		{"UnknownNetwork", allowlist, "alice", "other", false},
+		{"Default-Public", queue.NetworkAllowlist{}, "alice", queue.PublicNetwork, true},
+		{"Default-Private", queue.NetworkAllowlist{}, "alice", "private", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, err := tt.authz.Authorize(context.Background(), tt.user, tt.network)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed != tt.wantAllow {
+				t.Fatalf("Authorize(%s, %s) = %v, want %v", tt.user, tt.network, allowed, tt.wantAllow)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultBackpressurePollInterval is how often the depth of a queue is checked
+// when no interval is configured
+const DefaultBackpressurePollInterval = time.Second
+
+// Backpressure is used to slow producers down to the rate their consumers drain
+// the queue. Once the number of messages waiting in a queue reaches HighWaterMark,
+// publishing to it blocks until it has drained to LowWaterMark, rather than leaving
+// the broker to page the queue to disk. The depth is checked with QueueStats at most
+// once every PollInterval, so a queue may grow past its high water mark by the
+// messages published in between. Only messages published directly to a queue are
+// held back, and publishing carries on should the depth not be available.
+type Backpressure struct {
+	// HighWaterMark is the depth at which publishing blocks
+	HighWaterMark int
+	// LowWaterMark is the depth at which publishing resumes, defaulting to half of
+	// HighWaterMark
+	LowWaterMark int
+	// PollInterval is how often the depth is checked, defaulting to
+	// DefaultBackpressurePollInterval
+	PollInterval time.Duration
+}
+
+// pressure holds the last known depth of each queue published to with Backpressure.
+// Its zero value is ready to use
+type pressure struct {
+	mu     sync.Mutex
+	queues map[string]*queuePressure
+}
+
+// queuePressure is the last known state of a queue
+type queuePressure struct {
+	checked time.Time
+	full    bool
+}
+
+// waitForCapacity is used to block publishing to a queue while it's above its high
+// water mark, returning ctx.Err() if ctx is done first
+func (qm *Manager) waitForCapacity(ctx context.Context, queueName string) error {
+	bp := qm.Backpressure
+	// dry runs never reach the queue, so can't fill it
+	if bp == nil || bp.HighWaterMark <= 0 || queueName == "" || qm.DryRun {
+		return nil
+	}
+	interval := bp.PollInterval
+	if interval <= 0 {
+		interval = DefaultBackpressurePollInterval
+	}
+	low := bp.LowWaterMark
+	if low <= 0 || low > bp.HighWaterMark {
+		low = bp.HighWaterMark / 2
+	}
+	var timer *time.Timer
+	for blocked := false; ; blocked = true {
+		full, err := qm.pressure.check(queueName, interval, func() (int, error) {
+			stats, err := qm.QueueStats(queueName)
+			return stats.Messages, err
+		}, bp.HighWaterMark, low)
+		if err != nil {
+			qm.LogEntry(ctx).WithFields(log.Fields{
+				"queue": queueName,
+				"error": err.Error(),
+			}).Warn("failed to check queue depth, publishing without backpressure")
+			return nil
+		}
+		if !full {
+			if blocked {
+				qm.LogEntry(ctx).WithField("queue", queueName).Info("queue drained, resuming publishing")
+			}
+			return nil
+		}
+		if !blocked {
+			qm.LogEntry(ctx).WithField("queue", queueName).Warn("queue is full, blocking publishing")
+			timer = time.NewTimer(interval)
+			defer timer.Stop()
+		} else {
+			timer.Reset(interval)
+		}
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// check is used to get whether a queue is full, getting its depth with depth when
+// it hasn't been checked within the interval. A queue becomes full at the high
+// water mark, and stays full until it has drained to the low water mark
+func (p *pressure) check(queueName string, interval time.Duration, depth func() (int, error), high, low int) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.queues == nil {
+		p.queues = make(map[string]*queuePressure)
+	}
+	q, ok := p.queues[queueName]
+	if !ok {
+		q = &queuePressure{}
+		p.queues[queueName] = q
+	}
+	if time.Since(q.checked) < interval {
+		return q.full, nil
+	}
+	n, err := depth()
+	if err != nil {
+		return false, err
+	}
+	q.checked = time.Now()
+	switch {
+	case n >= high:
+		q.full = true
+	case n <= low:
+		q.full = false
+	}
+	return q.full, nil
+}
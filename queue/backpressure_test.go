@@ -0,0 +1,66 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestBackpressure(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithBackpressure(queue.Backpressure{
+		HighWaterMark: 2,
+		LowWaterMark:  1,
+		PollInterval:  10 * time.Millisecond,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if err := qm.PublishMessageContext(ctx, testPin("user")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// let the depth be checked again
+	time.Sleep(20 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		done <- qm.PublishMessageContext(ctx, testPin("user"))
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("expected publishing to block while the queue is full, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// publishing resumes once the queue has drained to the low water mark
+	if _, ok := broker.Get(queue.IpfsPinQueue); !ok {
+		t.Fatal("expected a message to be published")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.Len(queue.IpfsPinQueue); n != 2 {
+		t.Fatalf("expected 2 messages, got %v", n)
+	}
+	// blocked publishes give up once their context is done
+	time.Sleep(20 * time.Millisecond)
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if err := qm.PublishMessageContext(short, testPin("user")); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestNewManager_InvalidBackpressure(t *testing.T) {
+	for _, bp := range []queue.Backpressure{
+		{},
+		{HighWaterMark: 10, LowWaterMark: 20},
+		{HighWaterMark: 10, PollInterval: -time.Second},
+	} {
+		if _, err := queue.NewManager("", queue.WithBroker(queue.NewMemoryBroker()), queue.WithBackpressure(bp)); err == nil {
+			t.Fatalf("expected %+v to be refused", bp)
+		}
+	}
+}
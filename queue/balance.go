@@ -0,0 +1,116 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// ErrInsufficientCredits is the reason messages are refused when their user can't
+// afford their credit cost
+var ErrInsufficientCredits = errors.New("user has insufficient credits")
+
+// BalanceChecker is used to charge users the credit cost of their messages before
+// they're processed
+type BalanceChecker interface {
+	// Charge is used to deduct credits from the user's balance if it holds at least
+	// that many, returning false and deducting nothing if it doesn't. Checking and
+	// deducting must be atomic, so that messages of the same user processed
+	// concurrently can't overdraw their balance. Charges are identified by key, with
+	// a key which was already charged succeeding without deducting again, so that
+	// redelivered messages aren't charged twice.
+	Charge(ctx context.Context, userName string, credits float64, key string) (bool, error)
+}
+
+// MemoryBalances is an in-memory BalanceChecker, remembering every charge made
+type MemoryBalances struct {
+	mu       sync.Mutex
+	balances map[string]float64
+	charged  map[string]bool
+}
+
+// NewMemoryBalances is used to create in-memory balances holding the given credits
+// of each user
+func NewMemoryBalances(balances map[string]float64) *MemoryBalances {
+	b := &MemoryBalances{balances: make(map[string]float64), charged: make(map[string]bool)}
+	for user, credits := range balances {
+		b.balances[user] = credits
+	}
+	return b
+}
+
+// Charge is used to deduct credits from a user's balance
+func (b *MemoryBalances) Charge(ctx context.Context, userName string, credits float64, key string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.charged[key] {
+		return true, nil
+	}
+	if b.balances[userName] < credits {
+		return false, nil
+	}
+	b.balances[userName] -= credits
+	b.charged[key] = true
+	return true, nil
+}
+
+// Balance is used to get the credits a user has left
+func (b *MemoryBalances) Balance(userName string) float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.balances[userName]
+}
+
+// charge is used to charge a delivery's user its credit cost, multiplied by the
+// multiplier of its network, returning false if the delivery was settled because
+// they couldn't afford it, or we couldn't tell. refused messages are rejected and
+// the user notified, while messages we couldn't charge are requeued. the charge is
+// keyed by the message, as refunds are, so that redeliveries and replays aren't
+// charged twice, which like idempotency treats messages with identical bodies as
+// the same message
+func (qm *Manager) charge(ctx context.Context, d amqp.Delivery) bool {
+	if qm.Balances == nil || qm.prepaid(d) {
+		return true
+	}
+	var msg chargedMessage
+	if peek(d, &msg) != nil || msg.CreditCost <= 0 || msg.UserName == "" {
+		return true
+	}
+	credits, multiplier := msg.credits(qm.NetworkMultipliers)
+	entry := qm.LogEntry(ctx).WithFields(log.Fields{
+		"user_name":    msg.UserName,
+		"network_name": msg.NetworkName,
+		"credit_cost":  msg.CreditCost,
+		"multiplier":   multiplier,
+		"credits":      credits,
+	})
+	if credits <= 0 {
+		return true
+	}
+	ok, err := qm.Balances.Charge(ctx, msg.UserName, credits, qm.QueueName+":"+messageKey(d))
+	if err != nil {
+		qm.logError(ctx, err, "failed to charge credits")
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+		if err = d.Nack(false, true); err != nil {
+			qm.logError(ctx, err, "failed to requeue message")
+		}
+		return false
+	}
+	if ok {
+		entry.Info("charged credits")
+		return true
+	}
+	entry.Warn("rejecting message of user with insufficient credits")
+	qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+	if err = qm.reject(d, ErrInsufficientCredits); err != nil {
+		qm.logError(ctx, err, "failed to reject message")
+	}
+	email := NewInsufficientCreditsEmail(qm.QueueName, credits, []string{msg.UserName})
+	if err = qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); err != nil {
+		qm.logError(ctx, err, "failed to publish insufficient credits email")
+	}
+	return false
+}
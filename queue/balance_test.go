@@ -0,0 +1,96 @@
+package queue_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestMemoryBalances_Concurrent(t *testing.T) {
+	balances := queue.NewMemoryBalances(map[string]float64{"alice": 10})
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		charged int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := balances.Charge(context.Background(), "alice", 1, string(rune('a'+i)))
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				mu.Lock()
+				charged++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if charged != 10 || balances.Balance("alice") != 0 {
+		t.Fatalf("expected 10 charges leaving nothing, got %v leaving %v", charged, balances.Balance("alice"))
+	}
+	// charging a key again doesn't deduct twice
+	if ok, _ := balances.Charge(context.Background(), "alice", 1, "a"); !ok {
+		t.Fatal("expected a repeated charge to succeed")
+	}
+}
+
+func TestWithBalanceChecker(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	balances := queue.NewMemoryBalances(map[string]float64{"alice": 5})
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter(), queue.WithBalanceChecker(balances))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, cost := range []float64{3, 4, 0} {
+		pin := testPin("alice")
+		pin.CreditCost = cost
+		if err := qm.PublishMessageContext(ctx, pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var handled []float64
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		pin, err := queue.DecodeDelivery[queue.IPFSPin](d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handled = append(handled, pin.CreditCost)
+		// the second pin is refused, so the free pin is the last handled
+		if pin.CreditCost == 0 {
+			cancel()
+		}
+		return nil
+	})
+	if len(handled) != 2 || handled[0] != 3 || handled[1] != 0 {
+		t.Fatalf("unexpected pins handled %v", handled)
+	}
+	if balances.Balance("alice") != 2 {
+		t.Fatalf("expected alice to have 2 credits left, got %v", balances.Balance("alice"))
+	}
+	d, ok := broker.Get(queue.DeadLetterName(queue.IpfsPinQueue))
+	if !ok {
+		t.Fatal("expected the unaffordable pin to be dead lettered")
+	}
+	if reason, _ := d.Headers[queue.HeaderFailureReason].(string); reason != queue.ErrInsufficientCredits.Error() {
+		t.Fatalf("unexpected failure reason %q", reason)
+	}
+	d, ok = broker.Get(queue.EmailSendQueue)
+	if !ok {
+		t.Fatal("expected alice to be emailed")
+	}
+	email, err := queue.DecodeDelivery[queue.EmailSend](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != queue.InsufficientCreditsSubject || email.UserNames[0] != "alice" {
+		t.Fatalf("unexpected email %+v", email)
+	}
+}
@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Outcome is the result of processing a single message
+type Outcome int
+
+const (
+	// OutcomeAck acknowledges the message, removing it from the queue
+	OutcomeAck Outcome = iota
+	// OutcomeNack negatively acknowledges the message, returning it to the queue
+	OutcomeNack
+	// OutcomeDeadLetter rejects the message without returning it to the queue,
+	// so it is routed to the dead letter exchange if the queue has one
+	OutcomeDeadLetter
+)
+
+// errBatchDeadLettered is the reason credits are refunded for messages a batch
+// handler dead letters
+var errBatchDeadLettered = errors.New("message dead lettered by batch handler")
+
+// settle is used to acknowledge a delivery according to the outcome
+func (o Outcome) settle(d amqp.Delivery) error {
+	switch o {
+	case OutcomeAck:
+		return d.Ack(false)
+	case OutcomeDeadLetter:
+		return d.Nack(false, false)
+	default:
+		return d.Nack(false, true)
+	}
+}
+
+// recoverBatch is used to pass a batch to handler, returning a PanicError should it
+// panic, so that the consumer keeps running
+func recoverBatch(handler BatchHandler, batch []amqp.Delivery) (outcomes []Outcome, panicErr *PanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicErr = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return handler(batch), nil
+}
+
+// BatchHandler is used to process a batch of messages. It returns the outcome of
+// each message in the same order as the batch, so that a single bad message
+// doesn't force the whole batch to be reprocessed. Messages without a
+// corresponding outcome are returned to the queue.
+type BatchHandler func(batch []amqp.Delivery) []Outcome
+
+// ConsumeBatch is used to consume messages from the queue in batches of up to size
+// messages. A batch is passed to the handler once it is full, or once wait has
+// elapsed since its first message arrived, whichever comes first. Messages go
+// through the same checks as with ConsumeMessageContext before joining a batch,
+// such as verification, authorization, charging and idempotency, with those which
+// fail being settled without reaching the handler. Should the handler panic, every
+// message of the batch is rejected, being dead lettered if enabled, and the admin is
+// emailed, while the consumer carries on. consumer is the consumer's tag, with one
+// being made by ConsumerTag when empty. It returns once the manager is closed, or
+// once the consumer is cancelled with Cancel, after handling the messages it was
+// already sent.
+func (qm *Manager) ConsumeBatch(consumer string, size int, wait time.Duration, handler BatchHandler) error {
+	if size < 1 {
+		return errors.New("batch size must be at least 1")
+	}
+	if consumer == "" {
+		consumer = qm.ConsumerTag()
+	}
+	if err := qm.register(consumer); err != nil {
+		return err
+	}
+	defer qm.unregister(consumer)
+	// the broker won't deliver more unacknowledged messages than our prefetch
+	// count, so it has to be at least the batch size for a batch to fill up
+	prefetch := qm.prefetch()
+	if prefetch < size {
+		prefetch = size
+	}
+	gen := qm.generation()
+	msgs, err := qm.consume(consumer, prefetch)
+	if err != nil {
+		return err
+	}
+	var (
+		batch   []amqp.Delivery
+		timeout <-chan time.Time
+	)
+	flush := func() {
+		if !qm.begin() {
+			return
+		}
+		defer qm.end()
+		// messages go through the same checks as those consumed one at a time,
+		// with those refused being settled rather than reaching the handler
+		admitted := batch[:0]
+		var keys []string
+		for _, d := range batch {
+			ctx := headersContext(timestampContext(deliveryContext(context.Background(), d), d), d)
+			qm.Metrics.observeConsumed(qm.QueueName, qm.Service)
+			// batches are settled without republishing, so are upgraded in place
+			migrated, key, ok := qm.admit(ctx, &d)
+			if !ok {
+				continue
+			}
+			admitted = append(admitted, migrated)
+			keys = append(keys, key)
+		}
+		if batch = admitted; len(batch) == 0 {
+			batch, timeout = nil, nil
+			return
+		}
+		start := time.Now()
+		outcomes, panicErr := recoverBatch(handler, batch)
+		qm.Metrics.observeDuration(qm.QueueName, qm.Service, start)
+		if panicErr != nil {
+			ctx := deliveryContext(context.Background(), batch[0])
+			qm.logError(ctx, panicErr, "failed to process batch")
+			qm.Metrics.observePanic(qm.QueueName, qm.Service)
+			qm.notifyPanic(ctx, batch[0], panicErr)
+		}
+		for i, d := range batch {
+			outcome := OutcomeNack
+			switch {
+			case panicErr != nil:
+				// whatever the handler was doing is unlikely to succeed if
+				// redelivered, as with messages consumed one at a time
+				outcome = OutcomeDeadLetter
+			case i < len(outcomes):
+				outcome = outcomes[i]
+			}
+			qm.Metrics.observeSettled(qm.QueueName, qm.Service, outcome == OutcomeAck)
+			// allow messages which weren't processed to be processed again
+			if outcome != OutcomeAck && keys[i] != "" {
+				if err := qm.Idempotency.Release(keys[i]); err != nil {
+					qm.logError(deliveryContext(context.Background(), d), err, "failed to release idempotency key")
+				}
+			}
+			// dead lettered messages won't be processed, so the credits they
+			// were charged are refunded, while requeued ones aren't charged again
+			if outcome == OutcomeDeadLetter && qm.Balances != nil && !qm.prepaid(d) {
+				qm.RefundCredits(deliveryContext(context.Background(), d), d, errBatchDeadLettered)
+			}
+			var err error
+			if panicErr != nil {
+				// the panic is recorded as the reason the message was dead lettered
+				err = qm.reject(d, panicErr)
+			} else {
+				err = outcome.settle(d)
+			}
+			if err != nil {
+				qm.logError(deliveryContext(context.Background(), d), err, "failed to acknowledge message")
+			}
+		}
+		batch, timeout = nil, nil
+	}
+	for {
+		select {
+		case <-qm.closing():
+			return nil
+		case d, ok := <-msgs:
+			// the delivery channel is closed along with the amqp channel, at
+			// which point any unacknowledged messages are requeued by the broker,
+			// so the partial batch is discarded while we wait to reconnect
+			if !ok {
+				// a cancelled consumer's channel stays open, so the messages
+				// it holds have to be handled rather than left unacknowledged
+				if qm.cancelled(consumer) {
+					if len(batch) > 0 {
+						flush()
+					}
+					qm.LogEntry(context.Background()).WithField("consumer", consumer).Info("consumer cancelled")
+					return nil
+				}
+				batch, timeout = nil, nil
+				if msgs, gen, err = qm.resume(context.Background(), gen, consumer, prefetch); err != nil || msgs == nil {
+					return err
+				}
+				continue
+			}
+			batch = append(batch, d)
+			if len(batch) == 1 {
+				timeout = time.After(wait)
+			}
+			if len(batch) >= size {
+				flush()
+			}
+		case <-timeout:
+			flush()
+		}
+	}
+}
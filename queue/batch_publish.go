@@ -0,0 +1,178 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/streadway/amqp"
+)
+
+// BatchError is returned when some of the messages given to PublishBatch could
+// not be published, and records why each of them failed by its index
+type BatchError struct {
+	Errors map[int]error
+}
+
+// Error is used to summarize the failed messages, reporting the first failure
+func (e *BatchError) Error() string {
+	indices := e.Indices()
+	return fmt.Sprintf(
+		"failed to publish %v message(s), message %d: %s",
+		len(indices), indices[0], e.Errors[indices[0]],
+	)
+}
+
+// Indices is used to get the indices of the failed messages in ascending order
+func (e *BatchError) Indices() []int {
+	indices := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// PublishBatch is used to publish several messages to the queue at once.
+// It is equivalent to PublishBatchContext with a background context.
+func (qm *Manager) PublishBatch(msgs []interface{}) error {
+	return qm.PublishBatchContext(context.Background(), msgs)
+}
+
+// PublishBatchContext is used to publish several messages to the queue, waiting for
+// the broker to confirm all of them together rather than one at a time. Messages are
+// validated and signed as with PublishMessageContext, and should any of them fail
+// validation nothing is published. Otherwise a *BatchError is returned recording
+// which messages the broker did not accept, so that only those need to be retried.
+// The messages which were accepted are copied for billing and auditing as with
+// PublishMessageContext.
+func (qm *Manager) PublishBatchContext(ctx context.Context, msgs []interface{}) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	prepared := make([]amqp.Publishing, len(msgs))
+	failed := &BatchError{Errors: make(map[int]error)}
+	for i, body := range msgs {
+		msg, err := qm.prepare(ctx, body)
+		if err != nil {
+			failed.Errors[i] = err
+			continue
+		}
+		prepared[i] = msg
+	}
+	if len(failed.Errors) > 0 {
+		return failed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := qm.waitForCapacity(ctx, qm.QueueName); err != nil {
+		return err
+	}
+	// dry runs never reach the broker, so can't be refused by it
+	if qm.DryRun {
+		for _, msg := range prepared {
+			qm.send(ctx, nil, "", qm.QueueName, msg)
+		}
+		return nil
+	}
+	if qm.Broker != nil {
+		for i, msg := range prepared {
+			if err := qm.send(ctx, nil, "", qm.QueueName, msg); err != nil {
+				failed.Errors[i] = err
+			}
+		}
+	} else if err := qm.publishConfirmed(ctx, prepared, failed); err != nil {
+		return err
+	}
+	// only the messages which reached the queue are copied
+	for i, msg := range prepared {
+		if _, ok := failed.Errors[i]; ok {
+			continue
+		}
+		if qm.Billing {
+			qm.copyMessage(ctx, qm.channel(), BillingQueue, qm.QueueName, msg)
+		}
+		if qm.Audit {
+			qm.copyMessage(ctx, qm.channel(), AuditQueue, qm.QueueName, msg)
+		}
+	}
+	if len(failed.Errors) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// publishConfirmed is used to publish prepared messages to the queue through a
+// dedicated channel in confirm mode, recording those the broker did not accept in
+// failed. An error is returned should the channel not be opened
+func (qm *Manager) publishConfirmed(ctx context.Context, prepared []amqp.Publishing, failed *BatchError) error {
+	conn := qm.connection()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	// we use a dedicated channel so that our confirmations, whose delivery tags
+	// start from 1, can't be confused with those of other publishes
+	ch, err := conn.Channel()
+	if err != nil {
+		return connectionError(err)
+	}
+	defer ch.Close()
+	if err = ch.Confirm(false); err != nil {
+		return err
+	}
+	qm.watchReturns(ch)
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, len(prepared)))
+	sent := 0
+	for i, msg := range prepared {
+		if err = ch.Publish(
+			"",           // exchange
+			qm.QueueName, // routing key
+			qm.Mandatory, // mandatory
+			false,        // immediate
+			msg,
+		); err != nil {
+			// the channel is closed on error, so the remaining messages can't be sent
+			for j := i; j < len(prepared); j++ {
+				failed.Errors[j] = err
+			}
+			break
+		}
+		sent++
+	}
+	// delivery tags are assigned in publish order, so tag n confirms message n-1
+	confirmed := make([]bool, sent)
+	for waiting := sent; waiting > 0; {
+		select {
+		case conf, ok := <-confirms:
+			if !ok {
+				for i, done := range confirmed {
+					if !done {
+						failed.Errors[i] = amqp.ErrClosed
+					}
+				}
+				waiting = 0
+				continue
+			}
+			i := int(conf.DeliveryTag) - 1
+			if i < 0 || i >= sent || confirmed[i] {
+				continue
+			}
+			confirmed[i] = true
+			waiting--
+			if !conf.Ack {
+				failed.Errors[i] = ErrNacked
+				continue
+			}
+			qm.Metrics.observePublished(qm.QueueName, qm.Service)
+		case <-ctx.Done():
+			for i, done := range confirmed {
+				if !done {
+					failed.Errors[i] = ctx.Err()
+				}
+			}
+			waiting = 0
+		}
+	}
+	return nil
+}
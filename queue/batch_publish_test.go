@@ -0,0 +1,82 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestPublishBatchContext_Broker(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	for _, name := range []string{queue.BillingQueue, queue.AuditQueue} {
+		if err := broker.DeclareQueue(name, queue.QueueOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	qm := newMemoryManager(t, broker, queue.WithBilling(), queue.WithAudit())
+	if err := qm.PublishBatchContext(context.Background(), []interface{}{testPin("a"), testPin("b")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{queue.IpfsPinQueue, queue.BillingQueue, queue.AuditQueue} {
+		if n := broker.Len(name); n != 2 {
+			t.Fatalf("got %v messages in %s, want 2", n, name)
+		}
+	}
+}
+
+const benchCID = "QmPY5iMFjNZKxRbUZZC85wXb9CFgNSyzAy1LxwL62D8VGr"
+
+// newBenchManager is used to connect to the broker given by RABBITMQ_URL,
+// skipping the benchmark when it isn't set
+func newBenchManager(b *testing.B) *queue.Manager {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		b.Skip("RABBITMQ_URL not set")
+	}
+	qm, err := queue.NewManager(url, queue.WithQueue("bench-pin-queue"), queue.WithDurable(false))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return qm
+}
+
+func benchPin() queue.IPFSPin {
+	return queue.IPFSPin{
+		CID:              benchCID,
+		NetworkName:      "public",
+		UserName:         "bench",
+		HoldTimeInMonths: 1,
+		CreditCost:       0,
+	}
+}
+
+// single publishes wait for their confirmation, as batches do
+func BenchmarkPublish_Single(b *testing.B) {
+	qm := newBenchManager(b)
+	if err := qm.EnableConfirms(10 * time.Second); err != nil {
+		b.Fatal(err)
+	}
+	pin := benchPin()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := qm.PublishMessageContext(context.Background(), pin); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPublish_Batch(b *testing.B) {
+	qm := newBenchManager(b)
+	msgs := make([]interface{}, b.N)
+	for i := range msgs {
+		msgs[i] = benchPin()
+	}
+	b.ResetTimer()
+	if err := qm.PublishBatch(msgs); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,209 @@
+package queue_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// consumeBatch is used to run a batch consumer in the background, returning a
+// channel receiving its result
+func consumeBatch(qm *queue.Manager, consumer string, size int, handler queue.BatchHandler) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- qm.ConsumeBatch(consumer, size, 20*time.Millisecond, handler)
+	}()
+	return done
+}
+
+// ackAll is a batch handler acknowledging every message, sending each batch to got
+func ackAll(got chan<- []amqp.Delivery) queue.BatchHandler {
+	return func(batch []amqp.Delivery) []queue.Outcome {
+		got <- batch
+		return make([]queue.Outcome, len(batch))
+	}
+}
+
+func TestConsumeBatch_Cancel(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	got := make(chan []amqp.Delivery, 10)
+	done := consumeBatch(qm, "batch-test", 10, ackAll(got))
+	if err := qm.PublishMessageContext(context.Background(), testPin("user")); err != nil {
+		t.Fatal(err)
+	}
+	<-got
+	if err := qm.Cancel("batch-test"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the batch consumer to stop once cancelled")
+	}
+	if err := qm.Cancel("batch-test"); err == nil {
+		t.Fatal("expected the tag to be released once the consumer stopped")
+	}
+}
+
+// batched messages go through the same checks as those consumed one at a time
+func TestConsumeBatch_Admission(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter(), queue.WithIdempotency(queue.NewMemoryStore(), time.Hour))
+	ctx := context.Background()
+	// a duplicate is skipped, and a message we have no codec for is dead lettered
+	for i := 0; i < 2; i++ {
+		if err := qm.PublishMessageContext(ctx, testPin("user")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := broker.Publish(ctx, "", queue.IpfsPinQueue, amqp.Publishing{ContentType: "application/xml", Body: []byte("<pin/>")}); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan []amqp.Delivery, 10)
+	done := consumeBatch(qm, "batch-test", 3, ackAll(got))
+	defer func() {
+		qm.Cancel("batch-test")
+		<-done
+	}()
+	dlq := queue.DeadLetterName(queue.IpfsPinQueue)
+	deadline := time.Now().Add(5 * time.Second)
+	var handled int
+	for (handled < 1 || broker.Len(dlq) < 1) && time.Now().Before(deadline) {
+		select {
+		case batch := <-got:
+			handled += len(batch)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// give a wrongly admitted duplicate the chance to show up
+	select {
+	case batch := <-got:
+		handled += len(batch)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if handled != 1 {
+		t.Fatalf("expected only the first pin to be handled, got %v messages", handled)
+	}
+	if n := broker.Len(dlq); n != 1 {
+		t.Fatalf("expected the unsupported message to be dead lettered, got %v", n)
+	}
+}
+
+// batched messages are charged, with dead lettered ones being refunded
+func TestConsumeBatch_Charge(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.CreditRefundQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	balances := queue.NewMemoryBalances(map[string]float64{"user": 5})
+	qm := newMemoryManager(t, broker, queue.WithBalanceChecker(balances))
+	ctx := context.Background()
+	for _, hold := range []int64{1, 2} {
+		pin := testPin("user")
+		pin.HoldTimeInMonths, pin.CreditCost = hold, 3
+		if err := qm.PublishMessageContext(ctx, pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make(chan []amqp.Delivery, 10)
+	done := consumeBatch(qm, "batch-test", 2, func(batch []amqp.Delivery) []queue.Outcome {
+		got <- batch
+		return []queue.Outcome{queue.OutcomeDeadLetter}
+	})
+	defer func() {
+		qm.Cancel("batch-test")
+		<-done
+	}()
+	// the user can only afford the first pin
+	var batch []amqp.Delivery
+	select {
+	case batch = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a batch")
+	}
+	if len(batch) != 1 {
+		t.Fatalf("expected only the affordable pin to be handled, got %v messages", len(batch))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Len(queue.CreditRefundQueue) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	d, ok := broker.Get(queue.CreditRefundQueue)
+	if !ok {
+		t.Fatal("expected the dead lettered pin to be refunded")
+	}
+	refund, err := queue.DecodeDelivery[queue.CreditRefund](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.UserName != "user" || refund.Amount != 3 {
+		t.Fatalf("unexpected refund %+v", refund)
+	}
+	if b := balances.Balance("user"); b != 2 {
+		t.Fatalf("expected the user to have been charged once, got a balance of %v", b)
+	}
+}
+
+// a panicking batch handler has its batch dead lettered and the admin notified,
+// while the consumer carries on with the next batch
+func TestConsumeBatch_Panic(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter())
+	ctx := context.Background()
+	for _, user := range []string{"alice", "bob"} {
+		if err := qm.PublishMessageContext(ctx, testPin(user)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make(chan []amqp.Delivery, 10)
+	var batches int
+	done := consumeBatch(qm, "batch-test", 2, func(batch []amqp.Delivery) []queue.Outcome {
+		if batches++; batches == 1 {
+			panic("boom")
+		}
+		return ackAll(got)(batch)
+	})
+	defer func() {
+		qm.Cancel("batch-test")
+		<-done
+	}()
+	dlq := queue.DeadLetterName(queue.IpfsPinQueue)
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Len(dlq) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := broker.Len(dlq); n != 2 {
+		t.Fatalf("expected the panicking batch to be dead lettered, got %v messages", n)
+	}
+	d, ok := broker.Get(queue.EmailSendQueue)
+	if !ok {
+		t.Fatal("expected the admin to be notified of the panic")
+	}
+	email, err := queue.DecodeDelivery[queue.EmailSend](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != queue.HandlerPanicSubject || !strings.Contains(email.Content, "boom") {
+		t.Fatalf("unexpected email %+v", email)
+	}
+	if err := qm.PublishMessageContext(ctx, testPin("carol")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the consumer to continue after the panic")
+	}
+}
@@ -0,0 +1,240 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// BillingQueue is the name of both the topic exchange managers with billing enabled
+// copy the messages they publish to, routed by the queue they were published to,
+// and the queue BillingAggregate consumes them from
+const BillingQueue = "billing-aggregate"
+
+// DefaultBillingWindow is the period credits are summed over when no window is
+// configured
+const DefaultBillingWindow = 5 * time.Minute
+
+// Where billing totals are stored when no database or collection is configured
+const (
+	DefaultBillingDatabase   = "temporal"
+	DefaultBillingCollection = "billing"
+)
+
+// DefaultBilledQueues are the queues whose messages are billed when no queues are
+// configured, being those whose messages cost credits, along with CreditRefundQueue
+// so that refunded credits are subtracted from the totals
+var DefaultBilledQueues = []string{
+	DatabaseFileAddQueue,
+	IpfsPinQueue,
+	IpfsBulkPinQueue,
+	IpfsFileQueue,
+	IpfsClusterPinQueue,
+	IpnsEntryQueue,
+	IpnsUpdateQueue,
+	IpfsKeyCreationQueue,
+	RecordCreationQueue,
+	CreditRefundQueue,
+}
+
+// BillingOpts is used to control how BillingAggregate sums credits
+type BillingOpts struct {
+	// Window is the period credits are summed over, defaulting to DefaultBillingWindow
+	Window time.Duration
+	// Queues are the queues whose messages are billed, defaulting to DefaultBilledQueues
+	Queues []string
+	// Multipliers are the credit cost multipliers of networks, which should be those
+	// of the consumers charging users, so that totals reflect what users are charged
+	Multipliers NetworkMultipliers
+	// DatabaseName and CollectionName are where totals are stored, defaulting to
+	// DefaultBillingDatabase and DefaultBillingCollection
+	DatabaseName   string
+	CollectionName string
+}
+
+// billedMessage holds the fields of a message used for billing
+type billedMessage struct {
+	chargedMessage
+	// Amount is set by credit refunds
+	Amount float64 `json:"amount"`
+}
+
+// BillingAggregate is used to give a near real time view of the credits each user
+// spends. It consumes the copies of messages published by managers with billing
+// enabled (see WithBilling) from the manager's queue, which should be BillingQueue,
+// summing the credit cost of the messages of each user over every window, multiplied
+// by the multiplier of their network, with credit refunds being subtracted. At the
+// end of each window a MongoUpdate holding the user's total is published to
+// MongoUpdateQueue for every user who spent credits, with fields user_name,
+// credits, window_start and window_end. Totals are held in memory until then, so
+// those of a window which is cut short, such as by a crash, may be lost, and this
+// shouldn't be relied upon for charging users.
+// It runs until ctx is cancelled, at which point the totals of the current window
+// are published and ctx.Err() is returned.
+func (qm *Manager) BillingAggregate(ctx context.Context, opts BillingOpts) error {
+	if opts.Window <= 0 {
+		opts.Window = DefaultBillingWindow
+	}
+	if len(opts.Queues) == 0 {
+		opts.Queues = DefaultBilledQueues
+	}
+	if opts.DatabaseName == "" {
+		opts.DatabaseName = DefaultBillingDatabase
+	}
+	if opts.CollectionName == "" {
+		opts.CollectionName = DefaultBillingCollection
+	}
+	if qm.Broker == nil {
+		if err := qm.bindCopies(BillingQueue, opts.Queues); err != nil {
+			return err
+		}
+	}
+	b := &billing{queues: make(map[string]bool), totals: make(map[string]float64), start: time.Now()}
+	for _, name := range opts.Queues {
+		b.queues[name] = true
+	}
+	done := make(chan struct{})
+	defer func() {
+		<-done
+		// publish what we have of the last window, as ctx is done by now
+		qm.publishBilling(context.Background(), b, opts)
+	}()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(opts.Window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				qm.publishBilling(ctx, b, opts)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return qm.ConsumeMessageContext(ctx, "", func(ctx context.Context, d amqp.Delivery) error {
+		if !b.queues[d.RoutingKey] || qm.prepaid(d) {
+			return nil
+		}
+		var msg billedMessage
+		if err := peek(d, &msg); err != nil {
+			return Drop(err)
+		}
+		credits, _ := msg.credits(opts.Multipliers)
+		b.add(msg.UserName, credits-msg.Amount)
+		return nil
+	})
+}
+
+// billing holds the credits spent by each user in the current window
+type billing struct {
+	queues map[string]bool
+	mu     sync.Mutex
+	totals map[string]float64
+	start  time.Time
+}
+
+// add is used to add credits spent by a user to their total
+func (b *billing) add(userName string, credits float64) {
+	if userName == "" || credits == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.totals[userName] += credits
+	b.mu.Unlock()
+}
+
+// reset is used to take the totals of the window, starting the next one
+func (b *billing) reset() (map[string]float64, time.Time, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	totals, start, end := b.totals, b.start, time.Now()
+	b.totals, b.start = make(map[string]float64), end
+	return totals, start, end
+}
+
+// publishBilling is used to publish the totals of the window which has ended
+func (qm *Manager) publishBilling(ctx context.Context, b *billing, opts BillingOpts) {
+	totals, start, end := b.reset()
+	users := make([]string, 0, len(totals))
+	for user := range totals {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		update := MongoUpdate{
+			DatabaseName:   opts.DatabaseName,
+			CollectionName: opts.CollectionName,
+			Fields: map[string]string{
+				"user_name":    user,
+				"credits":      strconv.FormatFloat(totals[user], 'f', -1, 64),
+				"window_start": start.UTC().Format(time.RFC3339),
+				"window_end":   end.UTC().Format(time.RFC3339),
+			},
+		}
+		if err := qm.publish(ctx, qm.channel(), "", MongoUpdateQueue, update); err != nil {
+			qm.LogEntry(ctx).WithFields(log.Fields{
+				"user":    user,
+				"credits": totals[user],
+				"error":   err.Error(),
+			}).Error("failed to publish billing totals")
+		}
+	}
+}
+
+// bindCopies is used to bind the manager's queue to an exchange copies of messages
+// are published to, such as the billing exchange, so that it receives copies of the
+// messages published to each of the given queues
+func (qm *Manager) bindCopies(exchangeName string, queues []string) error {
+	ch := qm.channel()
+	if ch == nil {
+		return ErrNotConnected
+	}
+	if err := declareCopyExchange(ch, exchangeName); err != nil {
+		return connectionError(err)
+	}
+	for _, name := range queues {
+		if err := ch.QueueBind(
+			qm.QueueName, // name of the queue
+			name,         // routing key
+			exchangeName, // exchange
+			false,        // no-wait
+			nil,          // arguments
+		); err != nil {
+			return connectionError(err)
+		}
+	}
+	return nil
+}
+
+// declareCopyExchange is used to declare a topic exchange copies of messages are
+// published to, such as for billing or auditing
+func declareCopyExchange(ch *amqp.Channel, name string) error {
+	return ch.ExchangeDeclare(
+		name,    // name
+		"topic", // type
+		true,    // durable
+		false,   // auto-delete
+		false,   // internal
+		false,   // no-wait
+		nil,     // arguments
+	)
+}
+
+// copyMessage is used to publish a copy of a message published to a queue to an
+// exchange, such as the billing exchange, routed by the queue's name. Failing to do
+// so doesn't fail the publish, as the message has already been published
+func (qm *Manager) copyMessage(ctx context.Context, ch *amqp.Channel, exchangeName, queueName string, msg amqp.Publishing) {
+	if err := qm.send(ctx, ch, exchangeName, queueName, msg); err != nil {
+		qm.LogEntry(ctx).WithFields(log.Fields{
+			"queue":    queueName,
+			"exchange": exchangeName,
+			"error":    err.Error(),
+		}).Error("failed to publish copy of message")
+	}
+}
@@ -0,0 +1,83 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestBillingAggregate(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	for _, name := range []string{queue.BillingQueue, queue.MongoUpdateQueue, queue.CreditRefundQueue} {
+		if err := broker.DeclareQueue(name, queue.QueueOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pins := newMemoryManager(t, broker, queue.WithBilling())
+	refunds := newMemoryManager(t, broker, queue.WithQueue(queue.CreditRefundQueue), queue.WithBilling())
+	keys := newMemoryManager(t, broker, queue.WithQueue(queue.IpfsKeyCreationQueue), queue.WithBilling())
+	aggregator := newMemoryManager(t, broker, queue.WithQueue(queue.BillingQueue))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for user, costs := range map[string][]float64{"alice": {2, 3}, "bob": {1.5}} {
+		for _, cost := range costs {
+			pin := testPin(user)
+			pin.CreditCost = cost
+			if err := pins.PublishMessageContext(ctx, pin); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// pins of private networks cost more
+	private := testPin("dave")
+	private.NetworkName, private.CreditCost = "private", 1.5
+	if err := pins.PublishMessageContext(ctx, private); err != nil {
+		t.Fatal(err)
+	}
+	if err := refunds.PublishMessageContext(ctx, queue.CreditRefund{UserName: "alice", Amount: 1, Reason: "pin timed out", IdempotencyKey: "refund"}); err != nil {
+		t.Fatal(err)
+	}
+	// messages of queues which aren't watched are ignored
+	if err := keys.PublishMessageContext(ctx, queue.IPFSKeyCreation{
+		UserName: "carol", Name: "key", Type: queue.KeyTypeED25519, NetworkName: "public", CreditCost: 10,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- aggregator.BillingAggregate(ctx, queue.BillingOpts{
+			Window:      time.Hour,
+			Queues:      []string{queue.IpfsPinQueue, queue.CreditRefundQueue},
+			Multipliers: queue.NetworkMultipliers{"private": 2},
+		})
+	}()
+	for broker.Len(queue.BillingQueue)+broker.Unacked() != 0 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the totals of the last window are published once stopped
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	totals := make(map[string]string)
+	for {
+		d, ok := broker.Get(queue.MongoUpdateQueue)
+		if !ok {
+			break
+		}
+		update, err := queue.DecodeDelivery[queue.MongoUpdate](d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if update.DatabaseName != queue.DefaultBillingDatabase || update.CollectionName != queue.DefaultBillingCollection {
+			t.Fatalf("unexpected update %+v", update)
+		}
+		totals[update.Fields["user_name"]] = update.Fields["credits"]
+	}
+	if len(totals) != 3 || totals["alice"] != "4" || totals["bob"] != "1.5" || totals["dave"] != "3" {
+		t.Fatalf("unexpected totals %v", totals)
+	}
+}
@@ -0,0 +1,199 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// DefaultBreakerFailures is the number of consecutive failures which open a
+	// circuit breaker, when no threshold is configured
+	DefaultBreakerFailures = 5
+	// DefaultBreakerTimeout is how long a circuit breaker stays open before letting
+	// a message through to probe whether the downstream has recovered, when no
+	// timeout is configured
+	DefaultBreakerTimeout = 30 * time.Second
+)
+
+// BreakerState is the state of a CircuitBreaker, as reported by its metric
+type BreakerState int
+
+const (
+	// BreakerClosed lets every message through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen postpones every message without processing it
+	BreakerOpen
+	// BreakerHalfOpen lets a single message through, closing the breaker if it
+	// succeeds and opening it again if it fails
+	BreakerHalfOpen
+)
+
+// String is used to get the name of the state
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerOpts is used to control when a CircuitBreaker opens and closes
+type BreakerOpts struct {
+	// Name identifies the downstream the breaker protects, such as "ipfs", and
+	// labels its metric
+	Name string
+	// Failures is the number of consecutive failures which open the breaker,
+	// defaulting to DefaultBreakerFailures
+	Failures int
+	// Timeout is how long the breaker stays open before probing the downstream,
+	// defaulting to DefaultBreakerTimeout
+	Timeout time.Duration
+	// Delay is how long messages arriving while the breaker is open are postponed
+	// for, defaulting to Timeout
+	Delay time.Duration
+	// IsFailure optionally decides which of the handler's errors are failures of
+	// the downstream. By default every error is, other than those dropping the
+	// message, which fail however healthy the downstream is.
+	IsFailure func(err error) bool
+}
+
+// CircuitBreaker is used to stop processing messages while a downstream such as
+// ipfs is failing, so that it isn't hammered while trying to recover. A breaker may
+// be shared by the consumers of several queues using the same downstream.
+type CircuitBreaker struct {
+	opts BreakerOpts
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	// probing is set while a message is let through by the half-open breaker
+	probing bool
+}
+
+// NewCircuitBreaker is used to create a closed circuit breaker
+func NewCircuitBreaker(opts BreakerOpts) *CircuitBreaker {
+	if opts.Failures <= 0 {
+		opts.Failures = DefaultBreakerFailures
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultBreakerTimeout
+	}
+	if opts.Delay <= 0 {
+		opts.Delay = opts.Timeout
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool { return !errors.Is(err, ErrDrop) }
+	}
+	return &CircuitBreaker{opts: opts}
+}
+
+// State is used to get the breaker's current state
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow is used to check whether a message may be processed, half-opening the
+// breaker once it has been open for its timeout
+func (b *CircuitBreaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.opts.Timeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// only a single probe is let through at a time
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// record is used to record the outcome of processing a message which was allowed,
+// returning the breaker's state and whether it changed
+func (b *CircuitBreaker) record(failed bool, now time.Time) (BreakerState, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	before := b.state
+	switch {
+	case b.state == BreakerHalfOpen:
+		b.probing = false
+		if failed {
+			b.state, b.openedAt = BreakerOpen, now
+		} else {
+			b.state, b.failures = BreakerClosed, 0
+		}
+	case !failed:
+		b.failures = 0
+	case b.state == BreakerClosed:
+		if b.failures++; b.failures >= b.opts.Failures {
+			b.state, b.openedAt = BreakerOpen, now
+		}
+	}
+	return b.state, b.state != before
+}
+
+// release is used to give up on a message which was allowed without recording its
+// outcome, letting another probe through should it have been one
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// CircuitBreak is used to wrap handler with breaker, so that once the handler fails
+// the breaker's number of consecutive messages, messages are postponed without being
+// processed until the breaker's timeout has elapsed. A single message is then let
+// through, closing the breaker if it succeeds. As with throttling, postponed messages
+// are published to the back of the queue after the breaker's delay, which is spent in
+// the broker when the queue has delayed delivery enabled, and otherwise occupies one
+// of the consumer's workers.
+func (qm *Manager) CircuitBreak(handler Handler, breaker *CircuitBreaker) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		allowed := breaker.allow(time.Now())
+		qm.Metrics.observeBreaker(breaker.opts.Name, breaker.State())
+		if !allowed {
+			qm.LogEntry(ctx).WithField("breaker", breaker.opts.Name).Info("postponing message while circuit breaker is open")
+			if err := qm.postpone(ctx, d, breaker.opts.Delay); err != nil {
+				return RetryLater(err)
+			}
+			return nil
+		}
+		err := handler(ctx, d)
+		// being shut down says nothing of the downstream's health
+		if err != nil && ctx.Err() != nil {
+			breaker.release()
+			return err
+		}
+		state, changed := breaker.record(err != nil && breaker.opts.IsFailure(err), time.Now())
+		qm.Metrics.observeBreaker(breaker.opts.Name, state)
+		if !changed {
+			return err
+		}
+		entry := qm.LogEntry(ctx).WithField("breaker", breaker.opts.Name)
+		if state == BreakerOpen {
+			entry.Warn("circuit breaker opened")
+		} else {
+			entry.Info("circuit breaker closed")
+		}
+		return err
+	}
+}
@@ -0,0 +1,116 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streadway/amqp"
+)
+
+func TestManager_CircuitBreak(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	reg := prometheus.NewRegistry()
+	metrics, err := queue.NewMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qm := newMemoryManager(t, broker, queue.WithMetrics(metrics))
+	breaker := queue.NewCircuitBreaker(queue.BreakerOpts{
+		Name:     "ipfs",
+		Failures: 2,
+		Timeout:  50 * time.Millisecond,
+		Delay:    time.Millisecond,
+	})
+	down, calls := true, 0
+	handler := qm.CircuitBreak(func(ctx context.Context, d amqp.Delivery) error {
+		calls++
+		if down {
+			return errors.New("ipfs is overloaded")
+		}
+		return nil
+	}, breaker)
+	ctx := context.Background()
+	d := amqp.Delivery{ContentType: "application/json", Body: []byte(`{}`)}
+	for i := 0; i < 2; i++ {
+		if err = handler(ctx, d); err == nil {
+			t.Fatal("expected the handler's error")
+		}
+	}
+	if state := breaker.State(); state != queue.BreakerOpen {
+		t.Fatalf("expected the breaker to open, got %s", state)
+	}
+	if got := breakerGauge(t, reg); got != float64(queue.BreakerOpen) {
+		t.Fatalf("expected the open state to be reported, got %v", got)
+	}
+	// while open, messages are postponed without reaching the handler
+	if err = handler(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the open breaker to short circuit, got %v calls", calls)
+	}
+	if n := broker.Len(queue.IpfsPinQueue); n != 1 {
+		t.Fatalf("expected the message to be postponed, got %v messages", n)
+	}
+	// a failing probe opens it again
+	time.Sleep(60 * time.Millisecond)
+	if err = handler(ctx, d); err == nil || calls != 3 {
+		t.Fatalf("expected the half-open breaker to let a probe through, got %v calls", calls)
+	}
+	if state := breaker.State(); state != queue.BreakerOpen {
+		t.Fatalf("expected the failed probe to reopen the breaker, got %s", state)
+	}
+	// while a succeeding probe closes it
+	down = false
+	time.Sleep(60 * time.Millisecond)
+	if err = handler(ctx, d); err != nil || calls != 4 {
+		t.Fatalf("expected the probe to succeed, got %v after %v calls", err, calls)
+	}
+	if state := breaker.State(); state != queue.BreakerClosed {
+		t.Fatalf("expected the breaker to close, got %s", state)
+	}
+	if got := breakerGauge(t, reg); got != float64(queue.BreakerClosed) {
+		t.Fatalf("expected the closed state to be reported, got %v", got)
+	}
+}
+
+func TestManager_CircuitBreak_Dropped(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	breaker := queue.NewCircuitBreaker(queue.BreakerOpts{Name: "ipfs", Failures: 1})
+	handler := qm.CircuitBreak(func(ctx context.Context, d amqp.Delivery) error {
+		return queue.Drop(errors.New("malformed message"))
+	}, breaker)
+	for i := 0; i < 3; i++ {
+		handler(context.Background(), amqp.Delivery{})
+	}
+	// dropped messages are the message's fault, not the downstream's
+	if state := breaker.State(); state != queue.BreakerClosed {
+		t.Fatalf("expected the breaker to stay closed, got %s", state)
+	}
+}
+
+// breakerGauge is used to get the reported state of the ipfs breaker
+func breakerGauge(t *testing.T, reg *prometheus.Registry) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "temporal_queue_circuit_breaker_state" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			return m.GetGauge().GetValue()
+		}
+	}
+	t.Fatal("expected the breaker's state to be reported")
+	return 0
+}
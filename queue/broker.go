@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+)
+
+// Broker is the message broker a Manager publishes and consumes messages through.
+// Managers use rabbitmq through ChannelBroker by default, while MemoryBroker allows
+// testing handlers without a running broker.
+type Broker interface {
+	// DeclareQueue is used to declare a queue according to opts
+	DeclareQueue(name string, opts QueueOptions) error
+	// Publish is used to publish a message to an exchange, with the default
+	// exchange "" routing messages to the queue named by their routing key
+	Publish(ctx context.Context, exchangeName, routingKey string, msg amqp.Publishing) error
+	// Consume is used to start consuming messages from a queue, with at most
+	// prefetch messages delivered but unacknowledged at once
+	Consume(queueName, consumer string, prefetch int) (<-chan amqp.Delivery, error)
+}
+
+// ChannelBroker is a Broker publishing and consuming through a rabbitmq channel
+type ChannelBroker struct {
+	Channel *amqp.Channel
+	// Mandatory publishes messages with the mandatory flag, so that the broker
+	// returns those it can't route to any queue to the channel's NotifyReturn
+	// listeners rather than dropping them
+	Mandatory bool
+}
+
+// DeclareQueue is used to declare a queue, along with its dead letter exchange and
+// queue when dead lettering is enabled
+func (b ChannelBroker) DeclareQueue(name string, opts QueueOptions) error {
+	if opts.DeadLetter {
+		if err := declareDeadLetter(b.Channel, name); err != nil {
+			return err
+		}
+	}
+	_, err := b.Channel.QueueDeclare(
+		name,                  // name
+		!opts.Transient,       // durable
+		opts.AutoDelete,       // delete when unused
+		opts.Exclusive,        // exclusive
+		false,                 // no-wait
+		queueArgs(name, opts), // arguments
+	)
+	return err
+}
+
+// Publish is used to publish a message, returning once the channel has sent it or
+// ctx is done. publishing blocks while the broker applies flow control, so it is
+// done in the background allowing us to return as soon as ctx is done
+func (b ChannelBroker) Publish(ctx context.Context, exchangeName, routingKey string, msg amqp.Publishing) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Channel.Publish(
+			exchangeName, // exchange
+			routingKey,   // routing key
+			b.Mandatory,  // mandatory
+			false,        // immediate
+			msg,
+		)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consume is used to start consuming messages from a queue. we do not auto-ack, as
+// if a consumer dies we don't want the message to be lost
+func (b ChannelBroker) Consume(queueName, consumer string, prefetch int) (<-chan amqp.Delivery, error) {
+	if err := b.Channel.Qos(
+		prefetch, // prefetch count
+		0,        // prefetch size
+		false,    // global
+	); err != nil {
+		return nil, err
+	}
+	return b.Channel.Consume(
+		queueName, // queue
+		consumer,  // consumer
+		false,     // auto-ack
+		false,     // exclusive
+		false,     // no-local
+		false,     // no-wait
+		nil,       // args
+	)
+}
+
+// Cancel is used to stop delivering messages to a consumer, closing its channel
+// once the messages already sent to it have been delivered
+func (b ChannelBroker) Cancel(consumer string) error {
+	return b.Channel.Cancel(consumer, false)
+}
+
+// broker is used to get the broker the manager publishes and consumes through
+func (qm *Manager) broker() Broker {
+	if qm.Broker != nil {
+		return qm.Broker
+	}
+	return ChannelBroker{Channel: qm.channel(), Mandatory: qm.Mandatory}
+}
@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// PinFunc is used by BulkPinHandler to pin a single cid
+type PinFunc func(ctx context.Context, pin IPFSPin) error
+
+// BulkPinResult reports the outcome of each pin of a bulk pin
+type BulkPinResult struct {
+	Pinned []string `json:"pinned"`
+	// Failed maps each cid which failed to pin to the reason it failed
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// BulkPinHandler is used to pin the cids of bulk pin messages consumed from
+// IpfsBulkPinQueue using pin. Every cid is attempted, with the cids which failed
+// being refunded their share of the credit cost and their user notified in a
+// single email, while the result is returned to the publisher when they asked for
+// a reply. Messages whose pins partly fail are acknowledged, so that the refund
+// isn't repeated, while a message interrupted by ctx being cancelled is retried
+// later as a whole, as pinning is idempotent.
+func (qm *Manager) BulkPinHandler(pin PinFunc) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		req, err := DecodeDelivery[IPFSBulkPin](d)
+		if err != nil {
+			return Drop(err)
+		}
+		result := BulkPinResult{Failed: make(map[string]string)}
+		for _, c := range req.CIDs {
+			if ctx.Err() != nil {
+				return RetryLater(ctx.Err())
+			}
+			err := pin(ctx, IPFSPin{
+				CID:              c,
+				NetworkName:      req.NetworkName,
+				UserName:         req.UserName,
+				HoldTimeInMonths: req.HoldTimeInMonths,
+			})
+			if err != nil {
+				result.Failed[c] = err.Error()
+				continue
+			}
+			result.Pinned = append(result.Pinned, c)
+		}
+		qm.LogEntry(ctx).WithFields(log.Fields{
+			"pinned": len(result.Pinned),
+			"failed": len(result.Failed),
+		}).Info("bulk pin processed")
+		if len(result.Failed) > 0 {
+			qm.notifyBulkPinFailure(ctx, d, req, result)
+		}
+		return qm.Reply(ctx, d, result)
+	}
+}
+
+// notifyBulkPinFailure is used to refund the share of the credit cost of the pins
+// of a bulk pin which failed, and notify the user of them
+func (qm *Manager) notifyBulkPinFailure(ctx context.Context, d amqp.Delivery, req IPFSBulkPin, result BulkPinResult) {
+	if req.CreditCost > 0 {
+		refund := CreditRefund{
+			UserName:       req.UserName,
+			Amount:         req.CreditCost * float64(len(result.Failed)) / float64(len(req.CIDs)),
+			Reason:         fmt.Sprintf("%v of %v pins failed", len(result.Failed), len(req.CIDs)),
+			OriginalQueue:  qm.QueueName,
+			IdempotencyKey: qm.QueueName + ":" + messageKey(d),
+		}
+		if err := qm.publish(ctx, qm.channel(), "", CreditRefundQueue, refund); err != nil {
+			qm.logError(ctx, err, "failed to publish credit refund")
+		}
+	}
+	email := NewBulkPinFailedEmail(req.NetworkName, result.Failed, []string{req.UserName})
+	if err := qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); err != nil {
+		qm.logError(ctx, err, "failed to publish bulk pin failure email")
+	}
+}
@@ -0,0 +1,110 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestBulkPinHandler(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	for _, name := range []string{queue.CreditRefundQueue, queue.EmailSendQueue} {
+		if err := broker.DeclareQueue(name, queue.QueueOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	qm, err := queue.NewManager("", queue.WithQueue(queue.IpfsBulkPinQueue), queue.WithBroker(broker))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = qm.PublishMessageContext(ctx, queue.IPFSBulkPin{
+		CIDs:             []string{testCID, benchCID},
+		NetworkName:      "public",
+		UserName:         "user",
+		HoldTimeInMonths: 1,
+		CreditCost:       4,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var pinned []string
+	handler := qm.BulkPinHandler(func(ctx context.Context, pin queue.IPFSPin) error {
+		if pin.CID == benchCID {
+			return errors.New("pin timed out")
+		}
+		pinned = append(pinned, pin.CID)
+		return nil
+	})
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		defer cancel()
+		return handler(ctx, d)
+	})
+	if len(pinned) != 1 || pinned[0] != testCID {
+		t.Fatalf("unexpected pins %v", pinned)
+	}
+	// only the failed pin is refunded and notified
+	d, ok := broker.Get(queue.CreditRefundQueue)
+	if !ok {
+		t.Fatal("expected the failed pin to be refunded")
+	}
+	refund, err := queue.DecodeDelivery[queue.CreditRefund](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Amount != 2 || refund.UserName != "user" {
+		t.Fatalf("unexpected refund %+v", refund)
+	}
+	d, ok = broker.Get(queue.EmailSendQueue)
+	if !ok {
+		t.Fatal("expected the user to be notified of the failed pin")
+	}
+	email, err := queue.DecodeDelivery[queue.EmailSend](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures, _ := email.TemplateData["Failures"].(map[string]interface{})
+	if len(failures) != 1 || failures[benchCID] != "pin timed out" {
+		t.Fatalf("unexpected failures %v", email.TemplateData)
+	}
+	if broker.Len(queue.IpfsBulkPinQueue)+broker.Unacked() != 0 {
+		t.Fatal("expected the bulk pin to be acknowledged")
+	}
+}
+
+func TestIPFSBulkPin_Validate(t *testing.T) {
+	valid := queue.IPFSBulkPin{CIDs: []string{testCID}, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		modify func(*queue.IPFSBulkPin)
+	}{
+		{"NoCIDs", func(p *queue.IPFSBulkPin) { p.CIDs = nil }},
+		{"InvalidCID", func(p *queue.IPFSBulkPin) { p.CIDs = []string{testCID, "notacid"} }},
+		{"TooMany", func(p *queue.IPFSBulkPin) {
+			p.CIDs = make([]string, queue.MaxBulkPinSize+1)
+			for i := range p.CIDs {
+				p.CIDs[i] = testCID
+			}
+		}},
+		{"NoNetwork", func(p *queue.IPFSBulkPin) { p.NetworkName = "" }},
+		{"NoUserName", func(p *queue.IPFSBulkPin) { p.UserName = "" }},
+		{"NegativeCost", func(p *queue.IPFSBulkPin) { p.CreditCost = -1 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pin := valid
+			tt.modify(&pin)
+			if err := pin.Validate(); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
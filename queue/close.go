@@ -0,0 +1,132 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+)
+
+// Close is used to gracefully shut down the manager. Consumers are stopped, in-flight
+// messages are given until ctx is done to finish processing, and then the channel
+// and connection are closed, in that order. The contexts of handlers still running
+// once ctx is done are cancelled, and messages which haven't been acknowledged by
+// then are requeued by the broker. Calling Close more than once is a no-op.
+func (qm *Manager) Close(ctx context.Context) error {
+	qm.mu.Lock()
+	if qm.closed {
+		qm.mu.Unlock()
+		return nil
+	}
+	qm.closed = true
+	if qm.done == nil {
+		qm.done = make(chan struct{})
+	}
+	if qm.abort == nil {
+		qm.abort = make(chan struct{})
+	}
+	// signal our consumers to stop taking new messages, leaving the handlers of
+	// those already taken running
+	close(qm.done)
+	abort := qm.abort
+	qm.mu.Unlock()
+	// wait for in-flight handlers to finish, or for ctx to expire
+	var waitErr error
+	finished := make(chan struct{})
+	go func() {
+		qm.inflight.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		waitErr = ctx.Err()
+	}
+	// cancel the handlers we gave up waiting for
+	close(abort)
+	// closing the connection would close the channel anyway, however closing
+	// the channel first lets the broker know we're done with it cleanly
+	if ch := qm.channel(); ch != nil {
+		if err := ch.Close(); err != nil && err != amqp.ErrClosed {
+			return err
+		}
+	}
+	if conn := qm.connection(); conn != nil {
+		if err := conn.Close(); err != nil && err != amqp.ErrClosed {
+			return err
+		}
+	}
+	return waitErr
+}
+
+// closing is used to get a channel which is closed once the manager is shutting down
+func (qm *Manager) closing() chan struct{} {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if qm.done == nil {
+		qm.done = make(chan struct{})
+	}
+	return qm.done
+}
+
+// stopping is used to get whether the manager is shutting down
+func (qm *Manager) stopping() bool {
+	select {
+	case <-qm.closing():
+		return true
+	default:
+		return false
+	}
+}
+
+// aborting is used to get a channel which is closed once the manager has given up
+// waiting for in-flight handlers while shutting down
+func (qm *Manager) aborting() chan struct{} {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if qm.abort == nil {
+		qm.abort = make(chan struct{})
+	}
+	return qm.abort
+}
+
+// withStop is used to derive a context which is cancelled once the manager starts
+// shutting down, for loops taking new messages
+func (qm *Manager) withStop(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withSignal(ctx, qm.closing())
+}
+
+// withShutdown is used to derive a context for handlers, which is cancelled once the
+// manager has given up waiting for them while shutting down
+func (qm *Manager) withShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withSignal(ctx, qm.aborting())
+}
+
+// withSignal is used to derive a context which is cancelled once done is closed
+func withSignal(ctx context.Context, done <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// begin is used to register an in-flight message, returning false if the
+// manager is closed and the message shouldn't be processed
+func (qm *Manager) begin() bool {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if qm.closed {
+		return false
+	}
+	qm.inflight.Add(1)
+	return true
+}
+
+// end is used to mark an in-flight message as finished
+func (qm *Manager) end() {
+	qm.inflight.Done()
+}
@@ -0,0 +1,230 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"reflect"
+
+	"github.com/RTradeLtd/Temporal/queue/pb"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ContentTypeJSON is the content type of json encoded messages
+	ContentTypeJSON = "application/json"
+	// ContentTypeProtobuf is the content type of protobuf encoded messages
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+var (
+	// ErrUnsupportedMessage is returned by codecs asked to encode or decode a message
+	// type they don't support
+	ErrUnsupportedMessage = errors.New("message type is not supported by codec")
+	// ErrUnsupportedContentType is returned when decoding a message whose content type
+	// we have no codec for
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+)
+
+// Codec is used to marshal published messages and unmarshal consumed messages,
+// with consumers choosing the codec by the content type of each message so that
+// producers using different codecs interoperate
+type Codec interface {
+	// ContentType is the content type set on messages marshaled by the codec
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes messages as json, and is the default codec
+type JSONCodec struct{}
+
+// ContentType returns the content type of json encoded messages
+func (JSONCodec) ContentType() string { return ContentTypeJSON }
+
+// Marshal is used to encode a message as json
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal is used to decode a json encoded message
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// ProtobufCodec encodes our high volume messages, IPFSPin, IPFSFile and
+// IPFSClusterPin, as protobuf, returning ErrUnsupportedMessage for others.
+// Managers using it fall back to json for other messages.
+type ProtobufCodec struct{}
+
+// ContentType returns the content type of protobuf encoded messages
+func (ProtobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+// Marshal is used to encode a message as protobuf
+func (ProtobufCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := deref(v).(type) {
+	case IPFSPin:
+		return proto.Marshal(&pb.IPFSPin{
+			Cid:              m.CID,
+			NetworkName:      m.NetworkName,
+			UserName:         m.UserName,
+			HoldTimeInMonths: m.HoldTimeInMonths,
+			CreditCost:       m.CreditCost,
+		})
+	case IPFSFile:
+		return proto.Marshal(&pb.IPFSFile{
+			MinioHostIp:      m.MinioHostIP,
+			FileName:         m.FileName,
+			FileSize:         m.FileSize,
+			BucketName:       m.BucketName,
+			ObjectName:       m.ObjectName,
+			UserName:         m.UserName,
+			NetworkName:      m.NetworkName,
+			HoldTimeInMonths: m.HoldTimeInMonths,
+			CreditCost:       m.CreditCost,
+			Encrypted:        m.Encrypted,
+		})
+	case IPFSClusterPin:
+		return proto.Marshal(&pb.IPFSClusterPin{
+			Cid:              m.CID,
+			NetworkName:      m.NetworkName,
+			UserName:         m.UserName,
+			HoldTimeInMonths: m.HoldTimeInMonths,
+			CreditCost:       m.CreditCost,
+		})
+	}
+	return nil, ErrUnsupportedMessage
+}
+
+// Unmarshal is used to decode a protobuf encoded message into v, which must be a
+// pointer to one of the supported messages
+func (ProtobufCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *IPFSPin:
+		var p pb.IPFSPin
+		if err := proto.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		*m = IPFSPin{
+			CID:              p.Cid,
+			NetworkName:      p.NetworkName,
+			UserName:         p.UserName,
+			HoldTimeInMonths: p.HoldTimeInMonths,
+			CreditCost:       p.CreditCost,
+		}
+	case *IPFSFile:
+		var p pb.IPFSFile
+		if err := proto.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		*m = IPFSFile{
+			MinioHostIP:      p.MinioHostIp,
+			FileName:         p.FileName,
+			FileSize:         p.FileSize,
+			BucketName:       p.BucketName,
+			ObjectName:       p.ObjectName,
+			UserName:         p.UserName,
+			NetworkName:      p.NetworkName,
+			HoldTimeInMonths: p.HoldTimeInMonths,
+			CreditCost:       p.CreditCost,
+			Encrypted:        p.Encrypted,
+		}
+	case *IPFSClusterPin:
+		var p pb.IPFSClusterPin
+		if err := proto.Unmarshal(data, &p); err != nil {
+			return err
+		}
+		*m = IPFSClusterPin{
+			CID:              p.Cid,
+			NetworkName:      p.NetworkName,
+			UserName:         p.UserName,
+			HoldTimeInMonths: p.HoldTimeInMonths,
+			CreditCost:       p.CreditCost,
+		}
+	default:
+		return ErrUnsupportedMessage
+	}
+	return nil
+}
+
+// protobufTypes maps the type names protobuf encoded messages are published with
+// to their types, so that they can be decoded without knowing their type up front
+var protobufTypes = map[string]reflect.Type{
+	"IPFSPin":        reflect.TypeOf(IPFSPin{}),
+	"IPFSFile":       reflect.TypeOf(IPFSFile{}),
+	"IPFSClusterPin": reflect.TypeOf(IPFSClusterPin{}),
+}
+
+// codecFor is used to get the codec for a content type, ignoring parameters such as
+// the charset. messages published before we had codecs are json encoded, with a
+// text/plain or missing content type
+func codecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSONCodec{}, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %s", ErrUnsupportedContentType, contentType, err)
+	}
+	switch mediaType {
+	case ContentTypeJSON, "text/plain":
+		return JSONCodec{}, nil
+	case ContentTypeProtobuf:
+		return ProtobufCodec{}, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnsupportedContentType, contentType)
+}
+
+// UnmarshalDelivery is used to unmarshal a consumed message into v, using the codec
+// for the message's content type
+func UnmarshalDelivery(d amqp.Delivery, v interface{}) error {
+	codec, err := codecFor(d.ContentType)
+	if err != nil {
+		return err
+	}
+	return codec.Unmarshal(d.Body, v)
+}
+
+// DecodeDelivery is used to decode a consumed message into a value of type T using
+// the codec for its content type, validating it if T is able to validate itself
+func DecodeDelivery[T any](d amqp.Delivery) (T, error) {
+	var msg T
+	if err := UnmarshalDelivery(d, &msg); err != nil {
+		return msg, err
+	}
+	if v, ok := any(msg).(validator); ok {
+		if err := v.Validate(); err != nil {
+			return msg, validationError(err)
+		}
+	}
+	return msg, nil
+}
+
+// peek is used to read common fields, such as the user name, from a message of any
+// type into v, which has json tags for those fields. protobuf encoded messages are
+// decoded as the type they were published as and converted, which is slower than
+// reading json encoded messages, but only done for the fields of optional features
+func peek(d amqp.Delivery, v interface{}) error {
+	if d.ContentType != ContentTypeProtobuf {
+		return json.Unmarshal(d.Body, v)
+	}
+	typ, ok := protobufTypes[d.Type]
+	if !ok {
+		return ErrUnsupportedMessage
+	}
+	msg := reflect.New(typ)
+	if err := (ProtobufCodec{}).Unmarshal(d.Body, msg.Interface()); err != nil {
+		return err
+	}
+	converted, err := json.Marshal(msg.Interface())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(converted, v)
+}
+
+// messageType is used to get the type name a message is published with
+func messageType(body interface{}) string {
+	if t := reflect.TypeOf(deref(body)); t != nil {
+		return t.Name()
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestProtobufCodec_RoundTrip(t *testing.T) {
+	codec := queue.ProtobufCodec{}
+	pin := queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 3, CreditCost: 1.5}
+	file := queue.IPFSFile{
+		MinioHostIP: "127.0.0.1", FileName: "file.txt", FileSize: 1024, BucketName: "bucket", ObjectName: "object",
+		UserName: "user", NetworkName: "public", HoldTimeInMonths: 12, CreditCost: 2.25, Encrypted: true,
+	}
+	clusterPin := queue.IPFSClusterPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 6, CreditCost: 0.5}
+	t.Run("IPFSPin", func(t *testing.T) {
+		var got queue.IPFSPin
+		roundTrip(t, codec, pin, &got)
+		if got != pin {
+			t.Fatalf("got %+v, want %+v", got, pin)
+		}
+	})
+	t.Run("IPFSFile", func(t *testing.T) {
+		var got queue.IPFSFile
+		roundTrip(t, codec, &file, &got)
+		if got != file {
+			t.Fatalf("got %+v, want %+v", got, file)
+		}
+	})
+	t.Run("IPFSClusterPin", func(t *testing.T) {
+		var got queue.IPFSClusterPin
+		roundTrip(t, codec, clusterPin, &got)
+		if got != clusterPin {
+			t.Fatalf("got %+v, want %+v", got, clusterPin)
+		}
+	})
+	t.Run("Unsupported", func(t *testing.T) {
+		if _, err := codec.Marshal(queue.EmailSend{}); err != queue.ErrUnsupportedMessage {
+			t.Fatalf("expected ErrUnsupportedMessage, got %v", err)
+		}
+		if err := codec.Unmarshal(nil, &queue.EmailSend{}); err != queue.ErrUnsupportedMessage {
+			t.Fatalf("expected ErrUnsupportedMessage, got %v", err)
+		}
+	})
+}
+
+func roundTrip(t *testing.T, codec queue.Codec, msg, out interface{}) {
+	t.Helper()
+	data, err := codec.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = codec.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeDelivery(t *testing.T) {
+	pin := queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}
+	jsonBody, err := json.Marshal(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbBody, err := queue.ProtobufCodec{}.Marshal(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantErr     bool
+	}{
+		{"NoContentType", "", jsonBody, false},
+		{"Legacy", "text/plain", jsonBody, false},
+		{"JSON", queue.ContentTypeJSON, jsonBody, false},
+		{"Protobuf", queue.ContentTypeProtobuf, pbBody, false},
+		{"Unknown", "application/xml", jsonBody, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := queue.DecodeDelivery[queue.IPFSPin](amqp.Delivery{ContentType: tt.contentType, Body: tt.body})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeDelivery() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != pin {
+				t.Fatalf("got %+v, want %+v", got, pin)
+			}
+		})
+	}
+	// invalid messages are rejected whatever their encoding
+	invalid, _ := queue.ProtobufCodec{}.Marshal(queue.IPFSPin{CID: testCID})
+	if _, err = queue.DecodeDelivery[queue.IPFSPin](amqp.Delivery{ContentType: queue.ContentTypeProtobuf, Body: invalid}); err == nil {
+		t.Fatal("expected invalid message to be rejected")
+	}
+}
+
+// consumers decode messages with the codec they were published with, so json
+// and protobuf producers can share a queue
+func TestPublishMessageContext_MixedCodecs(t *testing.T) {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	jsonQM, err := queue.NewManager(url, queue.WithQueue("codec-test-queue"), queue.WithDurable(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jsonQM.Close(context.Background())
+	if _, err = jsonQM.Channel.QueuePurge(jsonQM.QueueName, false); err != nil {
+		t.Fatal(err)
+	}
+	pbQM, err := queue.NewManager(url, queue.WithQueue("codec-test-queue"), queue.WithDurable(false), queue.WithCodec(queue.ProtobufCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pbQM.Close(context.Background())
+	pin := queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}
+	for _, qm := range []*queue.Manager{jsonQM, pbQM} {
+		if err = qm.PublishMessageContext(context.Background(), pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	contentTypes := map[string]bool{}
+	jsonQM.ConsumeMessageContext(ctx, "codec-test", func(ctx context.Context, d amqp.Delivery) error {
+		got, err := queue.DecodeDelivery[queue.IPFSPin](d)
+		if err != nil {
+			t.Error(err)
+		} else if got != pin {
+			t.Errorf("got %+v, want %+v", got, pin)
+		}
+		if contentTypes[d.ContentType] = true; len(contentTypes) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if !contentTypes[queue.ContentTypeJSON] || !contentTypes[queue.ContentTypeProtobuf] {
+		t.Fatalf("expected json and protobuf messages, got %v", contentTypes)
+	}
+}
+
+func BenchmarkCodec_IPFSPin(b *testing.B) {
+	pin := queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1, CreditCost: 1}
+	for _, codec := range []queue.Codec{queue.JSONCodec{}, queue.ProtobufCodec{}} {
+		b.Run(codec.ContentType(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, err := codec.Marshal(pin)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var got queue.IPFSPin
+				if err = codec.Unmarshal(data, &got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
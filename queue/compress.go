@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/streadway/amqp"
+)
+
+// EncodingGzip is the content encoding of messages whose body is gzip compressed
+const EncodingGzip = "gzip"
+
+// MaxDecompressedSize limits how large a compressed message may be once
+// decompressed, so that a small message can't exhaust a consumer's memory
+var MaxDecompressedSize int64 = 32 << 20
+
+// Compress is used to gzip compress a message's body, setting its content encoding
+// so that consumers decompress it
+func Compress(msg *amqp.Publishing) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(msg.Body); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	msg.Body = buf.Bytes()
+	msg.ContentEncoding = EncodingGzip
+	return nil
+}
+
+// Decompress is used to decompress a delivery's body in place if it was compressed
+// when published, clearing its content encoding. Our consumers decompress messages
+// before handling them, so this is only needed when reading from a channel directly.
+func Decompress(d *amqp.Delivery) error {
+	switch d.ContentEncoding {
+	case "":
+		return nil
+	case EncodingGzip:
+	default:
+		return fmt.Errorf("unsupported content encoding %s", d.ContentEncoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(io.LimitReader(zr, MaxDecompressedSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > MaxDecompressedSize {
+		return fmt.Errorf("decompressed message exceeds %v bytes", MaxDecompressedSize)
+	}
+	d.Body = body
+	d.ContentEncoding = ""
+	return nil
+}
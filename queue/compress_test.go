@@ -0,0 +1,144 @@
+package queue_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// largeRecord is a representative record creation with sizable metadata
+func largeRecord() queue.RecordCreation {
+	meta := make(map[string]interface{})
+	for i := 0; i < 40; i++ {
+		meta[strings.Repeat("k", i+1)] = strings.Repeat("metadata value ", 12)
+	}
+	return queue.RecordCreation{
+		ZoneName:      "example.tns",
+		RecordName:    "www",
+		RecordKeyName: "record-key",
+		RecordType:    "DNSLINK",
+		Value:         "dnslink=/ipfs/" + testCID,
+		MetaData:      meta,
+		UserName:      "user",
+	}
+}
+
+func TestCompress_RoundTrip(t *testing.T) {
+	body, err := json.Marshal(largeRecord())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := amqp.Publishing{Body: append([]byte(nil), body...)}
+	if err = queue.Compress(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentEncoding != queue.EncodingGzip {
+		t.Fatalf("expected content encoding %s, got %s", queue.EncodingGzip, msg.ContentEncoding)
+	}
+	if len(msg.Body) >= len(body) {
+		t.Fatalf("compressed body is %v bytes, not smaller than %v", len(msg.Body), len(body))
+	}
+	d := amqp.Delivery{Body: msg.Body, ContentEncoding: msg.ContentEncoding}
+	if err = queue.Decompress(&d); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(d.Body, body) || d.ContentEncoding != "" {
+		t.Fatal("decompressed message differs from the original")
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	t.Run("Uncompressed", func(t *testing.T) {
+		d := amqp.Delivery{Body: []byte("{}")}
+		if err := queue.Decompress(&d); err != nil || string(d.Body) != "{}" {
+			t.Fatalf("Decompress() = %v, body %q", err, d.Body)
+		}
+	})
+	t.Run("UnknownEncoding", func(t *testing.T) {
+		d := amqp.Delivery{Body: []byte("{}"), ContentEncoding: "br"}
+		if err := queue.Decompress(&d); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("Corrupt", func(t *testing.T) {
+		d := amqp.Delivery{Body: []byte("not gzip"), ContentEncoding: queue.EncodingGzip}
+		if err := queue.Decompress(&d); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+	t.Run("TooLarge", func(t *testing.T) {
+		defer func(max int64) { queue.MaxDecompressedSize = max }(queue.MaxDecompressedSize)
+		msg := amqp.Publishing{Body: bytes.Repeat([]byte("a"), 1024)}
+		if err := queue.Compress(&msg); err != nil {
+			t.Fatal(err)
+		}
+		queue.MaxDecompressedSize = 512
+		d := amqp.Delivery{Body: msg.Body, ContentEncoding: msg.ContentEncoding}
+		if err := queue.Decompress(&d); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+// messages over the threshold are compressed in transit, and handed to
+// handlers decompressed
+func TestPublishMessageContext_Compression(t *testing.T) {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	qm, err := queue.NewManager(url,
+		queue.WithQueue("compression-test-queue"),
+		queue.WithDurable(false),
+		queue.WithCompression(1024),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qm.Close(context.Background())
+	if _, err = qm.Channel.QueuePurge(qm.QueueName, false); err != nil {
+		t.Fatal(err)
+	}
+	record := largeRecord()
+	if err = qm.PublishMessageContext(context.Background(), record); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got queue.RecordCreation
+	qm.ConsumeMessageContext(ctx, "compression-test", func(ctx context.Context, d amqp.Delivery) error {
+		defer cancel()
+		return json.Unmarshal(d.Body, &got)
+	})
+	if got.Value != record.Value || len(got.MetaData) != len(record.MetaData) {
+		t.Fatalf("unexpected record %+v", got)
+	}
+}
+
+// BenchmarkCompress reports the compressed size of a representative record
+// as a percentage of its original size
+func BenchmarkCompress(b *testing.B) {
+	body, err := json.Marshal(largeRecord())
+	if err != nil {
+		b.Fatal(err)
+	}
+	var compressed int
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg := amqp.Publishing{Body: body}
+		if err = queue.Compress(&msg); err != nil {
+			b.Fatal(err)
+		}
+		compressed = len(msg.Body)
+	}
+	b.ReportMetric(float64(len(body)), "bytes/op-original")
+	b.ReportMetric(float64(compressed), "bytes/op-compressed")
+	b.ReportMetric(100*float64(compressed)/float64(len(body)), "%size")
+}
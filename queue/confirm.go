@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrNacked is returned when the broker fails to enqueue a published message
+	ErrNacked = errors.New("message was nacked by the broker")
+	// ErrConfirmTimeout is returned when the broker doesn't confirm a published
+	// message in time, in which case it may or may not have been enqueued
+	ErrConfirmTimeout = errors.New("timed out waiting for publish confirmation")
+)
+
+// confirmer is used to wait for publisher confirms on a channel in confirm mode
+type confirmer struct {
+	// mu serializes publishes, so that each publish can wait for its own confirmation
+	mu      sync.Mutex
+	ch      *amqp.Channel
+	acks    chan amqp.Confirmation
+	timeout time.Duration
+	// mandatory publishes messages with the mandatory flag. the broker confirms
+	// messages it returns as unroutable, as they were handled, so they aren't nacked
+	mandatory bool
+	// tag is the delivery tag of the last message published on the channel
+	tag uint64
+}
+
+// EnableConfirms is used to place the manager's channel in confirm mode, after which
+// publishing a message blocks until the broker acknowledges it, or timeout elapses.
+// ErrNacked is returned should the broker fail to enqueue a message, so the caller
+// can retry. This guarantees delivery at the cost of throughput, so it is intended
+// for critical queues such as those used for payments.
+func (qm *Manager) EnableConfirms(timeout time.Duration) error {
+	c, err := newConfirmer(qm.channel(), timeout, qm.Mandatory)
+	if err != nil {
+		return err
+	}
+	qm.mu.Lock()
+	qm.confirm = c
+	qm.mu.Unlock()
+	return nil
+}
+
+// newConfirmer is used to place ch in confirm mode
+func newConfirmer(ch *amqp.Channel, timeout time.Duration, mandatory bool) (*confirmer, error) {
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+	return &confirmer{
+		ch:        ch,
+		acks:      ch.NotifyPublish(make(chan amqp.Confirmation, 1)),
+		timeout:   timeout,
+		mandatory: mandatory,
+	}, nil
+}
+
+// confirmerFor is used to get the confirmer for ch, if it is in confirm mode
+func (qm *Manager) confirmerFor(ch *amqp.Channel) *confirmer {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	if qm.confirm == nil || qm.confirm.ch != ch {
+		return nil
+	}
+	return qm.confirm
+}
+
+// publish is used to publish a message and wait for the broker to confirm it
+func (c *confirmer) publish(ctx context.Context, exchangeName, routingKey string, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the publish itself isn't abandoned when ctx is done, as we need to know
+	// whether the message was sent in order to match it to its confirmation
+	if err := c.ch.Publish(exchangeName, routingKey, c.mandatory, false, msg); err != nil {
+		return err
+	}
+	c.tag++
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case conf, ok := <-c.acks:
+			if !ok {
+				return amqp.ErrClosed
+			}
+			// skip confirmations for earlier messages we stopped waiting for
+			if conf.DeliveryTag < c.tag {
+				continue
+			}
+			if !conf.Ack {
+				return ErrNacked
+			}
+			return nil
+		case <-timer.C:
+			return ErrConfirmTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Handler is used to process a single message. The context is cancelled when
+// the consumer is asked to stop.
+type Handler func(ctx context.Context, d amqp.Delivery) error
+
+// EventFactory is used to derive a lifecycle event from a processed message and
+// the error returned by its handler, if any. It returns the queue to publish the
+// event to along with the event itself, and a nil event skips publishing.
+type EventFactory func(d amqp.Delivery, err error) (queueName string, event interface{})
+
+// ConsumeOption is used to configure how messages are consumed
+type ConsumeOption func(*consumeOpts)
+
+type consumeOpts struct {
+	events EventFactory
+}
+
+// WithCompletionEvent is used to publish a success or failure event, derived
+// from each message by factory, once its handler has returned
+func WithCompletionEvent(factory EventFactory) ConsumeOption {
+	return func(o *consumeOpts) {
+		o.events = factory
+	}
+}
+
+// Consume is used to consume messages from the queue, passing each one to handler.
+// It is equivalent to ConsumeMessageContext with a background context.
+func (qm *Manager) Consume(consumer string, handler Handler, opts ...ConsumeOption) error {
+	return qm.ConsumeMessageContext(context.Background(), consumer, handler, opts...)
+}
+
+// ConsumeMessageContext is used to consume messages from the queue, passing each
+// one to handler until ctx is cancelled, at which point ctx.Err() is returned, until
+// the manager is closed, or until the consumer is cancelled with Cancel, at which
+// point nil is returned. consumer is the consumer's tag, which must be unique among
+// the manager's running consumers, with one being made by ConsumerTag when empty.
+// Like our other consumers, messages are acknowledged whether or not the handler
+// succeeds, with failures being logged, unless the queue has dead lettering enabled
+// in which case failed messages are dead lettered.
+// Handlers may instead choose what happens to a message they fail to process by
+// returning ErrDrop, ErrRequeue or ErrRetryLater, as described alongside them.
+// Messages with a content type we have no codec for are rejected before reaching
+// handler, being dead lettered if enabled, with those without a content type being
+// treated as json.
+// The manager's middleware is applied around handler. Should it panic, the message
+// is rejected, being dead lettered if enabled, and the admin is emailed.
+//
+// When the manager has more than one worker, messages are processed concurrently
+// and so may complete, and be acknowledged, in a different order to that in which
+// they were queued. Handlers must not rely on messages being processed in order.
+// Before returning, we wait for the handlers of messages already being processed.
+func (qm *Manager) ConsumeMessageContext(ctx context.Context, consumer string, handler Handler, opts ...ConsumeOption) error {
+	var o consumeOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if consumer == "" {
+		consumer = qm.ConsumerTag()
+	}
+	if err := qm.register(consumer); err != nil {
+		return err
+	}
+	defer qm.unregister(consumer)
+	handler = Chain(handler, qm.Middleware...)
+	// handlers are given a context which is also cancelled should closing the
+	// manager give up waiting for them, while we stop taking messages as soon
+	// as it starts closing, and report on the caller's context
+	parent := ctx
+	ctx, cancel := qm.withShutdown(ctx)
+	defer cancel()
+	loopCtx, stop := qm.withStop(ctx)
+	defer stop()
+	// each delivery is processed and acknowledged by a single worker, and
+	// we're sent enough deliveries to keep every worker busy
+	workers := qm.workers()
+	prefetch := qm.prefetch()
+	if prefetch < workers {
+		prefetch = workers
+	}
+	jobs := make(chan amqp.Delivery)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				qm.handle(ctx, o, handler, d)
+				qm.end()
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+	gen := qm.generation()
+	msgs, err := qm.consume(consumer, prefetch)
+	if err != nil {
+		return err
+	}
+	qm.LogEntry(ctx).WithField("consumer", consumer).Info("processing messages")
+	for {
+		select {
+		case <-loopCtx.Done():
+			// any messages we've been sent but haven't processed remain
+			// unacknowledged, and are requeued once the channel closes
+			return parent.Err()
+		case d, ok := <-msgs:
+			// our deliveries stop when the connection drops, so wait for
+			// it to be re-established if reconnection is enabled, unless
+			// they stopped as the consumer was cancelled
+			if !ok {
+				if qm.cancelled(consumer) {
+					qm.LogEntry(ctx).WithField("consumer", consumer).Info("consumer cancelled")
+					return nil
+				}
+				if msgs, gen, err = qm.resume(loopCtx, gen, consumer, prefetch); loopCtx.Err() != nil {
+					return parent.Err()
+				} else if err != nil || msgs == nil {
+					return err
+				}
+				qm.LogInfo("resumed processing messages")
+				continue
+			}
+			if !qm.begin() {
+				return parent.Err()
+			}
+			select {
+			case jobs <- d:
+			case <-loopCtx.Done():
+				qm.end()
+				return parent.Err()
+			}
+		}
+	}
+}
+
+// handle is used to process and acknowledge a single message
+func (qm *Manager) handle(ctx context.Context, o consumeOpts, handler Handler, d amqp.Delivery) {
+	ctx = headersContext(timestampContext(deliveryContext(ctx, d), d), d)
+	qm.LogEntry(ctx).Info("new message received")
+	qm.Metrics.observeConsumed(qm.QueueName, qm.Service)
+	qm.Metrics.observeLatency(qm.QueueName, qm.Service, d.Timestamp)
+	qm.watchLag(ctx, d.Timestamp)
+	migrated, key, ok := qm.admit(ctx, &d)
+	if !ok {
+		return
+	}
+	ctx, span := qm.startSpan(ctx, d)
+	// the claim is released should the message fail, so that it can be processed
+	// again. handlers which time out hold on to it until they actually return, so
+	// that a redelivered copy isn't processed alongside them
+	release := func(err error) {
+		if key == "" || err == nil {
+			return
+		}
+		if relErr := qm.Idempotency.Release(key); relErr != nil {
+			qm.logError(ctx, relErr, "failed to release idempotency key")
+		}
+	}
+	start := time.Now()
+	// a panicking handler mustn't take down the consumer, and with it every
+	// message it has yet to acknowledge, nor may one which is stuck
+	err := qm.withTimeout(Recover(handler), release)(ctx, migrated)
+	qm.Metrics.observeDuration(qm.QueueName, qm.Service, start)
+	endSpan(span, err)
+	if err != nil {
+		qm.logError(ctx, err, "failed to process message")
+		var panicErr *PanicError
+		if errors.As(err, &panicErr) {
+			qm.Metrics.observePanic(qm.QueueName, qm.Service)
+			qm.notifyPanic(ctx, d, panicErr)
+		}
+		if !qm.timedOut(err) {
+			release(err)
+		}
+	}
+	// the event is published before acknowledging the message so that
+	// a crash in between results in a redelivery rather than a lost event.
+	// it isn't bound to ctx as the message is acknowledged regardless, but
+	// does carry on the trace. requeued messages have yet to complete, as
+	// have those whose handler timed out, which may yet be running
+	if o.events != nil && !qm.requeues(err) && !qm.timedOut(err) {
+		eventCtx := WithCorrelationID(context.Background(), CorrelationID(ctx))
+		eventCtx = trace.ContextWithSpanContext(eventCtx, span.SpanContext())
+		qm.publishEvent(eventCtx, o.events, d, err)
+	}
+	// a consumer failing message after message likely has a broken dependency
+	if qm.admin.failed(err != nil) {
+		qm.notifyAdmin(ctx, HandlerFailingSubject, fmt.Sprintf(
+			"Handler for queue %s of service %s has failed to process %v messages in a row, most recently with: %s",
+			qm.QueueName, qm.Service, AdminFailureThreshold, err,
+		))
+	}
+	if err = qm.settle(ctx, d, err); err != nil {
+		qm.logError(ctx, err, "failed to acknowledge message")
+	}
+}
+
+// admit is used to run a delivery through the checks it must pass before reaching
+// a handler, returning the message to give the handler, upgraded to the current
+// schema, along with its idempotency claim's key. d is decompressed in place, so
+// that it is settled as the handler saw it. false is returned if the delivery was
+// settled instead, such as when it failed verification or was already processed
+func (qm *Manager) admit(ctx context.Context, d *amqp.Delivery) (amqp.Delivery, string, bool) {
+	// refuse forged or tampered messages before they reach the handler,
+	// which like verification is given the message decompressed
+	err := Decompress(d)
+	if err == nil {
+		err = qm.verify(*d)
+	}
+	if err != nil {
+		qm.refuse(ctx, *d, err, "rejecting message which failed verification")
+		return amqp.Delivery{}, "", false
+	}
+	// refuse messages we have no codec for, such as those of a misconfigured
+	// producer, rather than having the handler fail to parse them
+	if _, err = codecFor(d.ContentType); err != nil {
+		qm.refuse(ctx, *d, err, "rejecting message with unsupported content type")
+		return amqp.Delivery{}, "", false
+	}
+	// refuse messages of producers whose clock is badly skewed, as whatever they
+	// did based on it, such as computing hold times, is likely wrong too
+	if err = qm.TimestampTolerance.check(*d, time.Now()); err != nil {
+		qm.refuse(ctx, *d, err, "rejecting message with skewed timestamp")
+		return amqp.Delivery{}, "", false
+	}
+	// upgrade messages published with older schemas, refusing those we don't
+	// understand. the handler is given the upgraded message, while the original
+	// is kept for settling so that it can be dead lettered as published
+	migrated, err := qm.migrate(*d)
+	if err != nil {
+		qm.refuse(ctx, *d, err, "rejecting message with unsupported schema version")
+		return amqp.Delivery{}, "", false
+	}
+	// refuse messages for networks their user isn't authorized to use
+	if !qm.authorize(ctx, *d) {
+		return amqp.Delivery{}, "", false
+	}
+	// hold back the messages of users exceeding their rate limit
+	if !qm.throttle(ctx, *d) {
+		return amqp.Delivery{}, "", false
+	}
+	// refuse messages their user can't afford before doing any work for them
+	if !qm.charge(ctx, *d) {
+		return amqp.Delivery{}, "", false
+	}
+	// skip messages we've already processed, such as those redelivered after
+	// a reconnection, while leaving them queued if we can't tell
+	key, claimed, err := qm.claim(*d)
+	if err != nil {
+		qm.logError(ctx, err, "failed to check whether message was already processed")
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+		if err = d.Nack(false, true); err != nil {
+			qm.logError(ctx, err, "failed to requeue message")
+		}
+		return amqp.Delivery{}, "", false
+	}
+	if !claimed {
+		qm.LogEntry(ctx).Info("skipping message which was already processed")
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, true)
+		if err = d.Ack(false); err != nil {
+			qm.logError(ctx, err, "failed to acknowledge message")
+		}
+		return amqp.Delivery{}, "", false
+	}
+	return migrated, key, true
+}
+
+// refuse is used to reject a delivery which isn't fit to be handled, logging why
+func (qm *Manager) refuse(ctx context.Context, d amqp.Delivery, reason error, msg string) {
+	qm.logError(ctx, reason, msg)
+	qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+	if err := qm.reject(d, reason); err != nil {
+		qm.logError(ctx, err, "failed to reject message")
+	}
+}
+
+// consume is used to start consuming messages from the queue, limiting the number
+// of unacknowledged messages the broker sends us to prefetch
+func (qm *Manager) consume(consumer string, prefetch int) (<-chan amqp.Delivery, error) {
+	// consumers cancelled while we were reconnecting aren't resumed
+	if qm.cancelled(consumer) {
+		return nil, nil
+	}
+	if qm.Broker == nil && qm.channel() == nil {
+		return nil, ErrNotConnected
+	}
+	msgs, err := qm.broker().Consume(qm.QueueName, consumer, prefetch)
+	return msgs, connectionError(err)
+}
+
+// canceller is implemented by brokers able to cancel a single consumer
+type canceller interface {
+	Cancel(consumer string) error
+}
+
+// ConsumerTag is used to make a unique consumer tag for the manager, of the form
+// <service>-<queue>-<uuid>, identifying the consumer in the broker's management
+// interface and allowing it to be cancelled with Cancel
+func (qm *Manager) ConsumerTag() string {
+	return fmt.Sprintf("%s-%s-%s", qm.Service, qm.QueueName, uuid.New().String())
+}
+
+// Cancel is used to stop the consumer with the given tag, such as when scaling down,
+// without closing the channel or affecting the manager's other consumers. Messages
+// already sent to the consumer are still processed, after which its
+// ConsumeMessageContext returns nil. An error is returned if no consumer with the
+// tag is running.
+func (qm *Manager) Cancel(consumer string) error {
+	qm.mu.Lock()
+	if !qm.consumers[consumer] {
+		qm.mu.Unlock()
+		return fmt.Errorf("no running consumer with tag %s", consumer)
+	}
+	qm.consumers[consumer] = false
+	qm.mu.Unlock()
+	if qm.Broker == nil && qm.channel() == nil {
+		// the consumer stops with the connection, and won't be resumed
+		return nil
+	}
+	c, ok := qm.broker().(canceller)
+	if !ok {
+		return errors.New("broker does not support cancelling consumers")
+	}
+	if err := c.Cancel(consumer); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return connectionError(err)
+	}
+	return nil
+}
+
+// register is used to record a consumer as running, refusing tags already in use
+// as the broker closes channels consuming with a duplicate tag
+func (qm *Manager) register(consumer string) error {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if _, ok := qm.consumers[consumer]; ok {
+		return fmt.Errorf("consumer tag %s is already in use", consumer)
+	}
+	if qm.consumers == nil {
+		qm.consumers = make(map[string]bool)
+	}
+	qm.consumers[consumer] = true
+	return nil
+}
+
+// unregister is used to forget a consumer once it has stopped
+func (qm *Manager) unregister(consumer string) {
+	qm.mu.Lock()
+	delete(qm.consumers, consumer)
+	qm.mu.Unlock()
+}
+
+// cancelled is used to check whether a consumer has been cancelled
+func (qm *Manager) cancelled(consumer string) bool {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	running, ok := qm.consumers[consumer]
+	return ok && !running
+}
+
+// publishEvent is used to publish the lifecycle event for a processed message
+func (qm *Manager) publishEvent(ctx context.Context, factory EventFactory, d amqp.Delivery, handlerErr error) {
+	queueName, event := factory(d, handlerErr)
+	if event == nil {
+		return
+	}
+	if err := qm.publish(ctx, qm.channel(), "", queueName, event); err != nil {
+		qm.LogEntry(ctx).WithFields(log.Fields{
+			"queue": queueName,
+			"error": err.Error(),
+		}).Error("failed to publish completion event")
+	}
+}
+
+// workers is used to get the number of messages processed concurrently by
+// consumers, which defaults to 1
+func (qm *Manager) workers() int {
+	if qm.Workers < 1 {
+		return 1
+	}
+	return qm.Workers
+}
+
+// prefetch is used to get the number of unacknowledged messages we allow the
+// broker to send us, which defaults to 1
+func (qm *Manager) prefetch() int {
+	if qm.PrefetchCount < 1 {
+		return 1
+	}
+	return qm.PrefetchCount
+}
@@ -0,0 +1,143 @@
+package queue_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestManager_Cancel(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithService("pinner"))
+	tag := qm.ConsumerTag()
+	if !strings.HasPrefix(tag, "pinner-"+queue.IpfsPinQueue+"-") {
+		t.Fatalf("unexpected consumer tag %s", tag)
+	}
+	if err := qm.Cancel(tag); err == nil {
+		t.Fatal("expected an error cancelling a consumer which isn't running")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var (
+		mu      sync.Mutex
+		handled = make(map[string]int)
+	)
+	handler := func(consumer string) queue.Handler {
+		return func(ctx context.Context, d amqp.Delivery) error {
+			mu.Lock()
+			handled[consumer]++
+			mu.Unlock()
+			return nil
+		}
+	}
+	cancelled := make(chan error, 1)
+	go func() { cancelled <- qm.ConsumeMessageContext(ctx, tag, handler("cancelled")) }()
+	remaining := make(chan error, 1)
+	go func() { remaining <- qm.ConsumeMessageContext(ctx, "remaining", handler("remaining")) }()
+	// consumers can only be cancelled once they're running
+	for qm.Cancel(tag) != nil && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-cancelled:
+		if err != nil {
+			t.Fatalf("expected a cancelled consumer to return nil, got %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("consumer wasn't cancelled")
+	}
+	// the other consumer keeps processing messages
+	for i := 0; i < 3; i++ {
+		if err := qm.PublishMessageContext(ctx, testPin("user")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for broker.Len(queue.IpfsPinQueue)+broker.Unacked() != 0 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := qm.ConsumeMessageContext(ctx, "remaining", handler("duplicate")); err == nil {
+		t.Fatal("expected a running consumer's tag to be refused")
+	}
+	cancel()
+	<-remaining
+	mu.Lock()
+	defer mu.Unlock()
+	if handled["remaining"] != 3 || handled["cancelled"] != 0 || handled["duplicate"] != 0 {
+		t.Fatalf("unexpected messages handled %v", handled)
+	}
+}
+
+// closing waits for in-flight handlers, only cancelling them once ctx is done
+func TestManager_Close_Drains(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	if err := qm.PublishMessageContext(context.Background(), testPin("drain")); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	var finished, cancelled bool
+	consumed := make(chan error, 1)
+	go func() {
+		consumed <- qm.ConsumeMessageContext(context.Background(), "", func(ctx context.Context, d amqp.Delivery) error {
+			close(started)
+			select {
+			case <-time.After(100 * time.Millisecond):
+				finished = true
+			case <-ctx.Done():
+				cancelled = true
+			}
+			return nil
+		})
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !finished || cancelled {
+		t.Fatal("expected the handler to finish before Close returned")
+	}
+	if err := <-consumed; err != nil {
+		t.Fatal(err)
+	}
+	if broker.Unacked() != 0 {
+		t.Fatal("expected the message to be acknowledged")
+	}
+}
+
+// handlers still running once ctx is done have their context cancelled
+func TestManager_Close_Aborts(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	if err := qm.PublishMessageContext(context.Background(), testPin("abort")); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	go qm.ConsumeMessageContext(context.Background(), "", func(ctx context.Context, d amqp.Delivery) error {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := qm.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the handler's context to be cancelled")
+	}
+}
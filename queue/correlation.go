@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// HeaderCorrelationID is the header used to carry a message's correlation id,
+// shared by every message published as a result of processing it
+const HeaderCorrelationID = "x-correlation-id"
+
+type correlationKey struct{}
+
+// WithCorrelationID is used to attach a correlation id to ctx, which is included
+// in messages published with it
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID is used to get the correlation id attached to ctx, returning an
+// empty string if there is none. Handlers are given a context carrying the
+// correlation id of the message they are processing.
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// LogEntry is used to get a log entry for the service which includes the
+// correlation id attached to ctx, for logging while processing a message
+func (qm *Manager) LogEntry(ctx context.Context) *log.Entry {
+	entry := qm.Logger.WithFields(log.Fields{
+		"service": qm.Service,
+	})
+	if id := CorrelationID(ctx); id != "" {
+		entry = entry.WithField("correlation_id", id)
+	}
+	return entry
+}
+
+// logError is used to log an error along with the correlation id attached to ctx
+func (qm *Manager) logError(ctx context.Context, err error, message string) {
+	entry := qm.LogEntry(ctx)
+	if err != nil {
+		entry = entry.WithField("error", err.Error())
+	}
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		entry = entry.WithField("stack", string(panicErr.Stack))
+	}
+	entry.Error(message)
+}
+
+// deliveryContext is used to attach the correlation id of a delivery to ctx,
+// generating a new one for messages which were published without
+func deliveryContext(ctx context.Context, d amqp.Delivery) context.Context {
+	if id, ok := d.Headers[HeaderCorrelationID].(string); ok && id != "" {
+		return WithCorrelationID(ctx, id)
+	}
+	return WithCorrelationID(ctx, uuid.New().String())
+}
+
+// withCorrelation is used to add the correlation id attached to ctx to the given
+// headers, starting a new correlation id if ctx doesn't carry one
+func withCorrelation(ctx context.Context, headers amqp.Table) amqp.Table {
+	id := CorrelationID(ctx)
+	if id == "" {
+		id = uuid.New().String()
+	}
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[HeaderCorrelationID] = id
+	return headers
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+)
+
+// deadLetter is used to move a message which failed processing to the dead letter
+// queue, recording the reason it failed and its original routing key in its headers
+func (qm *Manager) deadLetter(d amqp.Delivery, reason error) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderFailureReason] = reason.Error()
+	headers[HeaderOriginalRoutingKey] = d.RoutingKey
+	if err := qm.send(
+		context.Background(),
+		qm.channel(),
+		DeadLetterName(qm.QueueName),
+		d.RoutingKey,
+		amqp.Publishing{
+			Headers:         headers,
+			DeliveryMode:    amqp.Persistent,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			Type:            d.Type,
+			Priority:        d.Priority,
+			Timestamp:       d.Timestamp,
+			Body:            d.Body,
+		},
+	); err != nil {
+		// rejecting the message still dead letters it, albeit without a reason
+		qm.logError(deliveryContext(context.Background(), d), err, "failed to publish message to dead letter queue")
+		return d.Nack(false, false)
+	}
+	return d.Ack(false)
+}
+
+// ConsumeDeadLetters is used to consume messages from the queue's dead letter queue,
+// for use by retry tooling. Messages are acknowledged once handler succeeds; should
+// it fail, the message is returned to the dead letter queue and the error returned.
+func (qm *Manager) ConsumeDeadLetters(ctx context.Context, consumer string, handler Handler) error {
+	msgs, err := qm.broker().Consume(DeadLetterName(qm.QueueName), consumer, qm.prefetch())
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			if err := Decompress(&d); err != nil {
+				d.Nack(false, true)
+				return err
+			}
+			if err := handler(ctx, d); err != nil {
+				d.Nack(false, true)
+				return err
+			}
+			if err := d.Ack(false); err != nil {
+				return err
+			}
+		}
+	}
+}
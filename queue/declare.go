@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"github.com/streadway/amqp"
+)
+
+const (
+	// HeaderFailureReason is set on dead lettered messages to the reason processing failed
+	HeaderFailureReason = "x-failure-reason"
+	// HeaderOriginalRoutingKey is set on dead lettered messages to their original routing key
+	HeaderOriginalRoutingKey = "x-original-routing-key"
+)
+
+// DeadLetterName is used to get the name of the dead letter exchange and queue for a queue
+func DeadLetterName(queueName string) string {
+	return queueName + "-dlx"
+}
+
+// Declare is used to declare the manager's queue according to its options, binding
+// it to the manager's exchange if it uses one, which is declared too when it has an
+// exchange type. Declarations are idempotent, so this is safe to call every time we
+// connect. Note that the broker refuses to redeclare an existing queue with different
+// options, so enabling dead lettering or priorities for an existing queue requires it
+// to be deleted first.
+//
+// Managers using an injected broker only declare their queue, as exchanges are
+// specific to rabbitmq.
+func (qm *Manager) Declare() error {
+	if qm.Broker != nil {
+		if qm.QueueName == "" {
+			return nil
+		}
+		return qm.Broker.DeclareQueue(qm.QueueName, qm.Options)
+	}
+	return qm.declare(qm.connection(), qm.channel())
+}
+
+// declare is used to declare the manager's queues and exchanges through ch, which
+// belongs to conn
+func (qm *Manager) declare(conn *amqp.Connection, ch *amqp.Channel) error {
+	// the alternate exchange has to exist before the exchange using it
+	if qm.Options.AlternateExchange {
+		if err := declareFanoutQueue(ch, UnroutableName(qm.ExchangeName)); err != nil {
+			return err
+		}
+	}
+	if qm.Options.NetworkRouting {
+		if err := qm.declareNetworkExchange(ch); err != nil {
+			return err
+		}
+	}
+	if qm.Billing {
+		if err := declareCopyExchange(ch, BillingQueue); err != nil {
+			return err
+		}
+	}
+	if qm.Audit {
+		if err := declareCopyExchange(ch, AuditQueue); err != nil {
+			return err
+		}
+	}
+	// publishers which only use an exchange have no queue to declare
+	if qm.QueueName == "" {
+		return nil
+	}
+	if qm.Options.DeadLetter {
+		if err := declareDeadLetter(ch, qm.QueueName); err != nil {
+			return err
+		}
+	}
+	// unless asked otherwise we declare the queue as durable so that even
+	// if rabbitmq server stops our messages won't be lost
+	q, err := ch.QueueDeclare(
+		qm.QueueName,                        // name
+		!qm.Options.Transient,               // durable
+		qm.Options.AutoDelete,               // delete when unused
+		qm.Options.Exclusive,                // exclusive
+		false,                               // no-wait
+		queueArgs(qm.QueueName, qm.Options), // arguments
+	)
+	if err != nil {
+		return err
+	}
+	qm.Queue = &q
+	if qm.Options.Delay != DelayDisabled {
+		if err = qm.declareDelay(conn, ch); err != nil {
+			return err
+		}
+	}
+	if qm.ExchangeName == "" {
+		return nil
+	}
+	if qm.Options.ExchangeType != "" && !qm.Options.NetworkRouting {
+		if err = ch.ExchangeDeclare(
+			qm.ExchangeName,         // name
+			qm.Options.ExchangeType, // type
+			!qm.Options.Transient,   // durable
+			qm.Options.AutoDelete,   // auto-delete
+			false,                   // internal
+			false,                   // no-wait
+			exchangeArgs(qm.ExchangeName, qm.Options), // arguments
+		); err != nil {
+			return err
+		}
+	}
+	if qm.Options.NetworkRouting {
+		for _, network := range qm.Options.Networks {
+			if err = ch.QueueBind(
+				qm.QueueName,    // name of the queue
+				network,         // routing key
+				qm.ExchangeName, // exchange
+				false,           // no-wait
+				nil,             // arguments
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return ch.QueueBind(
+		qm.QueueName,    // name of the queue
+		"",              // routing key
+		qm.ExchangeName, // exchange
+		false,           // no-wait
+		nil,             // arguments
+	)
+}
+
+// queueArgs is used to get the arguments a queue is declared with according to opts
+func queueArgs(queueName string, opts QueueOptions) amqp.Table {
+	args := amqp.Table{}
+	if opts.DeadLetter {
+		args["x-dead-letter-exchange"] = DeadLetterName(queueName)
+	}
+	if opts.MaxPriority > 0 {
+		args["x-max-priority"] = int32(opts.MaxPriority)
+	}
+	return args
+}
+
+// declareDeadLetter is used to declare the dead letter exchange and queue for a
+// queue. The exchange is a fanout exchange so that messages are captured regardless
+// of their original routing key, which the broker preserves.
+func declareDeadLetter(ch *amqp.Channel, queueName string) error {
+	return declareFanoutQueue(ch, DeadLetterName(queueName))
+}
+
+// NetworkQueueName is used to get the conventional name of the queue a consumer
+// uses to receive messages for a network from a network routed exchange, such
+// as ipfs-pin-queue.mynetwork
+func NetworkQueueName(exchangeName, network string) string {
+	return exchangeName + "." + network
+}
+
+// declareNetworkExchange is used to declare the manager's exchange as a topic
+// exchange, through which messages are routed by their network name
+func (qm *Manager) declareNetworkExchange(ch *amqp.Channel) error {
+	return ch.ExchangeDeclare(
+		qm.ExchangeName, // name
+		"topic",         // type
+		true,            // durable
+		false,           // auto-delete
+		false,           // internal
+		false,           // no-wait
+		exchangeArgs(qm.ExchangeName, qm.Options), // arguments
+	)
+}
@@ -0,0 +1,45 @@
+package queue_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestNewManager_DeclarationOptions(t *testing.T) {
+	var tests = []struct {
+		name    string
+		opts    []queue.Option
+		wantErr bool
+	}{
+		{"Durable", []queue.Option{queue.WithQueue(queue.EmailSendQueue)}, false},
+		{"ReplyQueue", []queue.Option{queue.WithQueue("reply"), queue.WithDurable(false), queue.WithAutoDelete(), queue.WithExclusive()}, false},
+		{"ExclusiveDurable", []queue.Option{queue.WithQueue("reply"), queue.WithExclusive()}, true},
+		{"AutoDeleteWithoutQueue", []queue.Option{queue.WithAutoDelete()}, true},
+		{"FanoutExchange", []queue.Option{queue.WithQueue("events"), queue.WithExchange("events"), queue.WithExchangeType(amqp.ExchangeFanout)}, false},
+		{"UnknownExchangeType", []queue.Option{queue.WithQueue("events"), queue.WithExchange("events"), queue.WithExchangeType("round-robin")}, true},
+		{"ExchangeTypeWithoutExchange", []queue.Option{queue.WithQueue("events"), queue.WithExchangeType(amqp.ExchangeDirect)}, true},
+		{"NetworkRoutingDirect", []queue.Option{queue.WithNetworkRouting("pins"), queue.WithExchangeType(amqp.ExchangeDirect)}, true},
+		{"AlternateExchange", []queue.Option{queue.WithQueue("events"), queue.WithExchange("events"), queue.WithExchangeType(amqp.ExchangeFanout), queue.WithAlternateExchange()}, false},
+		{"AlternateExchangeNetworkRouting", []queue.Option{queue.WithNetworkRouting("pins"), queue.WithAlternateExchange()}, false},
+		{"AlternateExchangeWithoutExchange", []queue.Option{queue.WithQueue("events"), queue.WithAlternateExchange()}, true},
+		{"AlternateExchangeUndeclared", []queue.Option{queue.WithQueue("events"), queue.WithExchange("events"), queue.WithAlternateExchange()}, true},
+		{"Mandatory", []queue.Option{queue.WithQueue("events"), queue.WithDeadLetter(), queue.WithMandatory()}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// validation happens before dialing, so valid options fail to dial instead
+			_, err := queue.NewManager("amqp://127.0.0.1:1", tt.opts...)
+			if err == nil {
+				t.Fatal("expected dialing a closed port to fail")
+			}
+			var netErr net.Error
+			if invalid := !errors.As(err, &netErr); invalid != tt.wantErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
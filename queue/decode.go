@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// messageTypes maps each of our queues to the type of message sent through it
+var messageTypes = map[string]reflect.Type{
+	DatabaseFileAddQueue:         reflect.TypeOf(DatabaseFileAdd{}),
+	IpfsPinQueue:                 reflect.TypeOf(IPFSPin{}),
+	IpfsBulkPinQueue:             reflect.TypeOf(IPFSBulkPin{}),
+	IpfsFileQueue:                reflect.TypeOf(IPFSFile{}),
+	IpfsClusterPinQueue:          reflect.TypeOf(IPFSClusterPin{}),
+	EmailSendQueue:               reflect.TypeOf(EmailSend{}),
+	IpnsEntryQueue:               reflect.TypeOf(IPNSEntry{}),
+	IpnsUpdateQueue:              reflect.TypeOf(IPNSUpdate{}),
+	IpfsKeyCreationQueue:         reflect.TypeOf(IPFSKeyCreation{}),
+	IpfsKeyDeletionQueue:         reflect.TypeOf(IPFSKeyDeletion{}),
+	PaymentCreationQueue:         reflect.TypeOf(PaymentCreation{}),
+	PaymentConfirmationQueue:     reflect.TypeOf(PaymentConfirmation{}),
+	DashPaymentConfirmationQueue: reflect.TypeOf(DashPaymentConfirmation{}),
+	MongoUpdateQueue:             reflect.TypeOf(MongoUpdate{}),
+	ZoneCreationQueue:            reflect.TypeOf(ZoneCreation{}),
+	RecordCreationQueue:          reflect.TypeOf(RecordCreation{}),
+	RecordDeletionQueue:          reflect.TypeOf(RecordDeletion{}),
+	ZoneExportQueue:              reflect.TypeOf(ZoneExport{}),
+	ZoneImportQueue:              reflect.TypeOf(ZoneImport{}),
+	ClusterPinStatusQueue:        reflect.TypeOf(PinStatusRequest{}),
+	CreditRefundQueue:            reflect.TypeOf(CreditRefund{}),
+}
+
+// DecodeMessage is used to decode a message received from the given queue into
+// its typed struct, which is returned by value. Messages are validated once
+// decoded, so a message which landed on the wrong queue is rejected rather than
+// being handed to a consumer half populated.
+func DecodeMessage(queueName string, body []byte) (interface{}, error) {
+	typ, ok := messageTypes[queueName]
+	if !ok {
+		return nil, fmt.Errorf("no message type registered for queue %s", queueName)
+	}
+	msg := reflect.New(typ)
+	if err := json.Unmarshal(body, msg.Interface()); err != nil {
+		return nil, err
+	}
+	if v, ok := msg.Elem().Interface().(validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, validationError(fmt.Errorf("invalid message for queue %s: %w", queueName, err))
+		}
+	}
+	return msg.Elem().Interface(), nil
+}
+
+// Decode is used to decode a message into a value of type T, validating it
+// if T is able to validate itself
+func Decode[T any](body []byte) (T, error) {
+	var msg T
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return msg, err
+	}
+	if v, ok := any(msg).(validator); ok {
+		if err := v.Validate(); err != nil {
+			return msg, validationError(err)
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultPinDedupWindow is how long pins are remembered for when deduplicating pins
+// without a window configured
+const DefaultPinDedupWindow = time.Hour
+
+// ErrDuplicatePin is the reason given when refunding the credits of a pin which was
+// skipped as a duplicate
+var ErrDuplicatePin = errors.New("content was already pinned by a recent request")
+
+// PinDedupStore is used to remember recent pins, so that duplicate pins are skipped.
+// Implementations must be safe for concurrent use, and recording a pin must be atomic.
+type PinDedupStore interface {
+	// Record is used to record a pin identified by key, held for holdTime months,
+	// until expiry. It returns false, recording nothing, if a pin of key held for
+	// at least as long has been recorded and has yet to expire
+	Record(key string, holdTime int64, expiry time.Time) (bool, error)
+	// Forget is used to forget a pin which failed, so that it may be retried
+	Forget(key string) error
+}
+
+// MemoryPinDedupStore is an in-memory PinDedupStore, which only deduplicates pins
+// processed by the same process
+type MemoryPinDedupStore struct {
+	mu   sync.Mutex
+	pins map[string]recordedPin
+}
+
+// recordedPin is a pin remembered by a MemoryPinDedupStore
+type recordedPin struct {
+	holdTime int64
+	expiry   time.Time
+}
+
+// NewMemoryPinDedupStore is used to create an empty in-memory pin dedup store
+func NewMemoryPinDedupStore() *MemoryPinDedupStore {
+	return &MemoryPinDedupStore{pins: make(map[string]recordedPin)}
+}
+
+// Record is used to record a pin
+func (s *MemoryPinDedupStore) Record(key string, holdTime int64, expiry time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// drop expired pins as we go, so the store doesn't grow without bound
+	for k, p := range s.pins {
+		if now.After(p.expiry) {
+			delete(s.pins, k)
+		}
+	}
+	if p, ok := s.pins[key]; ok && p.holdTime >= holdTime {
+		return false, nil
+	}
+	s.pins[key] = recordedPin{holdTime: holdTime, expiry: expiry}
+	return true, nil
+}
+
+// Forget is used to forget a pin
+func (s *MemoryPinDedupStore) Forget(key string) error {
+	s.mu.Lock()
+	delete(s.pins, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// DedupPins is used to wrap the handler of an ipfs pin or ipfs cluster pin queue so
+// that a user pinning the same cid to the same network more than once within window
+// only has it pinned once. Duplicates are acknowledged without being passed to the
+// handler, with their credits refunded, unless they ask for the content to be held
+// for longer than the pin already made, in which case they're pinned again so that
+// the longer hold time is honored. Pins are forgotten once window or their hold time
+// elapses, whichever is sooner, or should the handler fail, so that later re-pins
+// are made. A window of 0 or less uses DefaultPinDedupWindow.
+func (qm *Manager) DedupPins(handler Handler, store PinDedupStore, window time.Duration) Handler {
+	if window <= 0 {
+		window = DefaultPinDedupWindow
+	}
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var pin IPFSPin
+		if err := peek(d, &pin); err != nil || pin.CID == "" {
+			return handler(ctx, d)
+		}
+		key := pinKey(pin)
+		now := time.Now()
+		expiry := now.Add(window)
+		if held := holdExpiry(now, pin.HoldTimeInMonths); held.Before(expiry) {
+			expiry = held
+		}
+		recorded, err := store.Record(key, pin.HoldTimeInMonths, expiry)
+		if err != nil {
+			// pinning twice is harmless, so don't hold up the pin
+			qm.logError(ctx, err, "failed to check for duplicate pin")
+			return handler(ctx, d)
+		}
+		if !recorded {
+			qm.LogEntry(ctx).WithField("cid", pin.CID).Info("skipping duplicate pin")
+			qm.RefundCredits(ctx, d, ErrDuplicatePin)
+			return nil
+		}
+		if err = handler(ctx, d); err != nil {
+			if fErr := store.Forget(key); fErr != nil {
+				qm.logError(ctx, fErr, "failed to forget failed pin")
+			}
+		}
+		return err
+	}
+}
+
+// pinKey is used to get the key identifying pins of the same content to the same
+// network by the same user
+func pinKey(pin IPFSPin) string {
+	sum := sha256.Sum256([]byte(pin.CID + "\x00" + pin.NetworkName + "\x00" + pin.UserName))
+	return hex.EncodeToString(sum[:])
+}
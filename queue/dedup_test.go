@@ -0,0 +1,83 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// pinDelivery is used to create a delivery of a pin
+func pinDelivery(t *testing.T, pin queue.IPFSPin) amqp.Delivery {
+	t.Helper()
+	body, err := json.Marshal(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amqp.Delivery{Body: body}
+}
+
+func TestDedupPins(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.CreditRefundQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	qm := newMemoryManager(t, broker)
+	var pinned int
+	fail := false
+	handler := qm.DedupPins(func(ctx context.Context, d amqp.Delivery) error {
+		if fail {
+			return errors.New("ipfs unavailable")
+		}
+		pinned++
+		return nil
+	}, queue.NewMemoryPinDedupStore(), 50*time.Millisecond)
+	ctx := context.Background()
+	pin := testPin("alice")
+	pin.CreditCost = 1
+	longer := pin
+	longer.HoldTimeInMonths = 12
+	other := testPin("bob")
+	var tests = []struct {
+		name   string
+		pin    queue.IPFSPin
+		pinned int
+	}{
+		{"First", pin, 1},
+		{"Duplicate", pin, 1},
+		{"OtherUser", other, 2},
+		{"LongerHoldTime", longer, 3},
+		{"ShorterHoldTime", pin, 3},
+	}
+	for _, tt := range tests {
+		if err := handler(ctx, pinDelivery(t, tt.pin)); err != nil {
+			t.Fatal(err)
+		}
+		if pinned != tt.pinned {
+			t.Fatalf("%s: expected %v pins, got %v", tt.name, tt.pinned, pinned)
+		}
+	}
+	// skipped duplicates have their credits refunded
+	if n := broker.Len(queue.CreditRefundQueue); n != 2 {
+		t.Fatalf("expected 2 refunds, got %v", n)
+	}
+	// pins are made again once the window has passed
+	time.Sleep(50 * time.Millisecond)
+	if handler(ctx, pinDelivery(t, pin)); pinned != 4 {
+		t.Fatal("expected a re-pin once the window passed")
+	}
+	// failed pins are forgotten so that they may be retried
+	fail = true
+	if err := handler(ctx, pinDelivery(t, other)); err == nil {
+		t.Fatal("expected the handler's error")
+	}
+	fail = false
+	if handler(ctx, pinDelivery(t, other)); pinned != 5 {
+		t.Fatal("expected a failed pin to be retried")
+	}
+}
@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DelayMechanism is the mechanism used to delay messages published with PublishDelayed
+type DelayMechanism int
+
+const (
+	// DelayDisabled disables delayed publishing, and is the default
+	DelayDisabled DelayMechanism = iota
+	// DelayAuto uses the delayed message plugin when the broker has it, and
+	// otherwise falls back to DelayTTL
+	DelayAuto
+	// DelayPlugin uses the rabbitmq delayed message exchange plugin
+	DelayPlugin
+	// DelayTTL parks messages in a queue per delay, which dead letters them to
+	// our queue once their ttl expires
+	DelayTTL
+)
+
+// ErrDelayUnavailable is returned when publishing a delayed message with a manager
+// which doesn't have delayed delivery enabled
+var ErrDelayUnavailable = errors.New("delayed delivery is not enabled for this queue")
+
+// DelayedExchangeName is used to get the name of the delayed message exchange for a queue
+func DelayedExchangeName(queueName string) string {
+	return queueName + "-delayed"
+}
+
+// DelayQueueName is used to get the name of the queue used to delay messages for a
+// queue by the given delay when the delayed message plugin isn't available
+func DelayQueueName(queueName string, delay time.Duration) string {
+	return fmt.Sprintf("%s-delay-%d", queueName, delay/time.Millisecond)
+}
+
+// DelayMechanism is used to get the mechanism chosen for delayed publishing when the
+// queue was declared, which is DelayDisabled when delayed delivery isn't enabled
+func (qm *Manager) DelayMechanism() DelayMechanism {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	return qm.delay
+}
+
+// PublishDelayed is used to publish a message to the queue which is only delivered
+// to consumers once delay has elapsed. Messages are validated and signed as with
+// PublishMessageContext, and a delay of 0 or less publishes the message immediately.
+func (qm *Manager) PublishDelayed(body interface{}, delay time.Duration) error {
+	ctx := context.Background()
+	if delay <= 0 {
+		return qm.PublishMessageContext(ctx, body)
+	}
+	mechanism := qm.DelayMechanism()
+	if mechanism == DelayDisabled {
+		return ErrDelayUnavailable
+	}
+	msg, err := qm.prepare(ctx, body)
+	if err != nil {
+		return err
+	}
+	return qm.sendDelayed(ctx, mechanism, msg, delay)
+}
+
+// sendDelayed is used to publish a prepared message to the queue with the given
+// mechanism, which is only delivered to consumers once delay has elapsed
+func (qm *Manager) sendDelayed(ctx context.Context, mechanism DelayMechanism, msg amqp.Publishing, delay time.Duration) error {
+	ch := qm.channel()
+	if mechanism == DelayPlugin {
+		if msg.Headers == nil {
+			msg.Headers = amqp.Table{}
+		}
+		msg.Headers["x-delay"] = int64(delay / time.Millisecond)
+		return qm.send(ctx, ch, DelayedExchangeName(qm.QueueName), qm.QueueName, msg)
+	}
+	// each delay has its own queue, as messages only expire from the head of
+	// a queue, so a shared queue would hold short delays behind long ones.
+	// an expiration would release the message early, and is dropped by the
+	// broker once the message is dead lettered, so we don't set one
+	msg.Expiration = ""
+	name := DelayQueueName(qm.QueueName, delay)
+	if !qm.DryRun {
+		if _, err := qm.declareDelayQueue(ch, delay); err != nil {
+			return err
+		}
+	}
+	return qm.send(ctx, ch, "", name, msg)
+}
+
+// declareDelay is used to declare what's needed for delayed publishing, choosing
+// the delayed message plugin when asked to pick automatically and it is available
+func (qm *Manager) declareDelay(conn *amqp.Connection, ch *amqp.Channel) error {
+	mechanism := qm.Options.Delay
+	if mechanism == DelayAuto || mechanism == DelayPlugin {
+		available, err := declareDelayedExchange(conn, qm.QueueName)
+		switch {
+		case err != nil:
+			return err
+		case available:
+			mechanism = DelayPlugin
+		case mechanism == DelayPlugin:
+			return errors.New("the rabbitmq delayed message plugin is not available")
+		default:
+			mechanism = DelayTTL
+		}
+	}
+	if mechanism == DelayPlugin {
+		if err := ch.QueueBind(
+			qm.QueueName,                      // name of the queue
+			qm.QueueName,                      // routing key
+			DelayedExchangeName(qm.QueueName), // exchange
+			false,                             // no-wait
+			nil,                               // arguments
+		); err != nil {
+			return err
+		}
+	}
+	qm.mu.Lock()
+	qm.delay = mechanism
+	qm.mu.Unlock()
+	return nil
+}
+
+// declareDelayedExchange is used to declare the queue's delayed message exchange,
+// returning false if the broker doesn't have the plugin. brokers without it close
+// the channel when asked for the exchange type, so we use a throwaway channel
+func declareDelayedExchange(conn *amqp.Connection, queueName string) (bool, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return false, err
+	}
+	err = ch.ExchangeDeclare(
+		DelayedExchangeName(queueName),         // name
+		"x-delayed-message",                    // type
+		true,                                   // durable
+		false,                                  // auto-delete
+		false,                                  // internal
+		false,                                  // no-wait
+		amqp.Table{"x-delayed-type": "direct"}, // arguments
+	)
+	if amqpErr, ok := err.(*amqp.Error); ok && amqpErr.Code == amqp.CommandInvalid {
+		return false, nil
+	}
+	ch.Close()
+	return err == nil, err
+}
+
+// declareDelayQueue is used to declare the queue messages are delayed in, which has
+// no consumers, and dead letters its messages to our queue as they expire. It is
+// deleted once unused for a while, so that one off delays don't leave queues behind
+func (qm *Manager) declareDelayQueue(ch *amqp.Channel, delay time.Duration) (string, error) {
+	name := DelayQueueName(qm.QueueName, delay)
+	ttl := int64(delay / time.Millisecond)
+	_, err := ch.QueueDeclare(
+		name,                  // name
+		!qm.Options.Transient, // durable
+		false,                 // delete when unused
+		false,                 // exclusive
+		false,                 // no-wait
+		amqp.Table{
+			"x-message-ttl":             ttl,
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": qm.QueueName,
+			"x-expires":                 ttl + int64(time.Hour/time.Millisecond),
+		}, // arguments
+	)
+	return name, err
+}
+
+// String returns the name of the mechanism
+func (m DelayMechanism) String() string {
+	switch m {
+	case DelayDisabled:
+		return "disabled"
+	case DelayAuto:
+		return "auto"
+	case DelayPlugin:
+		return "plugin"
+	case DelayTTL:
+		return "ttl"
+	}
+	return "unknown(" + strconv.Itoa(int(m)) + ")"
+}
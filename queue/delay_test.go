@@ -0,0 +1,95 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+// newDelayManager is used to connect to the broker given by RABBITMQ_URL with
+// delayed delivery enabled, skipping the test when it isn't set
+func newDelayManager(t *testing.T, name string, mechanism queue.DelayMechanism) *queue.Manager {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	qm, err := queue.NewManager(url,
+		queue.WithQueue(name),
+		queue.WithDurable(false),
+		queue.WithDelayedDelivery(mechanism),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = qm.Channel.QueuePurge(name, false); err != nil {
+		t.Fatal(err)
+	}
+	return qm
+}
+
+// testDelayedDelivery publishes a delayed message, and checks that it isn't
+// delivered before its delay has elapsed but is delivered afterwards
+func testDelayedDelivery(t *testing.T, qm *queue.Manager) {
+	msgs, err := qm.Channel.Consume(qm.QueueName, "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const delay = 2 * time.Second
+	start := time.Now()
+	if err = qm.PublishDelayed(queue.IPFSPin{
+		CID:              "QmPY5iMFjNZKxRbUZZC85wXb9CFgNSyzAy1LxwL62D8VGr",
+		NetworkName:      "public",
+		UserName:         "delay",
+		HoldTimeInMonths: 1,
+	}, delay); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-msgs:
+		if elapsed := time.Since(start); elapsed < delay {
+			t.Fatalf("message delivered after %s, expected at least %s", elapsed, delay)
+		}
+	case <-time.After(delay + 10*time.Second):
+		t.Fatal("delayed message was never delivered")
+	}
+}
+
+func TestPublishDelayed_Plugin(t *testing.T) {
+	qm := newDelayManager(t, "delay-plugin-queue", queue.DelayAuto)
+	defer qm.Close(context.Background())
+	if qm.DelayMechanism() != queue.DelayPlugin {
+		t.Skip("rabbitmq delayed message plugin not available")
+	}
+	testDelayedDelivery(t, qm)
+}
+
+func TestPublishDelayed_TTL(t *testing.T) {
+	qm := newDelayManager(t, "delay-ttl-queue", queue.DelayTTL)
+	defer qm.Close(context.Background())
+	if got := qm.DelayMechanism(); got != queue.DelayTTL {
+		t.Fatalf("expected ttl mechanism, got %s", got)
+	}
+	testDelayedDelivery(t, qm)
+}
+
+func TestPublishDelayed_Disabled(t *testing.T) {
+	qm := &queue.Manager{QueueName: "delay-disabled-queue"}
+	if err := qm.PublishDelayed(queue.IPFSPin{}, time.Second); err != queue.ErrDelayUnavailable {
+		t.Fatalf("expected ErrDelayUnavailable, got %v", err)
+	}
+}
+
+func TestNewManager_DelayRequiresQueue(t *testing.T) {
+	if _, err := queue.NewManager("amqp://localhost", queue.WithDelayedDelivery(queue.DelayAuto)); err == nil {
+		t.Fatal("expected an error enabling delayed delivery without a queue")
+	}
+}
+
+func TestDelayQueueName(t *testing.T) {
+	if got := queue.DelayQueueName("pin-queue", 1500*time.Millisecond); got != "pin-queue-delay-1500" {
+		t.Fatalf("unexpected delay queue name %q", got)
+	}
+}
@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// DefaultHeartbeat is the heartbeat interval negotiated with the broker
+	DefaultHeartbeat = 10 * time.Second
+	// DefaultDialTimeout bounds how long connecting to the broker may take
+	DefaultDialTimeout = 30 * time.Second
+	// DefaultLocale is the locale requested from the broker
+	DefaultLocale = "en_US"
+	// MaxHeartbeat is the longest heartbeat interval the broker accepts, as it's
+	// negotiated in whole seconds as an unsigned 16 bit integer
+	MaxHeartbeat = 65535 * time.Second
+)
+
+// ConnectionOpts is used to tune connections to the broker, with zero values
+// being replaced by their defaults
+type ConnectionOpts struct {
+	// Heartbeat is how often heartbeats are sent, between 1 second and MaxHeartbeat.
+	// The broker may negotiate a shorter interval, and the connection is considered
+	// dead after two missed heartbeats, so this should be well under any idle timeout
+	// of load balancers between us and the broker
+	Heartbeat time.Duration
+	// DialTimeout bounds how long establishing the tcp connection may take
+	DialTimeout time.Duration
+	// Locale is the locale requested from the broker for error messages
+	Locale string
+}
+
+// withDefaults is used to fill in unset options with their defaults
+func (o ConnectionOpts) withDefaults() ConnectionOpts {
+	if o.Heartbeat == 0 {
+		o.Heartbeat = DefaultHeartbeat
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = DefaultDialTimeout
+	}
+	if o.Locale == "" {
+		o.Locale = DefaultLocale
+	}
+	return o
+}
+
+// validate is used to check the options are accepted by the broker
+func (o ConnectionOpts) validate() error {
+	if o.Heartbeat < 0 || o.DialTimeout < 0 {
+		return errors.New("connection timeouts can't be negative")
+	}
+	// sub-second heartbeats would be truncated to zero, which disables them
+	if o.Heartbeat != 0 && (o.Heartbeat < time.Second || o.Heartbeat > MaxHeartbeat) {
+		return fmt.Errorf("heartbeat must be between 1s and %v", MaxHeartbeat)
+	}
+	return nil
+}
+
+// Dial is used to connect to the broker at url. Connections to amqps urls are
+// secured with tlsConfig, or the system's default configuration if it is nil,
+// while supplying a tls config for a plain amqp url is an error, so that a
+// misconfiguration never results in an insecure connection.
+func Dial(url string, tlsConfig *tls.Config) (*amqp.Connection, error) {
+	return DialConfig(url, tlsConfig, ConnectionOpts{})
+}
+
+// DialConfig is used to connect to the broker at url like Dial, with the
+// connection tuned by opts
+func DialConfig(url string, tlsConfig *tls.Config, opts ConnectionOpts) (*amqp.Connection, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(url, "amqps://") && tlsConfig != nil {
+		return nil, errors.New("tls config provided for non amqps url")
+	}
+	opts = opts.withDefaults()
+	return amqp.DialConfig(url, amqp.Config{
+		TLSClientConfig: tlsConfig,
+		Heartbeat:       opts.Heartbeat,
+		Locale:          opts.Locale,
+		Dial:            amqp.DefaultDial(opts.DialTimeout),
+	})
+}
+
+// NewTLSConfig is used to generate a tls config for connecting to the broker. The
+// CA certificate at caFile is trusted in addition to the system's roots, and when
+// certFile and keyFile are set the client certificate is presented for mutual TLS.
+// Any file which is set but can't be loaded results in an error.
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ca certificate: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse ca certificate %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("client certificate and key must be provided together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,43 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestNewManager_ConnectionOpts(t *testing.T) {
+	var tests = []struct {
+		name string
+		opts queue.ConnectionOpts
+	}{
+		{"NegativeHeartbeat", queue.ConnectionOpts{Heartbeat: -time.Second}},
+		{"SubSecondHeartbeat", queue.ConnectionOpts{Heartbeat: 500 * time.Millisecond}},
+		{"HeartbeatTooLong", queue.ConnectionOpts{Heartbeat: queue.MaxHeartbeat + time.Second}},
+		{"NegativeDialTimeout", queue.ConnectionOpts{DialTimeout: -time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// validation happens before dialing, so no broker is needed
+			if _, err := queue.NewManager("amqp://127.0.0.1:1", queue.WithConnectionOpts(tt.opts)); err == nil {
+				t.Fatal("expected invalid connection options to be rejected")
+			}
+		})
+	}
+}
+
+func TestDialConfig(t *testing.T) {
+	opts := queue.ConnectionOpts{Heartbeat: 5 * time.Second, DialTimeout: 100 * time.Millisecond}
+	// nothing listens on port 1, so this fails quickly once the options are accepted
+	start := time.Now()
+	if _, err := queue.DialConfig("amqp://127.0.0.1:1", nil, opts); err == nil {
+		t.Fatal("expected dialing a closed port to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("dial took %v despite its timeout", elapsed)
+	}
+	if _, err := queue.DialConfig("amqp://127.0.0.1:1", nil, queue.ConnectionOpts{Heartbeat: time.Millisecond}); err == nil {
+		t.Fatal("expected a sub-second heartbeat to be rejected")
+	}
+}
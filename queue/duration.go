@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration which is encoded in json as a duration string such
+// as "48h0m0s". Both duration strings and integer nanosecond counts, which is how
+// time.Duration is encoded, are accepted when decoding.
+type Duration time.Duration
+
+// MarshalJSON is used to encode the duration as a duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON is used to decode a duration string, or an integer number of nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+		return nil
+	}
+	nanoseconds, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(nanoseconds)
+	return nil
+}
+
+// String returns the duration formatted as a duration string
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
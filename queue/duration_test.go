@@ -0,0 +1,60 @@
+package queue_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestDuration_JSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"String", `{"life_time":"48h","ttl":"1m30s"}`, 48 * time.Hour, false},
+		{"Integer", `{"life_time":172800000000000,"ttl":90000000000}`, 48 * time.Hour, false},
+		{"BadString", `{"life_time":"two days"}`, 0, true},
+		{"BadType", `{"life_time":true}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entry queue.IPNSEntry
+			err := json.Unmarshal([]byte(tt.data), &entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if time.Duration(entry.LifeTime) != tt.want {
+				t.Fatalf("life time = %s, want %s", entry.LifeTime, tt.want)
+			}
+			if time.Duration(entry.TTL) != 90*time.Second {
+				t.Fatalf("ttl = %s, want %s", entry.TTL, 90*time.Second)
+			}
+			// both message shapes should encode durations identically
+			entryJSON, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			updateJSON, err := json.Marshal(queue.IPNSUpdate{LifeTime: entry.LifeTime, TTL: entry.TTL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var e, u map[string]interface{}
+			if err = json.Unmarshal(entryJSON, &e); err != nil {
+				t.Fatal(err)
+			}
+			if err = json.Unmarshal(updateJSON, &u); err != nil {
+				t.Fatal(err)
+			}
+			if e["life_time"] != "48h0m0s" || u["life_time"] != e["life_time"] || u["ttl"] != e["ttl"] {
+				t.Fatalf("inconsistent encoding: %s vs %s", entryJSON, updateJSON)
+			}
+		})
+	}
+}
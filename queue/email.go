@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// NewEmailAttachment is used to create an attachment from raw file content
+func NewEmailAttachment(fileName, mimeType string, content []byte) EmailAttachment {
+	return EmailAttachment{
+		FileName: fileName,
+		MimeType: mimeType,
+		Content:  base64.StdEncoding.EncodeToString(content),
+	}
+}
+
+// AttachTo is used by the email send consumer to add the email's attachments
+// to an outgoing message. Attachment content is already base64 encoded, as
+// sendgrid expects
+func (e EmailSend) AttachTo(m *mail.SGMailV3) {
+	for _, a := range e.Attachments {
+		m.AddAttachment(mail.NewAttachment().
+			SetFilename(a.FileName).
+			SetType(a.MimeType).
+			SetContent(a.Content).
+			SetDisposition("attachment"))
+	}
+}
+
+// NewPinFailedEmail is used to create the email notifying users that content could
+// not be pinned to a network
+func NewPinFailedEmail(cid, networkName, reason string, userNames []string) EmailSend {
+	return EmailSend{
+		Subject:      IpfsPinFailedSubject,
+		Content:      fmt.Sprintf(IpfsPinFailedContent, cid, networkName, reason),
+		ContentType:  "text/plain",
+		TemplateName: TemplatePinFailed,
+		TemplateData: map[string]interface{}{
+			"CID":         cid,
+			"NetworkName": networkName,
+			"Reason":      reason,
+		},
+		UserNames: userNames,
+	}
+}
+
+// NewBulkPinFailedEmail is used to create the email notifying users that some of
+// the pins of a bulk pin failed, given the reason each failed cid failed for
+func NewBulkPinFailedEmail(networkName string, failures map[string]string, userNames []string) EmailSend {
+	return EmailSend{
+		Subject:      IpfsPinFailedSubject,
+		Content:      fmt.Sprintf(IpfsBulkPinFailedContent, len(failures), networkName),
+		ContentType:  "text/plain",
+		TemplateName: TemplateBulkPinFailed,
+		TemplateData: map[string]interface{}{
+			"NetworkName": networkName,
+			"Failures":    failures,
+		},
+		UserNames: userNames,
+	}
+}
+
+// NewFileFailedEmail is used to create the email notifying users that an object
+// could not be added to a network
+func NewFileFailedEmail(objectName, networkName, reason string, userNames []string) EmailSend {
+	return EmailSend{
+		Subject:      IpfsFileFailedSubject,
+		Content:      fmt.Sprintf(IpfsFileFailedContent, objectName, networkName),
+		ContentType:  "text/plain",
+		TemplateName: TemplateFileFailed,
+		TemplateData: map[string]interface{}{
+			"ObjectName":  objectName,
+			"NetworkName": networkName,
+			"Reason":      reason,
+		},
+		UserNames: userNames,
+	}
+}
+
+// NewFileEncryptionFailedEmail is used to create the email notifying users that an
+// object flagged as encrypted was refused, as it isn't encrypted
+func NewFileEncryptionFailedEmail(objectName, networkName string, userNames []string) EmailSend {
+	return EmailSend{
+		Subject:     IpfsFileEncryptionFailedSubject,
+		Content:     fmt.Sprintf(IpfsFileEncryptionFailedContent, objectName, networkName),
+		ContentType: "text/plain",
+		UserNames:   userNames,
+	}
+}
+
+// NewIPNSEntryFailedEmail is used to create the email notifying users that an ipns
+// entry could not be created for content using a key
+func NewIPNSEntryFailedEmail(cid, key, reason string, userNames []string) EmailSend {
+	return EmailSend{
+		Subject:      IpnsEntryFailedSubject,
+		Content:      fmt.Sprintf(IpnsEntryFailedContent, cid, key, reason),
+		ContentType:  "text/plain",
+		TemplateName: TemplateIPNSFailed,
+		TemplateData: map[string]interface{}{
+			"CID":    cid,
+			"Key":    key,
+			"Reason": reason,
+		},
+		UserNames: userNames,
+	}
+}
+
+// NewNetworkUnauthorizedEmail is used to create the email notifying users that they
+// were refused use of a private network they aren't authorized to use
+func NewNetworkUnauthorizedEmail(networkName string, userNames []string) EmailSend {
+	return EmailSend{
+		Subject:      IpfsPrivateNetworkUnauthorizedSubject,
+		Content:      fmt.Sprintf(IpfsPrivateNetworkUnauthorizedContent, networkName),
+		ContentType:  "text/plain",
+		TemplateName: TemplateNetworkUnauthorized,
+		TemplateData: map[string]interface{}{
+			"NetworkName": networkName,
+		},
+		UserNames: userNames,
+	}
+}
+
+// NewPaymentFailedEmail is used to create the email notifying users that a payment
+// could not be confirmed
+func NewPaymentFailedEmail(txHash, reason string, userNames []string) EmailSend {
+	return EmailSend{
+		Subject:      PaymentConfirmationFailedSubject,
+		Content:      fmt.Sprintf(PaymentConfirmationFailedContent, txHash, reason),
+		ContentType:  "text/plain",
+		TemplateName: TemplatePaymentFailed,
+		TemplateData: map[string]interface{}{
+			"TxHash": txHash,
+			"Reason": reason,
+		},
+		UserNames: userNames,
+	}
+}
+
+// NewInsufficientCreditsEmail is used to create the email notifying users that a
+// request on a queue wasn't processed, as they can't afford its credit cost
+func NewInsufficientCreditsEmail(queueName string, creditCost float64, userNames []string) EmailSend {
+	return EmailSend{
+		Subject:     InsufficientCreditsSubject,
+		Content:     fmt.Sprintf(InsufficientCreditsContent, queueName, creditCost),
+		ContentType: "text/plain",
+		UserNames:   userNames,
+	}
+}
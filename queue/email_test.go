@@ -0,0 +1,45 @@
+package queue_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestEmailBuilders(t *testing.T) {
+	users := []string{"user"}
+	var tests = []struct {
+		name    string
+		email   queue.EmailSend
+		subject string
+		content []string
+	}{
+		{"PinFailed", queue.NewPinFailedEmail(testCID, "public", "timed out", users), queue.IpfsPinFailedSubject, []string{testCID, "public", "timed out"}},
+		{"BulkPinFailed", queue.NewBulkPinFailedEmail("public", map[string]string{testCID: "timed out"}, users), queue.IpfsPinFailedSubject, []string{"1 content hashes", "public"}},
+		{"FileFailed", queue.NewFileFailedEmail("object", "public", "timed out", users), queue.IpfsFileFailedSubject, []string{"object", "public"}},
+		{"FileEncryptionFailed", queue.NewFileEncryptionFailedEmail("object", "public", users), queue.IpfsFileEncryptionFailedSubject, []string{"object", "public"}},
+		{"IPNSEntryFailed", queue.NewIPNSEntryFailedEmail(testCID, "key", "timed out", users), queue.IpnsEntryFailedSubject, []string{testCID, "key", "timed out"}},
+		{"NetworkUnauthorized", queue.NewNetworkUnauthorizedEmail("private", users), queue.IpfsPrivateNetworkUnauthorizedSubject, []string{"private"}},
+		{"PaymentFailed", queue.NewPaymentFailedEmail("0xabc", "timed out", users), queue.PaymentConfirmationFailedSubject, []string{"0xabc", "timed out"}},
+		{"InsufficientCredits", queue.NewInsufficientCreditsEmail(queue.IpfsPinQueue, 2, users), queue.InsufficientCreditsSubject, []string{queue.IpfsPinQueue, "2 credits"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.email.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.email.Subject != tt.subject || len(tt.email.UserNames) != 1 || tt.email.UserNames[0] != "user" {
+				t.Fatalf("unexpected email %+v", tt.email)
+			}
+			for _, want := range tt.content {
+				if !strings.Contains(tt.email.Content, want) {
+					t.Fatalf("expected the content %q to contain %q", tt.email.Content, want)
+				}
+			}
+			if _, _, err := tt.email.Render(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// EncryptionHeaderSize is the number of bytes read from the start of an object to
+// check that it carries an encryption envelope
+const EncryptionHeaderSize = 512
+
+// ErrNotEncrypted is the reason ipfs files flagged as encrypted are refused when
+// their object doesn't carry an encryption envelope
+var ErrNotEncrypted = errors.New("object flagged as encrypted is not encrypted")
+
+// ObjectReader is used to read the objects ipfs files are added from
+type ObjectReader interface {
+	// ReadObjectHeader is used to read up to n bytes from the start of an object
+	// stored on the given minio host, returning fewer for smaller objects
+	ReadObjectHeader(ctx context.Context, minioHostIP, bucketName, objectName string, n int) ([]byte, error)
+}
+
+// EnvelopeCheck is used to check whether the start of an object, as read by an
+// ObjectReader, is the envelope our encryption wraps objects in
+type EnvelopeCheck func(header []byte) bool
+
+// PrefixEnvelope is used to check for an envelope beginning with the given magic bytes
+func PrefixEnvelope(magic []byte) EnvelopeCheck {
+	return func(header []byte) bool {
+		return len(magic) > 0 && bytes.HasPrefix(header, magic)
+	}
+}
+
+// CheckEncryption is used to wrap the handler of the ipfs file queue so that files
+// flagged as encrypted are only added once their object is found to carry the
+// encryption envelope, as checked by isEnvelope, so that content users expect to be
+// encrypted is never added to ipfs unencrypted. Files which aren't are dropped
+// without being passed to handler, with their credits refunded and the user
+// emailed IpfsFileEncryptionFailedContent. Should reading the object fail the file
+// fails too, so that it may be retried, rather than being added unchecked. Files
+// which aren't flagged as encrypted are passed straight to handler. Objects are read
+// from the host given by MinioHost, so FailoverMinioHost should wrap this.
+func (qm *Manager) CheckEncryption(handler Handler, objects ObjectReader, isEnvelope EnvelopeCheck) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var file IPFSFile
+		if err := UnmarshalDelivery(d, &file); err != nil {
+			return Drop(err)
+		}
+		if !file.Encrypted {
+			return handler(ctx, d)
+		}
+		header, err := objects.ReadObjectHeader(ctx, MinioHost(ctx, file), file.BucketName, file.ObjectName, EncryptionHeaderSize)
+		if err != nil {
+			return fmt.Errorf("failed to read object: %w", err)
+		}
+		if isEnvelope(header) {
+			return handler(ctx, d)
+		}
+		qm.LogEntry(ctx).WithFields(log.Fields{
+			"user":        file.UserName,
+			"bucket_name": file.BucketName,
+			"object_name": file.ObjectName,
+		}).Warn("rejecting ipfs file flagged as encrypted whose object is not encrypted")
+		qm.RefundCredits(ctx, d, ErrNotEncrypted)
+		email := NewFileEncryptionFailedEmail(file.ObjectName, file.NetworkName, []string{file.UserName})
+		if pubErr := qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); pubErr != nil {
+			qm.logError(ctx, pubErr, "failed to publish ipfs file encryption failure email")
+		}
+		return Drop(ErrNotEncrypted)
+	}
+}
@@ -0,0 +1,107 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// fakeObjects is an in memory queue.ObjectReader, keyed by bucket then object name
+type fakeObjects map[string]map[string][]byte
+
+func (f fakeObjects) ReadObjectHeader(ctx context.Context, minioHostIP, bucketName, objectName string, n int) ([]byte, error) {
+	object, ok := f[bucketName][objectName]
+	if !ok {
+		return nil, errors.New("object not found")
+	}
+	if len(object) > n {
+		object = object[:n]
+	}
+	return object, nil
+}
+
+func TestCheckEncryption(t *testing.T) {
+	magic := []byte("RTENC1")
+	objects := fakeObjects{"bucket": {
+		"encrypted": append(append([]byte{}, magic...), "ciphertext"...),
+		"plain":     []byte("hello world"),
+	}}
+	var tests = []struct {
+		name      string
+		object    string
+		encrypted bool
+		added     bool
+		failed    bool
+	}{
+		{"Encrypted", "encrypted", true, true, false},
+		{"NotEncrypted", "plain", true, false, false},
+		{"NotFlagged", "plain", false, true, false},
+		{"Unreadable", "missing", true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newAdminBroker(t)
+			defer broker.Close()
+			if err := broker.DeclareQueue(queue.CreditRefundQueue, queue.QueueOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			qm := newMemoryManager(t, broker, queue.WithQueue(queue.IpfsFileQueue))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := qm.PublishMessageContext(ctx, queue.IPFSFile{
+				MinioHostIP:      "127.0.0.1",
+				BucketName:       "bucket",
+				ObjectName:       tt.object,
+				UserName:         "user",
+				NetworkName:      "public",
+				HoldTimeInMonths: 1,
+				CreditCost:       2,
+				Encrypted:        tt.encrypted,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			var added bool
+			handler := qm.CheckEncryption(func(ctx context.Context, d amqp.Delivery) error {
+				added = true
+				return nil
+			}, objects, queue.PrefixEnvelope(magic))
+			var handlerErr error
+			qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+				defer cancel()
+				handlerErr = handler(ctx, d)
+				return handlerErr
+			})
+			if added != tt.added {
+				t.Fatalf("expected the file being added to be %v", tt.added)
+			}
+			if tt.added || tt.failed {
+				if (handlerErr != nil) != tt.failed || broker.Len(queue.EmailSendQueue)+broker.Len(queue.CreditRefundQueue) != 0 {
+					t.Fatalf("unexpected rejection: %v", handlerErr)
+				}
+				return
+			}
+			if !errors.Is(handlerErr, queue.ErrNotEncrypted) || !errors.Is(handlerErr, queue.ErrDrop) {
+				t.Fatalf("expected the file to be dropped, got %v", handlerErr)
+			}
+			if broker.Len(queue.CreditRefundQueue) != 1 {
+				t.Fatal("expected the file's credits to be refunded")
+			}
+			d, ok := broker.Get(queue.EmailSendQueue)
+			if !ok {
+				t.Fatal("expected the user to be notified")
+			}
+			email, err := queue.Decode[queue.EmailSend](d.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if email.Subject != queue.IpfsFileEncryptionFailedSubject || !strings.Contains(email.Content, tt.object) {
+				t.Fatalf("unexpected email %+v", email)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package queue
+
+import (
+	"errors"
+
+	"github.com/streadway/amqp"
+)
+
+// Callers can branch on the kind of error returned by the package with errors.Is,
+// as errors are either one of its sentinel errors or wrap one, along with the lower
+// level error, such as an *amqp.Error, where there is one:
+//
+//	ErrValidation          returned by the publishing methods and DecodeDelivery for
+//	                       messages which fail validation, which won't succeed if retried
+//	ErrMessageTooLarge     returned by the publishing methods for messages larger than
+//	                       the manager's MaxMessageSize, which are also validation errors
+//	ErrNotConnected        returned by the publishing methods and ConsumeMessageContext
+//	                       when the manager has no open connection to the broker
+//	ErrNacked              returned by the publishing methods when the broker refuses
+//	                       a message, once publisher confirms are enabled
+//	ErrConfirmTimeout      returned by the publishing methods when the broker doesn't
+//	                       confirm a message in time
+//	ErrDelayUnavailable    returned by PublishDelayed without delayed delivery enabled
+//	ErrQueueNotFound       returned by QueueStats for queues which don't exist
+//	ErrNoMinioHost         returned by MinioPool.Host and FailoverMinioHost when no minio
+//	                       host holding an ipfs file's object is reachable
+//	ErrRPCTimeout          returned by Call and RequestPinStatus when no reply arrives
+//	                       in time
+//	ErrUnsupportedMessage  returned by codecs for messages they don't support
+//	ErrUnsupportedContentType
+//	                       returned by DecodeDelivery for content types without a codec,
+//	                       whose messages consumers dead letter
+//	ErrClockSkew           the reason consumers dead letter messages whose timestamp is
+//	                       outside their TimestampTolerance
+//	ErrHandlerTimeout      the reason consumers requeue or dead letter messages whose
+//	                       handler ran for longer than their HandlerTimeout
+//
+// IsTransient reports whether an error returned when publishing may succeed if
+// retried. Handlers choose how their messages are settled with the errors alongside
+// ErrDrop, and the tns resolver's errors are described alongside ErrZoneNotFound.
+var (
+	// ErrNotConnected is returned when publishing or consuming without an open
+	// connection to the broker, such as once the manager is closed
+	ErrNotConnected = errors.New("not connected to the broker")
+	// ErrValidation is returned when a message fails validation
+	ErrValidation = errors.New("invalid message")
+)
+
+// kindError wraps an error with the sentinel error it is a kind of, so that both
+// can be checked for with errors.Is while keeping the error's message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string        { return e.err.Error() }
+func (e *kindError) Unwrap() error        { return e.err }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// validationError is used to mark err as a validation error
+func validationError(err error) error {
+	return &kindError{kind: ErrValidation, err: err}
+}
+
+// connectionError is used to mark err as ErrNotConnected when it is the error amqp
+// returns for a closed connection or channel
+func connectionError(err error) error {
+	if errors.Is(err, amqp.ErrClosed) {
+		return &kindError{kind: ErrNotConnected, err: err}
+	}
+	return err
+}
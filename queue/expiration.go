@@ -0,0 +1,34 @@
+package queue
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// WithExpiration is used to publish a message with the given ttl, after which the
+// broker discards it if it hasn't been consumed, overriding the manager's default
+func WithExpiration(ttl time.Duration) PublishOption {
+	return func(msg *amqp.Publishing) {
+		msg.Expiration = formatExpiration(ttl)
+	}
+}
+
+// formatExpiration is used to format a ttl as the broker expects, in milliseconds
+func formatExpiration(ttl time.Duration) string {
+	return strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+}
+
+// validateExpiration is used to check an expiration is a non-negative number of
+// milliseconds before publishing, as the broker closes the channel otherwise
+func validateExpiration(expiration string) error {
+	if expiration == "" {
+		return nil
+	}
+	if _, err := strconv.ParseUint(expiration, 10, 32); err != nil {
+		return fmt.Errorf("invalid message expiration %q: must be a non-negative number of milliseconds", expiration)
+	}
+	return nil
+}
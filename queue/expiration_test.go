@@ -0,0 +1,56 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestWithExpiration(t *testing.T) {
+	var tests = []struct {
+		name string
+		ttl  time.Duration
+		want string
+	}{
+		{"Minute", time.Minute, "60000"},
+		{"Milliseconds", 1500 * time.Microsecond, "1"},
+		{"Zero", 0, "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg amqp.Publishing
+			queue.WithExpiration(tt.ttl)(&msg)
+			if msg.Expiration != tt.want {
+				t.Fatalf("expected expiration %q, got %q", tt.want, msg.Expiration)
+			}
+		})
+	}
+}
+
+// invalid expirations are rejected before reaching the broker
+func TestPublishMessageContext_InvalidExpiration(t *testing.T) {
+	qm := &queue.Manager{QueueName: "expiration-test-queue"}
+	pin := queue.IPFSPin{
+		CID:              benchCID,
+		NetworkName:      "public",
+		UserName:         "expiration",
+		HoldTimeInMonths: 1,
+	}
+	var tests = []struct {
+		name string
+		opt  queue.PublishOption
+	}{
+		{"Negative", queue.WithExpiration(-time.Second)},
+		{"NotANumber", func(msg *amqp.Publishing) { msg.Expiration = "1m" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := qm.PublishMessageContext(context.Background(), pin, tt.opt); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
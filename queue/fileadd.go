@@ -0,0 +1,231 @@
+package queue
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// ErrDuplicateUpload is the reason given when refunding the credits of a file add
+// which was skipped, as the user already holds the content for at least as long
+var ErrDuplicateUpload = errors.New("content was already uploaded with at least as long a hold time")
+
+// Upload is a user's upload of content to a network, as recorded by an UploadStore
+type Upload struct {
+	Hash             string
+	UserName         string
+	NetworkName      string
+	HoldTimeInMonths int64
+	// Expiry is when the content may be garbage collected
+	Expiry time.Time
+}
+
+// UploadOutcome is what recording an upload did
+type UploadOutcome int
+
+const (
+	// UploadCreated is the outcome of recording content the user hadn't uploaded
+	UploadCreated UploadOutcome = iota
+	// UploadExtended is the outcome of recording content the user had uploaded,
+	// whose expiry was extended to that of the new upload
+	UploadExtended
+	// UploadUnchanged is the outcome of recording content the user had uploaded
+	// with an expiry at least as late as that of the new upload
+	UploadUnchanged
+)
+
+// UploadStore is used by the database file add consumer to record uploads
+type UploadStore interface {
+	// RecordUpload is used to record an upload, identified by its hash, user and
+	// network. Uploads which haven't been recorded are created, while those which
+	// have are extended to the new upload's hold time and expiry if it expires
+	// later, and are otherwise left unchanged, so that hold times are never
+	// shortened. Recording must be atomic, so that uploads of the same content
+	// recorded concurrently never create more than one upload.
+	RecordUpload(upload Upload) (UploadOutcome, error)
+}
+
+// MemoryUploadStore is an in-memory UploadStore
+type MemoryUploadStore struct {
+	mu      sync.Mutex
+	uploads map[[3]string]Upload
+}
+
+// NewMemoryUploadStore is used to create an empty in-memory upload store
+func NewMemoryUploadStore() *MemoryUploadStore {
+	return &MemoryUploadStore{uploads: make(map[[3]string]Upload)}
+}
+
+// RecordUpload is used to record an upload
+func (s *MemoryUploadStore) RecordUpload(upload Upload) (UploadOutcome, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := [3]string{upload.Hash, upload.UserName, upload.NetworkName}
+	existing, ok := s.uploads[key]
+	switch {
+	case !ok:
+		s.uploads[key] = upload
+		return UploadCreated, nil
+	case upload.Expiry.After(existing.Expiry):
+		s.uploads[key] = upload
+		return UploadExtended, nil
+	default:
+		return UploadUnchanged, nil
+	}
+}
+
+// Uploads is used to get every recorded upload
+func (s *MemoryUploadStore) Uploads() []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := make([]Upload, 0, len(s.uploads))
+	for _, upload := range s.uploads {
+		uploads = append(uploads, upload)
+	}
+	return uploads
+}
+
+// DatabaseFileAddHandler is used to record the uploads requested through
+// DatabaseFileAddQueue in store. Content the user already holds on the network is
+// never recorded twice, with its hold time extended should the new request hold it
+// for longer, and the request otherwise being acknowledged with its credits
+// refunded. Redelivered requests aren't refunded, as they may have been recorded
+// before being redelivered, nor are those whose content PinFileAdds pinned, as the
+// pin was paid for with their credits.
+func (qm *Manager) DatabaseFileAddHandler(store UploadStore) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		req, err := Decode[DatabaseFileAdd](d.Body)
+		if err != nil {
+			return Drop(err)
+		}
+		outcome, err := store.RecordUpload(Upload{
+			Hash:             req.Hash,
+			UserName:         req.UserName,
+			NetworkName:      req.NetworkName,
+			HoldTimeInMonths: req.HoldTimeInMonths,
+			Expiry:           req.ExpiryTime(time.Now()),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to record upload: %w", err)
+		}
+		entry := qm.LogEntry(ctx).WithFields(log.Fields{
+			"hash":         req.Hash,
+			"network_name": req.NetworkName,
+		})
+		switch outcome {
+		case UploadCreated:
+			entry.Info("upload recorded")
+		case UploadExtended:
+			entry.Info("upload hold time extended")
+		default:
+			entry.Info("skipping upload which is already held for as long")
+			if !d.Redelivered && !pinPublished(ctx) {
+				qm.RefundCredits(ctx, d, ErrDuplicateUpload)
+			}
+		}
+		return nil
+	}
+}
+
+// HeaderPrepaid marks messages whose credit cost was already charged with another
+// message, such as the pins published by PinFileAdds, so that they aren't charged or
+// billed again. Their credit cost is still refunded should they fail. When signing,
+// the header holds a signature covering the message's own signature, so that only
+// producers holding the SigningSecret can mark messages as prepaid, while without
+// signing, where every message is trusted, it holds true.
+const HeaderPrepaid = "x-prepaid"
+
+// pinPublishedKey is the context key marking file adds whose pin was published
+type pinPublishedKey struct{}
+
+// pinPublished is used to check whether PinFileAdds published a pin for the file add
+// being handled
+func pinPublished(ctx context.Context) bool {
+	published, _ := ctx.Value(pinPublishedKey{}).(bool)
+	return published
+}
+
+// FilePinOpts is used to control the pins published by PinFileAdds
+type FilePinOpts struct {
+	// PinShare is the fraction of a file add's credit cost carried by its pin,
+	// between 0 and 1, being what is refunded should the pin fail
+	PinShare float64
+}
+
+// PinFileAdds is used to wrap a handler of DatabaseFileAddQueue, such as
+// DatabaseFileAddHandler, so that the content of each file add is pinned, rather than
+// producers publishing a pin alongside it. An IPFSPin carrying the file add's hold
+// time, network and user, along with its share of the credit cost, is published to
+// IpfsPinQueue before the file add is handled, sharing its correlation id, and the
+// file add is retried later without being handled should publishing fail, so that the
+// upload is never recorded without its content being pinned. Pins are marked with
+// HeaderPrepaid, as their cost was charged with the file add, which is therefore not
+// refunded by DatabaseFileAddHandler should the user already hold the content. A file
+// add which fails to be handled may publish its pin again when redelivered, which
+// consumers of the pin queue with an idempotency store skip, as the pin is identical.
+func (qm *Manager) PinFileAdds(handler Handler, opts FilePinOpts) Handler {
+	if opts.PinShare < 0 {
+		opts.PinShare = 0
+	}
+	if opts.PinShare > 1 {
+		opts.PinShare = 1
+	}
+	return func(ctx context.Context, d amqp.Delivery) error {
+		req, err := Decode[DatabaseFileAdd](d.Body)
+		if err != nil {
+			return Drop(err)
+		}
+		pin := IPFSPin{
+			CID:              req.Hash,
+			NetworkName:      req.NetworkName,
+			UserName:         req.UserName,
+			HoldTimeInMonths: req.HoldTimeInMonths,
+			CreditCost:       req.CreditCost * opts.PinShare,
+		}
+		if err = qm.publish(ctx, qm.channel(), "", IpfsPinQueue, pin, qm.markPrepaid); err != nil {
+			// malformed file adds make for malformed pins, which won't succeed if retried
+			if errors.Is(err, ErrValidation) {
+				return Drop(fmt.Errorf("invalid pin for file add: %w", err))
+			}
+			return RetryLater(fmt.Errorf("failed to publish pin for file add: %w", err))
+		}
+		qm.LogEntry(ctx).WithFields(log.Fields{
+			"hash":         req.Hash,
+			"network_name": req.NetworkName,
+			"credit_cost":  pin.CreditCost,
+		}).Info("published pin for file add")
+		return handler(context.WithValue(ctx, pinPublishedKey{}, true), d)
+	}
+}
+
+// markPrepaid is a PublishOption marking a message with HeaderPrepaid
+func (qm *Manager) markPrepaid(msg *amqp.Publishing) {
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	if len(qm.SigningSecret) == 0 {
+		msg.Headers[HeaderPrepaid] = true
+		return
+	}
+	signature, _ := msg.Headers[HeaderSignature].(string)
+	msg.Headers[HeaderPrepaid] = qm.sign([]byte(HeaderPrepaid + signature))
+}
+
+// prepaid is used to check whether a delivery's credit cost was already charged.
+// when signing, deliveries whose header wasn't signed with our secret are charged
+func (qm *Manager) prepaid(d amqp.Delivery) bool {
+	if len(qm.SigningSecret) == 0 {
+		paid, _ := d.Headers[HeaderPrepaid].(bool)
+		return paid
+	}
+	tag, _ := d.Headers[HeaderPrepaid].(string)
+	signature, _ := d.Headers[HeaderSignature].(string)
+	expected := qm.sign([]byte(HeaderPrepaid + signature))
+	return signature != "" && hmac.Equal([]byte(tag), []byte(expected))
+}
@@ -0,0 +1,251 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestDatabaseFileAddHandler(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.CreditRefundQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.DatabaseFileAddQueue))
+	store := queue.NewMemoryUploadStore()
+	handler := qm.DatabaseFileAddHandler(store)
+	add := func(months int64, cost float64, redelivered bool) {
+		t.Helper()
+		body, err := json.Marshal(queue.DatabaseFileAdd{Hash: testCID, NetworkName: "public", UserName: "alice", HoldTimeInMonths: months, CreditCost: cost})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = handler(context.Background(), amqp.Delivery{Body: body, Redelivered: redelivered}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	held := func() int64 {
+		t.Helper()
+		uploads := store.Uploads()
+		if len(uploads) != 1 {
+			t.Fatalf("expected a single upload, got %v", len(uploads))
+		}
+		return uploads[0].HoldTimeInMonths
+	}
+	add(6, 1, false)
+	if held() != 6 {
+		t.Fatalf("expected the upload to be held for 6 months, got %v", held())
+	}
+	// a longer hold time extends the upload
+	add(12, 2, false)
+	if held() != 12 {
+		t.Fatalf("expected the upload to be extended to 12 months, got %v", held())
+	}
+	if _, ok := broker.Get(queue.CreditRefundQueue); ok {
+		t.Fatal("expected no refunds for recorded uploads")
+	}
+	// a shorter hold time never shortens it, and is refunded
+	add(3, 3, false)
+	if held() != 12 {
+		t.Fatalf("expected the upload to stay held for 12 months, got %v", held())
+	}
+	d, ok := broker.Get(queue.CreditRefundQueue)
+	if !ok {
+		t.Fatal("expected the skipped upload to be refunded")
+	}
+	refund, err := queue.Decode[queue.CreditRefund](d.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Amount != 3 || refund.UserName != "alice" {
+		t.Fatalf("unexpected refund %+v", refund)
+	}
+	// a redelivered request may have been recorded before, so isn't refunded
+	add(12, 2, true)
+	if _, ok := broker.Get(queue.CreditRefundQueue); ok {
+		t.Fatal("expected the redelivered upload not to be refunded")
+	}
+}
+
+func TestMemoryUploadStore_Concurrent(t *testing.T) {
+	store := queue.NewMemoryUploadStore()
+	upload := queue.Upload{Hash: testCID, UserName: "alice", NetworkName: "public", HoldTimeInMonths: 1}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outcome, err := store.RecordUpload(upload)
+			if err != nil {
+				t.Error(err)
+			}
+			if outcome == queue.UploadCreated {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 || len(store.Uploads()) != 1 {
+		t.Fatalf("expected a single upload to be created, got %v", created)
+	}
+}
+
+func TestPinFileAdds(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.IpfsPinQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	balances := queue.NewMemoryBalances(map[string]float64{"alice": 10})
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.DatabaseFileAddQueue), queue.WithBalanceChecker(balances))
+	pins := newMemoryManager(t, broker, queue.WithBalanceChecker(balances))
+	store := queue.NewMemoryUploadStore()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(queue.WithCorrelationID(ctx, "upload"), queue.DatabaseFileAdd{
+		Hash: testCID, NetworkName: "public", UserName: "alice", HoldTimeInMonths: 6, CreditCost: 4,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	qm.ConsumeMessageContext(ctx, "test", qm.PinFileAdds(func(ctx context.Context, d amqp.Delivery) error {
+		defer cancel()
+		return qm.DatabaseFileAddHandler(store)(ctx, d)
+	}, queue.FilePinOpts{PinShare: 0.25}))
+	if len(store.Uploads()) != 1 {
+		t.Fatal("expected the upload to be recorded")
+	}
+	d, ok := broker.Get(queue.IpfsPinQueue)
+	if !ok {
+		t.Fatal("expected a pin to be published")
+	}
+	pin, err := queue.DecodeDelivery[queue.IPFSPin](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "alice", HoldTimeInMonths: 6, CreditCost: 1}
+	if pin != want {
+		t.Fatalf("unexpected pin %+v", pin)
+	}
+	if id, _ := d.Headers[queue.HeaderCorrelationID].(string); id != "upload" {
+		t.Fatalf("expected the pin to share the file add's correlation id, got %q", id)
+	}
+	// the pin's cost was charged with the file add, so isn't charged again
+	if balance := balances.Balance("alice"); balance != 6 {
+		t.Fatalf("expected alice to have 6 credits left, got %v", balance)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = broker.Publish(ctx, "", queue.IpfsPinQueue, amqp.Publishing{Headers: d.Headers, ContentType: d.ContentType, Type: d.Type, Body: d.Body}); err != nil {
+		t.Fatal(err)
+	}
+	pins.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		cancel()
+		return nil
+	})
+	if balance := balances.Balance("alice"); balance != 6 {
+		t.Fatalf("expected the pin not to be charged, alice has %v credits left", balance)
+	}
+}
+
+func TestPinFileAdds_PublishFailure(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.DatabaseFileAddQueue))
+	store := queue.NewMemoryUploadStore()
+	body, err := json.Marshal(queue.DatabaseFileAdd{Hash: testCID, NetworkName: "public", UserName: "alice", HoldTimeInMonths: 6, CreditCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a closed broker fails the pin's publish, so the upload mustn't be recorded
+	broker.Close()
+	err = qm.PinFileAdds(qm.DatabaseFileAddHandler(store), queue.FilePinOpts{})(context.Background(), amqp.Delivery{Body: body})
+	if !errors.Is(err, queue.ErrRetryLater) {
+		t.Fatalf("expected the file add to be requeued, got %v", err)
+	}
+	if len(store.Uploads()) != 0 {
+		t.Fatal("expected the upload not to be recorded")
+	}
+}
+
+func TestPinFileAdds_HeldContent(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	for _, name := range []string{queue.IpfsPinQueue, queue.CreditRefundQueue} {
+		if err := broker.DeclareQueue(name, queue.QueueOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.DatabaseFileAddQueue))
+	store := queue.NewMemoryUploadStore()
+	handler := qm.PinFileAdds(qm.DatabaseFileAddHandler(store), queue.FilePinOpts{PinShare: 0.25})
+	body, err := json.Marshal(queue.DatabaseFileAdd{Hash: testCID, NetworkName: "public", UserName: "alice", HoldTimeInMonths: 6, CreditCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = handler(context.Background(), amqp.Delivery{Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// content already held is pinned again with the file add's credits, so the
+	// file add isn't refunded
+	if _, ok := broker.Get(queue.CreditRefundQueue); ok {
+		t.Fatal("expected the pinned file add not to be refunded")
+	}
+}
+
+func TestPinFileAdds_SignedPrepaid(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.IpfsPinQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	balances := queue.NewMemoryBalances(map[string]float64{"alice": 10})
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.DatabaseFileAddQueue))
+	pins := newMemoryManager(t, broker, queue.WithBalanceChecker(balances))
+	qm.SigningSecret, pins.SigningSecret = []byte("secret"), []byte("secret")
+	body, err := json.Marshal(queue.DatabaseFileAdd{Hash: testCID, NetworkName: "public", UserName: "alice", HoldTimeInMonths: 6, CreditCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = qm.PinFileAdds(func(ctx context.Context, d amqp.Delivery) error {
+		return nil
+	}, queue.FilePinOpts{PinShare: 0.25})(context.Background(), amqp.Delivery{Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a signed pin marked as prepaid by a producer without our secret is charged
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	forged := queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "alice", HoldTimeInMonths: 6, CreditCost: 1}
+	if err = pins.PublishMessageContext(ctx, forged, queue.WithHeaders(map[string]interface{}{
+		queue.HeaderPrepaid: true,
+	})); err != nil {
+		t.Fatal(err)
+	}
+	consumed := 0
+	pins.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		if consumed++; consumed == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if consumed != 2 {
+		t.Fatalf("expected both pins to be consumed, got %v", consumed)
+	}
+	if balance := balances.Balance("alice"); balance != 9 {
+		t.Fatalf("expected only the forged pin to be charged, alice has %v credits left", balance)
+	}
+}
@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/streadway/amqp"
+)
+
+// WithHeaders is used to publish a message with the given headers, such as routing
+// hints which don't belong in the message itself, alongside those the manager sets.
+// Values must be of a type amqp supports, as checked when publishing, such as
+// strings, signed integers, floats, booleans, times, byte slices, and []interface{}
+// and amqp.Table values holding those. Headers named like those the manager sets
+// replace them, so names should avoid the x- prefix the package uses.
+func WithHeaders(headers map[string]interface{}) PublishOption {
+	return func(msg *amqp.Publishing) {
+		if msg.Headers == nil {
+			msg.Headers = amqp.Table{}
+		}
+		for k, v := range headers {
+			msg.Headers[k] = v
+		}
+	}
+}
+
+// validateHeaders is used to check the values of a message's headers are of types
+// amqp supports before publishing, as the client fails to encode the message
+// otherwise. headers are checked in order of name, so that the error is stable
+func validateHeaders(headers amqp.Table) error {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := (amqp.Table{name: headers[name]}).Validate(); err != nil {
+			return fmt.Errorf("unsupported header: %w", err)
+		}
+	}
+	return nil
+}
+
+type headersKey struct{}
+
+// MessageHeaders is used to get the headers of the message being processed, for
+// handlers such as those created by Typed which aren't given the delivery. The
+// headers are a copy, so handlers may modify them.
+func MessageHeaders(ctx context.Context) amqp.Table {
+	headers, _ := ctx.Value(headersKey{}).(amqp.Table)
+	copied := make(amqp.Table, len(headers))
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return copied
+}
+
+// headersContext is used to attach the headers of a delivery to ctx
+func headersContext(ctx context.Context, d amqp.Delivery) context.Context {
+	if len(d.Headers) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, headersKey{}, d.Headers)
+}
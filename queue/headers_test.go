@@ -0,0 +1,67 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestManager_Headers(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	headers := map[string]interface{}{
+		"tenant":    "acme",
+		"tier":      int32(2),
+		"origin":    "api",
+		"sampled":   true,
+		"published": time.Now().Truncate(time.Second),
+		"tags":      []interface{}{"a", "b"},
+	}
+	if err := qm.PublishMessageContext(ctx, testPin("user"), queue.WithHeaders(headers)); err != nil {
+		t.Fatal(err)
+	}
+	var received amqp.Table
+	qm.ConsumeMessageContext(ctx, "test", queue.Typed(func(ctx context.Context, msg queue.IPFSPin) error {
+		received = queue.MessageHeaders(ctx)
+		cancel()
+		return nil
+	}))
+	if received["tenant"] != "acme" || received["tier"] != int32(2) || received["sampled"] != true {
+		t.Fatalf("expected the published headers to reach the handler, got %v", received)
+	}
+	if received[queue.HeaderCorrelationID] == nil {
+		t.Fatal("expected the manager's headers to be kept alongside")
+	}
+}
+
+func TestManager_Headers_Unsupported(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	for _, value := range []interface{}{
+		struct{ Tenant string }{"acme"},
+		map[string]string{"tenant": "acme"},
+		[]string{"a", "b"},
+		uint32(1),
+		func() {},
+	} {
+		err := qm.PublishMessageContext(context.Background(), testPin("user"), queue.WithHeaders(map[string]interface{}{
+			"tenant": "acme",
+			"hint":   value,
+		}))
+		if !errors.Is(err, queue.ErrValidation) || !strings.Contains(err.Error(), "hint") {
+			t.Fatalf("expected header value of type %T to be refused, got %v", value, err)
+		}
+	}
+	if n := broker.Len(queue.IpfsPinQueue); n != 0 {
+		t.Fatalf("expected nothing to be published, got %v messages", n)
+	}
+}
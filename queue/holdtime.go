@@ -0,0 +1,69 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// holdExpiry is used to get when content held for the given number of months from
+// from should be garbage collected. Calendar months are used, so that content held
+// for a month from the 15th expires on the 15th of the following month, with Go's
+// normalization carrying over short months, such that a month from January 31st
+// is March 3rd, or 2nd in a leap year.
+func holdExpiry(from time.Time, months int64) time.Time {
+	return from.AddDate(0, int(months), 0)
+}
+
+// ExpiryTime is used to get when the pin should be garbage collected, if held from from
+func (i IPFSPin) ExpiryTime(from time.Time) time.Time {
+	return holdExpiry(from, i.HoldTimeInMonths)
+}
+
+// ExpiryTime is used to get when the cluster pin should be garbage collected, if held from from
+func (i IPFSClusterPin) ExpiryTime(from time.Time) time.Time {
+	return holdExpiry(from, i.HoldTimeInMonths)
+}
+
+// ExpiryTime is used to get when the file should be garbage collected, if held from from
+func (i IPFSFile) ExpiryTime(from time.Time) time.Time {
+	return holdExpiry(from, i.HoldTimeInMonths)
+}
+
+// ExpiryTime is used to get when the file should be garbage collected, if held from from
+func (d DatabaseFileAdd) ExpiryTime(from time.Time) time.Time {
+	return holdExpiry(from, d.HoldTimeInMonths)
+}
+
+// UnmarshalJSON is used to decode an ipfs file message, accepting hold times encoded
+// as strings, as they were before hold times were standardized, so that messages
+// queued by older producers still decode
+func (i *IPFSFile) UnmarshalJSON(data []byte) error {
+	// the alias drops our methods, so that decoding it doesn't recurse
+	type ipfsFile IPFSFile
+	msg := struct {
+		*ipfsFile
+		HoldTimeInMonths json.RawMessage `json:"hold_time_in_months"`
+	}{ipfsFile: (*ipfsFile)(i)}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	raw := msg.HoldTimeInMonths
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		raw = json.RawMessage(s)
+	}
+	months, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return fmt.Errorf("hold_time_in_months is not a number: %w", err)
+	}
+	i.HoldTimeInMonths = months
+	return nil
+}
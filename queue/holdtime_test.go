@@ -0,0 +1,59 @@
+package queue_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestExpiryTime(t *testing.T) {
+	from := time.Date(2018, time.January, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		got  time.Time
+		want time.Time
+	}{
+		{"IPFSPin", queue.IPFSPin{HoldTimeInMonths: 1}.ExpiryTime(from), time.Date(2018, time.February, 15, 12, 0, 0, 0, time.UTC)},
+		{"IPFSClusterPin", queue.IPFSClusterPin{HoldTimeInMonths: 12}.ExpiryTime(from), time.Date(2019, time.January, 15, 12, 0, 0, 0, time.UTC)},
+		{"IPFSFile", queue.IPFSFile{HoldTimeInMonths: 3}.ExpiryTime(from), time.Date(2018, time.April, 15, 12, 0, 0, 0, time.UTC)},
+		{"DatabaseFileAdd", queue.DatabaseFileAdd{HoldTimeInMonths: 24}.ExpiryTime(from), time.Date(2020, time.January, 15, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.got.Equal(tt.want) {
+				t.Fatalf("ExpiryTime() = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPFSFile_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int64
+		wantErr bool
+	}{
+		{"Number", `{"object_name":"object","hold_time_in_months":6}`, 6, false},
+		{"LegacyString", `{"object_name":"object","hold_time_in_months":"6"}`, 6, false},
+		{"Missing", `{"object_name":"object"}`, 0, false},
+		{"BadString", `{"object_name":"object","hold_time_in_months":"six"}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var file queue.IPFSFile
+			err := json.Unmarshal([]byte(tt.data), &file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if file.HoldTimeInMonths != tt.want || file.ObjectName != "object" {
+				t.Fatalf("Unmarshal() = %+v, want hold time %v", file, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package queue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultIdempotencyWindow is how long processed messages are remembered for
+// when no window is configured
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// IdempotencyStore is used to record which messages have been processed, so that
+// redelivered messages are skipped. Implementations must be safe for concurrent
+// use, and claiming a key must be atomic, which for redis is a SET with NX and EX.
+type IdempotencyStore interface {
+	// Claim is used to claim a key for the given window, returning false if it
+	// has already been claimed within its window
+	Claim(key string, window time.Duration) (bool, error)
+	// Release is used to release a claimed key, so that a message which failed
+	// processing may be processed again
+	Release(key string) error
+}
+
+// MemoryStore is an in-memory IdempotencyStore, which only deduplicates messages
+// processed by the same process
+type MemoryStore struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// NewMemoryStore is used to create an empty in-memory idempotency store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{expires: make(map[string]time.Time)}
+}
+
+// Claim is used to claim a key for the given window
+func (s *MemoryStore) Claim(key string, window time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// drop expired keys as we go, so the store doesn't grow without bound
+	for k, expiry := range s.expires {
+		if now.After(expiry) {
+			delete(s.expires, k)
+		}
+	}
+	if _, ok := s.expires[key]; ok {
+		return false, nil
+	}
+	s.expires[key] = now.Add(window)
+	return true, nil
+}
+
+// Release is used to release a claimed key
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	delete(s.expires, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// idempotencyKey is used to get the key identifying a delivery, which is the
+// message's idempotency_key field when set, and otherwise derived from its contents.
+// messages requeued by Retry are distinguished by their attempt, as the message
+// they were copied from has already been claimed
+func idempotencyKey(queueName string, d amqp.Delivery) string {
+	key := messageKey(d)
+	if attempt := Attempt(d); attempt > 1 {
+		key = fmt.Sprintf("%s:%v", key, attempt)
+	}
+	return queueName + ":" + key
+}
+
+// messageKey is used to get the key identifying a message regardless of how many
+// times it has been attempted
+func messageKey(d amqp.Delivery) string {
+	var msg struct {
+		IdempotencyKey string `json:"idempotency_key"`
+	}
+	if err := peek(d, &msg); err == nil && msg.IdempotencyKey != "" {
+		return msg.IdempotencyKey
+	}
+	sum := sha256.Sum256(d.Body)
+	return hex.EncodeToString(sum[:])
+}
+
+// claim is used to claim a delivery before processing it, returning false if it
+// has already been processed. deliveries are always claimed when no idempotency
+// store is configured
+func (qm *Manager) claim(d amqp.Delivery) (key string, claimed bool, err error) {
+	if qm.Idempotency == nil {
+		return "", true, nil
+	}
+	window := qm.IdempotencyWindow
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	key = idempotencyKey(qm.QueueName, d)
+	claimed, err = qm.Idempotency.Claim(key, window)
+	return key, claimed, err
+}
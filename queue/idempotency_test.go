@@ -0,0 +1,31 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := queue.NewMemoryStore()
+	if claimed, err := s.Claim("key", time.Minute); err != nil || !claimed {
+		t.Fatalf("Claim() = %v, %v, want first claim to succeed", claimed, err)
+	}
+	if claimed, err := s.Claim("key", time.Minute); err != nil || claimed {
+		t.Fatalf("Claim() = %v, %v, want duplicate claim to fail", claimed, err)
+	}
+	if claimed, err := s.Claim("other", time.Minute); err != nil || !claimed {
+		t.Fatalf("Claim() = %v, %v, want other key to be claimed", claimed, err)
+	}
+	if err := s.Release("key"); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := s.Claim("key", time.Millisecond); err != nil || !claimed {
+		t.Fatalf("Claim() = %v, %v, want released key to be claimed", claimed, err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if claimed, err := s.Claim("key", time.Minute); err != nil || !claimed {
+		t.Fatalf("Claim() = %v, %v, want expired key to be claimed", claimed, err)
+	}
+}
@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/RTradeLtd/database/models"
+	"github.com/jinzhu/gorm"
+)
+
+// dbIPNSRecordStore is an IPNSRecordStore backed by our database
+type dbIPNSRecordStore struct {
+	db *gorm.DB
+}
+
+// ipnsPageSize is the number of records ExpiringRecords loads at a time
+const ipnsPageSize = 1000
+
+// NewIPNSRecordStore is used to create an IPNSRecordStore finding records in db.
+// Whether records were published resolved isn't stored, so they're all republished
+// resolved, as records are by default
+func NewIPNSRecordStore(db *gorm.DB) IPNSRecordStore {
+	return &dbIPNSRecordStore{db: db}
+}
+
+// ExpiringRecords is used to list the records expiring between the given times. As
+// lifetimes are stored as duration strings, expiry is worked out here rather than
+// by the database, which only excludes records published since before, as they
+// can't expire before it. Records are loaded a page at a time, while records the
+// user has deleted are soft deleted, so are excluded by gorm
+func (s *dbIPNSRecordStore) ExpiringRecords(after, before time.Time) ([]IPNSRecord, error) {
+	var (
+		records []IPNSRecord
+		lastID  uint
+	)
+	for {
+		var entries []models.IPNS
+		if err := s.db.Where("id > ? AND updated_at < ?", lastID, before).
+			Order("id").Limit(ipnsPageSize).Find(&entries).Error; err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			lifeTime, err := time.ParseDuration(entry.LifeTime)
+			if err != nil {
+				continue
+			}
+			ttl, err := time.ParseDuration(entry.TTL)
+			if err != nil {
+				continue
+			}
+			record := IPNSRecord{
+				IPNSHash:    entry.IPNSHash,
+				CID:         entry.CurrentIPFSHash,
+				Key:         entry.Key,
+				UserName:    entry.UserName,
+				NetworkName: entry.NetworkName,
+				LifeTime:    lifeTime,
+				TTL:         ttl,
+				Resolve:     true,
+				PublishedAt: entry.UpdatedAt,
+			}
+			if expiry := record.Expiry(); expiry.After(after) && expiry.Before(before) {
+				records = append(records, record)
+			}
+		}
+		if len(entries) < ipnsPageSize {
+			return records, nil
+		}
+		lastID = entries[len(entries)-1].ID
+	}
+}
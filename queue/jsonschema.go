@@ -0,0 +1,210 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JSONSchemaDraft is the json schema draft our message schemas conform to
+const JSONSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// JSONSchema is a json schema describing a message, or one of its fields
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+// requiredPattern matches the errors Validate returns for missing required fields
+var requiredPattern = regexp.MustCompile(`^(\S+) is required$`)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(Duration(0))
+	bytesType    = reflect.TypeOf([]byte(nil))
+)
+
+// MessageSchemas is used to get a json schema for the message of each of our queues,
+// keyed by queue name, so that clients can validate messages against the same
+// definitions our consumers use. Schemas are derived from the message structs and
+// their json tags, with the fields required by the messages' Validate methods being
+// listed as required.
+func MessageSchemas() map[string]*JSONSchema {
+	schemas := make(map[string]*JSONSchema, len(messageTypes))
+	for queueName, typ := range messageTypes {
+		schemas[queueName] = messageSchema(typ)
+	}
+	return schemas
+}
+
+// MessageSchema is used to get the json schema of the message of a single queue
+func MessageSchema(queueName string) (*JSONSchema, error) {
+	typ, ok := messageTypes[queueName]
+	if !ok {
+		return nil, fmt.Errorf("no message type registered for queue %s", queueName)
+	}
+	return messageSchema(typ), nil
+}
+
+// messageSchema is used to derive the json schema of a message type
+func messageSchema(typ reflect.Type) *JSONSchema {
+	schema := typeSchema(typ, make(map[reflect.Type]bool))
+	schema.Schema = JSONSchemaDraft
+	schema.Title = typ.Name()
+	requiredFields(typ, schema)
+	return schema
+}
+
+// typeSchema is used to derive the json schema of a type, with seen holding the
+// structs being described so that recursive types terminate
+func typeSchema(typ reflect.Type, seen map[reflect.Type]bool) *JSONSchema {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ {
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case durationType:
+		// durations are encoded as duration strings, such as 48h0m0s
+		return &JSONSchema{Type: "string"}
+	case bytesType:
+		return &JSONSchema{Type: "string", ContentEncoding: "base64"}
+	}
+	switch typ.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: typeSchema(typ.Elem(), seen)}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: typeSchema(typ.Elem(), seen)}
+	case reflect.Struct:
+		if seen[typ] {
+			return &JSONSchema{Type: "object"}
+		}
+		seen[typ] = true
+		defer delete(seen, typ)
+		schema := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			name, ok := jsonName(field)
+			if !ok {
+				continue
+			}
+			schema.Properties[name] = typeSchema(field.Type, seen)
+		}
+		return schema
+	}
+	// interfaces may hold anything
+	return &JSONSchema{}
+}
+
+// jsonName is used to get the name a struct field is encoded with, returning false
+// for fields which aren't encoded
+func jsonName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	switch name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	}
+	return name, true
+}
+
+// requiredFields is used to mark the fields of schema required by the Validate
+// method of typ. Validate reports the first missing required field, so we validate
+// the zero value of typ, filling in each field reported missing, until it passes or
+// fails for some other reason
+func requiredFields(typ reflect.Type, schema *JSONSchema) {
+	msg := reflect.New(typ).Elem()
+	for {
+		v, ok := msg.Interface().(validator)
+		if !ok {
+			return
+		}
+		err := v.Validate()
+		if err == nil {
+			return
+		}
+		match := requiredPattern.FindStringSubmatch(err.Error())
+		if match == nil {
+			return
+		}
+		field, parent := findField(msg, schema, match[1])
+		if !fill(field) {
+			return
+		}
+		parent.Required = append(parent.Required, match[1])
+		sort.Strings(parent.Required)
+	}
+}
+
+// fill is used to set an empty string, or string slice, field reported missing by
+// Validate, returning false for fields we're unable to fill
+func fill(field reflect.Value) bool {
+	if !field.IsValid() || !field.IsZero() {
+		return false
+	}
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString("required")
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		field.Set(reflect.Append(field, reflect.ValueOf("required").Convert(field.Type().Elem())))
+	default:
+		return false
+	}
+	return true
+}
+
+// findField is used to find the field encoded as name within v, searching
+// nested structs, returning it along with the schema of the object holding it
+func findField(v reflect.Value, schema *JSONSchema, name string) (reflect.Value, *JSONSchema) {
+	typ := v.Type()
+	var nested []int
+	for i := 0; i < typ.NumField(); i++ {
+		fieldName, ok := jsonName(typ.Field(i))
+		if !ok {
+			continue
+		}
+		if fieldName == name {
+			return v.Field(i), schema
+		}
+		if v.Field(i).Kind() == reflect.Struct && typ.Field(i).Type != timeType {
+			nested = append(nested, i)
+		}
+	}
+	for _, i := range nested {
+		fieldName, _ := jsonName(typ.Field(i))
+		if field, parent := findField(v.Field(i), schema.Properties[fieldName], name); field.IsValid() {
+			return field, parent
+		}
+	}
+	return reflect.Value{}, nil
+}
+
+// String is used to encode the schema as json
+func (s *JSONSchema) String() string {
+	data, _ := json.MarshalIndent(s, "", "  ")
+	return string(data)
+}
@@ -0,0 +1,86 @@
+package queue_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestMessageSchema(t *testing.T) {
+	schema, err := queue.MessageSchema(queue.IpfsPinQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Schema != queue.JSONSchemaDraft || schema.Title != "IPFSPin" || schema.Type != "object" {
+		t.Fatalf("unexpected schema %s", schema)
+	}
+	types := map[string]string{
+		"cid":                 "string",
+		"network_name":        "string",
+		"user_name":           "string",
+		"hold_time_in_months": "integer",
+		"credit_cost":         "number",
+	}
+	if len(schema.Properties) != len(types) {
+		t.Fatalf("unexpected properties %s", schema)
+	}
+	for name, typ := range types {
+		if prop := schema.Properties[name]; prop == nil || prop.Type != typ {
+			t.Fatalf("expected %s to be a %s, got %s", name, typ, schema)
+		}
+	}
+	if want := []string{"cid", "network_name", "user_name"}; !reflect.DeepEqual(schema.Required, want) {
+		t.Fatalf("expected %v to be required, got %v", want, schema.Required)
+	}
+	if _, err = queue.MessageSchema("unknown-queue"); err == nil {
+		t.Fatal("expected an error for an unknown queue")
+	}
+}
+
+func TestMessageSchema_Nested(t *testing.T) {
+	schema, err := queue.MessageSchema(queue.ZoneImportQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"user_name"}; !reflect.DeepEqual(schema.Required, want) {
+		t.Fatalf("unexpected required fields %v", schema.Required)
+	}
+	document := schema.Properties["document"]
+	if document == nil || document.Type != "object" {
+		t.Fatalf("unexpected document schema %s", schema)
+	}
+	if want := []string{"manager_key_name", "zone_key_name"}; !reflect.DeepEqual(document.Required, want) {
+		t.Fatalf("unexpected document required fields %v", document.Required)
+	}
+	if prop := document.Properties["exported_at"]; prop == nil || prop.Format != "date-time" {
+		t.Fatalf("expected exported_at to be a date-time, got %s", document)
+	}
+	if prop := document.Properties["record_key_names"]; prop == nil || prop.AdditionalProperties == nil || prop.AdditionalProperties.Type != "string" {
+		t.Fatalf("expected record_key_names to be a map of strings, got %s", document)
+	}
+	bulk, err := queue.MessageSchema(queue.IpfsBulkPinQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prop := bulk.Properties["cids"]; prop == nil || prop.Type != "array" || prop.Items.Type != "string" {
+		t.Fatalf("expected cids to be an array of strings, got %s", bulk)
+	}
+	if len(bulk.Required) == 0 || bulk.Required[0] != "cids" {
+		t.Fatalf("expected cids to be required, got %v", bulk.Required)
+	}
+}
+
+func TestMessageSchemas(t *testing.T) {
+	schemas := queue.MessageSchemas()
+	for _, name := range []string{queue.IpfsPinQueue, queue.EmailSendQueue, queue.ZoneCreationQueue, queue.CreditRefundQueue} {
+		schema, ok := schemas[name]
+		if !ok {
+			t.Fatalf("expected a schema for %s", name)
+		}
+		if _, err := json.Marshal(schema); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
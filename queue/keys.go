@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+var (
+	// ErrKeyNotOwned is returned when a user tries to delete, or publish ipns records
+	// with, a key they don't own
+	ErrKeyNotOwned = errors.New("key is not owned by user")
+	// ErrKeyInUse is returned when deleting a key which active ipns records are published with
+	ErrKeyInUse = errors.New("key is in use by ipns records")
+)
+
+// KeyOwnershipStore is used to check which user keys belong to, and is implemented
+// by every KeyStore
+type KeyOwnershipStore interface {
+	// KeyOwnedBy reports whether the named key belongs to the user
+	KeyOwnedBy(userName, name string) (bool, error)
+}
+
+// KeyStore is used by the key deletion consumer to look up and delete keys
+type KeyStore interface {
+	// HasKey reports whether the named key exists
+	HasKey(name string) (bool, error)
+	// KeyOwnedBy reports whether the named key belongs to the user
+	KeyOwnedBy(userName, name string) (bool, error)
+	// KeyInUse reports whether any of the user's ipns records are published with the key
+	KeyInUse(userName, name string) (bool, error)
+	// DeleteKey deletes the named key, removing it from the user. Keys which were
+	// partially deleted are finished off
+	DeleteKey(userName, name string) error
+}
+
+// KeyDeletionHandler is used to delete keys requested through IpfsKeyDeletionQueue.
+// Keys are only deleted once we've checked they belong to the requesting user and no
+// ipns records are published with them, with requests failing these checks being
+// dropped. Deleting a key which no longer exists succeeds, so that a redelivered
+// request is simply acknowledged.
+func (qm *Manager) KeyDeletionHandler(keys KeyStore) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		req, err := Decode[IPFSKeyDeletion](d.Body)
+		if err != nil {
+			return Drop(err)
+		}
+		owned, err := keys.KeyOwnedBy(req.UserName, req.Name)
+		if err != nil {
+			return fmt.Errorf("failed to check key ownership: %w", err)
+		}
+		if !owned {
+			exists, err := keys.HasKey(req.Name)
+			if err != nil {
+				return fmt.Errorf("failed to search for key: %w", err)
+			}
+			if exists {
+				return Drop(ErrKeyNotOwned)
+			}
+			qm.LogInfo("key already deleted")
+			return nil
+		}
+		inUse, err := keys.KeyInUse(req.UserName, req.Name)
+		if err != nil {
+			return fmt.Errorf("failed to check key usage: %w", err)
+		}
+		if inUse {
+			return Drop(ErrKeyInUse)
+		}
+		if err = keys.DeleteKey(req.UserName, req.Name); err != nil {
+			return fmt.Errorf("failed to delete key: %w", err)
+		}
+		qm.LogInfo("key deleted")
+		return nil
+	}
+}
+
+// CheckKeyOwnership is used to wrap the handler of the ipns entry queue so that users
+// may only publish ipns records with keys they own, preventing one user from taking
+// over another's ipns name. Entries using a key the user doesn't own are dropped
+// without being passed to handler, with their credits refunded and the user emailed
+// IpnsEntryFailedContent. Should the ownership check itself fail the entry fails too,
+// so that it may be retried, rather than being published unchecked.
+func (qm *Manager) CheckKeyOwnership(handler Handler, keys KeyOwnershipStore) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var entry IPNSEntry
+		if err := UnmarshalDelivery(d, &entry); err != nil {
+			return Drop(err)
+		}
+		owned, err := keys.KeyOwnedBy(entry.UserName, entry.Key)
+		if err != nil {
+			return fmt.Errorf("failed to check key ownership: %w", err)
+		}
+		if owned {
+			return handler(ctx, d)
+		}
+		qm.LogEntry(ctx).WithFields(log.Fields{
+			"user": entry.UserName,
+			"key":  entry.Key,
+		}).Warn("rejecting ipns entry using a key not owned by the user")
+		qm.RefundCredits(ctx, d, ErrKeyNotOwned)
+		email := NewIPNSEntryFailedEmail(entry.CID, entry.Key, ErrKeyNotOwned.Error(), []string{entry.UserName})
+		if pubErr := qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); pubErr != nil {
+			qm.logError(ctx, pubErr, "failed to publish ipns entry failure email")
+		}
+		return Drop(ErrKeyNotOwned)
+	}
+}
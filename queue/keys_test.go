@@ -0,0 +1,165 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// fakeKeyStore is an in memory KeyStore, holding the owner of each key
+type fakeKeyStore struct {
+	owners map[string]string
+	inUse  map[string]bool
+	// keys which were deleted from the keystore but not yet from their owner
+	partial map[string]bool
+}
+
+func (f *fakeKeyStore) HasKey(name string) (bool, error) {
+	_, ok := f.owners[name]
+	return ok && !f.partial[name], nil
+}
+
+func (f *fakeKeyStore) KeyOwnedBy(userName, name string) (bool, error) {
+	return f.owners[name] == userName, nil
+}
+
+func (f *fakeKeyStore) KeyInUse(userName, name string) (bool, error) {
+	return f.inUse[name], nil
+}
+
+func (f *fakeKeyStore) DeleteKey(userName, name string) error {
+	delete(f.owners, name)
+	delete(f.partial, name)
+	return nil
+}
+
+func TestKeyDeletionHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr error
+		// whether the key remains once the request is handled
+		remains bool
+	}{
+		{"Deleted", "mine", nil, false},
+		{"AlreadyDeleted", "gone", nil, false},
+		{"PartiallyDeleted", "partial", nil, false},
+		{"NotOwned", "theirs", queue.ErrKeyNotOwned, true},
+		{"InUse", "published", queue.ErrKeyInUse, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := &fakeKeyStore{
+				owners:  map[string]string{"mine": "user", "partial": "user", "theirs": "other", "published": "user"},
+				inUse:   map[string]bool{"published": true},
+				partial: map[string]bool{"partial": true},
+			}
+			broker := queue.NewMemoryBroker()
+			defer broker.Close()
+			qm, err := queue.NewManager("", queue.WithQueue(queue.IpfsKeyDeletionQueue), queue.WithBroker(broker))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err = qm.PublishMessageContext(ctx, queue.IPFSKeyDeletion{UserName: "user", Name: tt.key, NetworkName: "public"}); err != nil {
+				t.Fatal(err)
+			}
+			handler := qm.KeyDeletionHandler(keys)
+			var handlerErr error
+			qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+				defer cancel()
+				handlerErr = handler(ctx, d)
+				return handlerErr
+			})
+			if !errors.Is(handlerErr, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, handlerErr)
+			}
+			if tt.wantErr != nil && !errors.Is(handlerErr, queue.ErrDrop) {
+				t.Fatal("expected refused deletions to be dropped")
+			}
+			if _, exists := keys.owners[tt.key]; exists != tt.remains {
+				t.Fatalf("expected key remaining to be %v", tt.remains)
+			}
+			if broker.Len(queue.IpfsKeyDeletionQueue)+broker.Unacked() != 0 {
+				t.Fatal("expected the request to be acknowledged")
+			}
+		})
+	}
+}
+
+func TestCheckKeyOwnership(t *testing.T) {
+	keys := &fakeKeyStore{owners: map[string]string{"mine": "user", "theirs": "other"}}
+	var tests = []struct {
+		name      string
+		key       string
+		published bool
+	}{
+		{"Owned", "mine", true},
+		{"NotOwned", "theirs", false},
+		{"Missing", "missing", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := newAdminBroker(t)
+			defer broker.Close()
+			if err := broker.DeclareQueue(queue.CreditRefundQueue, queue.QueueOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			qm := newMemoryManager(t, broker, queue.WithQueue(queue.IpnsEntryQueue))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := qm.PublishMessageContext(ctx, queue.IPNSEntry{
+				CID:         testCID,
+				LifeTime:    queue.Duration(24 * time.Hour),
+				Key:         tt.key,
+				UserName:    "user",
+				NetworkName: "public",
+				CreditCost:  2,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			var published bool
+			handler := qm.CheckKeyOwnership(func(ctx context.Context, d amqp.Delivery) error {
+				published = true
+				return nil
+			}, keys)
+			var handlerErr error
+			qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+				defer cancel()
+				handlerErr = handler(ctx, d)
+				return handlerErr
+			})
+			if published != tt.published {
+				t.Fatalf("expected the entry being published to be %v", tt.published)
+			}
+			if tt.published {
+				if handlerErr != nil || broker.Len(queue.EmailSendQueue)+broker.Len(queue.CreditRefundQueue) != 0 {
+					t.Fatalf("unexpected rejection: %v", handlerErr)
+				}
+				return
+			}
+			if !errors.Is(handlerErr, queue.ErrKeyNotOwned) || !errors.Is(handlerErr, queue.ErrDrop) {
+				t.Fatalf("expected the entry to be dropped, got %v", handlerErr)
+			}
+			if broker.Len(queue.CreditRefundQueue) != 1 {
+				t.Fatal("expected the entry's credits to be refunded")
+			}
+			d, ok := broker.Get(queue.EmailSendQueue)
+			if !ok {
+				t.Fatal("expected the user to be notified")
+			}
+			email, err := queue.DecodeDelivery[queue.EmailSend](d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if email.Subject != queue.IpnsEntryFailedSubject || email.UserNames[0] != "user" {
+				t.Fatalf("unexpected email %+v", email)
+			}
+		})
+	}
+}
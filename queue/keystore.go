@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"github.com/RTradeLtd/database/models"
+	"github.com/RTradeLtd/rtfs"
+	"github.com/jinzhu/gorm"
+)
+
+// dbKeyStore is a KeyStore backed by our database and the ipfs keystore
+type dbKeyStore struct {
+	um       *models.UserManager
+	im       *models.IpnsManager
+	keystore *rtfs.KeystoreManager
+}
+
+// NewKeyStore is used to create a KeyStore checking ownership and usage of keys
+// with db, and deleting them from keystore
+func NewKeyStore(db *gorm.DB, keystore *rtfs.KeystoreManager) KeyStore {
+	return &dbKeyStore{
+		um:       models.NewUserManager(db),
+		im:       models.NewIPNSManager(db),
+		keystore: keystore,
+	}
+}
+
+func (k *dbKeyStore) HasKey(name string) (bool, error) {
+	return k.keystore.CheckIfKeyExists(name)
+}
+
+func (k *dbKeyStore) KeyOwnedBy(userName, name string) (bool, error) {
+	return k.um.CheckIfKeyOwnedByUser(userName, name)
+}
+
+func (k *dbKeyStore) KeyInUse(userName, name string) (bool, error) {
+	entries, err := k.im.FindByUserName(userName)
+	if gorm.IsRecordNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range *entries {
+		if entry.Key == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (k *dbKeyStore) DeleteKey(userName, name string) error {
+	keyID, err := k.um.GetKeyIDByName(userName, name)
+	if err != nil {
+		return err
+	}
+	// the key is removed from the user last, so that a redelivered request still
+	// sees the user owning the key and finishes deleting it
+	exists, err := k.keystore.CheckIfKeyExists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err = k.keystore.Store.Delete(name); err != nil {
+			return err
+		}
+	}
+	return k.um.RemoveIPFSKeyForUser(userName, name, keyID)
+}
@@ -0,0 +1,142 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLagWindow is how long the lag of a consumer must stay above its watchdog's
+// threshold before the watchdog fires, when no window is configured
+const DefaultLagWindow = 5 * time.Minute
+
+// DefaultLagSamples is the number of the most recently consumed messages whose lag
+// the watchdog's percentile is taken over, when no number is configured
+const DefaultLagSamples = 100
+
+// LagWatchdog is used to alert when a consumer falls behind, as measured by the p95
+// of the time between messages being published and their processing starting, taken
+// over the most recently consumed messages. Lag is only measured as messages are
+// consumed, so a consumer which has stopped consuming altogether isn't reported.
+type LagWatchdog struct {
+	// Threshold is the p95 lag above which the consumer is behind
+	Threshold time.Duration
+	// Window is how long the consumer must stay behind before the watchdog fires,
+	// so that short bursts aren't reported, defaulting to DefaultLagWindow
+	Window time.Duration
+	// Samples is the number of messages the p95 is taken over, defaulting to
+	// DefaultLagSamples
+	Samples int
+	// OnAlert is optionally called when the watchdog fires, and again once the
+	// consumer has caught up
+	OnAlert func(LagAlert)
+	// NotifyAdmin also emails AdminEmail when the watchdog fires
+	NotifyAdmin bool
+}
+
+// LagAlert describes a consumer falling behind, or catching up
+type LagAlert struct {
+	QueueName string
+	Service   string
+	// P95 is the p95 lag at the time of the alert
+	P95 time.Duration
+	// Since is when the consumer fell behind
+	Since time.Time
+	// Recovered is set once the consumer has caught up
+	Recovered bool
+}
+
+// lagTracker holds the recent lags of a consumer and whether it's behind. Its zero
+// value is ready to use
+type lagTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	// behind is when the p95 lag crossed the threshold, and is zero when it hasn't
+	behind  time.Time
+	alerted bool
+}
+
+// observe is used to record the lag of a message, returning an alert when the
+// watchdog fires or the consumer recovers after it did
+func (l *lagTracker) observe(w LagWatchdog, lag time.Duration, now time.Time) (LagAlert, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size := w.Samples
+	if size <= 0 {
+		size = DefaultLagSamples
+	}
+	if len(l.samples) < size {
+		l.samples = append(l.samples, lag)
+	} else {
+		l.samples[l.next%len(l.samples)] = lag
+		l.next++
+	}
+	p95 := percentile(l.samples, 0.95)
+	if p95 <= w.Threshold {
+		alert := LagAlert{P95: p95, Since: l.behind, Recovered: true}
+		recovered := l.alerted
+		l.behind, l.alerted = time.Time{}, false
+		return alert, recovered
+	}
+	if l.behind.IsZero() {
+		l.behind = now
+	}
+	window := w.Window
+	if window <= 0 {
+		window = DefaultLagWindow
+	}
+	if l.alerted || now.Sub(l.behind) < window {
+		return LagAlert{}, false
+	}
+	l.alerted = true
+	return LagAlert{P95: p95, Since: l.behind}, true
+}
+
+// percentile is used to get the qth percentile of durations, using the nearest rank
+func percentile(durations []time.Duration, q float64) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// watchLag is used to feed the lag of a consumed message, given when it was
+// published, to the manager's watchdog, alerting when it fires. as with latency
+// metrics, messages without a timestamp are ignored and those from the future
+// count as having no lag
+func (qm *Manager) watchLag(ctx context.Context, published time.Time) {
+	if qm.LagWatchdog == nil || published.IsZero() {
+		return
+	}
+	now := time.Now()
+	lag := now.Sub(published)
+	if lag < 0 {
+		lag = 0
+	}
+	alert, ok := qm.lag.observe(*qm.LagWatchdog, lag, now)
+	if !ok {
+		return
+	}
+	alert.QueueName, alert.Service = qm.QueueName, qm.Service
+	entry := qm.LogEntry(ctx).WithField("p95_lag", alert.P95.String())
+	if alert.Recovered {
+		entry.Info("consumer caught up")
+	} else {
+		entry.Warn("consumer falling behind")
+	}
+	if qm.LagWatchdog.OnAlert != nil {
+		qm.LagWatchdog.OnAlert(alert)
+	}
+	if qm.LagWatchdog.NotifyAdmin && !alert.Recovered {
+		qm.notifyAdmin(ctx, ConsumerLagSubject, fmt.Sprintf(
+			"Consumer of queue %s of service %s has had a p95 lag of %s, above %s, since %s",
+			qm.QueueName, qm.Service, alert.P95, qm.LagWatchdog.Threshold, alert.Since.Format(time.RFC3339),
+		))
+	}
+}
@@ -0,0 +1,69 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestManager_LagWatchdog(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	var alerts []queue.LagAlert
+	qm := newMemoryManager(t, broker, queue.WithLagWatchdog(queue.LagWatchdog{
+		Threshold:   time.Minute,
+		Window:      time.Nanosecond,
+		Samples:     1,
+		OnAlert:     func(alert queue.LagAlert) { alerts = append(alerts, alert) },
+		NotifyAdmin: true,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	lagging := func(msg *amqp.Publishing) {
+		msg.Timestamp = time.Now().Add(-2 * time.Hour)
+	}
+	// the first lagging message starts the window, which the second exceeds, while
+	// the first message on time recovers
+	for _, opts := range [][]queue.PublishOption{{lagging}, {lagging}, {lagging}, nil, nil} {
+		if err := qm.PublishMessageContext(ctx, testPin("user"), opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handled := 0
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		if handled++; handled == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if len(alerts) != 2 {
+		t.Fatalf("expected an alert and a recovery, got %+v", alerts)
+	}
+	if alerts[0].Recovered || alerts[0].P95 < time.Hour || alerts[0].QueueName != queue.IpfsPinQueue {
+		t.Fatalf("unexpected alert %+v", alerts[0])
+	}
+	if !alerts[1].Recovered || !alerts[1].Since.Equal(alerts[0].Since) {
+		t.Fatalf("unexpected recovery %+v", alerts[1])
+	}
+	if n := broker.Len(queue.EmailSendQueue); n != 1 {
+		t.Fatalf("expected the admin to be emailed once, got %v emails", n)
+	}
+	email, _ := broker.Get(queue.EmailSendQueue)
+	msg, err := queue.DecodeDelivery[queue.EmailSend](email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != queue.ConsumerLagSubject {
+		t.Fatalf("unexpected subject %q", msg.Subject)
+	}
+}
+
+func TestNewManager_LagWatchdogThreshold(t *testing.T) {
+	_, err := queue.NewManager("", queue.WithBroker(queue.NewMemoryBroker()), queue.WithLagWatchdog(queue.LagWatchdog{}))
+	if err == nil {
+		t.Fatal("expected a lag watchdog without a threshold to be refused")
+	}
+}
@@ -0,0 +1,228 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// NewManager is used to connect to the broker at url and create a Manager for the
+// configured queue, declaring it if one is set along with any network exchange.
+// Conflicting options result in an error rather than a misbehaving manager. When a
+// broker is injected with WithBroker, url is ignored and the manager uses that
+// broker instead.
+func NewManager(url string, opts ...Option) (*Manager, error) {
+	var cfg managerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.logger == nil {
+		cfg.logger = log.New()
+	}
+	if cfg.service == "" {
+		cfg.service = cfg.queueName
+	}
+	if cfg.broker != nil {
+		qm := cfg.manager(nil, nil)
+		if err := qm.Declare(); err != nil {
+			return nil, err
+		}
+		return qm, nil
+	}
+	conn, err := DialConfig(url, cfg.tlsConfig, cfg.connection)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	qm := cfg.manager(conn, ch)
+	qm.url = url
+	qm.watchReturns(ch)
+	if qm.QueueName != "" || qm.Options.NetworkRouting || qm.Billing || qm.Audit {
+		if err = qm.Declare(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if cfg.reconnect != nil {
+		qm.EnableReconnect(url, *cfg.reconnect)
+	}
+	return qm, nil
+}
+
+// manager is used to create a Manager from the config using the given connection
+func (c *managerConfig) manager(conn *amqp.Connection, ch *amqp.Channel) *Manager {
+	return &Manager{
+		Connection:           conn,
+		Channel:              ch,
+		Logger:               c.logger,
+		QueueName:            c.queueName,
+		Service:              c.service,
+		ExchangeName:         c.exchangeName,
+		Options:              c.options,
+		PrefetchCount:        c.prefetch,
+		Workers:              c.workers,
+		TLSConfig:            c.tlsConfig,
+		ConnectionOpts:       c.connection,
+		Metrics:              c.metrics,
+		TracerProvider:       c.tracer,
+		Idempotency:          c.idempotency,
+		IdempotencyWindow:    c.idemWindow,
+		Expiration:           c.expiration,
+		Authorizer:           c.authorizer,
+		CompressionThreshold: c.compression,
+		MaxMessageSize:       c.maxSize,
+		Policy:               c.policy,
+		Codec:                c.codec,
+		DryRun:               c.dryRun,
+		Billing:              c.billing,
+		Audit:                c.audit,
+		Balances:             c.balances,
+		NetworkMultipliers:   c.multipliers,
+		TimestampTolerance:   c.tolerance,
+		LagWatchdog:          c.lag,
+		Backpressure:         c.backpressure,
+		Middleware:           c.middleware,
+		Broker:               c.broker,
+		AdminNotifyInterval:  c.adminNotify,
+		HandlerTimeout:       c.timeout,
+		MaxHandlerTimeouts:   c.maxTimeouts,
+		MaxAbandonedHandlers: c.maxAbandoned,
+		RetryLaterDelay:      c.retryLater,
+		Mandatory:            c.mandatory,
+		UserRateLimit:        c.rateLimit,
+		RateLimits:           c.rateLimits,
+		ResolverCache:        c.resolver,
+	}
+}
+
+// validate is used to check for conflicting options
+func (c *managerConfig) validate() error {
+	if c.prefetch < 0 {
+		return errors.New("prefetch count can't be negative")
+	}
+	if c.workers < 0 {
+		return errors.New("worker count can't be negative")
+	}
+	if err := c.connection.validate(); err != nil {
+		return err
+	}
+	if c.broker != nil && c.reconnect != nil {
+		return errors.New("reconnection is not supported with an injected broker")
+	}
+	if c.reconnect != nil && (c.reconnect.Jitter < JitterFull || c.reconnect.Jitter > JitterNone) {
+		return fmt.Errorf("unknown reconnect jitter mode %v", c.reconnect.Jitter)
+	}
+	if c.broker != nil && (c.exchangeName != "" || c.options.NetworkRouting) {
+		return errors.New("exchanges are not supported with an injected broker")
+	}
+	if c.adminNotify < 0 {
+		return errors.New("admin notify interval can't be negative")
+	}
+	if c.rateLimit.Rate < 0 || c.rateLimit.Burst < 0 {
+		return errors.New("user rate limit can't be negative")
+	}
+	if c.compression < 0 {
+		return errors.New("compression threshold can't be negative")
+	}
+	if c.policy != nil && validateNetworkName(c.policy.DefaultNetwork) != nil {
+		return fmt.Errorf("invalid default network %q", c.policy.DefaultNetwork)
+	}
+	if c.maxSize < 0 {
+		return errors.New("max message size can't be negative")
+	}
+	if c.tolerance.Past < 0 || c.tolerance.Future < 0 {
+		return errors.New("timestamp tolerance can't be negative")
+	}
+	if c.lag != nil && c.lag.Threshold <= 0 {
+		return errors.New("lag watchdog requires a positive threshold")
+	}
+	if c.lag != nil && (c.lag.Window < 0 || c.lag.Samples < 0) {
+		return errors.New("lag watchdog window and samples can't be negative")
+	}
+	if c.timeout < 0 || c.maxTimeouts < 0 || c.maxAbandoned < 0 {
+		return errors.New("handler timeout, max timeouts and max abandoned handlers can't be negative")
+	}
+	if c.retryLater < 0 {
+		return errors.New("retry later delay can't be negative")
+	}
+	if err := c.multipliers.Validate(); err != nil {
+		return err
+	}
+	if c.backpressure != nil && c.backpressure.HighWaterMark <= 0 {
+		return errors.New("backpressure requires a positive high water mark")
+	}
+	if c.backpressure != nil && (c.backpressure.LowWaterMark < 0 || c.backpressure.LowWaterMark > c.backpressure.HighWaterMark) {
+		return errors.New("backpressure low water mark must be between 0 and the high water mark")
+	}
+	if c.backpressure != nil && c.backpressure.PollInterval < 0 {
+		return errors.New("backpressure poll interval can't be negative")
+	}
+	if c.expiration < 0 {
+		return errors.New("message expiration can't be negative")
+	}
+	if c.options.AlternateExchange && (c.exchangeName == "" || (c.options.ExchangeType == "" && !c.options.NetworkRouting)) {
+		return errors.New("an alternate exchange requires an exchange declared by the manager")
+	}
+	if c.broker != nil && c.mandatory {
+		return errors.New("mandatory publishing is not supported with an injected broker")
+	}
+	if c.options.DeadLetter && c.options.Transient {
+		return errors.New("dead lettering requires a durable queue")
+	}
+	if c.options.Exclusive && !c.options.Transient {
+		return errors.New("exclusive queues can't be durable")
+	}
+	if (c.options.Exclusive || c.options.AutoDelete) && c.queueName == "" {
+		return errors.New("exclusive and auto-delete flags require a queue")
+	}
+	switch c.options.ExchangeType {
+	case "", amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+	default:
+		return fmt.Errorf("unsupported exchange type %s", c.options.ExchangeType)
+	}
+	if c.options.ExchangeType != "" && c.exchangeName == "" {
+		return errors.New("an exchange type requires an exchange")
+	}
+	if c.options.NetworkRouting && c.options.ExchangeType != "" && c.options.ExchangeType != amqp.ExchangeTopic {
+		return errors.New("network routing requires a topic exchange")
+	}
+	if c.options.Delay != DelayDisabled && c.queueName == "" {
+		return errors.New("delayed delivery requires a queue")
+	}
+	if c.options.MaxPriority > 0 && c.queueName == "" {
+		return errors.New("priorities require a queue")
+	}
+	if c.options.DeadLetter && c.queueName == "" {
+		return errors.New("dead lettering requires a queue")
+	}
+	if c.exchangeName != "" && c.queueName == "" && !c.options.NetworkRouting {
+		return errors.New("binding to an exchange requires a queue")
+	}
+	if c.options.NetworkRouting && c.exchangeName == "" {
+		return errors.New("network routing requires an exchange")
+	}
+	if len(c.options.Networks) > 0 && c.queueName == "" {
+		return errors.New("subscribing to networks requires a queue")
+	}
+	return nil
+}
+
+// Reconnects is used to get the channel reconnection attempts are reported on,
+// which is nil if reconnection isn't enabled
+func (qm *Manager) Reconnects() <-chan ReconnectEvent {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	if qm.recon == nil {
+		return nil
+	}
+	return qm.recon.events
+}
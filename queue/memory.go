@@ -0,0 +1,381 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// MemoryBroker is an in-memory Broker for testing handlers without rabbitmq. As
+// with rabbitmq, queues must be declared before messages are published to them,
+// with messages routed to an undeclared queue being dropped. Messages published to
+// an exchange are delivered to the queue of the same name, as they are to our dead
+// letter queues, and consumed messages which are rejected without being requeued
+// are moved to the queue's dead letter queue if it has one. Auto-delete queues are
+// deleted once their last consumer is cancelled. Prefetch limits and message
+// expirations are not enforced.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	queues    map[string]*memoryQueue
+	unacked   map[uint64]memoryDelivery
+	consumers map[string]*memoryConsumer
+	tag       uint64
+	closed    bool
+	done      chan struct{}
+}
+
+// memoryQueue is a queue of a MemoryBroker
+type memoryQueue struct {
+	name  string
+	opts  QueueOptions
+	ready []amqp.Delivery
+}
+
+// memoryConsumer is a consumer of a MemoryBroker
+type memoryConsumer struct {
+	queue     *memoryQueue
+	cancelled bool
+}
+
+// memoryDelivery is a message delivered to a consumer, awaiting acknowledgement
+type memoryDelivery struct {
+	queue *memoryQueue
+	d     amqp.Delivery
+}
+
+// NewMemoryBroker is used to create an empty in-memory broker
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		queues:    make(map[string]*memoryQueue),
+		unacked:   make(map[uint64]memoryDelivery),
+		consumers: make(map[string]*memoryConsumer),
+		done:      make(chan struct{}),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// DeclareQueue is used to declare a queue, along with its dead letter queue when
+// dead lettering is enabled. Declaring an existing queue does nothing.
+func (b *MemoryBroker) DeclareQueue(name string, opts QueueOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return amqp.ErrClosed
+	}
+	if _, ok := b.queues[name]; !ok {
+		b.queues[name] = &memoryQueue{name: name, opts: opts}
+	}
+	if dlx := DeadLetterName(name); opts.DeadLetter && b.queues[dlx] == nil {
+		b.queues[dlx] = &memoryQueue{name: dlx}
+	}
+	return nil
+}
+
+// Publish is used to enqueue a message, delivering it to a consumer of its queue
+// if there is one
+func (b *MemoryBroker) Publish(ctx context.Context, exchangeName, routingKey string, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return amqp.ErrClosed
+	}
+	name := exchangeName
+	if name == "" {
+		name = routingKey
+	}
+	q, ok := b.queues[name]
+	if !ok {
+		return nil
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	q.push(amqp.Delivery{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Exchange:        exchangeName,
+		RoutingKey:      routingKey,
+		Body:            msg.Body,
+	})
+	b.cond.Broadcast()
+	return nil
+}
+
+// Consume is used to start consuming messages from a queue, which are delivered
+// through the returned channel until the broker is closed or the consumer is cancelled
+func (b *MemoryBroker) Consume(queueName, consumer string, prefetch int) (<-chan amqp.Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, amqp.ErrClosed
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil, errors.New("no queue named " + queueName)
+	}
+	// like rabbitmq's client, a consumer reusing a tag replaces the previous one
+	if prev, ok := b.consumers[consumer]; ok {
+		prev.cancelled = true
+		b.cond.Broadcast()
+	}
+	c := &memoryConsumer{queue: q}
+	b.consumers[consumer] = c
+	msgs := make(chan amqp.Delivery)
+	go b.deliver(q, consumer, c, msgs)
+	return msgs, nil
+}
+
+// deliver is used to send a consumer the messages of its queue as they arrive
+func (b *MemoryBroker) deliver(q *memoryQueue, consumer string, c *memoryConsumer, msgs chan<- amqp.Delivery) {
+	defer close(msgs)
+	defer func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.consumers[consumer] == c {
+			delete(b.consumers, consumer)
+		}
+		// like rabbitmq, auto-delete queues go once their last consumer does
+		if !q.opts.AutoDelete {
+			return
+		}
+		for _, other := range b.consumers {
+			if other.queue == q {
+				return
+			}
+		}
+		if b.queues[q.name] == q {
+			delete(b.queues, q.name)
+		}
+	}()
+	for {
+		b.mu.Lock()
+		for len(q.ready) == 0 && !b.closed && !c.cancelled {
+			b.cond.Wait()
+		}
+		if b.closed || c.cancelled {
+			b.mu.Unlock()
+			return
+		}
+		d := b.take(q)
+		d.ConsumerTag = consumer
+		b.unacked[d.DeliveryTag] = memoryDelivery{queue: q, d: d}
+		b.mu.Unlock()
+		select {
+		case msgs <- d:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// Cancel is used to stop delivering messages to a consumer, closing its channel
+func (b *MemoryBroker) Cancel(consumer string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.consumers[consumer]
+	if !ok {
+		return errors.New("no consumer with tag " + consumer)
+	}
+	c.cancelled = true
+	b.cond.Broadcast()
+	return nil
+}
+
+// Get is used to synchronously take the next message from a queue, returning false
+// if it is empty. The message is considered acknowledged once taken.
+func (b *MemoryBroker) Get(queueName string) (amqp.Delivery, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[queueName]
+	if !ok || len(q.ready) == 0 {
+		return amqp.Delivery{}, false
+	}
+	return b.take(q), true
+}
+
+// Peek is used to get copies of up to n of the messages waiting in a queue, in the
+// order they would be delivered, leaving them on the queue. ErrQueueNotFound is
+// returned if the queue hasn't been declared
+func (b *MemoryBroker) Peek(queueName string, n int) ([]amqp.Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil, ErrQueueNotFound
+	}
+	if n > len(q.ready) {
+		n = len(q.ready)
+	}
+	return append([]amqp.Delivery(nil), q.ready[:n]...), nil
+}
+
+// Drain is used to synchronously pass each message in a queue to handler until the
+// queue is empty, returning the number of messages handled. Messages are acknowledged
+// when handler succeeds, and rejected without being requeued when it fails.
+func (b *MemoryBroker) Drain(ctx context.Context, queueName string, handler Handler) (int, error) {
+	handled := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return handled, err
+		}
+		b.mu.Lock()
+		q, ok := b.queues[queueName]
+		if !ok || len(q.ready) == 0 {
+			b.mu.Unlock()
+			return handled, nil
+		}
+		d := b.take(q)
+		b.unacked[d.DeliveryTag] = memoryDelivery{queue: q, d: d}
+		b.mu.Unlock()
+		handled++
+		var err error
+		if handlerErr := handler(ctx, d); handlerErr != nil {
+			err = d.Reject(false)
+		} else {
+			err = d.Ack(false)
+		}
+		if err != nil {
+			return handled, err
+		}
+	}
+}
+
+// Len is used to get the number of messages waiting in a queue
+func (b *MemoryBroker) Len(queueName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[queueName]; ok {
+		return len(q.ready)
+	}
+	return 0
+}
+
+// QueueStats is used to get the number of messages waiting in a queue and its
+// number of consumers, returning ErrQueueNotFound if it hasn't been declared
+func (b *MemoryBroker) QueueStats(name string) (QueueStats, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		return QueueStats{}, ErrQueueNotFound
+	}
+	stats := QueueStats{Name: name, Messages: len(q.ready)}
+	for _, c := range b.consumers {
+		if c.queue == q && !c.cancelled {
+			stats.Consumers++
+		}
+	}
+	return stats, nil
+}
+
+// Unacked is used to get the number of messages delivered to consumers which
+// haven't been acknowledged
+func (b *MemoryBroker) Unacked() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.unacked)
+}
+
+// Close is used to stop delivering messages, closing every consumer's channel
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+		b.cond.Broadcast()
+	}
+	return nil
+}
+
+// Ack implements amqp.Acknowledger, settling deliveries
+func (b *MemoryBroker) Ack(tag uint64, multiple bool) error {
+	return b.settle(tag, multiple, func(memoryDelivery) {})
+}
+
+// Nack implements amqp.Acknowledger, requeueing deliveries or moving them to
+// their queue's dead letter queue
+func (b *MemoryBroker) Nack(tag uint64, multiple bool, requeue bool) error {
+	return b.settle(tag, multiple, func(m memoryDelivery) {
+		d := m.d
+		d.Acknowledger, d.DeliveryTag, d.ConsumerTag = nil, 0, ""
+		if requeue {
+			d.Redelivered = true
+			m.queue.ready = append([]amqp.Delivery{d}, m.queue.ready...)
+		} else if dlx, ok := b.queues[DeadLetterName(m.queue.name)]; ok && m.queue.opts.DeadLetter {
+			dlx.push(d)
+		}
+	})
+}
+
+// Reject implements amqp.Acknowledger, as a Nack of a single delivery
+func (b *MemoryBroker) Reject(tag uint64, requeue bool) error {
+	return b.Nack(tag, false, requeue)
+}
+
+// settle is used to settle the delivery with the given tag, or with every tag up to
+// it when multiple is set, passing each to fn
+func (b *MemoryBroker) settle(tag uint64, multiple bool, fn func(memoryDelivery)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.unacked[tag]; !ok {
+		return errors.New("unknown delivery tag")
+	}
+	for t, m := range b.unacked {
+		if t == tag || (multiple && t < tag) {
+			delete(b.unacked, t)
+			fn(m)
+		}
+	}
+	b.cond.Broadcast()
+	return nil
+}
+
+// take is used to remove the next message from a queue, tagging it for delivery.
+// it is called with the broker's lock held
+func (b *MemoryBroker) take(q *memoryQueue) amqp.Delivery {
+	d := q.ready[0]
+	q.ready = q.ready[1:]
+	b.tag++
+	d.DeliveryTag = b.tag
+	d.Acknowledger = b
+	return d
+}
+
+// push is used to enqueue a message, ahead of messages with a lower priority
+// when the queue has a max priority
+func (q *memoryQueue) push(d amqp.Delivery) {
+	if q.opts.MaxPriority == 0 {
+		q.ready = append(q.ready, d)
+		return
+	}
+	if d.Priority > q.opts.MaxPriority {
+		d.Priority = q.opts.MaxPriority
+	}
+	i := len(q.ready)
+	for i > 0 && q.ready[i-1].Priority < d.Priority {
+		i--
+	}
+	q.ready = append(q.ready, amqp.Delivery{})
+	copy(q.ready[i+1:], q.ready[i:])
+	q.ready[i] = d
+}
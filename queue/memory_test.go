@@ -0,0 +1,191 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func newMemoryManager(t *testing.T, broker queue.Broker, opts ...queue.Option) *queue.Manager {
+	t.Helper()
+	qm, err := queue.NewManager("", append([]queue.Option{queue.WithQueue(queue.IpfsPinQueue), queue.WithBroker(broker)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return qm
+}
+
+func testPin(user string) queue.IPFSPin {
+	return queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: user, HoldTimeInMonths: 1}
+}
+
+func TestMemoryBroker_Consume(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, user := range []string{"alice", "bob"} {
+		if err := qm.PublishMessageContext(ctx, testPin(user)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var users []string
+	err := qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		pin, err := queue.DecodeDelivery[queue.IPFSPin](d)
+		if err != nil {
+			return err
+		}
+		if users = append(users, pin.UserName); len(users) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected consumer to stop once cancelled, got %v", err)
+	}
+	if len(users) != 2 || users[0] != "alice" || users[1] != "bob" {
+		t.Fatalf("unexpected messages consumed %v", users)
+	}
+	if broker.Len(queue.IpfsPinQueue) != 0 || broker.Unacked() != 0 {
+		t.Fatalf("expected every message to be acknowledged, %v ready and %v unacked", broker.Len(queue.IpfsPinQueue), broker.Unacked())
+	}
+}
+
+// failed messages are dead lettered by the manager as with rabbitmq
+func TestMemoryBroker_DeadLetter(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, testPin("alice")); err != nil {
+		t.Fatal(err)
+	}
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		defer cancel()
+		return errors.New("pin timed out")
+	})
+	d, ok := broker.Get(queue.DeadLetterName(queue.IpfsPinQueue))
+	if !ok {
+		t.Fatal("expected the failed message to be dead lettered")
+	}
+	if reason := d.Headers[queue.HeaderFailureReason]; reason != "pin timed out" {
+		t.Fatalf("unexpected failure reason %v", reason)
+	}
+}
+
+func TestMemoryBroker_Drain(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter())
+	ctx := context.Background()
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if err := qm.PublishMessageContext(ctx, testPin(user)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handled, err := broker.Drain(ctx, queue.IpfsPinQueue, func(ctx context.Context, d amqp.Delivery) error {
+		pin, err := queue.DecodeDelivery[queue.IPFSPin](d)
+		if err == nil && pin.UserName == "bob" {
+			err = errors.New("bob's pin failed")
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handled != 3 {
+		t.Fatalf("expected 3 messages handled, got %v", handled)
+	}
+	// rejected messages are moved to the dead letter queue by the broker
+	if n := broker.Len(queue.DeadLetterName(queue.IpfsPinQueue)); n != 1 {
+		t.Fatalf("expected 1 dead lettered message, got %v", n)
+	}
+}
+
+func TestMemoryBroker_Priority(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithMaxPriority(5))
+	ctx := context.Background()
+	publish := func(user string, opts ...queue.PublishOption) {
+		if err := qm.PublishMessageContext(ctx, testPin(user), opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("bulk1")
+	publish("bulk2")
+	publish("urgent", queue.WithPriority(9))
+	publish("normal", queue.WithPriority(1))
+	var users []string
+	for {
+		d, ok := broker.Get(queue.IpfsPinQueue)
+		if !ok {
+			break
+		}
+		pin, err := queue.DecodeDelivery[queue.IPFSPin](d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, pin.UserName)
+	}
+	want := []string{"urgent", "normal", "bulk1", "bulk2"}
+	for i := range want {
+		if i >= len(users) || users[i] != want[i] {
+			t.Fatalf("got messages in order %v, want %v", users, want)
+		}
+	}
+}
+
+func TestMemoryBroker_Requeue(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	if err := broker.DeclareQueue("requeue", queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// messages published to undeclared queues are dropped
+	if err := broker.Publish(context.Background(), "", "undeclared", amqp.Publishing{Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish(context.Background(), "", "requeue", amqp.Publishing{Body: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := broker.Consume("requeue", "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := <-msgs
+	if err = d.Nack(false, true); err != nil {
+		t.Fatal(err)
+	}
+	d = <-msgs
+	if !d.Redelivered {
+		t.Fatal("expected requeued message to be redelivered")
+	}
+	if err = d.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Ack(false); err == nil {
+		t.Fatal("expected acknowledging twice to fail")
+	}
+	broker.Close()
+	if _, ok := <-msgs; ok {
+		t.Fatal("expected deliveries to stop once closed")
+	}
+}
+
+func TestNewManager_BrokerConflicts(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	if _, err := queue.NewManager("", queue.WithQueue("q"), queue.WithBroker(broker), queue.WithExchange("exchange")); err == nil {
+		t.Fatal("expected exchanges to be refused with an injected broker")
+	}
+	if _, err := queue.NewManager("", queue.WithQueue("q"), queue.WithBroker(broker), queue.WithMandatory()); err == nil {
+		t.Fatal("expected mandatory publishing to be refused with an injected broker")
+	}
+}
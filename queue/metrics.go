@@ -0,0 +1,170 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the prometheus collectors used to instrument a Manager. A nil
+// *Metrics is valid, and records nothing.
+type Metrics struct {
+	published *prometheus.CounterVec
+	dryRun    *prometheus.CounterVec
+	consumed  *prometheus.CounterVec
+	acked     *prometheus.CounterVec
+	nacked    *prometheus.CounterVec
+	panicked  *prometheus.CounterVec
+	duration  *prometheus.HistogramVec
+	latency   *prometheus.HistogramVec
+	breaker   *prometheus.GaugeVec
+}
+
+// NewMetrics is used to create our queue metrics and register them with reg, which
+// is typically the registry backing an existing metrics endpoint. When reg is nil,
+// nil is returned, disabling metrics.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	if reg == nil {
+		return nil, nil
+	}
+	labels := []string{"queue", "service"}
+	m := &Metrics{
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "messages_published_total",
+			Help:      "Number of messages published",
+		}, labels),
+		dryRun: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "messages_dry_run_total",
+			Help:      "Number of messages which would have been published by managers in dry run mode",
+		}, labels),
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "messages_consumed_total",
+			Help:      "Number of messages received by consumers",
+		}, labels),
+		acked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "messages_acked_total",
+			Help:      "Number of consumed messages which were acknowledged",
+		}, labels),
+		nacked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "messages_nacked_total",
+			Help:      "Number of consumed messages which were rejected or requeued",
+		}, labels),
+		panicked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "handler_panics_total",
+			Help:      "Number of consumed messages whose handler panicked",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "handler_duration_seconds",
+			Help:      "Time taken by handlers to process a message",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "message_latency_seconds",
+			Help:      "Time from messages being published to being received by consumers, by the producer's clock",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 16),
+		}, labels),
+		// breakers may be shared by the consumers of several queues
+		breaker: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "temporal",
+			Subsystem: "queue",
+			Name:      "circuit_breaker_state",
+			Help:      "State of circuit breakers, 0 when closed, 1 when open and 2 when half-open",
+		}, []string{"breaker"}),
+	}
+	for _, c := range []prometheus.Collector{m.published, m.dryRun, m.consumed, m.acked, m.nacked, m.panicked, m.duration, m.latency, m.breaker} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// observePublished is used to record a published message
+func (m *Metrics) observePublished(queueName, service string) {
+	if m == nil {
+		return
+	}
+	m.published.WithLabelValues(queueName, service).Inc()
+}
+
+// observeDryRun is used to record a message which would have been published
+func (m *Metrics) observeDryRun(queueName, service string) {
+	if m == nil {
+		return
+	}
+	m.dryRun.WithLabelValues(queueName, service).Inc()
+}
+
+// observeConsumed is used to record a message received by a consumer
+func (m *Metrics) observeConsumed(queueName, service string) {
+	if m == nil {
+		return
+	}
+	m.consumed.WithLabelValues(queueName, service).Inc()
+}
+
+// observeSettled is used to record whether a consumed message was acknowledged
+func (m *Metrics) observeSettled(queueName, service string, acked bool) {
+	if m == nil {
+		return
+	}
+	if acked {
+		m.acked.WithLabelValues(queueName, service).Inc()
+	} else {
+		m.nacked.WithLabelValues(queueName, service).Inc()
+	}
+}
+
+// observePanic is used to record a handler panicking
+func (m *Metrics) observePanic(queueName, service string) {
+	if m == nil {
+		return
+	}
+	m.panicked.WithLabelValues(queueName, service).Inc()
+}
+
+// observeDuration is used to record how long a handler took to process a message
+func (m *Metrics) observeDuration(queueName, service string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.duration.WithLabelValues(queueName, service).Observe(time.Since(start).Seconds())
+}
+
+// observeLatency is used to record how long a consumed message spent queued, given
+// when it was published, ignoring messages published without a timestamp. as clocks
+// may be skewed, latencies of messages from the future are recorded as 0
+func (m *Metrics) observeLatency(queueName, service string, published time.Time) {
+	if m == nil || published.IsZero() {
+		return
+	}
+	latency := time.Since(published)
+	if latency < 0 {
+		latency = 0
+	}
+	m.latency.WithLabelValues(queueName, service).Observe(latency.Seconds())
+}
+
+// observeBreaker is used to record the state of a circuit breaker
+func (m *Metrics) observeBreaker(name string, state BreakerState) {
+	if m == nil {
+		return
+	}
+	m.breaker.WithLabelValues(name).Set(float64(state))
+}
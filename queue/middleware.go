@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Middleware is used to wrap handlers with behaviour shared between consumers, such
+// as logging or panic recovery
+type Middleware func(Handler) Handler
+
+// Chain is used to wrap handler with middleware. The first middleware is outermost,
+// so sees each message first and the handler's error last
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// PanicError is returned in place of a handler's panic by Recover
+type PanicError struct {
+	// Value is the value the handler panicked with
+	Value interface{}
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Recover is a middleware turning panics in the handlers it wraps into a PanicError,
+// so that the message is settled as a failure and the consumer keeps running. The
+// panic's stack trace is logged along with the error
+func Recover(next Handler) Handler {
+	return func(ctx context.Context, d amqp.Delivery) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		return next(ctx, d)
+	}
+}
+
+// notifyPanic is used to notify the admin about a handler panicking while processing
+// the message d, so that the bug is found even though the consumer carries on
+func (qm *Manager) notifyPanic(ctx context.Context, d amqp.Delivery, panicErr *PanicError) {
+	qm.notifyAdmin(ctx, HandlerPanicSubject, fmt.Sprintf(
+		"Handler for queue %s of service %s panicked processing message %s (correlation id %s): %v\n\n%s",
+		qm.QueueName, qm.Service, d.MessageId, CorrelationID(ctx), panicErr.Value, panicErr.Stack,
+	))
+}
+
+// LogMessages is used to create a middleware logging each message once handled to
+// logger, along with its correlation id, how long it took and any error
+func LogMessages(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d amqp.Delivery) error {
+			start := time.Now()
+			err := next(ctx, d)
+			entry := logger.WithFields(log.Fields{
+				"correlation_id": CorrelationID(ctx),
+				"type":           d.Type,
+				"duration":       time.Since(start),
+			})
+			if err != nil {
+				entry.WithField("error", err.Error()).Warn("message failed")
+			} else {
+				entry.Info("message handled")
+			}
+			return err
+		}
+	}
+}
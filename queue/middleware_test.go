@@ -0,0 +1,103 @@
+package queue_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// tracing is used to create a middleware recording when it's entered and left
+func tracing(name string, calls *[]string) queue.Middleware {
+	return func(next queue.Handler) queue.Handler {
+		return func(ctx context.Context, d amqp.Delivery) error {
+			*calls = append(*calls, name+" in")
+			err := next(ctx, d)
+			*calls = append(*calls, name+" out")
+			return err
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	handler := queue.Chain(func(ctx context.Context, d amqp.Delivery) error {
+		calls = append(calls, "handler")
+		return nil
+	}, tracing("first", &calls), tracing("second", &calls))
+	if err := handler(context.Background(), amqp.Delivery{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "first in,second in,handler,second out,first out"
+	if got := strings.Join(calls, ","); got != want {
+		t.Fatalf("middleware called in order %s, want %s", got, want)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	handler := queue.Recover(func(ctx context.Context, d amqp.Delivery) error {
+		panic("boom")
+	})
+	err := handler(context.Background(), amqp.Delivery{})
+	var panicErr *queue.PanicError
+	if !errors.As(err, &panicErr) {
+		t.Fatalf("expected a PanicError, got %v", err)
+	}
+	if panicErr.Value != "boom" || len(panicErr.Stack) == 0 {
+		t.Fatalf("unexpected panic error %+v", panicErr)
+	}
+	// handlers which don't panic are unaffected
+	want := errors.New("pin timed out")
+	if err = queue.Recover(func(ctx context.Context, d amqp.Delivery) error { return want })(context.Background(), amqp.Delivery{}); err != want {
+		t.Fatalf("expected the handler's error, got %v", err)
+	}
+}
+
+func TestLogMessages(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+	handler := queue.LogMessages(logger)(func(ctx context.Context, d amqp.Delivery) error {
+		return errors.New("pin timed out")
+	})
+	ctx := queue.WithCorrelationID(context.Background(), "correlation")
+	handler(ctx, amqp.Delivery{})
+	if out := buf.String(); !strings.Contains(out, "correlation_id=correlation") || !strings.Contains(out, "pin timed out") {
+		t.Fatalf("unexpected log output %s", out)
+	}
+}
+
+// middleware given to the manager wraps consumers' handlers, so that a panicking
+// handler is dead lettered rather than crashing the consumer
+func TestWithMiddleware(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	var calls []string
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter(), queue.WithMiddleware(queue.Recover, tracing("outer", &calls)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, testPin("alice")); err != nil {
+		t.Fatal(err)
+	}
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		defer cancel()
+		panic("boom")
+	})
+	// the panic unwinds through the inner middleware to be recovered by the outer
+	if len(calls) != 1 || calls[0] != "outer in" {
+		t.Fatalf("expected the middleware to wrap the handler, got %v", calls)
+	}
+	d, ok := broker.Get(queue.DeadLetterName(queue.IpfsPinQueue))
+	if !ok {
+		t.Fatal("expected the message to be dead lettered")
+	}
+	if reason := d.Headers[queue.HeaderFailureReason]; reason != "handler panicked: boom" {
+		t.Fatalf("unexpected failure reason %v", reason)
+	}
+}
@@ -0,0 +1,192 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// DefaultMinioHealthInterval is how often minio hosts are health checked when
+	// no interval is configured
+	DefaultMinioHealthInterval = 30 * time.Second
+	// DefaultMinioPort is the port minio hosts are dialed on by the default health
+	// check
+	DefaultMinioPort = "9000"
+)
+
+// ErrNoMinioHost is returned when neither the minio host named by a message nor any
+// of its replicas holding the object are reachable
+var ErrNoMinioHost = errors.New("no reachable minio host holds the object")
+
+// MinioPoolOpts configures a MinioPool
+type MinioPoolOpts struct {
+	// Hosts are the ips of the minio hosts, which replicate each other's objects
+	Hosts []string
+	// Interval is how often hosts are health checked, defaulting to
+	// DefaultMinioHealthInterval
+	Interval time.Duration
+	// Check is used to check the health of a host, defaulting to dialing it on
+	// DefaultMinioPort
+	Check func(ctx context.Context, host string) error
+	// HasObject is used to check whether a host holds an object before failing
+	// over to it, with every host assumed to hold every object when nil
+	HasObject func(ctx context.Context, host, bucketName, objectName string) (bool, error)
+}
+
+// MinioPool tracks the health of a set of replicated minio hosts, so that ipfs files
+// stored on a host which is down can be added from a replica. It is safe for
+// concurrent use.
+type MinioPool struct {
+	opts    MinioPoolOpts
+	mu      sync.RWMutex
+	healthy map[string]bool
+}
+
+// NewMinioPool is used to create a pool of the given hosts, which are considered
+// healthy until checked by Run
+func NewMinioPool(opts MinioPoolOpts) (*MinioPool, error) {
+	if len(opts.Hosts) == 0 {
+		return nil, errors.New("a minio pool requires at least one host")
+	}
+	if opts.Interval < 0 {
+		return nil, errors.New("minio health check interval can't be negative")
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultMinioHealthInterval
+	}
+	if opts.Check == nil {
+		opts.Check = dialMinio
+	}
+	p := &MinioPool{opts: opts, healthy: make(map[string]bool, len(opts.Hosts))}
+	for _, host := range opts.Hosts {
+		p.healthy[host] = true
+	}
+	return p, nil
+}
+
+// Run is used to health check the pool's hosts every interval, starting
+// immediately, until ctx is cancelled, at which point ctx.Err() is returned
+func (p *MinioPool) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		p.CheckHosts(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckHosts is used to health check each of the pool's hosts once, concurrently
+func (p *MinioPool) CheckHosts(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, host := range p.opts.Hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			healthy := p.check(ctx, host)
+			p.mu.Lock()
+			p.healthy[host] = healthy
+			p.mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+}
+
+// Healthy is used to check whether a host passed its last health check, with hosts
+// outside the pool being checked there and then
+func (p *MinioPool) Healthy(ctx context.Context, host string) bool {
+	p.mu.RLock()
+	healthy, ok := p.healthy[host]
+	p.mu.RUnlock()
+	if !ok {
+		return p.check(ctx, host)
+	}
+	return healthy
+}
+
+// Host is used to get the host to read an ipfs file's object from, which is the host
+// named by the file while it is healthy, and otherwise the first healthy replica
+// holding the object. ErrNoMinioHost is returned if there is none.
+func (p *MinioPool) Host(ctx context.Context, file IPFSFile) (string, error) {
+	if p.Healthy(ctx, file.MinioHostIP) {
+		return file.MinioHostIP, nil
+	}
+	for _, host := range p.opts.Hosts {
+		if host == file.MinioHostIP || !p.Healthy(ctx, host) {
+			continue
+		}
+		if p.opts.HasObject != nil {
+			held, err := p.opts.HasObject(ctx, host, file.BucketName, file.ObjectName)
+			if err != nil || !held {
+				continue
+			}
+		}
+		return host, nil
+	}
+	return "", fmt.Errorf("%w %s/%s", ErrNoMinioHost, file.BucketName, file.ObjectName)
+}
+
+// check is used to health check a host
+func (p *MinioPool) check(ctx context.Context, host string) bool {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Interval)
+	defer cancel()
+	return p.opts.Check(ctx, host) == nil
+}
+
+// dialMinio is the default health check, dialing the host's minio port
+func dialMinio(ctx context.Context, host string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, DefaultMinioPort))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+type minioHostKey struct{}
+
+// MinioHost is used to get the minio host to read an ipfs file's object from, which
+// is the host chosen by FailoverMinioHost when the file's handler is wrapped by it,
+// and otherwise the host named by the file
+func MinioHost(ctx context.Context, file IPFSFile) string {
+	if host, ok := ctx.Value(minioHostKey{}).(string); ok {
+		return host
+	}
+	return file.MinioHostIP
+}
+
+// FailoverMinioHost is used to wrap the handler of the ipfs file queue so that files
+// whose minio host is down are added from a healthy replica holding their object,
+// as chosen by pool. The chosen host is given to handler through its context, from
+// which it must be read with MinioHost, leaving the message itself untouched. Should
+// no host be reachable the file fails, so that it may be retried, without being
+// passed to handler.
+func (qm *Manager) FailoverMinioHost(handler Handler, pool *MinioPool) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		var file IPFSFile
+		if err := UnmarshalDelivery(d, &file); err != nil {
+			return Drop(err)
+		}
+		host, err := pool.Host(ctx, file)
+		if err != nil {
+			return err
+		}
+		if host != file.MinioHostIP {
+			qm.LogEntry(ctx).WithFields(log.Fields{
+				"minio_host_ip": file.MinioHostIP,
+				"replica":       host,
+			}).Warn("minio host is unreachable, failing over to replica")
+		}
+		return handler(context.WithValue(ctx, minioHostKey{}, host), d)
+	}
+}
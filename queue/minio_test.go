@@ -0,0 +1,104 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// fakeMinio is a set of minio hosts, some of which are down, holding objects
+type fakeMinio struct {
+	mu      sync.Mutex
+	down    map[string]bool
+	objects map[string]bool
+}
+
+func (f *fakeMinio) check(ctx context.Context, host string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down[host] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (f *fakeMinio) hasObject(ctx context.Context, host, bucketName, objectName string) (bool, error) {
+	return f.objects[host+"/"+bucketName+"/"+objectName], nil
+}
+
+func TestMinioPool(t *testing.T) {
+	minio := &fakeMinio{
+		down:    map[string]bool{},
+		objects: map[string]bool{"10.0.0.1/bucket/object": true, "10.0.0.3/bucket/object": true},
+	}
+	pool, err := queue.NewMinioPool(queue.MinioPoolOpts{
+		Hosts:     []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		Check:     minio.check,
+		HasObject: minio.hasObject,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	file := queue.IPFSFile{MinioHostIP: "10.0.0.1", BucketName: "bucket", ObjectName: "object"}
+	if host, err := pool.Host(ctx, file); err != nil || host != "10.0.0.1" {
+		t.Fatalf("expected the file's host while healthy, got %s, %v", host, err)
+	}
+	// hosts are only considered down once checked
+	minio.down["10.0.0.1"] = true
+	pool.CheckHosts(ctx)
+	if pool.Healthy(ctx, "10.0.0.1") {
+		t.Fatal("expected the host to be unhealthy once checked")
+	}
+	// 10.0.0.2 doesn't hold the object, so the replica which does is chosen
+	if host, err := pool.Host(ctx, file); err != nil || host != "10.0.0.3" {
+		t.Fatalf("expected to fail over to the replica holding the object, got %s, %v", host, err)
+	}
+	minio.down["10.0.0.3"] = true
+	pool.CheckHosts(ctx)
+	if _, err := pool.Host(ctx, file); !errors.Is(err, queue.ErrNoMinioHost) {
+		t.Fatalf("expected ErrNoMinioHost, got %v", err)
+	}
+	// hosts recover once checked again
+	minio.down = map[string]bool{}
+	pool.CheckHosts(ctx)
+	if host, err := pool.Host(ctx, file); err != nil || host != "10.0.0.1" {
+		t.Fatalf("expected the recovered host, got %s, %v", host, err)
+	}
+}
+
+func TestFailoverMinioHost(t *testing.T) {
+	minio := &fakeMinio{down: map[string]bool{"10.0.0.1": true}}
+	pool, err := queue.NewMinioPool(queue.MinioPoolOpts{Hosts: []string{"10.0.0.1", "10.0.0.2"}, Check: minio.check})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.CheckHosts(context.Background())
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.IpfsFileQueue))
+	file := queue.IPFSFile{MinioHostIP: "10.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", NetworkName: "public"}
+	body, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var host string
+	handler := qm.FailoverMinioHost(func(ctx context.Context, d amqp.Delivery) error {
+		host = queue.MinioHost(ctx, file)
+		return nil
+	}, pool)
+	if err = handler(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	if host != "10.0.0.2" {
+		t.Fatalf("expected the handler to be given the replica, got %s", host)
+	}
+	if queue.MinioHost(context.Background(), file) != "10.0.0.1" {
+		t.Fatal("expected the file's host outside of a failover")
+	}
+}
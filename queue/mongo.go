@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MongoOperator is the mongodb update operator a MongoOperation applies
+type MongoOperator string
+
+const (
+	// MongoSet sets the field to the value
+	MongoSet MongoOperator = "$set"
+	// MongoInc increments the field by the value, which must be a number, creating
+	// the field when it doesn't exist
+	MongoInc MongoOperator = "$inc"
+	// MongoPush appends the value to the field, which must be an array
+	MongoPush MongoOperator = "$push"
+	// MongoUnset removes the field, ignoring the value
+	MongoUnset MongoOperator = "$unset"
+)
+
+// MongoOperation is a typed operation of a MongoUpdate on a single field, which may
+// be a dotted path into a nested document. Values may be of any type which encodes
+// to json, with nested documents given as maps or structs. As messages are json,
+// consumers receive numbers as float64 and documents as maps.
+type MongoOperation struct {
+	Operator MongoOperator `json:"operator"`
+	Field    string        `json:"field"`
+	Value    interface{}   `json:"value,omitempty"`
+}
+
+// validate is used to check an operation can be translated to a mongodb update
+func (o MongoOperation) validate() error {
+	if o.Field == "" {
+		return errors.New("field is required")
+	}
+	switch o.Operator {
+	case MongoSet, MongoPush, MongoUnset:
+		return nil
+	case MongoInc:
+		switch o.Value.(type) {
+		case int, int32, int64, float32, float64:
+			return nil
+		}
+		return fmt.Errorf("%s requires a number, got %T", o.Operator, o.Value)
+	}
+	return fmt.Errorf("unsupported operator %q", o.Operator)
+}
+
+// Document is used to translate the update into a mongodb update document, keyed
+// by operator, such as {"$set": {"a": "b"}, "$inc": {"credits": -5}}, with Fields
+// set alongside the operations. Messages published before operations were supported
+// hold only Fields, so are translated to a plain $set as they always were.
+func (m MongoUpdate) Document() map[string]map[string]interface{} {
+	doc := make(map[string]map[string]interface{})
+	add := func(operator MongoOperator, field string, value interface{}) {
+		if doc[string(operator)] == nil {
+			doc[string(operator)] = make(map[string]interface{})
+		}
+		doc[string(operator)][field] = value
+	}
+	for field, value := range m.Fields {
+		add(MongoSet, field, value)
+	}
+	for _, op := range m.Operations {
+		value := op.Value
+		// mongo ignores the value of unset fields, conventionally given as ""
+		if op.Operator == MongoUnset {
+			value = ""
+		}
+		add(op.Operator, op.Field, value)
+	}
+	return doc
+}
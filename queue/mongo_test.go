@@ -0,0 +1,75 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestMongoUpdate_Document(t *testing.T) {
+	update := queue.MongoUpdate{
+		DatabaseName:   "temporal",
+		CollectionName: "users",
+		Fields:         map[string]string{"user_name": "alice"},
+		Operations: []queue.MongoOperation{
+			{Operator: queue.MongoInc, Field: "credits", Value: -5},
+			{Operator: queue.MongoPush, Field: "history", Value: map[string]interface{}{"cost": 5}},
+			{Operator: queue.MongoSet, Field: "account.tier", Value: 2},
+			{Operator: queue.MongoUnset, Field: "pending", Value: true},
+		},
+	}
+	want := map[string]map[string]interface{}{
+		"$set":   {"user_name": "alice", "account.tier": 2},
+		"$inc":   {"credits": -5},
+		"$push":  {"history": map[string]interface{}{"cost": 5}},
+		"$unset": {"pending": ""},
+	}
+	if doc := update.Document(); !reflect.DeepEqual(doc, want) {
+		t.Fatalf("expected %v, got %v", want, doc)
+	}
+}
+
+func TestMongoUpdate_LegacyFields(t *testing.T) {
+	// updates published before operations were supported are a plain $set
+	var update queue.MongoUpdate
+	if err := json.Unmarshal([]byte(`{"database_name":"temporal","collection_name":"users","fields":{"a":"b"}}`), &update); err != nil {
+		t.Fatal(err)
+	}
+	if err := update.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]interface{}{"$set": {"a": "b"}}
+	if doc := update.Document(); !reflect.DeepEqual(doc, want) {
+		t.Fatalf("expected %v, got %v", want, doc)
+	}
+}
+
+func TestMongoUpdate_RoundTrip(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.MongoUpdateQueue))
+	update := queue.MongoUpdate{
+		DatabaseName:   "temporal",
+		CollectionName: "users",
+		Operations:     []queue.MongoOperation{{Operator: queue.MongoInc, Field: "credits", Value: 10}},
+	}
+	if err := qm.PublishMessageContext(context.Background(), update); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := broker.Get(queue.MongoUpdateQueue)
+	if !ok {
+		t.Fatal("expected the update to be published")
+	}
+	decoded, err := queue.DecodeDelivery[queue.MongoUpdate](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// numbers are decoded as float64, which is still a valid increment
+	want := map[string]map[string]interface{}{"$inc": {"credits": float64(10)}}
+	if doc := decoded.Document(); !reflect.DeepEqual(doc, want) {
+		t.Fatalf("expected %v, got %v", want, doc)
+	}
+}
@@ -0,0 +1,198 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// QueueConsumer is one of the queues consumed by MultiConsume
+type QueueConsumer struct {
+	// QueueName is the queue to consume, which must already be declared
+	QueueName string
+	// Handler processes the queue's messages, with the manager's middleware
+	// applied around it, and may be made from a typed handler with Typed
+	Handler Handler
+	// Prefetch and Workers override the manager's PrefetchCount and Workers for
+	// the queue when greater than 0
+	Prefetch int
+	Workers  int
+	// Options describes how the queue was declared, such as whether it dead
+	// letters failed messages, defaulting to the manager's Options when nil
+	Options *QueueOptions
+	// ConsumeOptions configure how the queue's messages are consumed
+	ConsumeOptions []ConsumeOption
+}
+
+// Typed is used to make a Handler from a handler of messages of type T, decoding
+// each message with DecodeDelivery. Messages which fail to decode are dropped, as
+// they never will, being dead lettered when enabled.
+func Typed[T any](handler func(ctx context.Context, msg T) error) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		msg, err := DecodeDelivery[T](d)
+		if err != nil {
+			return Drop(err)
+		}
+		return handler(ctx, msg)
+	}
+}
+
+// MultiConsume is used to consume several queues at once, such as the ipfs pin,
+// cluster pin and database file add queues of a single service, sharing the
+// manager's connection, middleware and settings, with messages dispatched to the
+// handler of the queue they were consumed from. Each queue is consumed on its own
+// channel, so that its prefetch and workers can differ. Like ConsumeMessageContext
+// it returns once ctx is cancelled or the manager is closed, waiting for the
+// messages of every queue already being processed, with the first queue to fail
+// stopping the others and its error being returned. When the connection drops,
+// consumption resumes once it is re-established if reconnection is enabled, and
+// otherwise ErrNotConnected is returned.
+func (qm *Manager) MultiConsume(ctx context.Context, consumers ...QueueConsumer) error {
+	if len(consumers) == 0 {
+		return errors.New("no queues to consume")
+	}
+	seen := make(map[string]bool, len(consumers))
+	for _, c := range consumers {
+		if c.QueueName == "" || c.Handler == nil {
+			return errors.New("consumed queues require a name and handler")
+		}
+		if seen[c.QueueName] {
+			return fmt.Errorf("queue %s is consumed more than once", c.QueueName)
+		}
+		seen[c.QueueName] = true
+	}
+	// closing the manager waits for us to finish, as we wait for our handlers
+	if !qm.begin() {
+		return ErrNotConnected
+	}
+	defer qm.end()
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for _, c := range consumers {
+		wg.Add(1)
+		go func(c QueueConsumer) {
+			defer wg.Done()
+			if err := qm.consumeQueue(ctx, c); err != nil && ctx.Err() == nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("failed to consume queue %s: %w", c.QueueName, err)
+				})
+				cancel()
+			}
+		}(c)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := parent.Err(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// consumeQueue is used to consume one of the queues of MultiConsume until ctx is
+// done, restarting on a new channel whenever the connection is re-established
+func (qm *Manager) consumeQueue(ctx context.Context, c QueueConsumer) error {
+	for {
+		gen := qm.generation()
+		sub, err := qm.queueManager(c)
+		if err != nil {
+			return err
+		}
+		err = sub.ConsumeMessageContext(ctx, "", c.Handler, c.ConsumeOptions...)
+		if qm.Broker == nil {
+			if closeErr := sub.Channel.Close(); closeErr != nil && !errors.Is(closeErr, amqp.ErrClosed) {
+				qm.logError(ctx, closeErr, "failed to close consumer channel")
+			}
+		}
+		if err != nil || ctx.Err() != nil || qm.stopping() {
+			return err
+		}
+		// our deliveries stopped without ctx being done, so the connection dropped
+		qm.mu.RLock()
+		r := qm.recon
+		qm.mu.RUnlock()
+		if r == nil {
+			return ErrNotConnected
+		}
+		stopCtx, stop := qm.withStop(ctx)
+		_, err = r.wait(stopCtx, gen)
+		stop()
+		if err != nil {
+			if qm.stopping() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// queueManager is used to make a manager consuming one of the queues of
+// MultiConsume, sharing our settings, with its own channel unless we have a broker
+func (qm *Manager) queueManager(c QueueConsumer) (*Manager, error) {
+	sub := &Manager{
+		Connection:           qm.connection(),
+		Logger:               qm.Logger,
+		QueueName:            c.QueueName,
+		Service:              qm.Service,
+		Options:              qm.Options,
+		PrefetchCount:        qm.PrefetchCount,
+		Workers:              qm.Workers,
+		SigningSecret:        qm.SigningSecret,
+		TracerProvider:       qm.TracerProvider,
+		Idempotency:          qm.Idempotency,
+		IdempotencyWindow:    qm.IdempotencyWindow,
+		Metrics:              qm.Metrics,
+		Policy:               qm.Policy,
+		Middleware:           qm.Middleware,
+		Broker:               qm.Broker,
+		Codec:                qm.Codec,
+		CompressionThreshold: qm.CompressionThreshold,
+		MaxMessageSize:       qm.MaxMessageSize,
+		HandlerTimeout:       qm.HandlerTimeout,
+		MaxHandlerTimeouts:   qm.MaxHandlerTimeouts,
+		MaxAbandonedHandlers: qm.MaxAbandonedHandlers,
+		RetryLaterDelay:      qm.RetryLaterDelay,
+		Balances:             qm.Balances,
+		NetworkMultipliers:   qm.NetworkMultipliers,
+		TimestampTolerance:   qm.TimestampTolerance,
+		LagWatchdog:          qm.LagWatchdog,
+		Authorizer:           qm.Authorizer,
+		UserRateLimit:        qm.UserRateLimit,
+		RateLimits:           qm.RateLimits,
+		ResolverCache:        qm.ResolverCache,
+		AdminNotifyInterval:  qm.AdminNotifyInterval,
+	}
+	// our consumers stop, and their handlers are cancelled, along with ours
+	sub.done, sub.abort = qm.closing(), qm.aborting()
+	if c.Options != nil {
+		sub.Options = *c.Options
+	}
+	if c.Prefetch > 0 {
+		sub.PrefetchCount = c.Prefetch
+	}
+	if c.Workers > 0 {
+		sub.Workers = c.Workers
+	}
+	if qm.Broker != nil {
+		return sub, nil
+	}
+	if sub.Connection == nil {
+		return nil, ErrNotConnected
+	}
+	ch, err := sub.Connection.Channel()
+	if err != nil {
+		return nil, connectionError(err)
+	}
+	sub.Channel = ch
+	return sub, nil
+}
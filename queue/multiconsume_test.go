@@ -0,0 +1,104 @@
+package queue_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestManager_MultiConsume(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, testPin("alice")); err != nil {
+		t.Fatal(err)
+	}
+	cluster := newMemoryManager(t, broker, queue.WithQueue(queue.IpfsClusterPinQueue))
+	if err := cluster.PublishMessageContext(ctx, queue.IPFSClusterPin{CID: testCID, NetworkName: "public", UserName: "bob", HoldTimeInMonths: 1}); err != nil {
+		t.Fatal(err)
+	}
+	files := newMemoryManager(t, broker, queue.WithQueue(queue.DatabaseFileAddQueue))
+	if err := files.PublishMessageContext(ctx, queue.DatabaseFileAdd{Hash: testCID, NetworkName: "public", UserName: "carol", HoldTimeInMonths: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu      sync.Mutex
+		handled = map[string]string{}
+	)
+	record := func(queueName, user string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if handled[queueName] = user; len(handled) == 3 {
+			cancel()
+		}
+		return nil
+	}
+	err := qm.MultiConsume(ctx,
+		queue.QueueConsumer{
+			QueueName: queue.IpfsPinQueue,
+			Handler: queue.Typed(func(ctx context.Context, pin queue.IPFSPin) error {
+				return record(queue.IpfsPinQueue, pin.UserName)
+			}),
+		},
+		queue.QueueConsumer{
+			QueueName: queue.IpfsClusterPinQueue,
+			Prefetch:  4,
+			Workers:   2,
+			Handler: queue.Typed(func(ctx context.Context, pin queue.IPFSClusterPin) error {
+				return record(queue.IpfsClusterPinQueue, pin.UserName)
+			}),
+		},
+		queue.QueueConsumer{
+			QueueName: queue.DatabaseFileAddQueue,
+			Handler: queue.Typed(func(ctx context.Context, file queue.DatabaseFileAdd) error {
+				return record(queue.DatabaseFileAddQueue, file.UserName)
+			}),
+		},
+	)
+	if err != context.Canceled {
+		t.Fatalf("expected the consumers to stop once cancelled, got %v", err)
+	}
+	want := map[string]string{
+		queue.IpfsPinQueue:         "alice",
+		queue.IpfsClusterPinQueue:  "bob",
+		queue.DatabaseFileAddQueue: "carol",
+	}
+	for queueName, user := range want {
+		if handled[queueName] != user {
+			t.Fatalf("expected %s's message to be handled from %s, got %v", user, queueName, handled)
+		}
+	}
+}
+
+func TestManager_MultiConsume_Fails(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	handler := queue.Typed(func(ctx context.Context, pin queue.IPFSPin) error { return nil })
+	// a queue failing to be consumed stops the others
+	err := qm.MultiConsume(ctx,
+		queue.QueueConsumer{QueueName: queue.IpfsPinQueue, Handler: handler},
+		queue.QueueConsumer{QueueName: "missing", Handler: handler},
+	)
+	if err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected the missing queue to fail, got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected the consumers to stop without waiting for ctx")
+	}
+	err = qm.MultiConsume(ctx,
+		queue.QueueConsumer{QueueName: queue.IpfsPinQueue, Handler: handler},
+		queue.QueueConsumer{QueueName: queue.IpfsPinQueue, Handler: handler},
+	)
+	if err == nil {
+		t.Fatal("expected consuming a queue twice to fail")
+	}
+}
@@ -0,0 +1,46 @@
+package queue
+
+import (
+	"fmt"
+)
+
+// DefaultNetworkMultiplier is the credit cost multiplier of networks without one
+const DefaultNetworkMultiplier = 1.0
+
+// NetworkMultipliers are credit cost multipliers keyed by network name, so that
+// messages for networks which cost more to operate, such as private networks, are
+// charged more than the credit cost their producer gave them
+type NetworkMultipliers map[string]float64
+
+// Multiplier is used to get the multiplier of a network, which is
+// DefaultNetworkMultiplier for networks without one
+func (m NetworkMultipliers) Multiplier(network string) float64 {
+	if multiplier, ok := m[network]; ok {
+		return multiplier
+	}
+	return DefaultNetworkMultiplier
+}
+
+// Validate is used to check that no multiplier is negative
+func (m NetworkMultipliers) Validate() error {
+	for network, multiplier := range m {
+		if multiplier < 0 {
+			return fmt.Errorf("credit cost multiplier of network %q can't be negative", network)
+		}
+	}
+	return nil
+}
+
+// chargedMessage holds the fields of a message used to charge its user
+type chargedMessage struct {
+	UserName    string  `json:"user_name"`
+	NetworkName string  `json:"network_name"`
+	CreditCost  float64 `json:"credit_cost"`
+}
+
+// credits is used to get what the message's user is charged, being its credit cost
+// multiplied by the multiplier of its network, along with the multiplier
+func (m chargedMessage) credits(multipliers NetworkMultipliers) (float64, float64) {
+	multiplier := multipliers.Multiplier(m.NetworkName)
+	return m.CreditCost * multiplier, multiplier
+}
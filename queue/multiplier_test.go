@@ -0,0 +1,91 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestNetworkMultipliers(t *testing.T) {
+	multipliers := queue.NetworkMultipliers{"private": 2.5, "free": 0}
+	for network, want := range map[string]float64{"private": 2.5, "free": 0, "public": 1} {
+		if got := multipliers.Multiplier(network); got != want {
+			t.Fatalf("expected network %s to have multiplier %v, got %v", network, want, got)
+		}
+	}
+	if err := multipliers.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (queue.NetworkMultipliers{"private": -1}).Validate(); err == nil {
+		t.Fatal("expected a negative multiplier to be refused")
+	}
+	_, err := queue.NewManager("", queue.WithBroker(queue.NewMemoryBroker()), queue.WithNetworkMultipliers(queue.NetworkMultipliers{"private": -1}))
+	if err == nil {
+		t.Fatal("expected a negative multiplier to be refused")
+	}
+}
+
+func TestWithNetworkMultipliers(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	balances := queue.NewMemoryBalances(map[string]float64{"alice": 10})
+	qm := newMemoryManager(t, broker,
+		queue.WithBalanceChecker(balances),
+		queue.WithNetworkMultipliers(queue.NetworkMultipliers{"private": 3, "free": 0}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, network := range []string{"private", "public", "free"} {
+		pin := testPin("alice")
+		pin.NetworkName, pin.CreditCost = network, 2
+		if err := qm.PublishMessageContext(ctx, pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handled := 0
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		if handled++; handled == 3 {
+			cancel()
+		}
+		return nil
+	})
+	// private pins cost 3x, public pins 1x, and pins of free networks nothing
+	if balance := balances.Balance("alice"); balance != 2 {
+		t.Fatalf("expected alice to have 2 credits left, got %v", balance)
+	}
+}
+
+func TestRefundCredits_Multiplier(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.CreditRefundQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	qm := newMemoryManager(t, broker, queue.WithNetworkMultipliers(queue.NetworkMultipliers{"private": 3}))
+	pin := testPin("alice")
+	pin.NetworkName, pin.CreditCost = "private", 2
+	if err := qm.PublishMessageContext(context.Background(), pin); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := broker.Get(queue.IpfsPinQueue)
+	if !ok {
+		t.Fatal("expected the pin to be published")
+	}
+	// users are refunded what they were charged
+	qm.RefundCredits(context.Background(), d, errors.New("pin timed out"))
+	d, ok = broker.Get(queue.CreditRefundQueue)
+	if !ok {
+		t.Fatal("expected a refund to be published")
+	}
+	refund, err := queue.DecodeDelivery[queue.CreditRefund](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Amount != 6 {
+		t.Fatalf("expected 6 credits refunded, got %v", refund.Amount)
+	}
+}
@@ -0,0 +1,70 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestWithDefaultNetwork(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDefaultNetwork(queue.PublicNetwork))
+	ctx := context.Background()
+	messages := []queue.NetworkNamed{
+		queue.IPFSPin{CID: testCID, UserName: "user", HoldTimeInMonths: 1},
+		queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", HoldTimeInMonths: 1},
+		queue.IPNSEntry{CID: testCID, Key: "key", UserName: "user", LifeTime: queue.Duration(time.Hour)},
+		// networks given by the producer are kept
+		queue.IPFSPin{CID: testCID, UserName: "user", NetworkName: "private", HoldTimeInMonths: 1},
+	}
+	for _, msg := range messages {
+		if err := qm.PublishMessageContext(ctx, msg); err != nil {
+			t.Fatalf("failed to publish %T: %v", msg, err)
+		}
+	}
+	want := []string{queue.PublicNetwork, queue.PublicNetwork, queue.PublicNetwork, "private"}
+	for i, network := range want {
+		d, ok := broker.Get(queue.IpfsPinQueue)
+		if !ok {
+			t.Fatal("expected the message to be published")
+		}
+		var msg struct {
+			NetworkName string `json:"network_name"`
+		}
+		if err := queue.UnmarshalDelivery(d, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.NetworkName != network {
+			t.Fatalf("expected %T to be published to %q, got %q", messages[i], network, msg.NetworkName)
+		}
+	}
+}
+
+func TestPublish_NoNetwork(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	for _, msg := range []interface{}{
+		queue.IPFSPin{CID: testCID, UserName: "user", HoldTimeInMonths: 1},
+		queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", HoldTimeInMonths: 1},
+		queue.IPNSEntry{CID: testCID, Key: "key", UserName: "user", LifeTime: queue.Duration(time.Hour)},
+		queue.PinStatusRequest{CID: testCID},
+	} {
+		if err := qm.PublishMessageContext(context.Background(), msg); !errors.Is(err, queue.ErrValidation) {
+			t.Fatalf("expected %T without a network to be refused, got %v", msg, err)
+		}
+	}
+}
+
+func TestNewManager_InvalidDefaultNetwork(t *testing.T) {
+	for _, network := range []string{"", " public"} {
+		_, err := queue.NewManager("", queue.WithBroker(queue.NewMemoryBroker()), queue.WithDefaultNetwork(network))
+		if err == nil {
+			t.Fatalf("expected default network %q to be refused", network)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package queue
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/tns"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option is used to configure a Manager created with NewManager
+type Option func(*managerConfig)
+
+// managerConfig holds the settings NewManager builds a Manager from
+type managerConfig struct {
+	queueName    string
+	service      string
+	exchangeName string
+	logger       *log.Logger
+	options      QueueOptions
+	prefetch     int
+	workers      int
+	tlsConfig    *tls.Config
+	connection   ConnectionOpts
+	reconnect    *ReconnectOpts
+	metrics      *Metrics
+	tracer       trace.TracerProvider
+	idempotency  IdempotencyStore
+	idemWindow   time.Duration
+	expiration   time.Duration
+	authorizer   NetworkAuthorizer
+	compression  int
+	maxSize      int
+	policy       *PublishPolicy
+	codec        Codec
+	dryRun       bool
+	billing      bool
+	audit        bool
+	balances     BalanceChecker
+	multipliers  NetworkMultipliers
+	tolerance    TimestampTolerance
+	lag          *LagWatchdog
+	backpressure *Backpressure
+	middleware   []Middleware
+	broker       Broker
+	adminNotify  time.Duration
+	timeout      time.Duration
+	maxTimeouts  int
+	maxAbandoned int
+	retryLater   time.Duration
+	mandatory    bool
+	rateLimit    RateLimit
+	rateLimits   RateLimitStore
+	resolver     *tns.ResolverCache
+}
+
+// WithQueue is used to set the queue the manager publishes to and consumes from
+func WithQueue(name string) Option {
+	return func(c *managerConfig) {
+		c.queueName = name
+	}
+}
+
+// WithService is used to set the service name included in log messages,
+// which defaults to the queue name
+func WithService(service string) Option {
+	return func(c *managerConfig) {
+		c.service = service
+	}
+}
+
+// WithLogger is used to set the logger, which defaults to a logger writing to stderr
+func WithLogger(logger *log.Logger) Option {
+	return func(c *managerConfig) {
+		c.logger = logger
+	}
+}
+
+// WithExchange is used to bind the queue to an existing exchange
+func WithExchange(name string) Option {
+	return func(c *managerConfig) {
+		c.exchangeName = name
+	}
+}
+
+// WithPrefetch is used to set the prefetch count used when consuming
+func WithPrefetch(count int) Option {
+	return func(c *managerConfig) {
+		c.prefetch = count
+	}
+}
+
+// WithWorkers is used to set the number of messages consumers process concurrently.
+// Messages may complete out of order when more than one worker is used
+func WithWorkers(n int) Option {
+	return func(c *managerConfig) {
+		c.workers = n
+	}
+}
+
+// WithTLS is used to set the tls config used when dialing amqps urls
+func WithTLS(cfg *tls.Config) Option {
+	return func(c *managerConfig) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithConnectionOpts is used to tune the heartbeat, dial timeout and locale used
+// when dialing the broker, including when reconnecting
+func WithConnectionOpts(opts ConnectionOpts) Option {
+	return func(c *managerConfig) {
+		c.connection = opts
+	}
+}
+
+// WithReconnect is used to enable automatic reconnection, with reconnection
+// attempts being reported on the channel returned by Manager.Reconnects
+func WithReconnect(opts ReconnectOpts) Option {
+	return func(c *managerConfig) {
+		c.reconnect = &opts
+	}
+}
+
+// WithDurable is used to control whether the queue survives broker restarts,
+// which it does by default
+func WithDurable(durable bool) Option {
+	return func(c *managerConfig) {
+		c.options.Transient = !durable
+	}
+}
+
+// WithAutoDelete is used to have the broker delete the queue once its last consumer
+// is cancelled, along with the exchange once its last queue is unbound
+func WithAutoDelete() Option {
+	return func(c *managerConfig) {
+		c.options.AutoDelete = true
+	}
+}
+
+// WithExclusive is used to declare the queue exclusive to the manager's connection,
+// so that it is deleted when the connection closes. Exclusive queues must be declared
+// transient with WithDurable(false)
+func WithExclusive() Option {
+	return func(c *managerConfig) {
+		c.options.Exclusive = true
+	}
+}
+
+// WithExchangeType is used to declare the manager's exchange as the given type, such as
+// amqp.ExchangeFanout, rather than binding to an existing exchange
+func WithExchangeType(kind string) Option {
+	return func(c *managerConfig) {
+		c.options.ExchangeType = kind
+	}
+}
+
+// WithDeadLetter is used to enable the queue's dead letter queue
+func WithDeadLetter() Option {
+	return func(c *managerConfig) {
+		c.options.DeadLetter = true
+	}
+}
+
+// WithAlternateExchange is used to capture the messages the manager's exchange can't
+// route in the queue named by UnroutableName
+func WithAlternateExchange() Option {
+	return func(c *managerConfig) {
+		c.options.AlternateExchange = true
+	}
+}
+
+// WithMandatory is used to publish messages as mandatory, so that those the broker
+// can't route to any queue are returned, logged and dead lettered rather than lost
+func WithMandatory() Option {
+	return func(c *managerConfig) {
+		c.mandatory = true
+	}
+}
+
+// WithMetrics is used to instrument the manager with metrics created by NewMetrics
+func WithMetrics(m *Metrics) Option {
+	return func(c *managerConfig) {
+		c.metrics = m
+	}
+}
+
+// WithTracerProvider is used to enable tracing, propagating span contexts
+// through message headers so traces can be followed across queues
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *managerConfig) {
+		c.tracer = tp
+	}
+}
+
+// WithNetworkRouting is used to route messages through exchangeName, declared as a
+// topic exchange, by their network name. Publishers send messages with
+// PublishToNetwork, while consumers receive the messages of the given networks,
+// typically using a queue named by NetworkQueueName
+func WithNetworkRouting(exchangeName string, networks ...string) Option {
+	return func(c *managerConfig) {
+		c.exchangeName = exchangeName
+		c.options.NetworkRouting = true
+		c.options.Networks = networks
+	}
+}
+
+// WithIdempotency is used to skip messages which were already processed within
+// window, as recorded in store. A window of 0 uses DefaultIdempotencyWindow
+func WithIdempotency(store IdempotencyStore, window time.Duration) Option {
+	return func(c *managerConfig) {
+		c.idempotency = store
+		c.idemWindow = window
+	}
+}
+
+// WithDefaultExpiration is used to set the default ttl of published messages, so
+// that stale messages are discarded rather than processed by lagging consumers
+func WithDefaultExpiration(ttl time.Duration) Option {
+	return func(c *managerConfig) {
+		c.expiration = ttl
+	}
+}
+
+// WithNetworkAuthorizer is used to check that the users of consumed messages are
+// authorized to use their message's network before processing them
+func WithNetworkAuthorizer(authorizer NetworkAuthorizer) Option {
+	return func(c *managerConfig) {
+		c.authorizer = authorizer
+	}
+}
+
+// WithBroker is used to publish and consume messages through broker rather than
+// rabbitmq, in which case NewManager doesn't connect to a url
+func WithBroker(broker Broker) Option {
+	return func(c *managerConfig) {
+		c.broker = broker
+	}
+}
+
+// WithMiddleware is used to add middleware applied around consumers' handlers, in
+// the order given, with middleware added first being outermost
+func WithMiddleware(middleware ...Middleware) Option {
+	return func(c *managerConfig) {
+		c.middleware = append(c.middleware, middleware...)
+	}
+}
+
+// WithBilling is used to copy published messages to the billing exchange, so that
+// the credits they cost are summed by BillingAggregate
+func WithBilling() Option {
+	return func(c *managerConfig) {
+		c.billing = true
+	}
+}
+
+// WithAudit is used to copy published messages to the audit exchange, so that they
+// are recorded by AuditLog
+func WithAudit() Option {
+	return func(c *managerConfig) {
+		c.audit = true
+	}
+}
+
+// WithBalanceChecker is used to charge users the credit cost of each consumed message
+// before it is handled, with messages users can't afford being dead lettered and the
+// user emailed
+func WithBalanceChecker(balances BalanceChecker) Option {
+	return func(c *managerConfig) {
+		c.balances = balances
+	}
+}
+
+// WithNetworkMultipliers is used to multiply the credit cost users are charged by the
+// network of their message, such as to charge more for private networks
+func WithNetworkMultipliers(multipliers NetworkMultipliers) Option {
+	return func(c *managerConfig) {
+		c.multipliers = multipliers
+	}
+}
+
+// WithTimestampTolerance is used to refuse consumed messages published further in
+// the past or future than the given durations, catching producers with broken
+// clocks. A duration of 0 disables that bound.
+func WithTimestampTolerance(past, future time.Duration) Option {
+	return func(c *managerConfig) {
+		c.tolerance = TimestampTolerance{Past: past, Future: future}
+	}
+}
+
+// WithLagWatchdog is used to alert when the p95 lag of consumed messages stays above
+// the watchdog's threshold for its window, see LagWatchdog
+func WithLagWatchdog(w LagWatchdog) Option {
+	return func(c *managerConfig) {
+		c.lag = &w
+	}
+}
+
+// WithHandlerTimeout is used to abandon messages whose handler takes longer than
+// timeout, cancelling its context, so that a stuck handler can't wedge the consumer.
+// Abandoned messages are requeued, or when maxTimeouts is greater than 0 are dead
+// lettered once they have been abandoned that many times
+func WithHandlerTimeout(timeout time.Duration, maxTimeouts int) Option {
+	return func(c *managerConfig) {
+		c.timeout = timeout
+		c.maxTimeouts = maxTimeouts
+	}
+}
+
+// WithMaxAbandonedHandlers is used to limit the number of handlers abandoned by the
+// handler timeout which may still be running, after which consumers wait for one to
+// return before handling another message
+func WithMaxAbandonedHandlers(n int) Option {
+	return func(c *managerConfig) {
+		c.maxAbandoned = n
+	}
+}
+
+// WithRetryLaterDelay is used to set how long messages whose handler returned
+// ErrRetryLater are postponed for before being redelivered
+func WithRetryLaterDelay(delay time.Duration) Option {
+	return func(c *managerConfig) {
+		c.retryLater = delay
+	}
+}
+
+// WithBackpressure is used to block publishing to a queue once it holds the high
+// water mark's number of messages, until it has drained to the low water mark
+func WithBackpressure(bp Backpressure) Option {
+	return func(c *managerConfig) {
+		c.backpressure = &bp
+	}
+}
+
+// WithDefaultNetwork is used to publish network scoped messages without a network
+// name, such as IPFSPin, IPFSFile and IPNSEntry, to the given network, typically
+// PublicNetwork, rather than refusing them. See PublishPolicy.DefaultNetwork
+func WithDefaultNetwork(network string) Option {
+	return func(c *managerConfig) {
+		if c.policy == nil {
+			c.policy = &PublishPolicy{}
+		}
+		c.policy.DefaultNetwork = network
+	}
+}
+
+// WithDryRun is used to log messages rather than publishing them, see Manager.DryRun
+func WithDryRun() Option {
+	return func(c *managerConfig) {
+		c.dryRun = true
+	}
+}
+
+// WithCodec is used to set the codec messages are published with, such as
+// ProtobufCodec. Consumers decode messages with the codec they were published with.
+func WithCodec(codec Codec) Option {
+	return func(c *managerConfig) {
+		c.codec = codec
+	}
+}
+
+// WithCompression is used to gzip compress published messages whose body is at
+// least threshold bytes, as compressing small messages wastes more than it saves
+func WithCompression(threshold int) Option {
+	return func(c *managerConfig) {
+		c.compression = threshold
+	}
+}
+
+// WithMaxMessageSize is used to set the largest body in bytes which is published,
+// which should be kept within the broker's max_message_size
+func WithMaxMessageSize(n int) Option {
+	return func(c *managerConfig) {
+		c.maxSize = n
+	}
+}
+
+// WithMaxPriority is used to declare the queue as a priority queue, so that messages
+// published with WithPriority are delivered ahead of those with lower priorities.
+// The broker recommends keeping max small, as each priority has a cost.
+func WithMaxPriority(max uint8) Option {
+	return func(c *managerConfig) {
+		c.options.MaxPriority = max
+	}
+}
+
+// WithDelayedDelivery is used to enable PublishDelayed using the given mechanism,
+// with DelayAuto using the delayed message plugin when the broker has it
+func WithDelayedDelivery(mechanism DelayMechanism) Option {
+	return func(c *managerConfig) {
+		c.options.Delay = mechanism
+	}
+}
+
+// WithAdminNotifyInterval is used to set the minimum time between admin notifications
+// with the same subject, which defaults to DefaultAdminNotifyInterval
+func WithAdminNotifyInterval(interval time.Duration) Option {
+	return func(c *managerConfig) {
+		c.adminNotify = interval
+	}
+}
+
+// WithUserRateLimit is used to limit the rate at which each user's messages are
+// processed, holding the users' token buckets in store, which defaults to an
+// in-memory store when nil
+func WithUserRateLimit(limit RateLimit, store RateLimitStore) Option {
+	return func(c *managerConfig) {
+		c.rateLimit = limit
+		c.rateLimits = store
+		if c.rateLimits == nil {
+			c.rateLimits = NewMemoryRateLimitStore()
+		}
+	}
+}
+
+// WithResolverCache is used to invalidate the names of cache changed by the tns
+// messages consumed, when processed by the zone creation, record creation and
+// record deletion consumers. Only the messages this manager consumes invalidate
+// the cache, so a cache within another process, such as a gateway's, needs its
+// own consumer of the changes or a ttl short enough for stale names to be
+// acceptable
+func WithResolverCache(cache *tns.ResolverCache) Option {
+	return func(c *managerConfig) {
+		c.resolver = cache
+	}
+}
@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// ConfirmationChecker is used to get the number of on-chain confirmations of a
+// transaction, with implementations typically supporting a single blockchain
+type ConfirmationChecker interface {
+	Confirmations(ctx context.Context, blockchain, txHash string) (int64, error)
+}
+
+// PaymentFinalizer is used to finalize a payment once it has been confirmed,
+// such as by crediting the user
+type PaymentFinalizer func(ctx context.Context, p PaymentConfirmation) error
+
+// ConfirmPayments is used to create a handler for the payment confirmation queue,
+// which finalizes payments once their transaction has the confirmations required.
+// Payments lacking confirmations are requeued after waiting for delay, which is
+// spent within the handler. Requeued payments count as a new attempt, so that they
+// aren't skipped by an idempotency store, which means that when combined with
+// Retry, MaxAttempts must allow for the time taken to confirm a payment.
+func (qm *Manager) ConfirmPayments(checker ConfirmationChecker, finalize PaymentFinalizer, delay time.Duration) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		p, err := Decode[PaymentConfirmation](d.Body)
+		if err != nil {
+			return err
+		}
+		if p.ConfirmationsRequired > 0 {
+			confirmations, err := checker.Confirmations(ctx, p.Blockchain, p.TxHash)
+			if err != nil {
+				return err
+			}
+			if confirmations < p.ConfirmationsRequired {
+				qm.LogEntry(ctx).WithFields(log.Fields{
+					"confirmations":          confirmations,
+					"confirmations_required": p.ConfirmationsRequired,
+				}).Info("payment awaiting confirmations")
+				timer := time.NewTimer(delay)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					// we're shutting down, so leave the message to be redelivered
+					return ctx.Err()
+				}
+				return qm.requeue(ctx, d, Attempt(d)+1)
+			}
+		}
+		return finalize(ctx, p)
+	}
+}
@@ -0,0 +1,5 @@
+// Package pb contains the protobuf encodings of our high volume queue messages,
+// used by the queue package's protobuf codec.
+package pb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative messages.proto
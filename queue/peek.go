@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// InspectedMessage is a message looked at with Peek, left on its queue
+type InspectedMessage struct {
+	// Message is the message decoded into its typed struct, such as IPFSPin, and is
+	// nil when the queue has no registered message type or decoding failed
+	Message interface{}
+	// Err is why the message couldn't be decoded, if it couldn't be
+	Err error
+	// Body is the message's body, decompressed if it was compressed
+	Body          []byte
+	Headers       amqp.Table
+	ContentType   string
+	MessageID     string
+	CorrelationID string
+	Type          string
+	Timestamp     time.Time
+	// Redelivered is set for messages which have been delivered before, including
+	// by an earlier Peek
+	Redelivered bool
+}
+
+// peeker is implemented by brokers able to look at messages without consuming them
+type peeker interface {
+	Peek(queueName string, n int) ([]amqp.Delivery, error)
+}
+
+// Peek is used to look at up to n of the messages waiting in a queue without
+// consuming them, for triaging incidents. Messages are taken with basic.get and
+// returned with a requeueing nack, so they stay for the queue's real consumers,
+// and are decoded according to the queue's message type, with dead letter queues
+// decoded as the queue they belong to. ErrQueueNotFound is returned if the queue
+// doesn't exist.
+//
+// Peeking isn't free of side effects: the messages are held until Peek returns,
+// so consumers may receive messages behind them first, requeued messages may be
+// reordered relative to those published meanwhile, and they are marked redelivered.
+// It shouldn't be relied upon for anything but debugging.
+func (qm *Manager) Peek(queueName string, n int) ([]InspectedMessage, error) {
+	if n <= 0 {
+		return nil, errors.New("number of messages to peek must be greater than 0")
+	}
+	var deliveries []amqp.Delivery
+	if qm.Broker != nil {
+		p, ok := qm.Broker.(peeker)
+		if !ok {
+			return nil, errors.New("broker does not support peeking")
+		}
+		var err error
+		if deliveries, err = p.Peek(queueName, n); err != nil {
+			return nil, err
+		}
+	} else {
+		// the broker closes the channel when getting from a queue which doesn't exist,
+		// and closing it returns anything we hold, so we use a throwaway channel
+		ch, err := qm.connection().Channel()
+		if err != nil {
+			return nil, connectionError(err)
+		}
+		defer ch.Close()
+		for len(deliveries) < n {
+			d, ok, err := ch.Get(queueName, false)
+			if amqpErr, isAmqp := err.(*amqp.Error); isAmqp && amqpErr.Code == amqp.NotFound {
+				return nil, ErrQueueNotFound
+			}
+			if err != nil {
+				return nil, connectionError(err)
+			}
+			if !ok {
+				break
+			}
+			deliveries = append(deliveries, d)
+		}
+		if len(deliveries) > 0 {
+			// returning every message at once keeps them in their original order
+			if err = ch.Nack(deliveries[len(deliveries)-1].DeliveryTag, true, true); err != nil {
+				return nil, connectionError(err)
+			}
+		}
+	}
+	inspected := make([]InspectedMessage, 0, len(deliveries))
+	for _, d := range deliveries {
+		inspected = append(inspected, inspect(queueName, d))
+	}
+	return inspected, nil
+}
+
+// inspect is used to decode a peeked message according to the message type of the
+// queue it was taken from
+func inspect(queueName string, d amqp.Delivery) InspectedMessage {
+	msg := InspectedMessage{
+		Headers:       d.Headers,
+		ContentType:   d.ContentType,
+		MessageID:     d.MessageId,
+		CorrelationID: d.CorrelationId,
+		Type:          d.Type,
+		Timestamp:     d.Timestamp,
+		Redelivered:   d.Redelivered,
+	}
+	if msg.Err = Decompress(&d); msg.Err != nil {
+		msg.Body = d.Body
+		return msg
+	}
+	msg.Body = d.Body
+	typ, ok := messageTypes[strings.TrimSuffix(queueName, DeadLetterName(""))]
+	if !ok {
+		return msg
+	}
+	v := reflect.New(typ)
+	if msg.Err = UnmarshalDelivery(d, v.Interface()); msg.Err == nil {
+		msg.Message = v.Elem().Interface()
+	}
+	return msg
+}
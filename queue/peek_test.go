@@ -0,0 +1,47 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestPeek(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithCompression(1))
+	ctx := context.Background()
+	for _, user := range []string{"first", "second", "third"} {
+		if err := qm.PublishMessageContext(ctx, testPin(user)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msgs, err := qm.Peek(queue.IpfsPinQueue, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	for i, user := range []string{"first", "second"} {
+		pin, ok := msgs[i].Message.(queue.IPFSPin)
+		if !ok || msgs[i].Err != nil {
+			t.Fatalf("expected an IPFSPin, got %T: %v", msgs[i].Message, msgs[i].Err)
+		}
+		if pin.UserName != user {
+			t.Fatalf("expected %s's pin, got %+v", user, pin)
+		}
+	}
+	// the messages stay for the real consumer
+	if broker.Len(queue.IpfsPinQueue) != 3 {
+		t.Fatalf("expected peeking to leave 3 messages, found %d", broker.Len(queue.IpfsPinQueue))
+	}
+	if _, err = qm.Peek("missing-queue", 1); !errors.Is(err, queue.ErrQueueNotFound) {
+		t.Fatalf("expected ErrQueueNotFound, got %v", err)
+	}
+	if _, err = qm.Peek(queue.IpfsPinQueue, 0); err == nil {
+		t.Fatal("expected an error peeking no messages")
+	}
+}
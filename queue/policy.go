@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"reflect"
+)
+
+// PricingFunc is used to compute the credit cost of a message
+type PricingFunc func(body interface{}) (float64, error)
+
+// PublishPolicy is used to backstop producers that forget to set a hold time,
+// credit cost or network, which would otherwise enqueue free, instantly expiring
+// jobs, or be refused
+type PublishPolicy struct {
+	// DefaultNetwork is applied to network scoped messages, being those
+	// implementing NetworkNamed and pin status requests, without a network name.
+	// Without a default such messages fail validation, as no network is assumed.
+	DefaultNetwork string
+	// DefaultHoldTimeInMonths is applied to messages without a hold time
+	DefaultHoldTimeInMonths int64
+	// Pricing is used to compute the credit cost of messages without one, and
+	// is called after the default hold time has been applied
+	Pricing PricingFunc
+}
+
+// apply is used to return a copy of the message with the policy applied.
+// messages with a hold time, credit cost and network are returned untouched
+func (p *PublishPolicy) apply(body interface{}) (interface{}, error) {
+	var err error
+	// we work on a copy so that the caller's message is never modified
+	switch msg := deref(body).(type) {
+	case IPFSPin:
+		msg.NetworkName = p.network(msg.NetworkName)
+		msg.HoldTimeInMonths = p.holdTime(msg.HoldTimeInMonths)
+		msg.CreditCost, err = p.creditCost(msg, msg.CreditCost)
+		return msg, err
+	case IPFSBulkPin:
+		msg.NetworkName = p.network(msg.NetworkName)
+		msg.HoldTimeInMonths = p.holdTime(msg.HoldTimeInMonths)
+		msg.CreditCost, err = p.creditCost(msg, msg.CreditCost)
+		return msg, err
+	case IPFSClusterPin:
+		msg.NetworkName = p.network(msg.NetworkName)
+		msg.HoldTimeInMonths = p.holdTime(msg.HoldTimeInMonths)
+		msg.CreditCost, err = p.creditCost(msg, msg.CreditCost)
+		return msg, err
+	case DatabaseFileAdd:
+		msg.NetworkName = p.network(msg.NetworkName)
+		msg.HoldTimeInMonths = p.holdTime(msg.HoldTimeInMonths)
+		msg.CreditCost, err = p.creditCost(msg, msg.CreditCost)
+		return msg, err
+	case IPFSFile:
+		msg.NetworkName = p.network(msg.NetworkName)
+		msg.HoldTimeInMonths = p.holdTime(msg.HoldTimeInMonths)
+		msg.CreditCost, err = p.creditCost(msg, msg.CreditCost)
+		return msg, err
+	case IPFSKeyCreation:
+		msg.NetworkName = p.network(msg.NetworkName)
+		msg.CreditCost, err = p.creditCost(msg, msg.CreditCost)
+		return msg, err
+	case IPNSUpdate:
+		msg.NetworkName = p.network(msg.NetworkName)
+		msg.CreditCost, err = p.creditCost(msg, msg.CreditCost)
+		return msg, err
+	case IPNSEntry:
+		msg.NetworkName = p.network(msg.NetworkName)
+		msg.CreditCost, err = p.creditCost(msg, msg.CreditCost)
+		return msg, err
+	case IPFSKeyDeletion:
+		msg.NetworkName = p.network(msg.NetworkName)
+		return msg, nil
+	case PinStatusRequest:
+		msg.NetworkName = p.network(msg.NetworkName)
+		return msg, nil
+	}
+	return body, nil
+}
+
+// holdTime is used to return the hold time to use for a message
+func (p *PublishPolicy) holdTime(months int64) int64 {
+	if months == 0 {
+		return p.DefaultHoldTimeInMonths
+	}
+	return months
+}
+
+// network is used to return the network name to use for a message
+func (p *PublishPolicy) network(name string) string {
+	if name == "" {
+		return p.DefaultNetwork
+	}
+	return name
+}
+
+// creditCost is used to return the credit cost to use for a message,
+// invoking the pricing function when the producer didn't set one
+func (p *PublishPolicy) creditCost(msg interface{}, cost float64) (float64, error) {
+	if cost != 0 || p.Pricing == nil {
+		return cost, nil
+	}
+	return p.Pricing(msg)
+}
+
+// deref is used to dereference a pointer to a message
+func deref(body interface{}) interface{} {
+	v := reflect.ValueOf(body)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return v.Elem().Interface()
+	}
+	return body
+}
@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// PublishOption is used to set per message properties when publishing
+type PublishOption func(*amqp.Publishing)
+
+// WithPriority is used to publish a message with the given priority, so that it is
+// delivered ahead of messages with lower priorities. Priorities only apply to queues
+// declared with a max priority (see WithMaxPriority), with priorities above the max
+// treated as the max, and messages are published with priority 0 by default.
+func WithPriority(priority uint8) PublishOption {
+	return func(msg *amqp.Publishing) {
+		msg.Priority = priority
+	}
+}
+
+// PublishMessageContext is used to publish a message to the queue, aborting if ctx
+// is cancelled or its deadline expires before the broker accepts the message.
+// Note that a publish which is already in flight when ctx is cancelled may still
+// reach the broker.
+func (qm *Manager) PublishMessageContext(ctx context.Context, body interface{}, opts ...PublishOption) error {
+	return qm.publish(ctx, qm.channel(), "", qm.QueueName, body, opts...)
+}
+
+// PublishToNetwork is used to publish a message through the manager's network routed
+// exchange, using the message's network name as the routing key so that it only
+// reaches consumers subscribed to that network
+func (qm *Manager) PublishToNetwork(ctx context.Context, body NetworkNamed, opts ...PublishOption) error {
+	if !qm.Options.NetworkRouting {
+		return errors.New("manager is not configured for network routing")
+	}
+	// messages without a network are routed to the default network applied to them
+	network := body.GetNetworkName()
+	if network == "" && qm.Policy != nil {
+		network = qm.Policy.DefaultNetwork
+	}
+	return qm.publish(ctx, qm.channel(), qm.ExchangeName, network, body, opts...)
+}
+
+// publish is used to marshal a message and publish it through the given channel
+func (qm *Manager) publish(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, body interface{}, opts ...PublishOption) error {
+	msg, err := qm.prepare(ctx, body)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	if err = validateExpiration(msg.Expiration); err != nil {
+		return validationError(err)
+	}
+	if err = validateHeaders(msg.Headers); err != nil {
+		return validationError(err)
+	}
+	// don't bother publishing if the caller has already given up
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if exchangeName == "" {
+		if err = qm.waitForCapacity(ctx, routingKey); err != nil {
+			return err
+		}
+	}
+	if err = qm.send(ctx, ch, exchangeName, routingKey, msg); err != nil {
+		return err
+	}
+	if qm.Billing && exchangeName == "" {
+		qm.copyMessage(ctx, ch, BillingQueue, routingKey, msg)
+	}
+	if qm.Audit && exchangeName == "" {
+		qm.copyMessage(ctx, ch, AuditQueue, routingKey, msg)
+	}
+	return nil
+}
+
+// prepare is used to validate and marshal a message, applying our publish policy.
+// messages are sent as persistent to combine with our durable queues
+func (qm *Manager) prepare(ctx context.Context, body interface{}) (amqp.Publishing, error) {
+	var err error
+	if qm.Policy != nil {
+		if body, err = qm.Policy.apply(body); err != nil {
+			return amqp.Publishing{}, err
+		}
+	}
+	// tns names are case insensitive, so are published in their canonical form
+	body = normalizeNames(body)
+	// make sure malformed messages never reach the queue
+	if v, ok := deref(body).(validator); ok {
+		if err = v.Validate(); err != nil {
+			return amqp.Publishing{}, validationError(err)
+		}
+	}
+	var codec Codec = JSONCodec{}
+	if qm.Codec != nil {
+		codec = qm.Codec
+	}
+	bodyMarshaled, err := codec.Marshal(body)
+	if err == ErrUnsupportedMessage {
+		codec = JSONCodec{}
+		bodyMarshaled, err = codec.Marshal(body)
+	}
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	// the type allows consumers to decode messages without knowing their type
+	msg := amqp.Publishing{
+		Headers:      qm.injectTrace(ctx, withCorrelation(ctx, qm.signingHeaders(bodyMarshaled))),
+		DeliveryMode: amqp.Persistent,
+		ContentType:  codec.ContentType(),
+		Type:         messageType(body),
+		Timestamp:    time.Now(),
+		Body:         bodyMarshaled,
+	}
+	stampSchema(&msg)
+	if qm.Expiration > 0 {
+		msg.Expiration = formatExpiration(qm.Expiration)
+	}
+	// messages are signed uncompressed, as consumers verify them once decompressed
+	if qm.CompressionThreshold > 0 && len(bodyMarshaled) >= qm.CompressionThreshold {
+		if err = Compress(&msg); err != nil {
+			return amqp.Publishing{}, err
+		}
+	}
+	// refuse messages the broker would close our channel over
+	if err = qm.checkSize(body, msg); err != nil {
+		return amqp.Publishing{}, err
+	}
+	return msg, nil
+}
+
+// send is used to publish a prepared message through the given channel. All
+// publishes go through here so that they are confirmed when in confirm mode
+func (qm *Manager) send(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, msg amqp.Publishing) error {
+	// messages sent through the default exchange are routed to the queue
+	// named by their routing key, otherwise we label by exchange
+	target := exchangeName
+	if target == "" {
+		target = routingKey
+	}
+	if qm.DryRun {
+		qm.LogEntry(ctx).WithFields(log.Fields{
+			"exchange":     exchangeName,
+			"routing_key":  routingKey,
+			"content_type": msg.ContentType,
+			"type":         msg.Type,
+			"size":         len(msg.Body),
+		}).Info("dry run, not publishing message")
+		qm.Metrics.observeDryRun(target, qm.Service)
+		return nil
+	}
+	err := qm.sendMessage(ctx, ch, exchangeName, routingKey, msg)
+	if err == nil {
+		qm.Metrics.observePublished(target, qm.Service)
+	}
+	return err
+}
+
+// sendMessage is used to publish a prepared message through the manager's broker,
+// or the given channel, waiting for the broker's confirmation when the channel is
+// in confirm mode
+func (qm *Manager) sendMessage(ctx context.Context, ch *amqp.Channel, exchangeName, routingKey string, msg amqp.Publishing) error {
+	if qm.Broker != nil {
+		return connectionError(qm.Broker.Publish(ctx, exchangeName, routingKey, msg))
+	}
+	if ch == nil {
+		return ErrNotConnected
+	}
+	if c := qm.confirmerFor(ch); c != nil {
+		return connectionError(c.publish(ctx, exchangeName, routingKey, msg))
+	}
+	return connectionError(ChannelBroker{Channel: ch, Mandatory: qm.Mandatory}.Publish(ctx, exchangeName, routingKey, msg))
+}
@@ -0,0 +1,171 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+func TestWithPriority(t *testing.T) {
+	var msg amqp.Publishing
+	queue.WithPriority(7)(&msg)
+	if msg.Priority != 7 {
+		t.Fatalf("expected priority 7, got %d", msg.Priority)
+	}
+}
+
+// urgent messages should be delivered ahead of a backlog of bulk messages
+func TestPublishMessageContext_Priority(t *testing.T) {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	qm, err := queue.NewManager(url,
+		queue.WithQueue("priority-test-queue"),
+		queue.WithDurable(false),
+		queue.WithMaxPriority(10),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qm.Close(context.Background())
+	if _, err = qm.Channel.QueuePurge(qm.QueueName, false); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if err = qm.PublishMessageContext(ctx, queue.IPFSPin{
+			CID:              benchCID,
+			NetworkName:      "public",
+			UserName:         "bulk",
+			HoldTimeInMonths: 1,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = qm.PublishMessageContext(ctx, queue.IPFSPin{
+		CID:              benchCID,
+		NetworkName:      "public",
+		UserName:         "urgent",
+		HoldTimeInMonths: 1,
+	}, queue.WithPriority(5)); err != nil {
+		t.Fatal(err)
+	}
+	// give the broker a moment to enqueue everything before we consume
+	time.Sleep(time.Second)
+	d, ok, err := qm.Channel.Get(qm.QueueName, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("no messages in queue")
+	}
+	var pin queue.IPFSPin
+	if err = json.Unmarshal(d.Body, &pin); err != nil {
+		t.Fatal(err)
+	}
+	if pin.UserName != "urgent" {
+		t.Fatalf("expected the urgent message first, got %s", pin.UserName)
+	}
+}
+
+// dry runs exercise the publish path without a broker, counting messages
+// separately from those really published
+// zone names are published lowercased, so that a zone is created under the name
+// its records are created and looked up by
+func TestPublishMessageContext_NormalizesNames(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.ZoneCreationQueue))
+	ctx := context.Background()
+	if err := qm.PublishMessageContext(ctx, queue.ZoneCreation{
+		Name: "Example.ORG.", ManagerKeyName: "manager", ZoneKeyName: "zone", UserName: "user",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := broker.Get(queue.ZoneCreationQueue)
+	if !ok {
+		t.Fatal("expected the zone creation to be published")
+	}
+	zone, err := queue.DecodeDelivery[queue.ZoneCreation](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zone.Name != "example.org" {
+		t.Fatalf("expected the zone name to be normalized, got %q", zone.Name)
+	}
+}
+
+func TestPublishMessageContext_DryRun(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := queue.NewMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qm := &queue.Manager{
+		QueueName: queue.IpfsPinQueue,
+		Logger:    log.New(),
+		Metrics:   metrics,
+		DryRun:    true,
+	}
+	ctx := context.Background()
+	if err = qm.PublishMessageContext(ctx, queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = qm.PublishMessageContext(ctx, queue.IPFSPin{CID: testCID}); err == nil {
+		t.Fatal("expected invalid message to fail validation")
+	}
+	if err = qm.PublishBatch([]interface{}{
+		queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1},
+		queue.IPFSPin{CID: benchCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if m.GetCounter() != nil {
+				counts[family.GetName()] += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if got := counts["temporal_queue_messages_dry_run_total"]; got != 3 {
+		t.Fatalf("expected 3 dry run messages, got %v", got)
+	}
+	if got := counts["temporal_queue_messages_published_total"]; got != 0 {
+		t.Fatalf("expected no published messages, got %v", got)
+	}
+}
+
+func TestPublish_Errors(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	qm := newMemoryManager(t, broker)
+	ctx := context.Background()
+	err := qm.PublishMessageContext(ctx, queue.IPFSPin{UserName: "alice"})
+	if !errors.Is(err, queue.ErrValidation) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if _, err = queue.Decode[queue.IPFSPin]([]byte(`{"user_name":"alice"}`)); !errors.Is(err, queue.ErrValidation) {
+		t.Fatalf("expected a validation error decoding, got %v", err)
+	}
+	broker.Close()
+	err = qm.PublishMessageContext(ctx, testPin("alice"))
+	if !errors.Is(err, queue.ErrNotConnected) || !errors.Is(err, amqp.ErrClosed) {
+		t.Fatalf("expected a connection error wrapping the amqp error, got %v", err)
+	}
+	if !queue.IsTransient(err) {
+		t.Fatal("expected a connection error to be transient")
+	}
+}
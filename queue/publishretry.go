@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// PublishRetryOpts is used to control how failed publishes are retried
+type PublishRetryOpts struct {
+	// MaxAttempts is the number of times a message is published before giving up,
+	// including the first attempt
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, which doubles with every
+	// subsequent retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries
+	MaxDelay time.Duration
+	// Retryable decides whether a failed publish is retried, and defaults to
+	// IsTransient
+	Retryable func(err error) bool
+}
+
+// IsTransient is used to check whether a publish failed due to the broker or the
+// connection to it, and so may succeed if retried, rather than the message being
+// rejected by validation
+func IsTransient(err error) bool {
+	for _, transient := range []error{ErrNotConnected, amqp.ErrClosed, ErrNacked, ErrConfirmTimeout, io.EOF} {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) {
+		switch amqpErr.Code {
+		case amqp.ConnectionForced, amqp.FrameError, amqp.ChannelError,
+			amqp.UnexpectedFrame, amqp.ResourceError, amqp.InternalError:
+			return true
+		}
+		return amqpErr.Recover
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// PublishWithRetry is used to publish a message to the queue as with
+// PublishMessageContext, retrying publishes which fail with a retryable error after
+// an exponentially increasing delay, and re-dialing the broker should the connection
+// have dropped. Messages which fail validation aren't retried. It returns once the
+// message is published, the error isn't retryable, ctx is done, or the message has
+// been attempted MaxAttempts times. Combined with publisher confirms this gives at
+// least once delivery, as a message whose confirmation timed out is published again.
+func (qm *Manager) PublishWithRetry(ctx context.Context, body interface{}, opts PublishRetryOpts, pubOpts ...PublishOption) error {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = time.Second
+	}
+	if opts.MaxDelay < opts.BaseDelay {
+		opts.MaxDelay = opts.BaseDelay
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsTransient
+	}
+	// the message is prepared once, so that every attempt publishes the same
+	// message, and so that malformed messages fail before reaching the broker
+	msg, err := qm.prepare(ctx, body)
+	if err != nil {
+		return err
+	}
+	for _, opt := range pubOpts {
+		opt(&msg)
+	}
+	if err = validateExpiration(msg.Expiration); err != nil {
+		return validationError(err)
+	}
+	if err = validateHeaders(msg.Headers); err != nil {
+		return validationError(err)
+	}
+	if err = qm.waitForCapacity(ctx, qm.QueueName); err != nil {
+		return err
+	}
+	delay := opts.BaseDelay
+	for attempt := 1; ; attempt++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		gen := qm.generation()
+		err = qm.send(ctx, qm.channel(), "", qm.QueueName, msg)
+		if err == nil || !opts.Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= opts.MaxAttempts {
+			return fmt.Errorf("giving up publishing after %v attempts: %w", attempt, err)
+		}
+		qm.LogEntry(ctx).WithField("attempt", attempt).WithField("error", err.Error()).Warn("retrying failed publish")
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		if delay *= 2; delay > opts.MaxDelay {
+			delay = opts.MaxDelay
+		}
+		if rdErr := qm.redialForPublish(ctx, gen); rdErr != nil {
+			qm.logError(ctx, rdErr, "failed to re-dial broker")
+		}
+	}
+}
+
+// redialForPublish is used to restore a dropped connection before retrying a
+// publish. When reconnection is enabled we wait for a connection newer than gen,
+// and otherwise dial the broker ourselves
+func (qm *Manager) redialForPublish(ctx context.Context, gen int) error {
+	conn := qm.connection()
+	if qm.Broker != nil || conn == nil || !conn.IsClosed() {
+		return nil
+	}
+	qm.mu.RLock()
+	r, url, closed := qm.recon, qm.url, qm.closed
+	qm.mu.RUnlock()
+	if closed {
+		return amqp.ErrClosed
+	}
+	if r != nil {
+		_, err := r.wait(ctx, gen)
+		return err
+	}
+	return qm.reconnect(url)
+}
@@ -0,0 +1,75 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// flakyBroker is a broker failing its first publishes with err
+type flakyBroker struct {
+	*queue.MemoryBroker
+	failures int
+	attempts int
+	err      error
+}
+
+func (b *flakyBroker) Publish(ctx context.Context, exchangeName, routingKey string, msg amqp.Publishing) error {
+	if b.attempts++; b.attempts <= b.failures {
+		return b.err
+	}
+	return b.MemoryBroker.Publish(ctx, exchangeName, routingKey, msg)
+}
+
+func TestPublishWithRetry(t *testing.T) {
+	opts := queue.PublishRetryOpts{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	var tests = []struct {
+		name      string
+		failures  int
+		err       error
+		body      interface{}
+		attempts  int
+		published bool
+	}{
+		{"Success", 0, nil, testPin("alice"), 1, true},
+		{"Transient", 2, amqp.ErrClosed, testPin("alice"), 3, true},
+		{"Nacked", 1, queue.ErrNacked, testPin("alice"), 2, true},
+		{"Exhausted", 3, amqp.ErrClosed, testPin("alice"), 3, false},
+		{"NotRetryable", 3, errors.New("access refused"), testPin("alice"), 1, false},
+		{"Invalid", 0, nil, queue.IPFSPin{UserName: "alice"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := &flakyBroker{MemoryBroker: queue.NewMemoryBroker(), failures: tt.failures, err: tt.err}
+			defer broker.Close()
+			qm := newMemoryManager(t, broker)
+			err := qm.PublishWithRetry(context.Background(), tt.body, opts)
+			if (err == nil) != tt.published {
+				t.Fatalf("expected published to be %v, got error %v", tt.published, err)
+			}
+			if broker.attempts != tt.attempts {
+				t.Fatalf("expected %v attempts, got %v", tt.attempts, broker.attempts)
+			}
+			if published := broker.Len(queue.IpfsPinQueue) == 1; published != tt.published {
+				t.Fatalf("expected message published to be %v", tt.published)
+			}
+		})
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, err := range []error{amqp.ErrClosed, queue.ErrNacked, queue.ErrConfirmTimeout, &amqp.Error{Code: amqp.ConnectionForced}} {
+		if !queue.IsTransient(err) {
+			t.Errorf("expected %v to be transient", err)
+		}
+	}
+	for _, err := range []error{errors.New("invalid cid"), &amqp.Error{Code: amqp.NotFound}, context.Canceled} {
+		if queue.IsTransient(err) {
+			t.Errorf("expected %v not to be transient", err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// RateLimit is a token bucket limit on the rate at which a single user's messages
+// are processed
+type RateLimit struct {
+	// Rate is the number of messages per second a user's tokens are refilled at
+	Rate float64
+	// Burst is the number of messages a user may have processed at once, having
+	// built up tokens, and is at least 1
+	Burst int
+}
+
+// RateLimitStore is used to hold the token buckets of rate limited users, which may
+// be shared between consumers. Implementations must be safe for concurrent use, and
+// taking a token must be atomic.
+type RateLimitStore interface {
+	// Take is used to take a token from the bucket identified by key, returning
+	// false along with how long until a token is available when the bucket is empty
+	Take(key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+// MemoryRateLimitStore is an in-memory RateLimitStore, which only limits messages
+// processed by the same process
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket is a token bucket, holding tokens as of updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewMemoryRateLimitStore is used to create an empty in-memory rate limit store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+// Take is used to take a token from a bucket
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	// drop buckets which have refilled as we go, as they're no different to new
+	// buckets, so the store doesn't grow without bound
+	for k, b := range s.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*limit.Rate >= burst {
+			delete(s.buckets, k)
+		}
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.updated).Seconds() * limit.Rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// throttle is used to check a delivery against its user's rate limit, returning
+// false if it was throttled, in which case it has already been settled. Throttled
+// messages are published to the back of the queue once a token is available, and
+// the original acknowledged. When the queue has delayed delivery enabled the wait
+// is spent in the broker, while otherwise it occupies one of the consumer's workers.
+func (qm *Manager) throttle(ctx context.Context, d amqp.Delivery) bool {
+	if qm.RateLimits == nil || qm.UserRateLimit.Rate <= 0 {
+		return true
+	}
+	var msg struct {
+		UserName string `json:"user_name"`
+	}
+	if peek(d, &msg) != nil || msg.UserName == "" {
+		return true
+	}
+	ok, wait, err := qm.RateLimits.Take(qm.QueueName+":"+msg.UserName, qm.UserRateLimit)
+	if err != nil {
+		// an unavailable store shouldn't halt processing for everyone
+		qm.logError(ctx, err, "failed to check user rate limit")
+		return true
+	}
+	if ok {
+		return true
+	}
+	qm.LogEntry(ctx).WithField("wait", wait).Info("throttling message of rate limited user")
+	if err = qm.postpone(ctx, d, wait); err != nil {
+		qm.logError(ctx, err, "failed to postpone throttled message")
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+		if err = d.Nack(false, true); err != nil {
+			qm.logError(ctx, err, "failed to requeue message")
+		}
+		return false
+	}
+	qm.Metrics.observeSettled(qm.QueueName, qm.Service, true)
+	if err = d.Ack(false); err != nil {
+		qm.logError(ctx, err, "failed to acknowledge message")
+	}
+	return false
+}
+
+// postpone is used to publish a copy of a delivery to the back of our queue once
+// wait has elapsed. Deliveries routed to our queue by another routing key, such as
+// the copies consumed by AuditLog, keep it in their headers
+func (qm *Manager) postpone(ctx context.Context, d amqp.Delivery, wait time.Duration) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	if _, ok := headers[HeaderOriginalRoutingKey]; !ok && d.RoutingKey != "" && d.RoutingKey != qm.QueueName {
+		headers[HeaderOriginalRoutingKey] = d.RoutingKey
+	}
+	msg := amqp.Publishing{
+		Headers:         headers,
+		DeliveryMode:    amqp.Persistent,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		CorrelationId:   d.CorrelationId,
+		MessageId:       d.MessageId,
+		Type:            d.Type,
+		Timestamp:       d.Timestamp,
+		Body:            d.Body,
+	}
+	if mechanism := qm.DelayMechanism(); mechanism != DelayDisabled {
+		// delays are rounded up to the second, as each delay has its own
+		// queue when the delayed message plugin isn't available
+		return qm.sendDelayed(ctx, mechanism, msg, wait.Truncate(time.Second)+time.Second)
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return qm.send(ctx, qm.channel(), "", qm.QueueName, msg)
+}
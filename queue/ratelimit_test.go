@@ -0,0 +1,68 @@
+package queue_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := queue.NewMemoryRateLimitStore()
+	limit := queue.RateLimit{Rate: 10, Burst: 2}
+	for i := 0; i < 2; i++ {
+		if ok, _, err := store.Take("alice", limit); err != nil || !ok {
+			t.Fatalf("expected token %v of the burst to be available", i)
+		}
+	}
+	ok, wait, err := store.Take("alice", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("expected to wait for a token, got %v %v", ok, wait)
+	}
+	// buckets are kept per key
+	if ok, _, _ = store.Take("bob", limit); !ok {
+		t.Fatal("expected bob's bucket to be unaffected")
+	}
+	time.Sleep(wait)
+	if ok, _, _ = store.Take("alice", limit); !ok {
+		t.Fatal("expected a token once refilled")
+	}
+}
+
+// a user exceeding their rate limit has their messages postponed behind those of
+// other users
+func TestUserRateLimit(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithUserRateLimit(queue.RateLimit{Rate: 20, Burst: 1}, nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, user := range []string{"alice", "alice", "bob"} {
+		if err := qm.PublishMessageContext(ctx, testPin(user)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var handled []string
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		pin, err := queue.DecodeDelivery[queue.IPFSPin](d)
+		if err != nil {
+			return err
+		}
+		if handled = append(handled, pin.UserName); len(handled) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if got := strings.Join(handled, ","); got != "alice,bob,alice" {
+		t.Fatalf("expected alice's second message to be postponed, handled %s", got)
+	}
+	if broker.Len(queue.IpfsPinQueue)+broker.Unacked() != 0 {
+		t.Fatal("expected every message to be acknowledged")
+	}
+}
@@ -0,0 +1,292 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ReconnectOpts is used to control how a Manager recovers from a dropped connection
+type ReconnectOpts struct {
+	// MaxRetries is the number of dial attempts made before giving up,
+	// with 0 retrying forever
+	MaxRetries int
+	// BaseDelay is the delay before the first attempt, which doubles after
+	// every failed attempt
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts
+	MaxDelay time.Duration
+	// Jitter randomizes the delay between attempts, so that consumers which lost
+	// their connection together don't all reconnect together, and defaults to
+	// JitterFull
+	Jitter JitterMode
+	// Rand is optionally used as the source of randomness for Jitter, returning
+	// numbers in [0, 1), and defaults to math/rand
+	Rand func() float64
+}
+
+// JitterMode is how the delay between reconnection attempts is randomized
+type JitterMode int
+
+const (
+	// JitterFull delays each attempt by a random duration up to the backoff, which
+	// spreads attempts the most, and is the default
+	JitterFull JitterMode = iota
+	// JitterEqual delays each attempt by half the backoff plus a random duration up
+	// to the other half, guaranteeing some delay between attempts
+	JitterEqual
+	// JitterNone delays each attempt by exactly the backoff, opting out of jitter
+	JitterNone
+)
+
+// Delay is used to get the delay before the given attempt, starting at 1, being
+// the exponential backoff from BaseDelay capped at MaxDelay, randomized by Jitter
+func (o ReconnectOpts) Delay(attempt int) time.Duration {
+	backoff := o.BaseDelay
+	for i := 1; i < attempt && backoff < o.MaxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > o.MaxDelay {
+		backoff = o.MaxDelay
+	}
+	random := o.Rand
+	if random == nil {
+		random = rand.Float64
+	}
+	switch o.Jitter {
+	case JitterNone:
+		return backoff
+	case JitterEqual:
+		half := backoff / 2
+		return half + time.Duration(random()*float64(backoff-half))
+	default:
+		return time.Duration(random() * float64(backoff))
+	}
+}
+
+// ReconnectEvent describes a single reconnection attempt
+type ReconnectEvent struct {
+	// Attempt is the number of the attempt, starting at 1
+	Attempt int
+	// Reason is the error the broker closed the connection with
+	Reason *amqp.Error
+	// Err is the error the attempt failed with, and is nil on success
+	Err error
+}
+
+// reconnector holds the state used to recover a manager's connection
+type reconnector struct {
+	url    string
+	opts   ReconnectOpts
+	events chan ReconnectEvent
+
+	mu sync.Mutex
+	// gen is incremented every time a new connection is established
+	gen int
+	// changed is closed, and replaced, whenever gen or err change
+	changed chan struct{}
+	// err is set once we've given up reconnecting
+	err error
+}
+
+// EnableReconnect is used to automatically re-dial url when the connection to the
+// broker drops unexpectedly, re-declaring the queue and resuming any consumers
+// once the connection is restored. Every reconnection attempt is reported on the
+// returned channel, with events being dropped if the channel is not drained.
+//
+// Messages which were being processed when the connection dropped can no longer
+// be acknowledged, and are redelivered by the broker once we reconnect, so
+// handlers must tolerate processing the same message twice.
+func (qm *Manager) EnableReconnect(url string, opts ReconnectOpts) <-chan ReconnectEvent {
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = time.Second
+	}
+	if opts.MaxDelay < opts.BaseDelay {
+		opts.MaxDelay = opts.BaseDelay
+	}
+	r := &reconnector{
+		url:     url,
+		opts:    opts,
+		events:  make(chan ReconnectEvent, 10),
+		changed: make(chan struct{}),
+	}
+	qm.mu.Lock()
+	qm.recon = r
+	qm.mu.Unlock()
+	go qm.watchConnection(r)
+	return r.events
+}
+
+// watchConnection is used to re-dial the broker whenever the connection drops
+func (qm *Manager) watchConnection(r *reconnector) {
+	for {
+		closed := qm.connection().NotifyClose(make(chan *amqp.Error, 1))
+		// a nil reason means the connection was closed by us, so we stop watching
+		reason := <-closed
+		if reason == nil {
+			r.setErr(amqp.ErrClosed)
+			return
+		}
+		if err := qm.redial(r, reason); err != nil {
+			r.setErr(err)
+			return
+		}
+		r.mu.Lock()
+		r.gen++
+		r.broadcast()
+		r.mu.Unlock()
+	}
+}
+
+// redial is used to re-establish the connection, backing off between attempts
+func (qm *Manager) redial(r *reconnector, reason *amqp.Error) error {
+	for attempt := 1; r.opts.MaxRetries == 0 || attempt <= r.opts.MaxRetries; attempt++ {
+		time.Sleep(r.opts.Delay(attempt))
+		err := qm.reconnect(r.url)
+		r.emit(ReconnectEvent{Attempt: attempt, Reason: reason, Err: err})
+		if err == amqp.ErrClosed {
+			return err
+		}
+		if err == nil {
+			qm.NotifyAdmin(ConnectionLostSubject, fmt.Sprintf(
+				"Connection to rabbitmq for queue %s of service %s was lost (%s), and restored after %v attempts",
+				qm.QueueName, qm.Service, reason, attempt,
+			))
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to reconnect after %v attempts", r.opts.MaxRetries)
+}
+
+// reconnect is used to dial the broker and re-declare our queue, replacing the
+// connection and channel held by the manager once that has succeeded
+func (qm *Manager) reconnect(url string) error {
+	conn, err := DialConfig(url, qm.TLSConfig, qm.ConnectionOpts)
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	// we only switch over to a connection we've declared our queue on, so
+	// that failing to declare doesn't leave a broken connection in place
+	if err = qm.declare(conn, ch); err != nil {
+		conn.Close()
+		return err
+	}
+	qm.mu.Lock()
+	// don't resurrect a manager which was closed while we were dialing
+	if qm.closed {
+		qm.mu.Unlock()
+		conn.Close()
+		return amqp.ErrClosed
+	}
+	// the new channel has to be placed back in confirm mode
+	if qm.confirm != nil {
+		c, err := newConfirmer(ch, qm.confirm.timeout, qm.Mandatory)
+		if err != nil {
+			qm.mu.Unlock()
+			conn.Close()
+			return err
+		}
+		qm.confirm = c
+	}
+	qm.Connection, qm.Channel = conn, ch
+	qm.mu.Unlock()
+	qm.watchReturns(ch)
+	return nil
+}
+
+// connection is used to get the manager's current connection
+func (qm *Manager) connection() *amqp.Connection {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	return qm.Connection
+}
+
+// channel is used to get the manager's current channel
+func (qm *Manager) channel() *amqp.Channel {
+	qm.mu.RLock()
+	defer qm.mu.RUnlock()
+	return qm.Channel
+}
+
+// resume is used to wait for the connection to be re-established once our
+// deliveries stop, returning a new delivery channel. A nil channel is returned
+// if reconnection isn't enabled, in which case consumers should stop.
+func (qm *Manager) resume(ctx context.Context, gen int, consumer string, prefetch int) (<-chan amqp.Delivery, int, error) {
+	qm.mu.RLock()
+	r := qm.recon
+	qm.mu.RUnlock()
+	if r == nil {
+		return nil, gen, nil
+	}
+	gen, err := r.wait(ctx, gen)
+	if err != nil {
+		return nil, gen, err
+	}
+	msgs, err := qm.consume(consumer, prefetch)
+	return msgs, gen, err
+}
+
+// generation is used to get the current connection generation, which is
+// 0 when reconnection isn't enabled
+func (qm *Manager) generation() int {
+	qm.mu.RLock()
+	r := qm.recon
+	qm.mu.RUnlock()
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gen
+}
+
+// wait is used to block until a connection newer than gen is established
+func (r *reconnector) wait(ctx context.Context, gen int) (int, error) {
+	for {
+		r.mu.Lock()
+		current, err, changed := r.gen, r.err, r.changed
+		r.mu.Unlock()
+		if current > gen {
+			return current, nil
+		}
+		if err != nil {
+			return current, err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return current, ctx.Err()
+		}
+	}
+}
+
+// setErr is used to record that we've stopped reconnecting
+func (r *reconnector) setErr(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.broadcast()
+	r.mu.Unlock()
+}
+
+// broadcast is used to wake up any waiters, and must be called with mu held
+func (r *reconnector) broadcast() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// emit is used to report a reconnection attempt without blocking
+func (r *reconnector) emit(event ReconnectEvent) {
+	select {
+	case r.events <- event:
+	default:
+	}
+}
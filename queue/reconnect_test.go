@@ -0,0 +1,58 @@
+package queue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestReconnectOpts_Delay(t *testing.T) {
+	half := func() float64 { return 0.5 }
+	tests := []struct {
+		name    string
+		jitter  queue.JitterMode
+		attempt int
+		want    time.Duration
+	}{
+		{"NoneFirst", queue.JitterNone, 1, time.Second},
+		{"NoneBackoff", queue.JitterNone, 3, 4 * time.Second},
+		{"NoneCapped", queue.JitterNone, 10, 10 * time.Second},
+		{"FullFirst", queue.JitterFull, 1, 500 * time.Millisecond},
+		{"FullBackoff", queue.JitterFull, 3, 2 * time.Second},
+		{"FullCapped", queue.JitterFull, 10, 5 * time.Second},
+		{"EqualFirst", queue.JitterEqual, 1, 750 * time.Millisecond},
+		{"EqualBackoff", queue.JitterEqual, 3, 3 * time.Second},
+		{"EqualCapped", queue.JitterEqual, 10, 7500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := queue.ReconnectOpts{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: tt.jitter, Rand: half}
+			if got := opts.Delay(tt.attempt); got != tt.want {
+				t.Fatalf("expected a delay of %v, got %v", tt.want, got)
+			}
+		})
+	}
+	// full jitter may retry straight away, while equal jitter always waits half the backoff
+	zero := func() float64 { return 0 }
+	if got := (queue.ReconnectOpts{BaseDelay: time.Second, MaxDelay: time.Second, Jitter: queue.JitterFull, Rand: zero}).Delay(1); got != 0 {
+		t.Fatalf("expected no delay, got %v", got)
+	}
+	if got := (queue.ReconnectOpts{BaseDelay: time.Second, MaxDelay: time.Second, Jitter: queue.JitterEqual, Rand: zero}).Delay(1); got != 500*time.Millisecond {
+		t.Fatalf("expected a delay of 500ms, got %v", got)
+	}
+}
+
+func TestReconnectOpts_DefaultJitter(t *testing.T) {
+	// options without a jitter mode spread their attempts with full jitter
+	opts := queue.ReconnectOpts{BaseDelay: time.Second, MaxDelay: 10 * time.Second, Rand: func() float64 { return 0.25 }}
+	if got := opts.Delay(3); got != time.Second {
+		t.Fatalf("expected a delay of 1s, got %v", got)
+	}
+}
+
+func TestNewManager_InvalidJitter(t *testing.T) {
+	if _, err := queue.NewManager("amqp://localhost", queue.WithReconnect(queue.ReconnectOpts{Jitter: queue.JitterMode(42)})); err == nil {
+		t.Fatal("expected an unknown jitter mode to be refused")
+	}
+}
@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+)
+
+// CreditFunc is used by CreditRefundHandler to credit a user back
+type CreditFunc func(ctx context.Context, refund CreditRefund) error
+
+// RefundCredits is used as a RetryOpts.OnExhausted hook for queues whose messages
+// cost credits, publishing a CreditRefund for the message's credit cost, multiplied
+// by the multiplier of its network, once it has been abandoned. Messages which were
+// free are ignored.
+func (qm *Manager) RefundCredits(ctx context.Context, d amqp.Delivery, err error) {
+	refund, ok := qm.creditRefund(d, err)
+	if !ok {
+		return
+	}
+	if pubErr := qm.publish(ctx, qm.channel(), "", CreditRefundQueue, refund); pubErr != nil {
+		qm.logError(ctx, pubErr, "failed to publish credit refund")
+	}
+}
+
+// creditRefund is used to get the refund for an abandoned message, returning false
+// if the message didn't cost anything. the refund is keyed by the message rather
+// than the delivery, so that redeliveries and replays aren't refunded twice
+func (qm *Manager) creditRefund(d amqp.Delivery, err error) (CreditRefund, bool) {
+	var msg chargedMessage
+	if peek(d, &msg) != nil || msg.CreditCost <= 0 || msg.UserName == "" {
+		return CreditRefund{}, false
+	}
+	// users are refunded what they were charged
+	credits, _ := msg.credits(qm.NetworkMultipliers)
+	if credits <= 0 {
+		return CreditRefund{}, false
+	}
+	return CreditRefund{
+		UserName:       msg.UserName,
+		Amount:         credits,
+		Reason:         err.Error(),
+		OriginalQueue:  qm.QueueName,
+		IdempotencyKey: qm.QueueName + ":" + messageKey(d),
+	}, true
+}
+
+// CreditRefundHandler is used by the credit refund consumer to credit users back
+// using credit. Refunds are only idempotent when the consumer's manager has an
+// idempotency store (see WithIdempotency), as redelivered refunds are otherwise
+// credited again.
+func (qm *Manager) CreditRefundHandler(credit CreditFunc) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		refund, err := DecodeDelivery[CreditRefund](d)
+		if err != nil {
+			return err
+		}
+		qm.LogEntry(ctx).WithField("user_name", refund.UserName).Info("refunding credits")
+		return credit(ctx, refund)
+	}
+}
@@ -0,0 +1,88 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+func TestCreditRefundHandler(t *testing.T) {
+	qm := &queue.Manager{Logger: log.New()}
+	var refunded []queue.CreditRefund
+	handler := qm.CreditRefundHandler(func(ctx context.Context, refund queue.CreditRefund) error {
+		refunded = append(refunded, refund)
+		return nil
+	})
+	body, err := json.Marshal(queue.CreditRefund{UserName: "user", Amount: 2, IdempotencyKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = handler(context.Background(), amqp.Delivery{Body: body}); err != nil {
+		t.Fatal(err)
+	}
+	if len(refunded) != 1 || refunded[0].Amount != 2 {
+		t.Fatalf("unexpected refunds %+v", refunded)
+	}
+	// invalid refunds are never credited
+	body, _ = json.Marshal(queue.CreditRefund{UserName: "user", IdempotencyKey: "key"})
+	if err = handler(context.Background(), amqp.Delivery{Body: body}); err == nil {
+		t.Fatal("expected an error for a refund without an amount")
+	}
+	if len(refunded) != 1 {
+		t.Fatal("invalid refund was credited")
+	}
+	// refunds are decoded according to their content type
+	body, _ = json.Marshal(queue.CreditRefund{UserName: "user", Amount: 3, IdempotencyKey: "key"})
+	if err = handler(context.Background(), amqp.Delivery{Body: body, ContentType: "application/xml"}); !errors.Is(err, queue.ErrUnsupportedContentType) {
+		t.Fatalf("expected ErrUnsupportedContentType, got %v", err)
+	}
+}
+
+// abandoned pins are refunded once, however many times they're redelivered
+func TestRefundCredits(t *testing.T) {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	refunds, err := queue.NewManager(url, queue.WithQueue(queue.CreditRefundQueue), queue.WithIdempotency(queue.NewMemoryStore(), 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refunds.Close(context.Background())
+	if _, err = refunds.Channel.QueuePurge(refunds.QueueName, false); err != nil {
+		t.Fatal(err)
+	}
+	pins, err := queue.NewManager(url, queue.WithQueue(queue.IpfsPinQueue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pins.Close(context.Background())
+
+	body, err := json.Marshal(queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1, CreditCost: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := amqp.Delivery{Body: body}
+	pins.RefundCredits(context.Background(), d, errors.New("pin timed out"))
+	pins.RefundCredits(context.Background(), d, errors.New("pin timed out"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var credited float64
+	refunds.ConsumeMessageContext(ctx, "refund-test", refunds.CreditRefundHandler(
+		func(ctx context.Context, refund queue.CreditRefund) error {
+			credited += refund.Amount
+			return nil
+		},
+	))
+	if credited != 3 {
+		t.Fatalf("expected 3 credits refunded, got %v", credited)
+	}
+}
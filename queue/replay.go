@@ -0,0 +1,138 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// ReplayOpts controls which dead lettered messages ReplayDeadLetter replays
+type ReplayOpts struct {
+	// Reason only replays messages whose failure reason contains it, replaying
+	// every message when empty
+	Reason string
+	// Limit is the maximum number of messages to replay, with 0 meaning no limit
+	Limit int
+	// DryRun only logs and counts the messages which would be replayed, leaving
+	// them in the dead letter queue
+	DryRun bool
+}
+
+// ReplayResult reports what ReplayDeadLetter did
+type ReplayResult struct {
+	// Replayed is the number of messages replayed, or which would have been
+	// replayed when doing a dry run
+	Replayed int
+	// Skipped is the number of messages left in the dead letter queue as they
+	// didn't match the reason
+	Skipped int
+}
+
+// ReplayDeadLetter is used to re-inject messages from the queue's dead letter queue
+// back onto the queue once whatever caused them to fail has been fixed. Messages
+// are published with their original routing key and their attempt count reset,
+// and messages which aren't replayed are returned to the dead letter queue once
+// it has been drained. As messages are held until then, this shouldn't be run
+// while anything else consumes the dead letter queue. Replaying needs a connection
+// to rabbitmq, so it isn't supported with an injected broker.
+func (qm *Manager) ReplayDeadLetter(ctx context.Context, opts ReplayOpts) (ReplayResult, error) {
+	var result ReplayResult
+	if qm.Broker != nil {
+		return result, errors.New("replaying dead letters is not supported with an injected broker")
+	}
+	conn := qm.connection()
+	if conn == nil {
+		return result, ErrNotConnected
+	}
+	// we use our own channel so that should we fail part way through, closing
+	// it returns every message we hold to the dead letter queue
+	ch, err := conn.Channel()
+	if err != nil {
+		return result, connectionError(err)
+	}
+	defer ch.Close()
+	var kept []amqp.Delivery
+	defer func() {
+		for _, d := range kept {
+			d.Nack(false, true)
+		}
+	}()
+	for opts.Limit == 0 || result.Replayed < opts.Limit {
+		if err = ctx.Err(); err != nil {
+			return result, err
+		}
+		d, ok, err := ch.Get(DeadLetterName(qm.QueueName), false)
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			break
+		}
+		reason, _ := d.Headers[HeaderFailureReason].(string)
+		if opts.Reason != "" && !strings.Contains(reason, opts.Reason) {
+			result.Skipped++
+			kept = append(kept, d)
+			continue
+		}
+		exchangeName, routingKey := qm.replayRoute(d)
+		qm.LogEntry(deliveryContext(ctx, d)).WithFields(log.Fields{
+			"reason":      reason,
+			"exchange":    exchangeName,
+			"routing_key": routingKey,
+			"dry_run":     opts.DryRun,
+		}).Info("replaying dead lettered message")
+		if opts.DryRun {
+			result.Replayed++
+			kept = append(kept, d)
+			continue
+		}
+		if err = qm.send(ctx, qm.channel(), exchangeName, routingKey, replayPublishing(d)); err != nil {
+			kept = append(kept, d)
+			return result, err
+		}
+		if err = d.Ack(false); err != nil {
+			return result, err
+		}
+		result.Replayed++
+	}
+	return result, nil
+}
+
+// replayRoute is used to get where a dead lettered message should be replayed to.
+// messages published directly to the queue have its name as their routing key,
+// while the rest went through the manager's exchange
+func (qm *Manager) replayRoute(d amqp.Delivery) (string, string) {
+	routingKey, ok := d.Headers[HeaderOriginalRoutingKey].(string)
+	if !ok || routingKey == qm.QueueName || qm.ExchangeName == "" {
+		return "", qm.QueueName
+	}
+	return qm.ExchangeName, routingKey
+}
+
+// replayPublishing is used to turn a dead lettered message back into the message
+// originally published, dropping the headers recording its failure. it's timestamped
+// as published when replayed, so that it isn't refused as too old
+func replayPublishing(d amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		switch k {
+		case HeaderFailureReason, HeaderOriginalRoutingKey, HeaderAttempt, "x-death":
+			continue
+		}
+		headers[k] = v
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		DeliveryMode:    amqp.Persistent,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		Type:            d.Type,
+		Priority:        d.Priority,
+		Timestamp:       time.Now(),
+		Body:            d.Body,
+	}
+}
@@ -0,0 +1,87 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestReplayDeadLetter_InjectedBroker(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter())
+	if _, err := qm.ReplayDeadLetter(context.Background(), queue.ReplayOpts{}); err == nil {
+		t.Fatal("expected replaying to be refused with an injected broker")
+	}
+}
+
+// failed messages are dead lettered, then replayed once the bug is fixed
+func TestReplayDeadLetter(t *testing.T) {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	qm, err := queue.NewManager(url, queue.WithQueue("replay-test-queue"), queue.WithDeadLetter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qm.Close(context.Background())
+	for _, name := range []string{qm.QueueName, queue.DeadLetterName(qm.QueueName)} {
+		if _, err = qm.Channel.QueuePurge(name, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	pin := queue.IPFSPin{CID: benchCID, NetworkName: "public", UserName: "replay", HoldTimeInMonths: 1}
+	for i := 0; i < 2; i++ {
+		if err = qm.PublishMessageContext(ctx, pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	consumeCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	failures := []error{errors.New("pin timed out"), errors.New("node offline")}
+	var failed int
+	qm.ConsumeMessageContext(consumeCtx, "replay-test", func(ctx context.Context, d amqp.Delivery) error {
+		err := failures[failed%len(failures)]
+		if failed++; failed == len(failures) {
+			cancel()
+		}
+		return err
+	})
+	// give the broker a moment to dead letter the messages
+	time.Sleep(time.Second)
+
+	result, err := qm.ReplayDeadLetter(ctx, queue.ReplayOpts{Reason: "timed out", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replayed != 1 || result.Skipped != 1 {
+		t.Fatalf("unexpected dry run result %+v", result)
+	}
+	result, err = qm.ReplayDeadLetter(ctx, queue.ReplayOpts{Reason: "timed out"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replayed != 1 || result.Skipped != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	d, ok, err := qm.Channel.Get(qm.QueueName, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("replayed message not found on queue")
+	}
+	if _, ok = d.Headers[queue.HeaderFailureReason]; ok {
+		t.Fatal("replayed message still has its failure reason")
+	}
+	if queue.Attempt(d) != 1 {
+		t.Fatalf("expected attempt to be reset, got %d", queue.Attempt(d))
+	}
+}
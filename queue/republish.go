@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRepublishInterval is how often expiring ipns records are checked for when
+// no interval is configured
+const DefaultRepublishInterval = time.Hour
+
+// DefaultRepublishLeadTime is how long before their expiry ipns records are
+// republished when no lead time is configured
+const DefaultRepublishLeadTime = 6 * time.Hour
+
+// IPNSRecord is a published ipns record
+type IPNSRecord struct {
+	IPNSHash    string
+	CID         string
+	Key         string
+	UserName    string
+	NetworkName string
+	LifeTime    time.Duration
+	TTL         time.Duration
+	// Resolve is whether the record is published resolved. It isn't stored by the
+	// store returned by NewIPNSRecordStore, whose records are all republished
+	// resolved, as records are by default
+	Resolve bool
+	// PublishedAt is when the record was last published, which it expires
+	// LifeTime after
+	PublishedAt time.Time
+}
+
+// Expiry is used to get when the record expires
+func (r IPNSRecord) Expiry() time.Time {
+	return r.PublishedAt.Add(r.LifeTime)
+}
+
+// IPNSRecordStore is used to find the ipns records which need republishing
+type IPNSRecordStore interface {
+	// ExpiringRecords is used to list the records which expire after after and
+	// before before, excluding those their user has deleted
+	ExpiringRecords(after, before time.Time) ([]IPNSRecord, error)
+}
+
+// RepublishOpts is used to control how ipns records are republished
+type RepublishOpts struct {
+	// Interval is how often expiring records are checked for, defaulting to
+	// DefaultRepublishInterval
+	Interval time.Duration
+	// LeadTime is how long before their expiry records are republished, defaulting
+	// to DefaultRepublishLeadTime. It should be comfortably longer than Interval,
+	// so that records are republished before they expire
+	LeadTime time.Duration
+}
+
+// RepublishIPNS is used to keep ipns records alive, periodically publishing an
+// IPNSUpdate to IpnsUpdateQueue for each record of store approaching its expiry,
+// which republishes the record's content with its lifetime, ttl and resolve setting.
+// Records are only republished once per expiry, as the republished record isn't
+// stored with its new expiry until the update is consumed, and records which have
+// already expired, such as those whose republishing failed, aren't republished. It runs until ctx is
+// cancelled, at which point ctx.Err() is returned.
+func (qm *Manager) RepublishIPNS(ctx context.Context, store IPNSRecordStore, opts RepublishOpts) error {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultRepublishInterval
+	}
+	if opts.LeadTime <= 0 {
+		opts.LeadTime = DefaultRepublishLeadTime
+	}
+	r := &republisher{store: store, leadTime: opts.LeadTime, published: make(map[string]time.Time)}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if n, err := qm.republishExpiring(ctx, r); err != nil {
+			qm.logError(ctx, err, "failed to republish expiring ipns records")
+		} else if n > 0 {
+			qm.LogEntry(ctx).WithField("records", n).Info("republishing expiring ipns records")
+		}
+		timer.Reset(opts.Interval)
+	}
+}
+
+// republisher holds the state of RepublishIPNS
+type republisher struct {
+	store    IPNSRecordStore
+	leadTime time.Duration
+	mu       sync.Mutex
+	// published is the expiry each record was last republished for
+	published map[string]time.Time
+}
+
+// republishExpiring is used to publish an IPNSUpdate for each record expiring
+// within the lead time, returning the number published
+func (qm *Manager) republishExpiring(ctx context.Context, r *republisher) (int, error) {
+	now := time.Now()
+	records, err := r.store.ExpiringRecords(now, now.Add(r.leadTime))
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// forget records which have since expired, as they'll either have been
+	// republished with a new expiry or are gone
+	for hash, expiry := range r.published {
+		if now.After(expiry) {
+			delete(r.published, hash)
+		}
+	}
+	var published int
+	for _, record := range records {
+		expiry := record.Expiry()
+		if last, ok := r.published[record.IPNSHash]; ok && !expiry.After(last) {
+			continue
+		}
+		update := IPNSUpdate{
+			CID:         record.CID,
+			IPNSHash:    record.IPNSHash,
+			LifeTime:    Duration(record.LifeTime),
+			TTL:         Duration(record.TTL),
+			Key:         record.Key,
+			Resolve:     record.Resolve,
+			UserName:    record.UserName,
+			NetworkName: record.NetworkName,
+		}
+		if err = qm.publish(ctx, qm.channel(), "", IpnsUpdateQueue, update); err != nil {
+			qm.LogEntry(ctx).WithFields(log.Fields{
+				"ipns_hash": record.IPNSHash,
+				"error":     err.Error(),
+			}).Error("failed to publish ipns update")
+			continue
+		}
+		r.published[record.IPNSHash] = expiry
+		published++
+	}
+	return published, nil
+}
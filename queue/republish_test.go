@@ -0,0 +1,72 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+// recordStore is an IPNSRecordStore holding records in memory
+type recordStore []queue.IPNSRecord
+
+func (s recordStore) ExpiringRecords(after, before time.Time) ([]queue.IPNSRecord, error) {
+	var records []queue.IPNSRecord
+	for _, r := range s {
+		if r.Expiry().After(after) && r.Expiry().Before(before) {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func TestRepublishIPNS(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.IpnsUpdateQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	qm := newMemoryManager(t, broker)
+	now := time.Now()
+	store := recordStore{
+		// expiring within the lead time
+		{IPNSHash: "expiring", CID: testCID, Key: "key", UserName: "alice", NetworkName: "public",
+			LifeTime: 24 * time.Hour, TTL: time.Hour, Resolve: false, PublishedAt: now.Add(-23 * time.Hour)},
+		// already expired
+		{IPNSHash: "expired", CID: testCID, Key: "key", UserName: "alice", NetworkName: "public",
+			LifeTime: 24 * time.Hour, TTL: time.Hour, Resolve: true, PublishedAt: now.Add(-25 * time.Hour)},
+		// recently published
+		{IPNSHash: "fresh", CID: testCID, Key: "key", UserName: "alice", NetworkName: "public",
+			LifeTime: 24 * time.Hour, TTL: time.Hour, Resolve: true, PublishedAt: now},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	opts := queue.RepublishOpts{Interval: 10 * time.Millisecond, LeadTime: 6 * time.Hour}
+	if err := qm.RepublishIPNS(ctx, store, opts); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	// the expiring record is only republished once, however many times it's seen,
+	// while the expired record isn't
+	if n := broker.Len(queue.IpnsUpdateQueue); n != 1 {
+		t.Fatalf("expected 1 ipns update, got %v", n)
+	}
+	d, _ := broker.Get(queue.IpnsUpdateQueue)
+	update, err := queue.DecodeDelivery[queue.IPNSUpdate](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := queue.IPNSUpdate{
+		CID:         testCID,
+		IPNSHash:    "expiring",
+		LifeTime:    queue.Duration(24 * time.Hour),
+		TTL:         queue.Duration(time.Hour),
+		Key:         "key",
+		Resolve:     false,
+		UserName:    "alice",
+		NetworkName: "public",
+	}
+	if update != want {
+		t.Fatalf("unexpected update %+v", update)
+	}
+}
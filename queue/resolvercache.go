@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/RTradeLtd/Temporal/tns"
+	"github.com/streadway/amqp"
+)
+
+// InvalidateResolverCache is used to wrap the handler of the zone creation, record
+// creation or record deletion queue so that once a message is processed, the names
+// cache had resolved through the record or zone are invalidated, including names
+// cached as not existing. Names are invalidated whether or not handler succeeds, as
+// a failed change may have been partly made. Only this process's cache is
+// invalidated, so caches within other processes never see the change, and need
+// consumers of their own.
+func (qm *Manager) InvalidateResolverCache(handler Handler, cache *tns.ResolverCache) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		err := handler(ctx, d)
+		qm.invalidateResolverCache(d, cache)
+		return err
+	}
+}
+
+// InvalidateResolverCacheOnSettle is used to invalidate cache as
+// InvalidateResolverCache does for consumers reading deliveries from msgs
+// themselves, such as ProcessTNSRecordCreation, with names being invalidated once
+// each delivery is acked, nacked or rejected.
+func (qm *Manager) InvalidateResolverCacheOnSettle(msgs <-chan amqp.Delivery, cache *tns.ResolverCache) <-chan amqp.Delivery {
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+		for d := range msgs {
+			d := d
+			d.Acknowledger = &invalidatingAcknowledger{
+				Acknowledger: d.Acknowledger,
+				invalidate:   func() { qm.invalidateResolverCache(d, cache) },
+			}
+			out <- d
+		}
+	}()
+	return out
+}
+
+// invalidateResolverCache is used to invalidate the names of cache changed by d
+func (qm *Manager) invalidateResolverCache(d amqp.Delivery, cache *tns.ResolverCache) {
+	var msg struct {
+		Name       string `json:"name"`
+		ZoneName   string `json:"zone_name"`
+		RecordName string `json:"record_name"`
+	}
+	if peek(d, &msg) != nil {
+		return
+	}
+	switch {
+	case qm.QueueName == ZoneCreationQueue && msg.Name != "":
+		cache.InvalidateZone(msg.Name)
+	case msg.ZoneName != "" && msg.RecordName != "":
+		cache.Invalidate(msg.RecordName + "." + msg.ZoneName)
+	}
+}
+
+// invalidatingAcknowledger is an amqp.Acknowledger calling invalidate once its
+// delivery is settled
+type invalidatingAcknowledger struct {
+	amqp.Acknowledger
+	invalidate func()
+}
+
+func (a *invalidatingAcknowledger) Ack(tag uint64, multiple bool) error {
+	defer a.invalidate()
+	return a.Acknowledger.Ack(tag, multiple)
+}
+
+func (a *invalidatingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	defer a.invalidate()
+	return a.Acknowledger.Nack(tag, multiple, requeue)
+}
+
+func (a *invalidatingAcknowledger) Reject(tag uint64, requeue bool) error {
+	defer a.invalidate()
+	return a.Acknowledger.Reject(tag, requeue)
+}
@@ -0,0 +1,126 @@
+package queue_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/tns"
+	"github.com/streadway/amqp"
+)
+
+// tnsRecords is an in memory tns.RecordFinder, keyed by zone then by record name, or by
+// tns.RecordKey where a name holds records of several types
+type tnsRecords map[string]map[string]*tns.Record
+
+func (r tnsRecords) FindRecord(zoneName, recordName, recordType string) (*tns.Record, error) {
+	zone, ok := r[zoneName]
+	if !ok {
+		return nil, tns.ErrZoneNotFound
+	}
+	if record, ok := zone[tns.RecordKey(recordName, recordType)]; ok {
+		return record, nil
+	}
+	if record, ok := zone[recordName]; ok && record.Type == recordType {
+		return record, nil
+	}
+	return nil, tns.ErrRecordNotFound
+}
+
+func TestInvalidateResolverCache(t *testing.T) {
+	records := tnsRecords{"example.org": {
+		"www": {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testCID},
+	}}
+	resolver := tns.NewResolver(records, nil)
+	resolver.Cache = tns.NewResolverCache(0, time.Hour)
+	if _, err := resolver.Resolve("www.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.RecordDeletionQueue))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, queue.RecordDeletion{ZoneName: "example.org", RecordName: "www", UserName: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	handler := qm.InvalidateResolverCache(func(ctx context.Context, d amqp.Delivery) error {
+		delete(records["example.org"], "www")
+		return nil
+	}, resolver.Cache)
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		defer cancel()
+		return handler(ctx, d)
+	})
+	if _, err := resolver.Resolve("www.example.org"); err == nil {
+		t.Fatal("expected the deleted record to no longer resolve")
+	}
+}
+
+func TestInvalidateResolverCacheOnSettle(t *testing.T) {
+	records := tnsRecords{"example.org": {
+		"www": {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testCID},
+	}}
+	resolver := tns.NewResolver(records, nil)
+	resolver.Cache = tns.NewResolverCache(0, time.Hour)
+	if _, err := resolver.Resolve("www.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.RecordDeletionQueue))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, queue.RecordDeletion{ZoneName: "example.org", RecordName: "www", UserName: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := broker.Consume(queue.RecordDeletionQueue, "test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// consumers such as ProcessTNSRecordDeletion read deliveries themselves
+	d := <-qm.InvalidateResolverCacheOnSettle(msgs, resolver.Cache)
+	delete(records["example.org"], "www")
+	if resolver.Cache.Len() != 1 {
+		t.Fatal("expected the name to stay cached until the delivery is settled")
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolver.Resolve("www.example.org"); err == nil {
+		t.Fatal("expected the deleted record to no longer resolve")
+	}
+}
+
+func TestInvalidateResolverCache_ZoneCreation(t *testing.T) {
+	records := tnsRecords{}
+	resolver := tns.NewResolver(records, nil)
+	resolver.Cache = tns.NewResolverCache(0, time.Hour)
+	resolver.Cache.EnableNegativeCaching(0, time.Hour)
+	if _, err := resolver.Resolve("www.example.org"); err == nil {
+		t.Fatal("expected the name not to exist")
+	}
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.ZoneCreationQueue))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	zone := queue.ZoneCreation{Name: "example.org", ManagerKeyName: "manager", ZoneKeyName: "zone", UserName: "user"}
+	if err := qm.PublishMessageContext(ctx, zone); err != nil {
+		t.Fatal(err)
+	}
+	handler := qm.InvalidateResolverCache(func(ctx context.Context, d amqp.Delivery) error {
+		records["example.org"] = map[string]*tns.Record{
+			"www": {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testCID},
+		}
+		return nil
+	}, resolver.Cache)
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		defer cancel()
+		return handler(ctx, d)
+	})
+	if cid, err := resolver.Resolve("www.example.org"); err != nil || cid != testCID {
+		t.Fatalf("expected the name to resolve once its zone was created, got %s, %v", cid, err)
+	}
+}
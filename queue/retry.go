@@ -0,0 +1,177 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// HeaderAttempt is the header used to record how many times a message has been
+// attempted, and is absent on a message's first attempt
+const HeaderAttempt = "x-attempt"
+
+// RetryOpts is used to control how failed messages are retried
+type RetryOpts struct {
+	// MaxAttempts is the number of times a message is processed before giving up,
+	// including the first attempt
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, which doubles with every
+	// subsequent retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries
+	MaxDelay time.Duration
+	// Jitter randomizes each delay by up to this fraction of it, between 0 and 1,
+	// so that messages which failed together aren't all retried together
+	Jitter float64
+	// OnExhausted is optionally called with the final error once a message has
+	// failed MaxAttempts times
+	OnExhausted func(ctx context.Context, d amqp.Delivery, err error)
+}
+
+// Retry is used to wrap handler so that messages it fails to process are retried.
+// A failed message is requeued with its attempt count recorded in its headers,
+// after waiting for an exponentially increasing delay, and the original message
+// is acknowledged. Note that the delay is spent within the handler, so occupies
+// one of the consumer's workers. Once a message has been attempted MaxAttempts
+// times its error is returned, so that it is dead lettered if the queue has dead
+// lettering enabled, after calling OnExhausted. Messages the handler drops with
+// ErrDrop aren't retried.
+func (qm *Manager) Retry(handler Handler, opts RetryOpts) Handler {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = time.Second
+	}
+	if opts.MaxDelay < opts.BaseDelay {
+		opts.MaxDelay = opts.BaseDelay
+	}
+	return func(ctx context.Context, d amqp.Delivery) error {
+		err := handler(ctx, d)
+		// dropped messages would fail however often they're retried
+		if err == nil || errors.Is(err, ErrDrop) {
+			return err
+		}
+		attempt := Attempt(d)
+		if attempt >= opts.MaxAttempts {
+			if opts.OnExhausted != nil {
+				opts.OnExhausted(ctx, d, err)
+			}
+			// the handler's error isn't wrapped, as once exhausted the message
+			// mustn't be requeued should the handler have asked for it to be
+			return fmt.Errorf("giving up after %v attempts: %s", attempt, err)
+		}
+		qm.LogEntry(ctx).WithField("attempt", attempt).Info("retrying failed message")
+		timer := time.NewTimer(opts.delay(attempt))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			// we're shutting down, so leave the message to be redelivered
+			return ctx.Err()
+		}
+		if rqErr := qm.requeue(ctx, d, attempt+1); rqErr != nil {
+			return fmt.Errorf("%s: failed to requeue message: %w", err, rqErr)
+		}
+		return nil
+	}
+}
+
+// Attempt is used to get the attempt number of a delivery, starting at 1
+func Attempt(d amqp.Delivery) int {
+	switch n := d.Headers[HeaderAttempt].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 1
+}
+
+// delay is used to get the delay before retrying a message which has failed
+// the given number of attempts
+func (o RetryOpts) delay(attempt int) time.Duration {
+	delay := o.BaseDelay
+	for i := 1; i < attempt && delay < o.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.MaxDelay {
+		delay = o.MaxDelay
+	}
+	if o.Jitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * o.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// requeue is used to publish a copy of a delivery back to our queue as the given attempt
+func (qm *Manager) requeue(ctx context.Context, d amqp.Delivery, attempt int) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderAttempt] = int32(attempt)
+	return qm.send(ctx, qm.channel(), "", qm.QueueName, amqp.Publishing{
+		Headers:         headers,
+		DeliveryMode:    amqp.Persistent,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		Type:            d.Type,
+		Priority:        d.Priority,
+		Timestamp:       d.Timestamp,
+		Body:            d.Body,
+	})
+}
+
+// NotifyPinFailure is used as a RetryOpts.OnExhausted hook for the ipfs pin and
+// ipfs cluster pin queues, emailing the user once their content could not be pinned
+// and refunding the pin's credits
+func (qm *Manager) NotifyPinFailure(ctx context.Context, d amqp.Delivery, err error) {
+	qm.RefundCredits(ctx, d, err)
+	var pin IPFSPin
+	if jsonErr := peek(d, &pin); jsonErr != nil {
+		qm.logError(ctx, jsonErr, "failed to unmarshal pin")
+		return
+	}
+	email := NewPinFailedEmail(pin.CID, pin.NetworkName, err.Error(), []string{pin.UserName})
+	if pubErr := qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); pubErr != nil {
+		qm.logError(ctx, pubErr, "failed to publish pin failure email")
+	}
+}
+
+// NotifyFileFailure is used as a RetryOpts.OnExhausted hook for the ipfs file queue,
+// emailing the user once their file could not be added and refunding its credits
+func (qm *Manager) NotifyFileFailure(ctx context.Context, d amqp.Delivery, err error) {
+	qm.RefundCredits(ctx, d, err)
+	var file IPFSFile
+	if jsonErr := UnmarshalDelivery(d, &file); jsonErr != nil {
+		qm.logError(ctx, jsonErr, "failed to unmarshal file")
+		return
+	}
+	email := NewFileFailedEmail(file.ObjectName, file.NetworkName, err.Error(), []string{file.UserName})
+	if pubErr := qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); pubErr != nil {
+		qm.logError(ctx, pubErr, "failed to publish file failure email")
+	}
+}
+
+// NotifyIPNSEntryFailure is used as a RetryOpts.OnExhausted hook for the ipns entry
+// queue, emailing the user once their ipns entry could not be created and refunding
+// its credits
+func (qm *Manager) NotifyIPNSEntryFailure(ctx context.Context, d amqp.Delivery, err error) {
+	qm.RefundCredits(ctx, d, err)
+	var entry IPNSEntry
+	if jsonErr := peek(d, &entry); jsonErr != nil {
+		qm.logError(ctx, jsonErr, "failed to unmarshal ipns entry")
+		return
+	}
+	email := NewIPNSEntryFailedEmail(entry.CID, entry.Key, err.Error(), []string{entry.UserName})
+	if pubErr := qm.publish(ctx, qm.channel(), "", EmailSendQueue, email); pubErr != nil {
+		qm.logError(ctx, pubErr, "failed to publish ipns entry failure email")
+	}
+}
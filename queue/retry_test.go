@@ -0,0 +1,70 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestAttempt(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{"NoHeaders", nil, 1},
+		{"NoAttempt", amqp.Table{"other": "value"}, 1},
+		{"Int32", amqp.Table{queue.HeaderAttempt: int32(3)}, 3},
+		{"Int64", amqp.Table{queue.HeaderAttempt: int64(4)}, 4},
+		{"WrongType", amqp.Table{queue.HeaderAttempt: "5"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := queue.Attempt(amqp.Delivery{Headers: tt.headers}); got != tt.want {
+				t.Fatalf("Attempt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// retried protobuf messages keep their type, so they can still be decoded
+func TestRetry_KeepsType(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithCodec(queue.ProtobufCodec{}))
+	pin := testPin("user")
+	if err := qm.PublishMessageContext(context.Background(), pin, queue.WithPriority(3)); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := broker.Get(queue.IpfsPinQueue)
+	if !ok {
+		t.Fatal("expected the message to be published")
+	}
+	if d.ContentType != queue.ContentTypeProtobuf || d.Type == "" {
+		t.Fatalf("expected a typed protobuf message, got %q of type %q", d.ContentType, d.Type)
+	}
+	handler := qm.Retry(func(ctx context.Context, d amqp.Delivery) error {
+		return errors.New("pin failed")
+	}, queue.RetryOpts{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	if err := handler(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	retried, ok := broker.Get(queue.IpfsPinQueue)
+	if !ok {
+		t.Fatal("expected the message to be requeued")
+	}
+	if retried.Type != d.Type || retried.Priority != d.Priority {
+		t.Fatalf("got type %q and priority %v, want %q and %v", retried.Type, retried.Priority, d.Type, d.Priority)
+	}
+	got, err := queue.DecodeDelivery[queue.IPFSPin](retried)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != pin {
+		t.Fatalf("got %+v, want %+v", got, pin)
+	}
+}
@@ -0,0 +1,178 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+// DefaultRPCTimeout is how long requests wait for a reply when their context has
+// no deadline of its own
+var DefaultRPCTimeout = 30 * time.Second
+
+// ErrRPCTimeout is returned when no reply arrives before a request times out
+var ErrRPCTimeout = errors.New("timed out waiting for reply")
+
+// PinStatusFunc is used by PinStatusHandler to look up the status of a pin across
+// the cluster
+type PinStatusFunc func(ctx context.Context, req PinStatusRequest) (PinStatusResponse, error)
+
+// RequestPinStatus is used to ask the cluster consumer for the replication status
+// of a pin, waiting until ctx is done or DefaultRPCTimeout elapses for its reply
+func (qm *Manager) RequestPinStatus(ctx context.Context, req PinStatusRequest) (PinStatusResponse, error) {
+	var resp PinStatusResponse
+	if err := qm.CallContext(ctx, ClusterPinStatusQueue, req, &resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+// PinStatusHandler is used by the cluster consumer to answer pin status requests
+// consumed from ClusterPinStatusQueue using fn. Failures to look up the status are
+// returned to the requester, so the request is acknowledged regardless.
+func (qm *Manager) PinStatusHandler(fn PinStatusFunc) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		req, err := DecodeDelivery[PinStatusRequest](d)
+		var resp PinStatusResponse
+		if err == nil {
+			resp, err = fn(ctx, req)
+		}
+		if err != nil {
+			resp = PinStatusResponse{CID: req.CID, Error: err.Error()}
+		}
+		return qm.Reply(ctx, d, resp)
+	}
+}
+
+// Call is used to make a request of the consumer of queueName, decoding its reply
+// into resp, waiting up to timeout, which defaults to DefaultRPCTimeout when 0 or
+// less. It is equivalent to CallContext with a context expiring after timeout.
+func (qm *Manager) Call(queueName string, req, resp interface{}, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultRPCTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return qm.CallContext(ctx, queueName, req, resp)
+}
+
+// CallContext is used to make a request of the consumer of queueName, decoding its
+// reply into resp, for request and reply exchanges such as looking up the status of
+// a pin. The reply is sent to a reply queue declared exclusive to the call, which is
+// deleted once the call returns, and is matched to the request by its correlation
+// id, with replies to other requests being discarded. Replies are decoded according
+// to their content type. ErrRPCTimeout is returned if no reply arrives before ctx's
+// deadline, which defaults to DefaultRPCTimeout when ctx has none, and ctx.Err() if
+// ctx is cancelled first. Consumers send replies with Reply.
+func (qm *Manager) CallContext(ctx context.Context, queueName string, req, resp interface{}) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRPCTimeout)
+		defer cancel()
+	}
+	broker, ch, err := qm.rpcBroker()
+	if err != nil {
+		return err
+	}
+	if ch != nil {
+		// closing the channel also cancels our consumer, deleting the reply queue
+		defer ch.Close()
+	}
+	replyQueue := "rpc-reply-" + uuid.New().String()
+	err = broker.DeclareQueue(replyQueue, QueueOptions{Transient: true, AutoDelete: true, Exclusive: true})
+	if err != nil {
+		return connectionError(err)
+	}
+	replies, err := broker.Consume(replyQueue, replyQueue, 1)
+	if err != nil {
+		return connectionError(err)
+	}
+	defer func() {
+		if c, ok := broker.(canceller); ok {
+			if err := c.Cancel(replyQueue); err != nil && !errors.Is(err, amqp.ErrClosed) {
+				qm.logError(ctx, err, "failed to cancel reply consumer")
+			}
+		}
+	}()
+	msg, err := qm.prepare(ctx, req)
+	if err != nil {
+		return err
+	}
+	id := uuid.New().String()
+	msg.ReplyTo = replyQueue
+	msg.CorrelationId = id
+	// nobody is waiting for the reply once we time out, so neither should the request
+	msg.DeliveryMode = amqp.Transient
+	deadline, _ := ctx.Deadline()
+	msg.Expiration = formatExpiration(time.Until(deadline))
+	if err = qm.send(ctx, ch, "", queueName, msg); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return ErrRPCTimeout
+			}
+			return ctx.Err()
+		case d, ok := <-replies:
+			if !ok {
+				return ErrNotConnected
+			}
+			if err = d.Ack(false); err != nil {
+				return connectionError(err)
+			}
+			if d.CorrelationId != id {
+				qm.LogEntry(ctx).WithField("reply_correlation_id", d.CorrelationId).Warn("discarding reply to another request")
+				continue
+			}
+			if err = Decompress(&d); err != nil {
+				return err
+			}
+			if err = qm.verify(d); err != nil {
+				return err
+			}
+			return UnmarshalDelivery(d, resp)
+		}
+	}
+}
+
+// rpcBroker is used to get the broker CallContext makes requests through, which with
+// rabbitmq is a channel of its own, so that the reply consumer's prefetch doesn't
+// affect our other consumers and is cancelled by closing the channel
+func (qm *Manager) rpcBroker() (Broker, *amqp.Channel, error) {
+	if qm.Broker != nil {
+		return qm.Broker, nil, nil
+	}
+	conn := qm.connection()
+	if conn == nil {
+		return nil, nil, ErrNotConnected
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, connectionError(err)
+	}
+	return ChannelBroker{Channel: ch}, ch, nil
+}
+
+// Reply is used by consumers to reply to a request made with Call, doing nothing if
+// the requester didn't ask for one. Replies bypass send, as labelling metrics by
+// their routing key would create a series per request.
+func (qm *Manager) Reply(ctx context.Context, d amqp.Delivery, body interface{}) error {
+	if d.ReplyTo == "" {
+		return nil
+	}
+	msg, err := qm.prepare(ctx, body)
+	if err != nil {
+		return err
+	}
+	msg.CorrelationId = d.CorrelationId
+	msg.DeliveryMode = amqp.Transient
+	return qm.sendMessage(ctx, qm.channel(), "", d.ReplyTo, msg)
+}
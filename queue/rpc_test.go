@@ -0,0 +1,150 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// newPinStatusManager is used to connect to the broker given by RABBITMQ_URL using
+// the pin status queue, skipping the test when it isn't set
+func newPinStatusManager(t *testing.T) *queue.Manager {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	qm, err := queue.NewManager(url, queue.WithQueue(queue.ClusterPinStatusQueue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = qm.Channel.QueuePurge(qm.QueueName, false); err != nil {
+		t.Fatal(err)
+	}
+	return qm
+}
+
+func TestRequestPinStatus(t *testing.T) {
+	server := newPinStatusManager(t)
+	defer server.Close(context.Background())
+	client := newPinStatusManager(t)
+	defer client.Close(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.ConsumeMessageContext(ctx, "pin-status-test", server.PinStatusHandler(
+		func(ctx context.Context, req queue.PinStatusRequest) (queue.PinStatusResponse, error) {
+			if req.CID != testCID {
+				return queue.PinStatusResponse{}, errors.New("unknown pin")
+			}
+			return queue.PinStatusResponse{
+				CID: req.CID,
+				Peers: []queue.PeerPinStatus{
+					{PeerID: "peer1", Status: "pinned"},
+					{PeerID: "peer2", Status: "pinning"},
+				},
+			}, nil
+		},
+	))
+
+	reqCtx, reqCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer reqCancel()
+	resp, err := client.RequestPinStatus(reqCtx, queue.PinStatusRequest{CID: testCID, NetworkName: "public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CID != testCID || len(resp.Peers) != 2 || resp.Peers[0].Status != "pinned" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if _, err = client.RequestPinStatus(reqCtx, queue.PinStatusRequest{CID: benchCID, NetworkName: "public"}); err == nil || err.Error() != "unknown pin" {
+		t.Fatalf("expected the server's error, got %v", err)
+	}
+}
+
+func TestRequestPinStatus_Timeout(t *testing.T) {
+	client := newPinStatusManager(t)
+	defer client.Close(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := client.RequestPinStatus(ctx, queue.PinStatusRequest{CID: testCID, NetworkName: "public"}); err != queue.ErrRPCTimeout {
+		t.Fatalf("expected ErrRPCTimeout, got %v", err)
+	}
+}
+
+func TestManager_Call(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	server := newMemoryManager(t, broker, queue.WithQueue(queue.ClusterPinStatusQueue))
+	client := newMemoryManager(t, broker, queue.WithQueue(queue.ClusterPinStatusQueue))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	replyQueues := make(chan string, 1)
+	go server.ConsumeMessageContext(ctx, "call-test", func(ctx context.Context, d amqp.Delivery) error {
+		replyQueues <- d.ReplyTo
+		// a stray reply to another request is discarded
+		stray := d
+		stray.CorrelationId = "another-request"
+		if err := server.Reply(ctx, stray, queue.PinStatusResponse{CID: "QmStray"}); err != nil {
+			return err
+		}
+		return server.Reply(ctx, d, queue.PinStatusResponse{CID: testCID, Peers: []queue.PeerPinStatus{{PeerID: "peer1", Status: "pinned"}}})
+	})
+	var resp queue.PinStatusResponse
+	if err := client.Call(queue.ClusterPinStatusQueue, queue.PinStatusRequest{CID: testCID, NetworkName: "public"}, &resp, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if resp.CID != testCID || len(resp.Peers) != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	// the reply queue is deleted once the call returns
+	replyQueue := <-replyQueues
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := broker.Peek(replyQueue, 1); errors.Is(err, queue.ErrQueueNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the reply queue to be deleted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManager_Call_Timeout(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	client := newMemoryManager(t, broker, queue.WithQueue(queue.ClusterPinStatusQueue))
+	var resp queue.PinStatusResponse
+	err := client.Call(queue.ClusterPinStatusQueue, queue.PinStatusRequest{CID: testCID, NetworkName: "public"}, &resp, 50*time.Millisecond)
+	if !errors.Is(err, queue.ErrRPCTimeout) {
+		t.Fatalf("expected ErrRPCTimeout, got %v", err)
+	}
+}
+
+// compressed requests and replies are decoded through an injected broker
+func TestRequestPinStatus_Broker(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	server := newMemoryManager(t, broker, queue.WithQueue(queue.ClusterPinStatusQueue), queue.WithCompression(1))
+	client := newMemoryManager(t, broker, queue.WithQueue(queue.ClusterPinStatusQueue), queue.WithCompression(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.ConsumeMessageContext(ctx, "pin-status-test", server.PinStatusHandler(
+		func(ctx context.Context, req queue.PinStatusRequest) (queue.PinStatusResponse, error) {
+			return queue.PinStatusResponse{CID: req.CID, Peers: []queue.PeerPinStatus{{PeerID: "peer1", Status: "pinned"}}}, nil
+		},
+	))
+	reqCtx, reqCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer reqCancel()
+	resp, err := client.RequestPinStatus(reqCtx, queue.PinStatusRequest{CID: testCID, NetworkName: "public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CID != testCID || len(resp.Peers) != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+}
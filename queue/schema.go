@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// HeaderSchemaVersion is the header holding the schema version of a message's type
+// it was published with. Messages published before versioning have no header, and
+// are treated as version 1
+const HeaderSchemaVersion = "x-schema-version"
+
+// ErrUnsupportedSchema is returned when consuming a message of a newer schema version
+// than we understand, or an older one we have no migration for
+var ErrUnsupportedSchema = errors.New("unsupported message schema version")
+
+// Migration is used to upgrade the json body of a message by one schema version
+type Migration func(body []byte) ([]byte, error)
+
+var (
+	migrationsMu sync.RWMutex
+	// migrations holds the migrations of each message type by the version they
+	// upgrade from
+	migrations = map[string]map[int]Migration{}
+)
+
+// RegisterMigration is used to register the migration of messages of type T from
+// the given schema version to the next, making that next version the current
+// version of T. Messages of T are stamped with its current version when published,
+// and consumers upgrade older messages before handling them, so that a message's
+// shape can change safely by registering a migration from the old shape to the new.
+// Migrations should be registered at startup, with gaps or duplicates panicking
+func RegisterMigration[T any](from int, fn Migration) {
+	name := reflect.TypeOf((*T)(nil)).Elem().Name()
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+	if from != schemaVersion(name) {
+		panic(fmt.Sprintf("migration of %s from version %v registered out of order", name, from))
+	}
+	if migrations[name] == nil {
+		migrations[name] = make(map[int]Migration)
+	}
+	migrations[name][from] = fn
+}
+
+// SchemaVersion is used to get the current schema version of messages of type T
+func SchemaVersion[T any]() int {
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	return schemaVersion(reflect.TypeOf((*T)(nil)).Elem().Name())
+}
+
+// schemaVersion is used to get the current schema version of the named message
+// type, which is one past its latest migration. migrationsMu must be held
+func schemaVersion(name string) int {
+	return len(migrations[name]) + 1
+}
+
+// stampSchema is used to set the schema version header of a message being published
+func stampSchema(msg *amqp.Publishing) {
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	msg.Headers[HeaderSchemaVersion] = int32(schemaVersion(msg.Type))
+}
+
+// migrate is used to upgrade a consumed message to the current schema version of
+// its type, given by the message or otherwise the queue's message type. The
+// upgraded message is returned, leaving the delivery untouched, and
+// ErrUnsupportedSchema is returned when the message can't be upgraded
+func (qm *Manager) migrate(d amqp.Delivery) (amqp.Delivery, error) {
+	name := d.Type
+	if name == "" {
+		if typ, ok := messageTypes[qm.QueueName]; ok {
+			name = typ.Name()
+		}
+	}
+	version := 1
+	switch v := d.Headers[HeaderSchemaVersion].(type) {
+	case nil:
+	case int32:
+		version = int(v)
+	case int64:
+		version = int(v)
+	case int:
+		version = v
+	default:
+		return d, ErrUnsupportedSchema
+	}
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+	current := schemaVersion(name)
+	if version == current {
+		return d, nil
+	}
+	if version < 1 || version > current {
+		return d, ErrUnsupportedSchema
+	}
+	// migrations operate on json, as other encodings are versioned by their schema
+	if codec, err := codecFor(d.ContentType); err != nil || codec.ContentType() != ContentTypeJSON {
+		return d, ErrUnsupportedSchema
+	}
+	body := d.Body
+	for v := version; v < current; v++ {
+		var err error
+		if body, err = migrations[name][v](body); err != nil {
+			return d, fmt.Errorf("failed to migrate %s from version %v: %w", name, v, err)
+		}
+	}
+	headers := make(amqp.Table, len(d.Headers))
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderSchemaVersion] = int32(current)
+	d.Headers, d.Body = headers, body
+	return d, nil
+}
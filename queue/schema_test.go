@@ -0,0 +1,143 @@
+package queue_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+// SchemaTestMessage was first published with a name, which became a user name in
+// version 2
+type SchemaTestMessage struct {
+	UserName string `json:"user_name"`
+}
+
+func init() {
+	queue.RegisterMigration[SchemaTestMessage](1, func(body []byte) ([]byte, error) {
+		var v1 struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(body, &v1); err != nil {
+			return nil, err
+		}
+		return json.Marshal(SchemaTestMessage{UserName: v1.Name})
+	})
+}
+
+const schemaTestQueue = "schema-test-queue"
+
+// consumeSchemaTest is used to publish msg directly to the broker and consume it,
+// returning the delivery the handler was given, if any
+func consumeSchemaTest(t *testing.T, broker *queue.MemoryBroker, msg amqp.Publishing) (amqp.Delivery, bool) {
+	t.Helper()
+	qm, err := queue.NewManager("", queue.WithQueue(schemaTestQueue), queue.WithBroker(broker), queue.WithDeadLetter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg.Type = "SchemaTestMessage"
+	if err = broker.Publish(ctx, "", schemaTestQueue, msg); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		handled amqp.Delivery
+		ok      bool
+	)
+	go func() {
+		// rejected messages never reach the handler
+		for broker.Len(queue.DeadLetterName(schemaTestQueue)) == 0 && ctx.Err() == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}()
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		defer cancel()
+		handled, ok = d, true
+		return nil
+	})
+	return handled, ok
+}
+
+func TestSchemaVersion(t *testing.T) {
+	if v := queue.SchemaVersion[SchemaTestMessage](); v != 2 {
+		t.Fatalf("expected version 2 once migrated, got %v", v)
+	}
+	if v := queue.SchemaVersion[queue.IPFSPin](); v != 1 {
+		t.Fatalf("expected unmigrated messages to be version 1, got %v", v)
+	}
+}
+
+func TestPublish_StampsSchemaVersion(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	if err := qm.PublishMessageContext(context.Background(), testPin("alice")); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := broker.Get(queue.IpfsPinQueue)
+	if !ok {
+		t.Fatal("expected message to be published")
+	}
+	if v := d.Headers[queue.HeaderSchemaVersion]; v != int32(1) {
+		t.Fatalf("unexpected schema version %v", v)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		body    string
+		handled bool
+	}{
+		{"Unversioned", nil, `{"name":"alice"}`, true},
+		{"Old", amqp.Table{queue.HeaderSchemaVersion: int32(1)}, `{"name":"alice"}`, true},
+		{"Current", amqp.Table{queue.HeaderSchemaVersion: int32(2)}, `{"user_name":"alice"}`, true},
+		{"Future", amqp.Table{queue.HeaderSchemaVersion: int32(3)}, `{"user_name":"alice"}`, false},
+		{"Invalid", amqp.Table{queue.HeaderSchemaVersion: "two"}, `{"user_name":"alice"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := queue.NewMemoryBroker()
+			defer broker.Close()
+			d, handled := consumeSchemaTest(t, broker, amqp.Publishing{
+				Headers:     tt.headers,
+				ContentType: queue.ContentTypeJSON,
+				Body:        []byte(tt.body),
+			})
+			if handled != tt.handled {
+				t.Fatalf("expected handled to be %v", tt.handled)
+			}
+			if !handled {
+				if broker.Len(queue.DeadLetterName(schemaTestQueue)) != 1 {
+					t.Fatal("expected unsupported message to be dead lettered")
+				}
+				return
+			}
+			msg, err := queue.DecodeDelivery[SchemaTestMessage](d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.UserName != "alice" {
+				t.Fatalf("message not migrated, got %s", d.Body)
+			}
+			if v := d.Headers[queue.HeaderSchemaVersion]; v != int32(2) {
+				t.Fatalf("unexpected schema version %v", v)
+			}
+		})
+	}
+}
+
+func TestRegisterMigration_OutOfOrder(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected registering a migration out of order to panic")
+		}
+	}()
+	queue.RegisterMigration[SchemaTestMessage](1, func(body []byte) ([]byte, error) { return body, nil })
+}
@@ -0,0 +1,108 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultRetryLaterDelay is how long messages whose handler returned ErrRetryLater
+// are postponed for before being redelivered, when the manager doesn't set one
+const DefaultRetryLaterDelay = 5 * time.Second
+
+// Handlers control what happens to a message they fail to process by returning one
+// of these errors, or an error wrapping one of them such as those returned by Drop,
+// Requeue and RetryLater, which are mapped to an ack, a nack without requeueing and
+// a nack with requeueing respectively:
+//
+//	ErrDrop        the message is acknowledged and discarded, for permanent failures
+//	               such as malformed messages which would fail however often retried
+//	ErrRequeue     the message is rejected without being requeued, which moves it to
+//	               the dead letter queue, from which it can be replayed once the
+//	               problem is fixed. Queues without dead lettering discard it
+//	ErrRetryLater  the message is returned to the queue to be redelivered, for
+//	               transient failures such as a dependency being briefly down. It is
+//	               postponed by the manager's RetryLaterDelay first, so that messages
+//	               which keep failing aren't redelivered over and over in a hot loop
+//
+// Any other error is treated as before: the message is dead lettered if the queue has
+// dead lettering enabled, and otherwise acknowledged with the failure logged.
+var (
+	ErrDrop       = errors.New("dropping message")
+	ErrRequeue    = errors.New("requeueing message")
+	ErrRetryLater = errors.New("retrying message later")
+)
+
+// Drop is used to wrap a handler's error so that its message is discarded
+func Drop(err error) error {
+	return &kindError{kind: ErrDrop, err: err}
+}
+
+// Requeue is used to wrap a handler's error so that its message is rejected without
+// being requeued, moving it to the dead letter queue
+func Requeue(err error) error {
+	return &kindError{kind: ErrRequeue, err: err}
+}
+
+// RetryLater is used to wrap a handler's error so that its message is redelivered
+// once the manager's RetryLaterDelay has elapsed
+func RetryLater(err error) error {
+	return &kindError{kind: ErrRetryLater, err: err}
+}
+
+// requeues is used to check whether a handler's error results in its message
+// being returned to the queue
+func (qm *Manager) requeues(err error) bool {
+	return errors.Is(err, ErrRetryLater)
+}
+
+// settle is used to acknowledge, requeue or dead letter a message according to the
+// error returned by its handler, if any
+func (qm *Manager) settle(ctx context.Context, d amqp.Delivery, err error) error {
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		// whatever the handler was doing is unlikely to succeed if redelivered
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+		return qm.reject(d, err)
+	case err == nil || errors.Is(err, ErrDrop):
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, true)
+		return d.Ack(false)
+	case qm.requeues(err):
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+		return qm.retryLater(ctx, d)
+	case errors.Is(err, ErrRequeue):
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+		return qm.reject(d, err)
+	case qm.Options.DeadLetter:
+		qm.Metrics.observeSettled(qm.QueueName, qm.Service, false)
+		return qm.deadLetter(d, err)
+	}
+	qm.Metrics.observeSettled(qm.QueueName, qm.Service, true)
+	return d.Ack(false)
+}
+
+// retryLater is used to return a message to the queue once RetryLaterDelay has
+// elapsed, by publishing a copy in its place. Should we be shutting down, or fail to
+// publish the copy, the message is requeued straight away instead
+func (qm *Manager) retryLater(ctx context.Context, d amqp.Delivery) error {
+	ctx, cancel := qm.withStop(ctx)
+	defer cancel()
+	if err := qm.postpone(ctx, d, qm.retryLaterDelay()); err != nil {
+		if ctx.Err() == nil {
+			qm.logError(ctx, err, "failed to postpone message")
+		}
+		return d.Nack(false, true)
+	}
+	return d.Ack(false)
+}
+
+// retryLaterDelay is used to get how long messages are postponed for by RetryLater
+func (qm *Manager) retryLaterDelay() time.Duration {
+	if qm.RetryLaterDelay > 0 {
+		return qm.RetryLaterDelay
+	}
+	return DefaultRetryLaterDelay
+}
@@ -0,0 +1,193 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestSettle(t *testing.T) {
+	failure := errors.New("pin timed out")
+	var tests = []struct {
+		name       string
+		deadLetter bool
+		err        error
+		requeued   bool
+		dead       bool
+	}{
+		{"Success", true, nil, false, false},
+		{"Drop", true, queue.Drop(failure), false, false},
+		{"DropSentinel", true, queue.ErrDrop, false, false},
+		{"Requeue", true, queue.Requeue(failure), false, true},
+		{"RequeueWithoutDeadLetter", false, queue.Requeue(failure), false, false},
+		{"RetryLater", true, queue.RetryLater(failure), true, false},
+		{"RetryLaterWithoutDeadLetter", false, queue.RetryLater(failure), true, false},
+		{"Other", true, failure, false, true},
+		{"OtherWithoutDeadLetter", false, failure, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := queue.NewMemoryBroker()
+			defer broker.Close()
+			var opts []queue.Option
+			if tt.deadLetter {
+				opts = append(opts, queue.WithDeadLetter())
+			}
+			qm := newMemoryManager(t, broker, opts...)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := qm.PublishMessageContext(ctx, testPin("alice")); err != nil {
+				t.Fatal(err)
+			}
+			qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+				defer cancel()
+				return tt.err
+			})
+			// a requeued message may already have been taken for redelivery
+			if requeued := broker.Len(queue.IpfsPinQueue)+broker.Unacked() == 1; requeued != tt.requeued {
+				t.Fatalf("expected requeued to be %v", tt.requeued)
+			}
+			if tt.deadLetter {
+				if dead := broker.Len(queue.DeadLetterName(queue.IpfsPinQueue)) == 1; dead != tt.dead {
+					t.Fatalf("expected dead lettered to be %v", tt.dead)
+				}
+			}
+		})
+	}
+}
+
+// messages to be retried later are postponed rather than redelivered straight away
+func TestSettle_RetryLaterDelay(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	delay := 100 * time.Millisecond
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter(), queue.WithRetryLaterDelay(delay))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, testPin("alice")); err != nil {
+		t.Fatal(err)
+	}
+	var attempts []time.Time
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 1 {
+			return queue.RetryLater(errors.New("pin timed out"))
+		}
+		cancel()
+		return nil
+	})
+	if len(attempts) != 2 {
+		t.Fatalf("expected the message to be redelivered once, got %v attempts", len(attempts))
+	}
+	if wait := attempts[1].Sub(attempts[0]); wait < delay {
+		t.Fatalf("expected the message to be postponed for %v, redelivered after %v", delay, wait)
+	}
+	if broker.Len(queue.DeadLetterName(queue.IpfsPinQueue)) != 0 {
+		t.Fatal("expected the message not to be dead lettered")
+	}
+}
+
+func TestSettle_WrappedError(t *testing.T) {
+	failure := errors.New("pin timed out")
+	err := queue.RetryLater(failure)
+	if err.Error() != failure.Error() {
+		t.Fatalf("unexpected error message %q", err)
+	}
+	if !errors.Is(err, failure) || !errors.Is(err, queue.ErrRetryLater) || errors.Is(err, queue.ErrRequeue) {
+		t.Fatal("unexpected error chain")
+	}
+}
+
+// a panicking handler has its message dead lettered and the admin notified, while
+// the consumer carries on with the next message
+func TestSettle_Panic(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	if err := broker.DeclareQueue(queue.EmailSendQueue, queue.QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, user := range []string{"alice", "bob"} {
+		if err := qm.PublishMessageContext(ctx, testPin(user)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var handled []string
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		pin, err := queue.DecodeDelivery[queue.IPFSPin](d)
+		if err != nil {
+			return err
+		}
+		if pin.UserName == "alice" {
+			panic("boom")
+		}
+		handled = append(handled, pin.UserName)
+		cancel()
+		return nil
+	})
+	if len(handled) != 1 || handled[0] != "bob" {
+		t.Fatalf("expected the consumer to continue after the panic, handled %v", handled)
+	}
+	if broker.Len(queue.DeadLetterName(queue.IpfsPinQueue)) != 1 {
+		t.Fatal("expected the panicking message to be dead lettered")
+	}
+	d, ok := broker.Get(queue.EmailSendQueue)
+	if !ok {
+		t.Fatal("expected the admin to be notified of the panic")
+	}
+	email, err := queue.DecodeDelivery[queue.EmailSend](d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.Subject != queue.HandlerPanicSubject || len(email.Emails) != 1 || email.Emails[0] != queue.AdminEmail {
+		t.Fatalf("unexpected email %+v", email)
+	}
+	if !strings.Contains(email.Content, "boom") {
+		t.Fatalf("expected the email to include the panic, got %s", email.Content)
+	}
+}
+
+func TestSettle_UnsupportedContentType(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	body := []byte(`{"cid":"` + testCID + `","network_name":"public","user_name":"alice","hold_time_in_months":1}`)
+	for _, contentType := range []string{"application/xml", "application/json; charset=utf-8", ""} {
+		if err := broker.Publish(ctx, "", queue.IpfsPinQueue, amqp.Publishing{ContentType: contentType, Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var handled []string
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		handled = append(handled, d.ContentType)
+		if _, err := queue.DecodeDelivery[queue.IPFSPin](d); err != nil {
+			t.Error(err)
+		}
+		if len(handled) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if len(handled) != 2 || handled[0] != "application/json; charset=utf-8" || handled[1] != "" {
+		t.Fatalf("unexpected messages handled %v", handled)
+	}
+	d, ok := broker.Get(queue.DeadLetterName(queue.IpfsPinQueue))
+	if !ok {
+		t.Fatal("expected the unsupported message to be dead lettered")
+	}
+	if reason, _ := d.Headers[queue.HeaderFailureReason].(string); !strings.Contains(reason, "unsupported content type") {
+		t.Fatalf("unexpected failure reason %q", reason)
+	}
+	if _, err := queue.DecodeDelivery[queue.IPFSPin](d); !errors.Is(err, queue.ErrUnsupportedContentType) {
+		t.Fatalf("expected ErrUnsupportedContentType, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package queue
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/streadway/amqp"
+)
+
+// HeaderSignature is the header holding the hmac-sha256 signature of a message body
+const HeaderSignature = "x-signature"
+
+var (
+	// ErrUnsigned is returned when a message without a signature is received
+	ErrUnsigned = errors.New("message is not signed")
+	// ErrBadSignature is returned when a message's signature doesn't match its body
+	ErrBadSignature = errors.New("message signature is invalid")
+)
+
+// sign is used to compute the signature of a message body
+func (qm *Manager) sign(body []byte) string {
+	mac := hmac.New(sha256.New, qm.SigningSecret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signingHeaders is used to generate the headers for a signed message, and
+// returns nil when signing is disabled
+func (qm *Manager) signingHeaders(body []byte) amqp.Table {
+	if len(qm.SigningSecret) == 0 {
+		return nil
+	}
+	return amqp.Table{HeaderSignature: qm.sign(body)}
+}
+
+// verify is used to check that a delivery was signed with our secret. When
+// signing is disabled, every delivery is accepted.
+func (qm *Manager) verify(d amqp.Delivery) error {
+	if len(qm.SigningSecret) == 0 {
+		return nil
+	}
+	signature, ok := d.Headers[HeaderSignature].(string)
+	if !ok || signature == "" {
+		return ErrUnsigned
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+	mac := hmac.New(sha256.New, qm.SigningSecret)
+	mac.Write(d.Body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// reject is used to refuse a message without returning it to the queue, moving
+// it to the dead letter queue if the queue has one
+func (qm *Manager) reject(d amqp.Delivery, reason error) error {
+	if qm.Options.DeadLetter {
+		return qm.deadLetter(d, reason)
+	}
+	return d.Nack(false, false)
+}
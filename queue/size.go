@@ -0,0 +1,76 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultMaxMessageSize is the largest body, in bytes, managers publish when no
+// limit is configured. Bodies are split across frames of the broker's frame_max, so
+// the limit which matters is the broker's max_message_size, whose default we stay
+// within, while leaving room for emails carrying the largest attachments allowed
+const DefaultMaxMessageSize = 16 << 20
+
+// ErrMessageTooLarge is returned by the publishing methods for messages whose body
+// exceeds the manager's MaxMessageSize, rather than having the broker refuse them
+var ErrMessageTooLarge = errors.New("message too large")
+
+// checkSize is used to check that a prepared message's body is within our limit,
+// naming the field of the message contributing most to its size otherwise
+func (qm *Manager) checkSize(body interface{}, msg amqp.Publishing) error {
+	limit := qm.MaxMessageSize
+	if limit <= 0 {
+		limit = DefaultMaxMessageSize
+	}
+	if len(msg.Body) <= limit {
+		return nil
+	}
+	var hint string
+	if field := largestField(body); field != "" {
+		hint = fmt.Sprintf(", most of which is field %s", field)
+	}
+	advice := "compress it with WithCompression, or split it into smaller messages published with PublishBatch"
+	if msg.ContentEncoding == EncodingGzip {
+		advice = "split it into smaller messages published with PublishBatch"
+	}
+	return validationError(fmt.Errorf(
+		"%w: %v byte body exceeds the limit of %v bytes%s; %s",
+		ErrMessageTooLarge, len(msg.Body), limit, hint, advice,
+	))
+}
+
+// largestField is used to get the json name of the field of a message whose encoded
+// value is largest, returning an empty string for messages which aren't structs
+func largestField(body interface{}) string {
+	v := reflect.ValueOf(deref(body))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	var (
+		largest string
+		size    int
+	)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		encoded, err := json.Marshal(v.Field(i).Interface())
+		if err == nil && len(encoded) > size {
+			largest, size = name, len(encoded)
+		}
+	}
+	return largest
+}
@@ -0,0 +1,81 @@
+package queue_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestManager_MaxMessageSize(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.EmailSendQueue), queue.WithMaxMessageSize(1<<10))
+	email := queue.EmailSend{
+		Subject:     "large",
+		Content:     strings.Repeat("a", 2<<10),
+		ContentType: "text/plain",
+		UserNames:   []string{"alice"},
+	}
+	err := qm.PublishMessageContext(context.Background(), email)
+	if !errors.Is(err, queue.ErrMessageTooLarge) || !errors.Is(err, queue.ErrValidation) {
+		t.Fatalf("expected a message too large validation error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "field content") || !strings.Contains(err.Error(), "WithCompression") {
+		t.Fatalf("expected the error to name the field and suggest compression, got %v", err)
+	}
+	if n := broker.Len(queue.EmailSendQueue); n != 0 {
+		t.Fatalf("expected nothing to be published, got %v messages", n)
+	}
+	email.Content = "small"
+	if err = qm.PublishMessageContext(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.Len(queue.EmailSendQueue); n != 1 {
+		t.Fatalf("expected 1 message to be published, got %v", n)
+	}
+}
+
+func TestManager_MaxMessageSize_Compressed(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker,
+		queue.WithQueue(queue.EmailSendQueue),
+		queue.WithMaxMessageSize(1<<10),
+		queue.WithCompression(512),
+	)
+	// compressible content fits once compressed
+	email := queue.EmailSend{
+		Subject:     "large",
+		Content:     strings.Repeat("a", 2<<10),
+		ContentType: "text/plain",
+		UserNames:   []string{"alice"},
+	}
+	if err := qm.PublishMessageContext(context.Background(), email); err != nil {
+		t.Fatal(err)
+	}
+	// while random content doesn't, leaving batching as the suggestion
+	random := make([]byte, 2<<10)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	email.Content = hex.EncodeToString(random)
+	err := qm.PublishMessageContext(context.Background(), email)
+	if !errors.Is(err, queue.ErrMessageTooLarge) {
+		t.Fatalf("expected ErrMessageTooLarge, got %v", err)
+	}
+	if strings.Contains(err.Error(), "WithCompression") || !strings.Contains(err.Error(), "PublishBatch") {
+		t.Fatalf("expected the error to suggest batching, got %v", err)
+	}
+}
+
+func TestNewManager_NegativeMaxMessageSize(t *testing.T) {
+	_, err := queue.NewManager("", queue.WithBroker(queue.NewMemoryBroker()), queue.WithMaxMessageSize(-1))
+	if err == nil {
+		t.Fatal("expected a negative max message size to be refused")
+	}
+}
@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"errors"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrQueueNotFound is returned when inspecting a queue which doesn't exist
+var ErrQueueNotFound = errors.New("queue does not exist")
+
+// QueueStats reports the state of a queue
+type QueueStats struct {
+	Name string
+	// Messages is the number of messages ready for delivery, which excludes
+	// messages delivered to consumers which are awaiting acknowledgement
+	Messages int
+	// Consumers is the number of consumers of the queue
+	Consumers int
+}
+
+// statser is implemented by brokers able to report the state of a queue
+type statser interface {
+	QueueStats(name string) (QueueStats, error)
+}
+
+// QueueStats is used to get the message and consumer counts of the named queue,
+// for use in alerting and autoscaling. ErrQueueNotFound is returned if the queue
+// doesn't exist, while other errors indicate a problem with the connection.
+func (qm *Manager) QueueStats(name string) (QueueStats, error) {
+	if qm.Broker != nil {
+		s, ok := qm.Broker.(statser)
+		if !ok {
+			return QueueStats{}, errors.New("broker does not support queue stats")
+		}
+		return s.QueueStats(name)
+	}
+	// the broker closes the channel when inspecting a queue which doesn't
+	// exist, so we use a throwaway channel rather than the manager's
+	ch, err := qm.connection().Channel()
+	if err != nil {
+		return QueueStats{}, err
+	}
+	q, err := ch.QueueInspect(name)
+	if amqpErr, ok := err.(*amqp.Error); ok && amqpErr.Code == amqp.NotFound {
+		return QueueStats{}, ErrQueueNotFound
+	}
+	ch.Close()
+	if err != nil {
+		return QueueStats{}, err
+	}
+	return QueueStats{
+		Name:      q.Name,
+		Messages:  q.Messages,
+		Consumers: q.Consumers,
+	}, nil
+}
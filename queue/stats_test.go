@@ -0,0 +1,48 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestQueueStats(t *testing.T) {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	qm, err := queue.NewManager(url, queue.WithQueue("stats-test-queue"), queue.WithDurable(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qm.Close(context.Background())
+	if _, err = qm.Channel.QueuePurge(qm.QueueName, false); err != nil {
+		t.Fatal(err)
+	}
+	pin := queue.IPFSPin{CID: benchCID, NetworkName: "public", UserName: "stats", HoldTimeInMonths: 1}
+	if err = qm.EnableConfirms(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = qm.PublishMessageContext(context.Background(), pin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := qm.QueueStats(qm.QueueName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Messages != 3 || stats.Consumers != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if _, err = qm.QueueStats("stats-test-missing-queue"); err != queue.ErrQueueNotFound {
+		t.Fatalf("expected ErrQueueNotFound, got %v", err)
+	}
+	// the manager's channel is unaffected by inspecting a missing queue
+	if _, err = qm.QueueStats(qm.QueueName); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+const (
+	// TemplatePinFailed is used to notify users of pin failures, taking the
+	// CID, NetworkName, and Reason as data
+	TemplatePinFailed = "pin-failed"
+	// TemplateBulkPinFailed is used to notify users of the pins of a bulk pin which
+	// failed, taking the NetworkName, and Failures mapping each failed CID to its
+	// reason, as data
+	TemplateBulkPinFailed = "bulk-pin-failed"
+	// TemplateFileFailed is used to notify users of file add failures, taking
+	// the ObjectName, NetworkName, and Reason as data
+	TemplateFileFailed = "file-failed"
+	// TemplateIPNSFailed is used to notify users of ipns entry creation failures,
+	// taking the CID, Key, and Reason as data
+	TemplateIPNSFailed = "ipns-failed"
+	// TemplateNetworkUnauthorized is used to notify users that they tried to use
+	// a private network they aren't authorized to use, taking the NetworkName as data
+	TemplateNetworkUnauthorized = "network-unauthorized"
+	// TemplatePaymentFailed is used to notify users of payment confirmation
+	// failures, taking the TxHash and Reason as data
+	TemplatePaymentFailed = "payment-failed"
+)
+
+// emailLayout wraps every templated email, so that they are styled consistently
+const emailLayout = `{{define "layout"}}<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #333333;">
+<h2>{{template "title" .}}</h2>
+{{template "body" .}}
+<p style="color: #888888; font-size: small;">This is an automated message from Temporal, by RTrade Technologies.</p>
+</body>
+</html>{{end}}`
+
+// emailBodies are the title and body of each email template
+var emailBodies = map[string]string{
+	TemplatePinFailed: `{{define "title"}}IPFS Pin Failed{{end}}
+{{define "body"}}<p>Pinning content hash <code>{{.CID}}</code> on IPFS network <code>{{.NetworkName}}</code> failed.</p>
+<p>Reason: {{.Reason}}</p>{{end}}`,
+	TemplateBulkPinFailed: `{{define "title"}}IPFS Pins Failed{{end}}
+{{define "body"}}<p>Pinning the following content hashes on IPFS network <code>{{.NetworkName}}</code> failed.</p>
+<ul>{{range $cid, $reason := .Failures}}<li><code>{{$cid}}</code>: {{$reason}}</li>{{end}}</ul>{{end}}`,
+	TemplateFileFailed: `{{define "title"}}IPFS File Add Failed{{end}}
+{{define "body"}}<p>Adding object <code>{{.ObjectName}}</code> to IPFS network <code>{{.NetworkName}}</code> failed.</p>
+<p>Reason: {{.Reason}}</p>{{end}}`,
+	TemplateIPNSFailed: `{{define "title"}}IPNS Entry Creation Failed{{end}}
+{{define "body"}}<p>Creating an IPNS entry for content hash <code>{{.CID}}</code> using key <code>{{.Key}}</code> failed.</p>
+<p>Reason: {{.Reason}}</p>{{end}}`,
+	TemplateNetworkUnauthorized: `{{define "title"}}Unauthorized Access To IPFS Private Network{{end}}
+{{define "body"}}<p>Your request to use IPFS private network <code>{{.NetworkName}}</code> was refused, as you are not authorized to use it.</p>{{end}}`,
+	TemplatePaymentFailed: `{{define "title"}}Payment Confirmation Failed{{end}}
+{{define "body"}}<p>Confirming the payment with transaction hash <code>{{.TxHash}}</code> failed.</p>
+<p>Reason: {{.Reason}}</p>{{end}}`,
+}
+
+// emailTemplates are our parsed email templates, by name
+var emailTemplates = parseEmailTemplates()
+
+// parseEmailTemplates is used to parse each email body along with our layout
+func parseEmailTemplates() map[string]*template.Template {
+	templates := make(map[string]*template.Template, len(emailBodies))
+	for name, body := range emailBodies {
+		t := template.Must(template.New(name).Parse(emailLayout))
+		templates[name] = template.Must(t.Parse(body))
+	}
+	return templates
+}
+
+// Render is used by the email send consumer to get the content of an email and
+// its content type. Templated emails are rendered as html, with all template data
+// escaped, while other emails are sent with their content as given
+func (e EmailSend) Render() (content, contentType string, err error) {
+	if e.TemplateName == "" {
+		return e.Content, e.ContentType, nil
+	}
+	t, ok := emailTemplates[e.TemplateName]
+	if !ok {
+		return "", "", fmt.Errorf("unknown email template %s", e.TemplateName)
+	}
+	var buf bytes.Buffer
+	if err = t.ExecuteTemplate(&buf, "layout", e.TemplateData); err != nil {
+		return "", "", err
+	}
+	return buf.String(), "text/html", nil
+}
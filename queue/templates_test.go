@@ -0,0 +1,74 @@
+package queue_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestEmailSend_Render(t *testing.T) {
+	t.Run("Plain", func(t *testing.T) {
+		e := queue.EmailSend{Content: "<b>hello</b>", ContentType: "text/html"}
+		content, contentType, err := e.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content != e.Content || contentType != e.ContentType {
+			t.Fatalf("Render() = %q, %q, want content as given", content, contentType)
+		}
+	})
+	t.Run("Templates", func(t *testing.T) {
+		data := map[string]interface{}{
+			"CID":         testCID,
+			"NetworkName": "public",
+			"ObjectName":  "object",
+			"Key":         "key",
+			"TxHash":      "0x0",
+			"Reason":      "timed out",
+			"Failures":    map[string]string{testCID: "timed out"},
+		}
+		for _, name := range []string{queue.TemplatePinFailed, queue.TemplateBulkPinFailed, queue.TemplateFileFailed, queue.TemplateIPNSFailed, queue.TemplatePaymentFailed} {
+			content, contentType, err := queue.EmailSend{TemplateName: name, TemplateData: data}.Render()
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if contentType != "text/html" {
+				t.Fatalf("%s: content type = %s, want text/html", name, contentType)
+			}
+			if !strings.Contains(content, "timed out") {
+				t.Fatalf("%s: reason missing from content", name)
+			}
+		}
+	})
+	t.Run("Escapes", func(t *testing.T) {
+		content, _, err := queue.EmailSend{
+			TemplateName: queue.TemplatePinFailed,
+			TemplateData: map[string]interface{}{"Reason": "<script>alert(1)</script>"},
+		}.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(content, "<script>") {
+			t.Fatal("template data was not escaped")
+		}
+	})
+	t.Run("Unknown", func(t *testing.T) {
+		if _, _, err := (queue.EmailSend{TemplateName: "unknown"}).Render(); err == nil {
+			t.Fatal("expected error rendering unknown template")
+		}
+	})
+}
+
+func TestEmailSend_RenderNetworkUnauthorized(t *testing.T) {
+	content, _, err := queue.EmailSend{
+		TemplateName: queue.TemplateNetworkUnauthorized,
+		TemplateData: map[string]interface{}{"NetworkName": "private"},
+	}.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<code>private</code>"; !strings.Contains(content, want) {
+		t.Fatalf("expected content to contain %s", want)
+	}
+}
@@ -0,0 +1,222 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// HeaderTimeouts is the header used to record how many times handling a message has
+// timed out, and is absent on messages which haven't
+const HeaderTimeouts = "x-timeouts"
+
+// ErrHandlerTimeout is the error messages whose handler didn't return within the
+// manager's HandlerTimeout are settled with
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// DefaultMaxAbandonedHandlers is the number of abandoned handlers which may still be
+// running before consumers stop taking messages, when no limit is configured
+const DefaultMaxAbandonedHandlers = 100
+
+// withTimeout is used to wrap handler so that it is abandoned once it has run for
+// longer than the manager's HandlerTimeout, cancelling its context. We stop waiting
+// for the handler rather than for it to notice the cancellation, so that a handler
+// which ignores its context, such as one stuck in a call which never returns, can't
+// wedge the consumer, although it keeps running in the background until it does
+// return. handler must already recover from panics, as it is run in its own
+// goroutine.
+//
+// returned is called with the result of handlers which timed out once they have
+// returned, which for abandoned handlers is after the message has been settled, so
+// that what they hold on to, such as the message's idempotency claim, outlives them.
+// Abandoned handlers are counted until they return, and once there are
+// MaxAbandonedHandlers of them we wait for one to return before handling another
+// message, so that they can't pile up without bound.
+//
+// Messages which time out are retried later, unless MaxHandlerTimeouts is set, in
+// which case they are republished to the back of the queue with the number of times
+// they timed out recorded in their headers, and once they have timed out that many
+// times they are dead lettered, or discarded if the queue doesn't dead letter them.
+func (qm *Manager) withTimeout(handler Handler, returned func(error)) Handler {
+	if qm.HandlerTimeout <= 0 {
+		return handler
+	}
+	return func(ctx context.Context, d amqp.Delivery) error {
+		if n, max := qm.AbandonedHandlers(), qm.maxAbandoned(); n >= max {
+			qm.LogEntry(ctx).WithField("abandoned", n).Warn("too many abandoned handlers, waiting for one to return")
+		}
+		if err := qm.abandoned.wait(ctx, qm.maxAbandoned()); err != nil {
+			return RetryLater(err)
+		}
+		hctx, cancel := context.WithCancel(ctx)
+		timer := time.NewTimer(qm.HandlerTimeout)
+		defer timer.Stop()
+		var (
+			mu       sync.Mutex
+			finished bool
+			gaveUp   bool
+		)
+		done := make(chan error, 1)
+		go func() {
+			err := handler(hctx, d)
+			mu.Lock()
+			finished = true
+			abandoned := gaveUp
+			mu.Unlock()
+			done <- err
+			if abandoned {
+				returned(err)
+				qm.abandoned.add(-1)
+			}
+		}()
+		select {
+		case err := <-done:
+			cancel()
+			return err
+		case <-timer.C:
+			cancel()
+		}
+		// the handler may have finished just as it timed out
+		mu.Lock()
+		if finished {
+			mu.Unlock()
+			err := <-done
+			if err == nil {
+				return nil
+			}
+			returned(err)
+		} else {
+			gaveUp = true
+			mu.Unlock()
+			qm.abandoned.add(1)
+		}
+		timeouts := Timeouts(d) + 1
+		err := fmt.Errorf("%w after %v", ErrHandlerTimeout, qm.HandlerTimeout)
+		qm.LogEntry(ctx).WithFields(log.Fields{
+			"message_id": d.MessageId,
+			"type":       d.Type,
+			"timeouts":   timeouts,
+			"abandoned":  qm.AbandonedHandlers(),
+		}).Warn("abandoning message whose handler timed out")
+		if qm.MaxHandlerTimeouts <= 0 {
+			return RetryLater(err)
+		}
+		if timeouts >= qm.MaxHandlerTimeouts {
+			return Requeue(fmt.Errorf("%w, %v times", err, timeouts))
+		}
+		// the original is acknowledged once the copy has been published in its place
+		if pubErr := qm.requeueTimedOut(d, timeouts); pubErr != nil {
+			return RetryLater(fmt.Errorf("%s: failed to republish message: %w", err, pubErr))
+		}
+		return Drop(err)
+	}
+}
+
+// timedOut is used to get whether a handler's error is due to it timing out
+func (qm *Manager) timedOut(err error) bool {
+	return qm.HandlerTimeout > 0 && errors.Is(err, ErrHandlerTimeout)
+}
+
+// AbandonedHandlers is used to get the number of handlers which timed out and were
+// abandoned, but have yet to return
+func (qm *Manager) AbandonedHandlers() int {
+	return qm.abandoned.count()
+}
+
+// maxAbandoned is used to get the number of abandoned handlers which may be running
+// before we stop handling messages
+func (qm *Manager) maxAbandoned() int {
+	if qm.MaxAbandonedHandlers > 0 {
+		return qm.MaxAbandonedHandlers
+	}
+	return DefaultMaxAbandonedHandlers
+}
+
+// abandonedHandlers counts the handlers which timed out but are still running. Its
+// zero value is ready to use
+type abandonedHandlers struct {
+	mu      sync.Mutex
+	n       int
+	changed chan struct{}
+}
+
+// add is used to adjust the count, waking anyone waiting for it to change
+func (a *abandonedHandlers) add(delta int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.n += delta
+	if a.changed != nil {
+		close(a.changed)
+		a.changed = nil
+	}
+}
+
+// count is used to get the number of abandoned handlers
+func (a *abandonedHandlers) count() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.n
+}
+
+// wait is used to block while there are limit or more abandoned handlers, returning
+// ctx.Err() if ctx is done first
+func (a *abandonedHandlers) wait(ctx context.Context, limit int) error {
+	for {
+		a.mu.Lock()
+		if a.n < limit {
+			a.mu.Unlock()
+			return nil
+		}
+		if a.changed == nil {
+			a.changed = make(chan struct{})
+		}
+		changed := a.changed
+		a.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Timeouts is used to get the number of times handling a delivery has timed out
+func Timeouts(d amqp.Delivery) int {
+	switch n := d.Headers[HeaderTimeouts].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
+
+// requeueTimedOut is used to publish a copy of a delivery back to our queue with the
+// number of times it has timed out. the handler's context is done by now, so the
+// copy is published with a context of its own
+func (qm *Manager) requeueTimedOut(d amqp.Delivery, timeouts int) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[HeaderTimeouts] = int32(timeouts)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return qm.send(ctx, qm.channel(), "", qm.QueueName, amqp.Publishing{
+		Headers:         headers,
+		DeliveryMode:    amqp.Persistent,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		Type:            d.Type,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Body:            d.Body,
+	})
+}
@@ -0,0 +1,198 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestWithHandlerTimeout(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithHandlerTimeout(50*time.Millisecond, 0), queue.WithRetryLaterDelay(time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, testPin("user")); err != nil {
+		t.Fatal(err)
+	}
+	// the first attempt hangs, ignoring its cancelled context
+	release := make(chan struct{})
+	defer close(release)
+	cancelled := make(chan struct{})
+	var calls int32
+	qm.ConsumeMessageContext(ctx, "test", func(hctx context.Context, d amqp.Delivery) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-hctx.Done()
+			close(cancelled)
+			<-release
+			return nil
+		}
+		cancel()
+		return nil
+	})
+	if calls != 2 {
+		t.Fatalf("expected the message to be handled again once abandoned, got %v calls", calls)
+	}
+	select {
+	case <-cancelled:
+	default:
+		t.Fatal("expected the stuck handler's context to be cancelled")
+	}
+}
+
+func TestWithHandlerTimeout_DeadLetter(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter(), queue.WithHandlerTimeout(20*time.Millisecond, 2))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, testPin("user")); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	dlq := queue.DeadLetterName(queue.IpfsPinQueue)
+	for broker.Len(dlq) == 0 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	d, ok := broker.Get(dlq)
+	if !ok {
+		t.Fatal("expected the message to be dead lettered")
+	}
+	// the message was republished after timing out once, and dead lettered the second time
+	if n := queue.Timeouts(d); n != 1 {
+		t.Fatalf("expected the dead lettered message to have timed out once before, got %v", n)
+	}
+	if reason, _ := d.Headers[queue.HeaderFailureReason].(string); !strings.Contains(reason, queue.ErrHandlerTimeout.Error()) {
+		t.Fatalf("unexpected failure reason %q", reason)
+	}
+	if n := broker.Len(queue.IpfsPinQueue); n != 0 {
+		t.Fatalf("expected no copies of the message to remain, got %v", n)
+	}
+}
+
+func TestNewManager_NegativeHandlerTimeout(t *testing.T) {
+	if _, err := queue.NewManager("", queue.WithBroker(queue.NewMemoryBroker()), queue.WithHandlerTimeout(-time.Second, 0)); err == nil {
+		t.Fatal("expected a negative handler timeout to be refused")
+	}
+}
+
+// releaseCounter is an idempotency store counting the keys released
+type releaseCounter struct {
+	*queue.MemoryStore
+	released int32
+}
+
+func (s *releaseCounter) Release(key string) error {
+	atomic.AddInt32(&s.released, 1)
+	return s.MemoryStore.Release(key)
+}
+
+// the claim of a timed out message is held until its handler returns, so that its
+// redelivered copy isn't processed alongside it, and no completion event is sent
+func TestWithHandlerTimeout_Idempotency(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	store := &releaseCounter{MemoryStore: queue.NewMemoryStore()}
+	qm := newMemoryManager(t, broker, queue.WithIdempotency(store, time.Hour), queue.WithHandlerTimeout(20*time.Millisecond, 0), queue.WithRetryLaterDelay(time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := qm.PublishMessageContext(ctx, testPin("user")); err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	var calls, events int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		qm.ConsumeMessageContext(ctx, "test", func(hctx context.Context, d amqp.Delivery) error {
+			atomic.AddInt32(&calls, 1)
+			defer close(returned)
+			<-release
+			return errors.New("pin failed")
+		}, queue.WithCompletionEvent(func(d amqp.Delivery, err error) (string, interface{}) {
+			atomic.AddInt32(&events, 1)
+			return "events", nil
+		}))
+	}()
+	// the requeued copy is skipped as already claimed
+	for (broker.Len(queue.IpfsPinQueue) != 0 || broker.Unacked() != 0 || qm.AbandonedHandlers() != 1) && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected the handler to be called once, got %v", n)
+	}
+	if n := atomic.LoadInt32(&store.released); n != 0 {
+		t.Fatalf("expected the claim to be held while the handler runs, got %v releases", n)
+	}
+	close(release)
+	<-returned
+	for qm.AbandonedHandlers() != 0 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&store.released); n != 1 {
+		t.Fatalf("expected the claim to be released once the handler failed, got %v releases", n)
+	}
+	cancel()
+	<-done
+	if n := atomic.LoadInt32(&events); n != 0 {
+		t.Fatalf("expected no completion event for the timed out message, got %v", n)
+	}
+}
+
+// consumers stop handling messages while too many abandoned handlers are running
+func TestWithMaxAbandonedHandlers(t *testing.T) {
+	broker := newAdminBroker(t)
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithHandlerTimeout(20*time.Millisecond, 0), queue.WithMaxAbandonedHandlers(1), queue.WithRetryLaterDelay(time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, user := range []string{"a", "b"} {
+		if err := qm.PublishMessageContext(ctx, testPin(user)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release := make(chan struct{})
+	var calls int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		qm.ConsumeMessageContext(ctx, "test", func(hctx context.Context, d amqp.Delivery) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-release
+			}
+			return nil
+		})
+	}()
+	for qm.AbandonedHandlers() != 1 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected no messages to be handled while at the limit, got %v calls", n)
+	}
+	close(release)
+	for atomic.LoadInt32(&calls) < 3 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("expected both messages to be handled once the handler returned, got %v calls", n)
+	}
+}
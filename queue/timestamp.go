@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrClockSkew is the reason messages are refused when their timestamp is further
+// in the past or future than the manager's TimestampTolerance allows, which usually
+// means their producer's clock is broken
+var ErrClockSkew = errors.New("message timestamp is outside the tolerated clock skew")
+
+// TimestampTolerance is how far the timestamps of consumed messages may be from the
+// consumer's clock before they're refused, with 0 disabling either bound. Past should
+// allow for the longest a message may legitimately wait, including being retried or
+// postponed, as messages keep the timestamp they were first published with until
+// they're replayed.
+type TimestampTolerance struct {
+	Past   time.Duration
+	Future time.Duration
+}
+
+type timestampKey struct{}
+
+// PublishedAt is used to get when the message being processed was published,
+// according to its producer's clock, returning false if it has no timestamp, such
+// as messages of producers predating timestamps. Timestamps sent through rabbitmq
+// have a resolution of a second.
+func PublishedAt(ctx context.Context) (time.Time, bool) {
+	ts, ok := ctx.Value(timestampKey{}).(time.Time)
+	return ts, ok
+}
+
+// timestampContext is used to attach the timestamp of a delivery to ctx
+func timestampContext(ctx context.Context, d amqp.Delivery) context.Context {
+	if d.Timestamp.IsZero() {
+		return ctx
+	}
+	return context.WithValue(ctx, timestampKey{}, d.Timestamp)
+}
+
+// check is used to check a delivery's timestamp is within tolerance of now,
+// ignoring deliveries without one
+func (t TimestampTolerance) check(d amqp.Delivery, now time.Time) error {
+	if d.Timestamp.IsZero() {
+		return nil
+	}
+	// rabbitmq truncates timestamps to the second, so allow for it when in future
+	skew := d.Timestamp.Sub(now)
+	if t.Future > 0 && skew > t.Future+time.Second {
+		return fmt.Errorf("%w: published %s in the future", ErrClockSkew, skew)
+	}
+	if t.Past > 0 && -skew > t.Past {
+		return fmt.Errorf("%w: published %s ago", ErrClockSkew, -skew)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package queue_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestManager_Timestamps(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithDeadLetter(), queue.WithTimestampTolerance(time.Hour, time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	skewed := func(offset time.Duration) queue.PublishOption {
+		return func(msg *amqp.Publishing) {
+			msg.Timestamp = time.Now().Add(offset)
+		}
+	}
+	for _, opts := range [][]queue.PublishOption{
+		{skewed(2 * time.Hour)},
+		{skewed(-2 * time.Hour)},
+		{skewed(-30 * time.Minute)},
+		nil,
+	} {
+		if err := qm.PublishMessageContext(ctx, testPin("user"), opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var published []time.Time
+	qm.ConsumeMessageContext(ctx, "test", func(ctx context.Context, d amqp.Delivery) error {
+		ts, ok := queue.PublishedAt(ctx)
+		if !ok || !ts.Equal(d.Timestamp) {
+			t.Errorf("expected the message's timestamp, got %v", ts)
+		}
+		if published = append(published, ts); len(published) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if len(published) != 2 {
+		t.Fatalf("expected the messages within tolerance to be handled, got %v", len(published))
+	}
+	if since := time.Since(published[1]); since < 0 || since > 5*time.Second {
+		t.Fatalf("expected the message to be timestamped when published, got %v", published[1])
+	}
+	for i := 0; i < 2; i++ {
+		d, ok := broker.Get(queue.DeadLetterName(queue.IpfsPinQueue))
+		if !ok {
+			t.Fatal("expected the skewed messages to be dead lettered")
+		}
+		if reason, _ := d.Headers[queue.HeaderFailureReason].(string); !strings.Contains(reason, queue.ErrClockSkew.Error()) {
+			t.Fatalf("unexpected failure reason %q", reason)
+		}
+	}
+}
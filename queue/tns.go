@@ -1,7 +1,10 @@
 package queue
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/RTradeLtd/rtfs"
@@ -10,14 +13,19 @@ import (
 	"github.com/RTradeLtd/config"
 	"github.com/RTradeLtd/database/models"
 	"github.com/jinzhu/gorm"
+	ci "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	"github.com/streadway/amqp"
 )
 
-// ProcessTNSRecordCreation is used to process new TNS record creation requests
+// ProcessTNSRecordCreation is used to process new TNS record creation requests,
+// invalidating the manager's ResolverCache once each is processed
 func (qm *Manager) ProcessTNSRecordCreation(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
 	zm := models.NewZoneManager(db)
 	rm := models.NewRecordManager(db)
+	if qm.ResolverCache != nil {
+		msgs = qm.InvalidateResolverCacheOnSettle(msgs, qm.ResolverCache)
+	}
 	qm.LogInfo("processing messages")
 	// process new messages
 	for d := range msgs {
@@ -30,8 +38,13 @@ func (qm *Manager) ProcessTNSRecordCreation(msgs <-chan amqp.Delivery, db *gorm.
 			d.Ack(false)
 			continue
 		}
+		// names are case insensitive, so are stored normalized, keeping the name
+		// as given for display, which also prevents records differing only by case
+		displayName := strings.TrimSpace(req.RecordName)
+		req.ZoneName, req.RecordName = tns.NormalizeName(req.ZoneName), tns.NormalizeName(req.RecordName)
 		// search for zone in db
-		if _, err := zm.FindZoneByNameAndUser(req.ZoneName, req.UserName); err != nil {
+		zoneModel, err := zm.FindZoneByNameAndUser(req.ZoneName, req.UserName)
+		if err != nil {
 			qm.LogError(err, "failed to search for zone")
 			d.Ack(false)
 			continue
@@ -46,6 +59,22 @@ func (qm *Manager) ProcessTNSRecordCreation(msgs <-chan amqp.Delivery, db *gorm.
 		rtfsManager, err := rtfs.NewManager(cfg.IPFS.APIConnection.Host+":"+cfg.IPFS.APIConnection.Port, keystore, time.Minute*10)
 		if err != nil {
 			qm.LogError(err, "failed to initialize connection to ipfs")
+			qm.notifyIPFSFailure(err)
+			d.Ack(false)
+			continue
+		}
+		existing, err := findExistingRecord(
+			rm, rtfsManager, req.UserName, req.ZoneName, req.RecordName, req.RecordType,
+		)
+		if err != nil {
+			qm.LogError(err, "failed to search for existing record")
+			d.Ack(false)
+			continue
+		}
+		// as with dns, aliases can't share their name with records of other types
+		if err = checkSharedName(rm, req); err != nil {
+			qm.LogError(err, "record conflicts with an existing record",
+				"zone", req.ZoneName, "record", req.RecordName)
 			d.Ack(false)
 			continue
 		}
@@ -64,13 +93,46 @@ func (qm *Manager) ProcessTNSRecordCreation(msgs <-chan amqp.Delivery, db *gorm.
 			continue
 		}
 		// create record object
-		r := tns.Record{
+		ttl := time.Duration(req.TTL)
+		if ttl == 0 {
+			ttl = tns.DefaultRecordTTL
+		}
+		r := &tns.Record{
 			PublicKey: recordPKID.Pretty(),
 			Name:      req.RecordName,
+			Type:      req.RecordType,
+			Value:     req.Value,
+			TTL:       int64(ttl / time.Second),
 			MetaData:  req.MetaData,
 		}
+		if displayName != req.RecordName {
+			r.DisplayName = displayName
+		}
+		// a record sharing its name and type with an existing record is added to
+		// its record set unless replacing it. aliases are refused from sets, as
+		// they only point at one name
+		if existing != nil && !req.Replace {
+			if r, err = tns.AddToSet(existing, r); err != nil {
+				qm.LogError(err, "record conflicts with an existing record",
+					"zone", req.ZoneName, "record", req.RecordName)
+				d.Ack(false)
+				continue
+			}
+		}
+		// sign the record with the zone's key, so that clients can verify it
+		zonePK, err := keystore.GetPrivateKeyByName(zoneModel.ZonePublicKeyName)
+		if err != nil {
+			qm.LogError(err, "failed to get zone private key")
+			d.Ack(false)
+			continue
+		}
+		if err = r.Sign(zonePK); err != nil {
+			qm.LogError(err, "failed to sign tns record")
+			d.Ack(false)
+			continue
+		}
 		// marshal it
-		marshaled, err := json.Marshal(&r)
+		marshaled, err := json.Marshal(r)
 		if err != nil {
 			qm.LogError(err, "failed to marshal tns record")
 			d.Ack(false)
@@ -81,130 +143,367 @@ func (qm *Manager) ProcessTNSRecordCreation(msgs <-chan amqp.Delivery, db *gorm.
 		if err != nil {
 			qm.LogError(err, "failed to put record in ipfs")
 			d.Ack(false)
-		}
-		// update the zone in database
-		zone, err := zm.AddRecordForZone(
-			req.ZoneName, req.RecordName, req.UserName,
-		)
-		if err != nil {
-			qm.LogError(err, "failed to add record to zone in database")
-			d.Ack(false)
 			continue
 		}
-		// update the database with a new record
-		if _, err := rm.AddRecord(
-			req.UserName, req.RecordName, req.RecordKeyName, req.ZoneName, req.MetaData,
-		); err != nil {
-			qm.LogError(err, "unable to add record in database")
+		// the database holds a single record per name and type, keyed by
+		// tns.RecordKey and pointing at its latest version in ipfs, which already
+		// exists when adding to a record set or replacing a record
+		key := tns.RecordKey(req.RecordName, req.RecordType)
+		var zone *models.Zone
+		if existing == nil {
+			// update the zone in database
+			zone, err = zm.AddRecordForZone(req.ZoneName, key, req.UserName)
+			if err != nil {
+				qm.LogError(err, "failed to add record to zone in database")
+				d.Ack(false)
+				continue
+			}
+			// update the database with a new record
+			if _, err := rm.AddRecord(
+				req.UserName, key, req.RecordKeyName, req.ZoneName, req.MetaData,
+			); err != nil {
+				qm.LogError(err, "unable to add record in database")
+				d.Ack(false)
+				continue
+			}
+		} else if zone, err = zm.FindZoneByNameAndUser(req.ZoneName, req.UserName); err != nil {
+			qm.LogError(err, "failed to search for zone")
 			d.Ack(false)
 			continue
 		}
 		// update the latest ipfs hash for this record
-		if _, err := rm.UpdateLatestIPFSHash(
-			req.UserName, req.RecordName, resp,
-		); err != nil {
+		if _, err := rm.UpdateLatestIPFSHash(req.UserName, key, resp); err != nil {
 			qm.LogError(err, "unable to update ipfs hash for record in database")
 			d.Ack(false)
 			continue
 		}
-		zonePK, err := keystore.GetPrivateKeyByName(zone.ZonePublicKeyName)
-		if err != nil {
-			qm.LogError(err, "failed to get zone private key")
+		// regenerate the zone file to include the new record
+		if err = qm.publishZoneFile(zm, rm, keystore, rtfsManager, zone); err != nil {
 			d.Ack(false)
 			continue
 		}
-		// convert private key to id
-		zonePKID, err := peer.IDFromPublicKey(zonePK.GetPublic())
-		if err != nil {
-			qm.LogError(err, "failed to get zone id from public key")
+		qm.LogInfo("record added to ipfs and database")
+		d.Ack(false)
+	}
+	return nil
+}
+
+// findExistingRecord is used to get the latest version of the record of the zone
+// with the given name and type, returning nil if there is none. The record's values
+// are only held by its latest version in ipfs
+func findExistingRecord(rm *models.RecordManager, rtfsManager *rtfs.IpfsManager, userName, zoneName, recordName, recordType string) (*tns.Record, error) {
+	existing, err := rm.FindRecordByNameAndUser(userName, tns.RecordKey(recordName, recordType))
+	if gorm.IsRecordNotFoundError(err) || (err == nil && existing.ZoneName != zoneName) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record tns.Record
+	if err = rtfsManager.DagGet(existing.LatestIPFSHash, &record); err != nil {
+		return nil, fmt.Errorf("failed to get existing record: %w", err)
+	}
+	return &record, nil
+}
+
+// checkSharedName is used to check that the record being created may share its name
+// with the zone's records of other types, which it can't when either is an alias
+func checkSharedName(rm *models.RecordManager, req RecordCreation) error {
+	records, err := rm.FindRecordsByZone(req.UserName, req.ZoneName)
+	if err != nil {
+		return fmt.Errorf("failed to find records: %w", err)
+	}
+	for _, v := range *records {
+		name, recordType := tns.ParseRecordKey(v.Name)
+		if name == req.RecordName && recordType != req.RecordType &&
+			!tns.CanShareName(req.RecordType, recordType) {
+			return fmt.Errorf("record %s already has type %s", name, recordType)
+		}
+	}
+	return nil
+}
+
+// ProcessTNSRecordDeletion is used to process TNS record deletion requests. Records
+// are only deleted from zones owned by the requesting user, after which the zone
+// file is regenerated without the record, and the manager's ResolverCache
+// invalidated
+func (qm *Manager) ProcessTNSRecordDeletion(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+	zm := models.NewZoneManager(db)
+	rm := models.NewRecordManager(db)
+	if qm.ResolverCache != nil {
+		msgs = qm.InvalidateResolverCacheOnSettle(msgs, qm.ResolverCache)
+	}
+	qm.LogInfo("processing messages")
+	// process new messages
+	for d := range msgs {
+		// message received
+		qm.LogInfo("new message received")
+		req := RecordDeletion{}
+		// unmarshal message
+		if err := json.Unmarshal(d.Body, &req); err != nil {
+			qm.LogError(err, "failed to unmarshal message")
 			d.Ack(false)
 			continue
 		}
-		// get zone manager private key
-		zoneManagerPK, err := keystore.GetPrivateKeyByName(zone.ManagerPublicKeyName)
-		if err != nil {
-			qm.LogError(err, "failed to get zone manager private key")
+		req.ZoneName, req.RecordName = tns.NormalizeName(req.ZoneName), tns.NormalizeName(req.RecordName)
+		if err := req.Validate(); err != nil {
+			qm.LogError(err, "invalid record deletion request")
 			d.Ack(false)
 			continue
 		}
-		zomeManagerPKID, err := peer.IDFromPublicKey(zoneManagerPK.GetPublic())
+		// searching by user ensures the zone exists and is owned by them
+		zone, err := zm.FindZoneByNameAndUser(req.ZoneName, req.UserName)
 		if err != nil {
-			qm.LogError(err, "failed to get zone manager id from private key")
+			qm.LogError(err, "failed to search for zone")
 			d.Ack(false)
 			continue
 		}
-		records, err := rm.FindRecordsByZone(zone.UserName, zone.Name)
+		record, err := rm.FindRecordByNameAndUser(
+			req.UserName, tns.RecordKey(req.RecordName, req.RecordType),
+		)
 		if err != nil {
-			qm.LogError(err, "failed to find records")
+			qm.LogError(err, "failed to search for record")
 			d.Ack(false)
 			continue
 		}
-		m := make(map[string]*tns.Record)
-		mr := make(map[string]string)
-		for _, v := range *records {
-			tnR := &tns.Record{
-				PublicKey: v.RecordKeyName,
-				Name:      v.Name,
-				MetaData:  nil,
-			}
-			m[v.Name] = tnR
-			mr[v.Name] = v.RecordKeyName
-		}
-		z := tns.Zone{
-			PublicKey: zonePKID.Pretty(),
-			Manager: &tns.ZoneManager{
-				PublicKey: zomeManagerPKID.Pretty(),
-			},
-			Name:                    zone.Name,
-			Records:                 m,
-			RecordNamesToPublicKeys: mr,
-		}
-		// marshal to bytes
-		marshaled, err = json.Marshal(&z)
+		if record.ZoneName != zone.Name {
+			qm.LogError(nil, "record does not belong to zone",
+				"zone", zone.Name, "record", record.Name)
+			d.Ack(false)
+			continue
+		}
+		if req.RecordKeyName != "" && req.RecordKeyName != record.RecordKeyName {
+			qm.LogError(nil, "record key does not match record",
+				"zone", zone.Name, "record", record.Name)
+			d.Ack(false)
+			continue
+		}
+		// connect to ipfs
+		keystore, err := rtfs.NewKeystoreManager()
 		if err != nil {
-			qm.LogError(err, "failed to marshal tns zone")
+			qm.LogError(err, "failed to initialize keystore manager")
 			d.Ack(false)
 			continue
 		}
-		// put to ipfs
-		resp, err = rtfsManager.DagPut(marshaled, "json", "cbor")
+		rtfsManager, err := rtfs.NewManager(cfg.IPFS.APIConnection.Host+":"+cfg.IPFS.APIConnection.Port, keystore, time.Minute*10)
 		if err != nil {
-			qm.LogError(err, "failed to put zone file in ipfs")
+			qm.LogError(err, "failed to initialize connection to ipfs")
+			qm.notifyIPFSFailure(err)
 			d.Ack(false)
 			continue
 		}
-		// update database with has
-		zone.LatestIPFSHash = resp
-		if _, err = zm.UpdateLatestIPFSHashForZone(zone.Name, zone.UserName, resp); err != nil {
-			qm.LogError(err, "failed to update zone in database")
+		// remove the record from the database
+		if err = deleteRecord(db, zone, record); err != nil {
+			qm.LogError(err, "failed to delete record from database")
 			d.Ack(false)
 			continue
 		}
-		qm.LogInfo("record added to ipfs and database")
+		// regenerate the zone file without the deleted record
+		if err = qm.publishZoneFile(zm, rm, keystore, rtfsManager, zone); err != nil {
+			d.Ack(false)
+			continue
+		}
+		qm.LogInfo("record deleted from database and zone updated in ipfs")
 		d.Ack(false)
 	}
 	return nil
 }
 
-// ProcessTNSZoneCreation is used to process new TNS zone creation requests
-func (qm *Manager) ProcessTNSZoneCreation(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+// deleteRecord is used to delete a record, removing it from its zone's record names
+// within the same transaction
+func deleteRecord(db *gorm.DB, zone *models.Zone, record *models.Record) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	err := tx.Delete(record).Error
+	if err == nil {
+		err = tx.Model(zone).Update(
+			"record_names", gorm.Expr("array_remove(record_names, ?)", record.Name),
+		).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// publishZoneFile is used to regenerate a zone file from the zone's records,
+// put it in ipfs, and record its hash in the database. failures are logged, and
+// the error returned
+func (qm *Manager) publishZoneFile(zm *models.ZoneManager, rm *models.RecordManager, keystore *rtfs.KeystoreManager, rtfsManager *rtfs.IpfsManager, zone *models.Zone) error {
+	records, err := rm.FindRecordsByZone(zone.UserName, zone.Name)
+	if err != nil {
+		qm.LogError(err, "failed to find records")
+		return err
+	}
+	z, err := zoneFile(keystore, rtfsManager, zone, *records)
+	if err != nil {
+		qm.LogError(err, "failed to generate zone file")
+		return err
+	}
+	// marshal to bytes
+	marshaled, err := json.Marshal(&z)
+	if err != nil {
+		qm.LogError(err, "failed to marshal tns zone")
+		return err
+	}
+	// put to ipfs
+	resp, err := rtfsManager.DagPut(marshaled, "json", "cbor")
+	if err != nil {
+		qm.LogError(err, "failed to put zone file in ipfs")
+		return err
+	}
+	// update database with has
+	zone.LatestIPFSHash = resp
+	if _, err = zm.UpdateLatestIPFSHashForZone(zone.Name, zone.UserName, resp); err != nil {
+		qm.LogError(err, "failed to update zone in database")
+		return err
+	}
+	return nil
+}
+
+// zoneFile is used to generate the zone file of a zone with the given records, each
+// being the latest version of the record in ipfs
+func zoneFile(keystore *rtfs.KeystoreManager, ipfs *rtfs.IpfsManager, zone *models.Zone, records []models.Record) (*tns.Zone, error) {
+	zonePK, err := keystore.GetPrivateKeyByName(zone.ZonePublicKeyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zone private key: %w", err)
+	}
+	// convert private key to id
+	zonePKID, err := peer.IDFromPublicKey(zonePK.GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zone id from public key: %w", err)
+	}
+	// get zone manager private key
+	zoneManagerPK, err := keystore.GetPrivateKeyByName(zone.ManagerPublicKeyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zone manager private key: %w", err)
+	}
+	zomeManagerPKID, err := peer.IDFromPublicKey(zoneManagerPK.GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get zone manager id from private key: %w", err)
+	}
+	// records are stored under their tns.RecordKey, as a name may hold a
+	// record set of each type
+	m := make(map[string]*tns.Record)
+	mr := make(map[string]string)
+	for _, v := range records {
+		// the record's values, ttl and signature are only held by its latest
+		// version, with records yet to be put in ipfs having just a name
+		name, recordType := tns.ParseRecordKey(v.Name)
+		tnR := &tns.Record{
+			PublicKey: v.RecordKeyName,
+			Name:      name,
+			Type:      recordType,
+		}
+		if v.LatestIPFSHash != "" {
+			if err := ipfs.DagGet(v.LatestIPFSHash, tnR); err != nil {
+				return nil, fmt.Errorf("failed to get record %s: %w", v.Name, err)
+			}
+		}
+		m[v.Name] = tnR
+		mr[v.Name] = v.RecordKeyName
+	}
+	return &tns.Zone{
+		PublicKey: zonePKID.Pretty(),
+		Manager: &tns.ZoneManager{
+			PublicKey: zomeManagerPKID.Pretty(),
+		},
+		Name:                    zone.Name,
+		Records:                 m,
+		RecordNamesToPublicKeys: mr,
+	}, nil
+}
+
+// ZoneExporter is used to create a ZoneExportFunc for ZoneExportHandler, which
+// exports zones owned by the requesting user from the database, signed with the
+// zone's private key, getting the latest version of each record from ipfs
+func (qm *Manager) ZoneExporter(db *gorm.DB, ipfs *rtfs.IpfsManager) ZoneExportFunc {
 	zm := models.NewZoneManager(db)
+	rm := models.NewRecordManager(db)
+	return func(ctx context.Context, req ZoneExport) (*tns.ZoneDocument, error) {
+		// searching by user ensures the zone exists and is owned by them
+		zone, err := zm.FindZoneByNameAndUser(req.ZoneName, req.UserName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search for zone: %w", err)
+		}
+		records, err := rm.FindRecordsByZone(zone.UserName, zone.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find records: %w", err)
+		}
+		keystore, err := rtfs.NewKeystoreManager()
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize keystore manager: %w", err)
+		}
+		z, err := zoneFile(keystore, ipfs, zone, *records)
+		if err != nil {
+			return nil, err
+		}
+		doc := &tns.ZoneDocument{
+			Version:        tns.ZoneDocumentVersion,
+			ExportedAt:     time.Now().UTC(),
+			Zone:           *z,
+			ManagerKeyName: zone.ManagerPublicKeyName,
+			ZoneKeyName:    zone.ZonePublicKeyName,
+			RecordKeyNames: make(map[string]string),
+			RecordHashes:   make(map[string]string),
+		}
+		for _, v := range *records {
+			doc.RecordKeyNames[v.Name] = v.RecordKeyName
+			doc.RecordHashes[v.Name] = v.LatestIPFSHash
+		}
+		zonePK, err := keystore.GetPrivateKeyByName(zone.ZonePublicKeyName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get zone private key: %w", err)
+		}
+		if err = doc.Sign(zonePK); err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+}
+
+// ProcessTNSZoneImport is used to process TNS zone import requests, restoring zones
+// from documents produced by ExportZone. Documents whose signature or version is
+// invalid are rejected, as are imports of zones the user already has, or whose keys
+// the user doesn't own or which don't match the document
+func (qm *Manager) ProcessTNSZoneImport(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig) error {
+	zm := models.NewZoneManager(db)
+	rm := models.NewRecordManager(db)
+	um := models.NewUserManager(db)
 	qm.LogInfo("processing messages")
-	// process messages
+	// process new messages
 	for d := range msgs {
-		// new message
+		// message received
 		qm.LogInfo("new message received")
-		req := ZoneCreation{}
-		// unmarshal the message into a typed format
+		req := ZoneImport{}
+		// unmarshal message
 		if err := json.Unmarshal(d.Body, &req); err != nil {
 			qm.LogError(err, "failed to unmarshal message")
 			d.Ack(false)
 			continue
 		}
-		// get the zone from db
-		zone, err := zm.FindZoneByNameAndUser(req.Name, req.UserName)
-		if err != nil {
-			qm.LogError(err, "failed to search for zone")
+		if err := req.Validate(); err != nil {
+			qm.LogError(err, "invalid zone import request")
+			d.Ack(false)
+			continue
+		}
+		doc := req.Document
+		if err := doc.Verify(); err != nil {
+			qm.LogError(err, "failed to verify zone document", "zone", doc.Zone.Name)
+			d.Ack(false)
+			continue
+		}
+		// imports never overwrite an existing zone
+		if _, err := zm.FindZoneByNameAndUser(doc.Zone.Name, req.UserName); err == nil {
+			qm.LogError(nil, "zone already exists", "zone", doc.Zone.Name)
+			d.Ack(false)
+			continue
+		}
+		if err := checkKeysOwned(um, req.UserName, doc); err != nil {
+			qm.LogError(err, "zone keys can't be used", "zone", doc.Zone.Name)
 			d.Ack(false)
 			continue
 		}
@@ -218,69 +517,235 @@ func (qm *Manager) ProcessTNSZoneCreation(msgs <-chan amqp.Delivery, db *gorm.DB
 		rtfsManager, err := rtfs.NewManager(cfg.IPFS.APIConnection.Host+":"+cfg.IPFS.APIConnection.Port, keystore, time.Minute*10)
 		if err != nil {
 			qm.LogError(err, "failed to initialize connection to ipfs")
+			qm.notifyIPFSFailure(err)
 			d.Ack(false)
 			continue
 		}
-		// get zone manager private key
-		zoneManagerPK, err := keystore.GetPrivateKeyByName(req.ManagerKeyName)
-		if err != nil {
-			qm.LogError(err, "failed to get zone manager private key")
+		// the restored zone is published with these keys, so they must be the ones
+		// the document was exported with
+		if err = checkKeysMatch(keystore, doc); err != nil {
+			qm.LogError(err, "zone keys don't match document", "zone", doc.Zone.Name)
 			d.Ack(false)
 			continue
 		}
-		// get zone private key
-		zonePK, err := keystore.GetPrivateKeyByName(req.ZoneKeyName)
+		zone, err := zm.NewZone(req.UserName, doc.Zone.Name, doc.ManagerKeyName, doc.ZoneKeyName, "")
 		if err != nil {
-			qm.LogError(err, "failed to get zone private key")
+			qm.LogError(err, "failed to create zone in database")
 			d.Ack(false)
 			continue
 		}
-		// convert private key to id
-		zonePKID, err := peer.IDFromPublicKey(zonePK.GetPublic())
-		if err != nil {
-			qm.LogError(err, "failed to get zone peer id from public key")
+		if err = importRecords(zm, rm, req.UserName, doc); err != nil {
+			qm.LogError(err, "failed to import records", "zone", doc.Zone.Name)
 			d.Ack(false)
 			continue
 		}
-		zoneManagerPKID, err := peer.IDFromPublicKey(zoneManagerPK.GetPublic())
-		if err != nil {
-			qm.LogError(err, "failed to get zone manager peer id from pubclic key")
+		// regenerate the zone file from the imported records
+		if err = qm.publishZoneFile(zm, rm, keystore, rtfsManager, zone); err != nil {
 			d.Ack(false)
 			continue
 		}
-		// generate initial zone object
-		z := tns.Zone{
-			PublicKey: zonePKID.Pretty(),
-			Manager: &tns.ZoneManager{
-				PublicKey: zoneManagerPKID.Pretty(),
-			},
-			Name: req.Name,
+		qm.LogInfo("zone imported to database and published to ipfs")
+		d.Ack(false)
+	}
+	return nil
+}
+
+// checkKeysOwned is used to check that the user owns every key of the document
+func checkKeysOwned(um *models.UserManager, userName string, doc tns.ZoneDocument) error {
+	keys := []string{doc.ManagerKeyName, doc.ZoneKeyName}
+	for _, keyName := range doc.RecordKeyNames {
+		keys = append(keys, keyName)
+	}
+	for _, keyName := range keys {
+		owned, err := um.CheckIfKeyOwnedByUser(userName, keyName)
+		if err != nil {
+			return err
+		}
+		if !owned {
+			return fmt.Errorf("key %s is not owned by user", keyName)
 		}
-		// marshal to bytes
-		marshaled, err := json.Marshal(&z)
+	}
+	return nil
+}
+
+// checkKeysMatch is used to check that the zone and manager keys in the keystore
+// are the ones identified by the document
+func checkKeysMatch(keystore *rtfs.KeystoreManager, doc tns.ZoneDocument) error {
+	keys := map[string]string{doc.ZoneKeyName: doc.Zone.PublicKey}
+	if doc.Zone.Manager != nil {
+		keys[doc.ManagerKeyName] = doc.Zone.Manager.PublicKey
+	}
+	for keyName, id := range keys {
+		pk, err := keystore.GetPrivateKeyByName(keyName)
 		if err != nil {
-			qm.LogError(err, "failed to marshal tns zone")
-			d.Ack(false)
-			continue
+			return fmt.Errorf("failed to get private key %s: %w", keyName, err)
 		}
-		// put to ipfs
-		resp, err := rtfsManager.DagPut(marshaled, "json", "cbor")
+		pkID, err := peer.IDFromPublicKey(pk.GetPublic())
 		if err != nil {
-			qm.LogError(err, "failed to put zone in ipfs")
-			d.Ack(false)
-			continue
+			return err
 		}
-		// update database with has
-		zone.LatestIPFSHash = resp
-		if _, err = zm.UpdateLatestIPFSHashForZone(zone.Name, zone.UserName, resp); err != nil {
-			qm.LogError(err, "failed to update zone in database")
-			d.Ack(false)
-			continue
+		if pkID.Pretty() != id {
+			return fmt.Errorf("key %s does not match the zone's public key %s", keyName, id)
+		}
+	}
+	return nil
+}
+
+// importRecords is used to add the records of a document to its zone
+func importRecords(zm *models.ZoneManager, rm *models.RecordManager, userName string, doc tns.ZoneDocument) error {
+	for name, keyName := range doc.RecordKeyNames {
+		var metaData map[string]interface{}
+		if r := doc.Zone.Records[name]; r != nil {
+			metaData = r.MetaData
+		}
+		if _, err := zm.AddRecordForZone(doc.Zone.Name, name, userName); err != nil {
+			return err
+		}
+		if _, err := rm.AddRecord(userName, name, keyName, doc.Zone.Name, metaData); err != nil {
+			return err
+		}
+		if hash := doc.RecordHashes[name]; hash != "" {
+			if _, err := rm.UpdateLatestIPFSHash(userName, name, hash); err != nil {
+				return err
+			}
 		}
-		// success
-		qm.LogInfo("zone published and database updated")
-		d.Ack(false)
-		continue
 	}
 	return nil
 }
+
+// ProcessTNSZoneCreation is used to process new TNS zone creation requests with
+// ZoneCreationHandler, invalidating the manager's ResolverCache once each is
+// processed. Zones are marked as being created in inProgress, which must be shared
+// by every consumer of the queue, such as a store backed by a database or redis,
+// so that concurrent duplicates are caught across consumers. Zones aren't marked
+// when it's nil
+func (qm *Manager) ProcessTNSZoneCreation(msgs <-chan amqp.Delivery, db *gorm.DB, cfg *config.TemporalConfig, inProgress IdempotencyStore) error {
+	keystore, err := rtfs.NewKeystoreManager()
+	if err != nil {
+		qm.LogError(err, "failed to initialize keystore manager")
+		return err
+	}
+	rtfsManager, err := rtfs.NewManager(cfg.IPFS.APIConnection.Host+":"+cfg.IPFS.APIConnection.Port, keystore, time.Minute*10)
+	if err != nil {
+		qm.LogError(err, "failed to initialize connection to ipfs")
+		qm.notifyIPFSFailure(err)
+		return err
+	}
+	handler := qm.ZoneCreationHandler(
+		&dbZoneStore{zm: models.NewZoneManager(db)},
+		&rtfsZonePublisher{keystore: keystore, ipfs: rtfsManager},
+		ZoneCreationOpts{InProgress: inProgress},
+	)
+	if qm.ResolverCache != nil {
+		handler = qm.InvalidateResolverCache(handler, qm.ResolverCache)
+	}
+	qm.LogInfo("processing messages")
+	for d := range msgs {
+		ctx := deliveryContext(context.Background(), d)
+		qm.LogEntry(ctx).Info("new message received")
+		err := handler(ctx, d)
+		if err != nil {
+			qm.logError(ctx, err, "failed to create zone")
+		}
+		if err = qm.settle(ctx, d, err); err != nil {
+			qm.logError(ctx, err, "failed to settle message")
+		}
+	}
+	return nil
+}
+
+// pendingZoneHash is the hash zones are added to the database with by the api,
+// until they're published
+const pendingZoneHash = "qm.."
+
+// dbZoneStore is a ZoneStore backed by our database
+type dbZoneStore struct {
+	zm *models.ZoneManager
+}
+
+func (s *dbZoneStore) ZonePublished(name, userName string) (bool, error) {
+	zone, err := s.zm.FindZoneByNameAndUser(name, userName)
+	if gorm.IsRecordNotFoundError(err) {
+		return false, tns.ErrZoneNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return zone.LatestIPFSHash != "" && zone.LatestIPFSHash != pendingZoneHash, nil
+}
+
+func (s *dbZoneStore) SetZoneHash(name, userName, hash string) error {
+	_, err := s.zm.UpdateLatestIPFSHashForZone(name, userName, hash)
+	return err
+}
+
+// dbRecordFinder is a tns.RecordFinder backed by our database, whose records point
+// at their latest version in ipfs, holding their values
+type dbRecordFinder struct {
+	db   *gorm.DB
+	rm   *models.RecordManager
+	ipfs *rtfs.IpfsManager
+}
+
+// NewRecordFinder is used to create a tns.RecordFinder finding records in db, and
+// getting their latest version from ipfs, for resolving names with a tns.Resolver.
+// As zone names are only unique per user, the zone created first is used
+func NewRecordFinder(db *gorm.DB, ipfs *rtfs.IpfsManager) tns.RecordFinder {
+	return &dbRecordFinder{db: db, rm: models.NewRecordManager(db), ipfs: ipfs}
+}
+
+func (f *dbRecordFinder) FindRecord(zoneName, recordName, recordType string) (*tns.Record, error) {
+	var zone models.Zone
+	err := f.db.Where("name = ?", zoneName).First(&zone).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, tns.ErrZoneNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	record, err := findExistingRecord(
+		f.rm, f.ipfs, zone.UserName, zoneName, recordName, recordType,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, tns.ErrRecordNotFound
+	}
+	return record, nil
+}
+
+// rtfsZonePublisher is a ZonePublisher using the ipfs keystore and node
+type rtfsZonePublisher struct {
+	keystore *rtfs.KeystoreManager
+	ipfs     *rtfs.IpfsManager
+}
+
+func (p *rtfsZonePublisher) EnsureKey(name string) (string, error) {
+	exists, err := p.keystore.CheckIfKeyExists(name)
+	if err != nil {
+		return "", err
+	}
+	var pk ci.PrivKey
+	if exists {
+		pk, err = p.keystore.GetPrivateKeyByName(name)
+	} else {
+		pk, err = p.keystore.CreateAndSaveKey(name, ci.Ed25519, 256)
+	}
+	if err != nil {
+		return "", err
+	}
+	id, err := peer.IDFromPublicKey(pk.GetPublic())
+	if err != nil {
+		return "", err
+	}
+	return id.Pretty(), nil
+}
+
+func (p *rtfsZonePublisher) PutZone(zone tns.Zone) (string, error) {
+	marshaled, err := json.Marshal(&zone)
+	if err != nil {
+		return "", err
+	}
+	return p.ipfs.DagPut(marshaled, "json", "cbor")
+}
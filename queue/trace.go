@@ -0,0 +1,99 @@
+package queue
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation name our spans are reported under
+const tracerName = "github.com/RTradeLtd/Temporal/queue"
+
+// propagator is used to carry span contexts in message headers
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// headerCarrier adapts amqp message headers for use by our propagator
+type headerCarrier amqp.Table
+
+// Get is used to retrieve a header, returning an empty string if it is not set
+func (c headerCarrier) Get(key string) string {
+	v, _ := c[key].(string)
+	return v
+}
+
+// Set is used to set a header
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys is used to list the headers which are set
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// injectTrace is used to add the span context carried by ctx to the given
+// headers, which are returned unchanged when tracing is disabled
+func (qm *Manager) injectTrace(ctx context.Context, headers amqp.Table) amqp.Table {
+	if qm.TracerProvider == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return headers
+	}
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	propagator.Inject(ctx, headerCarrier(headers))
+	return headers
+}
+
+// startSpan is used to start a span around the processing of a delivery, as a
+// child of the span which published it. When tracing is disabled, ctx is
+// returned along with a span which records nothing.
+func (qm *Manager) startSpan(ctx context.Context, d amqp.Delivery) (context.Context, trace.Span) {
+	if qm.TracerProvider == nil {
+		return ctx, noop.Span{}
+	}
+	if d.Headers != nil {
+		ctx = propagator.Extract(ctx, headerCarrier(d.Headers))
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.destination", qm.QueueName),
+	}
+	// not every message is sent on behalf of a user or network
+	var owner struct {
+		UserName    string `json:"user_name"`
+		NetworkName string `json:"network_name"`
+	}
+	if err := peek(d, &owner); err == nil {
+		if owner.UserName != "" {
+			attrs = append(attrs, attribute.String("user_name", owner.UserName))
+		}
+		if owner.NetworkName != "" {
+			attrs = append(attrs, attribute.String("network_name", owner.NetworkName))
+		}
+	}
+	return qm.TracerProvider.Tracer(tracerName).Start(ctx, qm.QueueName+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan is used to finish a span, recording the handler's error if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
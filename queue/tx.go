@@ -0,0 +1,63 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// TxPublisher is used to publish messages as part of an AMQP transaction
+type TxPublisher struct {
+	qm *Manager
+	ch *amqp.Channel
+}
+
+// Publish is used to publish a message to the manager's queue within the transaction
+func (tx TxPublisher) Publish(body interface{}) error {
+	return tx.qm.publish(context.Background(), tx.ch, "", tx.qm.QueueName, body)
+}
+
+// PublishToQueue is used to publish a message to another queue within the transaction,
+// allowing related messages such as a zone and its records to be sent together
+func (tx TxPublisher) PublishToQueue(queueName string, body interface{}) error {
+	return tx.qm.publish(context.Background(), tx.ch, "", queueName, body)
+}
+
+// PublishWithExchange is used to publish a message to an exchange within the transaction
+func (tx TxPublisher) PublishWithExchange(body interface{}, exchangeName string) error {
+	return tx.qm.publish(context.Background(), tx.ch, exchangeName, "", body)
+}
+
+// Tx is used to publish several messages atomically. The messages published by fn
+// are committed if it returns nil, and rolled back if it returns an error, so that
+// either all or none of them reach the broker. Transactions need a connection to
+// rabbitmq, so they aren't supported with an injected broker.
+func (qm *Manager) Tx(fn func(TxPublisher) error) error {
+	if qm.Broker != nil {
+		return errors.New("transactions are not supported with an injected broker")
+	}
+	// once a channel is placed in transactional mode it can't leave it, so we
+	// open a dedicated channel instead of altering the one held by the manager.
+	// closing the channel discards any uncommitted messages should fn panic
+	conn := qm.connection()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return connectionError(err)
+	}
+	defer ch.Close()
+	if err = ch.Tx(); err != nil {
+		return err
+	}
+	if err = fn(TxPublisher{qm: qm, ch: ch}); err != nil {
+		if rbErr := ch.TxRollback(); rbErr != nil {
+			return fmt.Errorf("%w: failed to rollback transaction: %s", err, rbErr)
+		}
+		return err
+	}
+	return ch.TxCommit()
+}
@@ -0,0 +1,27 @@
+package queue_test
+
+import (
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/queue"
+)
+
+func TestTx_InjectedBroker(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker)
+	called := false
+	err := qm.Tx(func(tx queue.TxPublisher) error {
+		called = true
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected transactions to be refused with an injected broker")
+	}
+	if called {
+		t.Fatal("expected fn not to be called")
+	}
+	if broker.Len(queue.IpfsPinQueue) != 0 {
+		t.Fatal("expected nothing to be published")
+	}
+}
@@ -1,13 +1,22 @@
 package queue
 
 import (
+	"crypto/tls"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/RTradeLtd/Temporal/tns"
 )
 
-// Various variables used by our queue package
+// Various variables used by our queue package. Each of our named queues is declared
+// durable, neither exclusive nor auto-deleted, by default, so that queued messages
+// survive both broker and consumer restarts. Queues whose messages needn't outlive
+// their consumers, such as reply queues, may be declared with WithDurable(false),
+// WithAutoDelete and WithExclusive.
 
 var (
 	nilTime time.Time
@@ -15,6 +24,8 @@ var (
 	DatabaseFileAddQueue = "dfa-queue"
 	// IpfsPinQueue is a queue used for ipfs pins
 	IpfsPinQueue = "ipfs-pin-queue"
+	// IpfsBulkPinQueue is a queue used for pinning many cids at once
+	IpfsBulkPinQueue = "ipfs-bulk-pin-queue"
 	// IpfsFileQueue is a queue used for advanced file adds
 	IpfsFileQueue = "ipfs-file-queue"
 	// IpfsClusterPinQueue is a queue used for ipfs cluster pins
@@ -23,8 +34,12 @@ var (
 	EmailSendQueue = "email-send-queue"
 	// IpnsEntryQueue is a queue used to handle ipns entry creation
 	IpnsEntryQueue = "ipns-entry-queue"
+	// IpnsUpdateQueue is a queue used to republish ipns records
+	IpnsUpdateQueue = "ipns-update-queue"
 	// IpfsKeyCreationQueue is a queue used to handle ipfs key creation
 	IpfsKeyCreationQueue = "ipfs-key-creation-queue"
+	// IpfsKeyDeletionQueue is a queue used to handle ipfs key deletion
+	IpfsKeyDeletionQueue = "ipfs-key-deletion-queue"
 	// PaymentCreationQueue is a queue used to handle payment processing
 	PaymentCreationQueue = "payment-creation-queue"
 	// PaymentConfirmationQueue is a queue used to handle payment confirmations
@@ -37,6 +52,16 @@ var (
 	ZoneCreationQueue = "zone-creation-queue"
 	// RecordCreationQueue is a queue used to handle tns record creation
 	RecordCreationQueue = "record-creation-queue"
+	// RecordDeletionQueue is a queue used to handle tns record deletion
+	RecordDeletionQueue = "record-deletion-queue"
+	// ZoneExportQueue is a queue used to request signed exports of tns zones
+	ZoneExportQueue = "zone-export-queue"
+	// ZoneImportQueue is a queue used to restore tns zones from exports
+	ZoneImportQueue = "zone-import-queue"
+	// ClusterPinStatusQueue is a queue used to ask the cluster for the replication status of pins
+	ClusterPinStatusQueue = "ipfs-cluster-pin-status-queue"
+	// CreditRefundQueue is a queue used to refund the credits of failed operations
+	CreditRefundQueue = "credit-refund-queue"
 	// AdminEmail is the email used to notify RTrade about any critical errors
 	AdminEmail = "temporal.reports@rtradetechnologies.com"
 	// IpfsPinFailedContent is a to-be formatted message sent on IPFS pin failures
@@ -47,6 +72,14 @@ var (
 	IpfsFileFailedContent = "IPFS File Add Failed for object name %s on IPFS network %s"
 	// IpfsFileFailedSubject is a subject for ipfs file add fails
 	IpfsFileFailedSubject = "IPFS File Add Failed"
+	// IpfsFileEncryptionFailedContent is a to be formatted message sent when a file flagged as encrypted isn't
+	IpfsFileEncryptionFailedContent = "IPFS File Add refused for object name %s on IPFS network %s, as it was flagged as encrypted but is not encrypted. Nothing was added to IPFS, and your credits have been refunded"
+	// IpfsFileEncryptionFailedSubject is a subject for ipfs file adds refused as they aren't encrypted
+	IpfsFileEncryptionFailedSubject = "IPFS File Add Refused: Not Encrypted"
+	// IpfsBulkPinFailedContent is a to be formatted message sent when pins of a bulk pin fail
+	IpfsBulkPinFailedContent = "Pinning %v content hashes on IPFS network %s failed"
+	// IpfsPrivateNetworkUnauthorizedContent is a to be formatted message sent whenever someone tries to access a bad private network
+	IpfsPrivateNetworkUnauthorizedContent = "Your request to use IPFS private network %s was refused, as you are not authorized to use it"
 	// IpfsPrivateNetworkUnauthorizedSubject is a subject whenever someone tries to access a bad private network
 	IpfsPrivateNetworkUnauthorizedSubject = "Unauthorized access to IPFS private network"
 	// IpfsInitializationFailedSubject is a subject used when connecting to ipfs fails
@@ -57,6 +90,18 @@ var (
 	IpnsEntryFailedContent = "IPNS Entry creation failed for content hash %s using key %s for reason %s"
 	// PaymentConfirmationFailedSubject is a subject used when payment confirmations fail
 	PaymentConfirmationFailedSubject = "Payment Confirmation Failed"
+	// HandlerPanicSubject is a subject used when a consumer's handler panics
+	HandlerPanicSubject = "Queue Handler Panicked"
+	// HandlerFailingSubject is a subject used when a consumer repeatedly fails to process messages
+	HandlerFailingSubject = "Queue Handler Failing"
+	// ConnectionLostSubject is a subject used when the connection to rabbitmq drops
+	ConnectionLostSubject = "Connection to RabbitMQ lost"
+	// ConsumerLagSubject is a subject used when a consumer falls behind its lag watchdog's threshold
+	ConsumerLagSubject = "Queue Consumer Falling Behind"
+	// InsufficientCreditsSubject is a subject used when a user can't afford a request
+	InsufficientCreditsSubject = "Insufficient Credits"
+	// InsufficientCreditsContent is a to be formatted message sent when a user can't afford a request
+	InsufficientCreditsContent = "Your request on queue %s was not processed, as it costs %v credits which exceeds your balance"
 	// PaymentConfirmationFailedContent is a content used when a payment confirmation failure occurs
 	PaymentConfirmationFailedContent = "Payment failed for content hash %s with error %s"
 )
@@ -70,12 +115,211 @@ type Manager struct {
 	QueueName    string
 	Service      string
 	ExchangeName string
+	// Options controls how the queue is declared
+	Options QueueOptions
+	// PrefetchCount limits the number of unacknowledged messages the broker
+	// sends a consumer, and defaults to 1. As messages are acknowledged once
+	// processed, this bounds how many messages a consumer holds in memory, with
+	// the broker holding back further messages until earlier ones are acked.
+	// Raising it allows more parallelism on queues with lightweight messages.
+	PrefetchCount int
+	// Workers is the number of messages consumers process concurrently, defaulting
+	// to 1. Messages may complete out of order when greater than 1
+	Workers int
+	// TLSConfig is used when dialing amqps urls
+	TLSConfig *tls.Config
+	// ConnectionOpts tunes the heartbeat, dial timeout and locale used when dialing
+	ConnectionOpts ConnectionOpts
+	// SigningSecret is used to sign published messages with hmac-sha256, and to
+	// reject consumed messages which are unsigned or whose signature doesn't
+	// match. Signing and verification are skipped when no secret is set.
+	SigningSecret []byte
+	// TracerProvider is used to trace messages across queues, and may be nil
+	TracerProvider trace.TracerProvider
+	// Idempotency is used to skip messages which have already been processed,
+	// remembering them for IdempotencyWindow, and may be nil
+	Idempotency       IdempotencyStore
+	IdempotencyWindow time.Duration
+	// Metrics is used to instrument publishing and consuming, and may be nil
+	Metrics *Metrics
+	// Policy is optionally used to fill in hold times, credit costs and networks
+	// that were left unset by producers
+	Policy *PublishPolicy
+	// Middleware is applied around the handlers of ConsumeMessageContext in order,
+	// with the first being outermost
+	Middleware []Middleware
+	// Broker is optionally used to publish and consume messages rather than
+	// the manager's rabbitmq channel, such as a MemoryBroker in tests
+	Broker Broker
+	// DryRun validates, signs and encodes published messages as usual, but logs
+	// them rather than publishing them, for testing producers against production
+	// configuration. It is intended for producers, as consumers in dry run mode
+	// acknowledge messages they would otherwise move to another queue.
+	DryRun bool
+	// Codec is used to marshal published messages, falling back to json for
+	// messages it doesn't support, and defaults to json when nil
+	Codec Codec
+	// CompressionThreshold is the size in bytes from which published messages
+	// are gzip compressed, with 0 disabling compression. Consumers decompress
+	// messages regardless.
+	CompressionThreshold int
+	// MaxMessageSize is the largest body in bytes, once compressed, which is
+	// published, with larger messages refused with ErrMessageTooLarge rather than
+	// sent to the broker. It defaults to DefaultMaxMessageSize.
+	MaxMessageSize int
+	// Billing publishes a copy of each message published to a queue to the
+	// BillingQueue exchange, routed by the queue's name, for BillingAggregate
+	Billing bool
+	// Audit publishes a copy of each message published to a queue to the
+	// AuditQueue exchange, routed by the queue's name, for AuditLog
+	Audit bool
+	// Balances is optionally used to charge users the credit cost of consumed
+	// messages before they're handled, refusing those they can't afford
+	Balances BalanceChecker
+	// NetworkMultipliers multiply the credit cost users are charged and refunded
+	// by the network of their message, with networks not listed costing 1x
+	NetworkMultipliers NetworkMultipliers
+	// TimestampTolerance optionally refuses consumed messages whose timestamp is
+	// too far from our clock, dead lettering them
+	TimestampTolerance TimestampTolerance
+	// Authorizer is optionally used to refuse consumed messages for networks
+	// their user isn't authorized to use
+	Authorizer NetworkAuthorizer
+	// UserRateLimit limits the rate at which each user's messages are processed,
+	// using the token buckets held by RateLimits, with excess messages postponed
+	// rather than dropped. Messages aren't limited when either is unset.
+	UserRateLimit RateLimit
+	RateLimits    RateLimitStore
+	// ResolverCache is optionally invalidated by the tns consumers, as
+	// configured with WithResolverCache
+	ResolverCache *tns.ResolverCache
+	// LagWatchdog optionally alerts when consumers fall behind
+	LagWatchdog *LagWatchdog
+	// Backpressure optionally blocks publishing to queues which are full, until
+	// their consumers have drained them
+	Backpressure *Backpressure
+	// AdminNotifyInterval is the minimum time between admin notifications with the
+	// same subject, defaulting to DefaultAdminNotifyInterval
+	AdminNotifyInterval time.Duration
+	// HandlerTimeout is how long handlers may take to process a message before
+	// it is abandoned, cancelling the handler's context, with 0 never abandoning
+	// messages. Abandoned messages are requeued, or with MaxHandlerTimeouts set
+	// are dead lettered once they have been abandoned that many times.
+	HandlerTimeout     time.Duration
+	MaxHandlerTimeouts int
+	// MaxAbandonedHandlers is the number of abandoned handlers which may still be
+	// running before consumers wait for one to return before handling another
+	// message, defaulting to DefaultMaxAbandonedHandlers
+	MaxAbandonedHandlers int
+	// RetryLaterDelay is how long messages whose handler returned ErrRetryLater are
+	// postponed for before being redelivered, defaulting to DefaultRetryLaterDelay
+	RetryLaterDelay time.Duration
+	// Mandatory publishes messages with the mandatory flag, so that the broker
+	// returns those it can't route to any queue rather than dropping them. Returned
+	// messages are logged, and moved to the dead letter queue when the queue has one.
+	Mandatory bool
+	// Expiration is the default ttl of published messages, after which the
+	// broker discards them if they haven't been consumed. Expired messages are
+	// dead lettered when the queue has dead lettering enabled. Messages don't
+	// expire by default, and WithExpiration overrides this per message.
+	Expiration time.Duration
+
+	// mu guards Connection and Channel, which are replaced on reconnection,
+	// along with our shutdown state
+	mu       sync.RWMutex
+	recon    *reconnector
+	confirm  *confirmer
+	closed   bool
+	done     chan struct{}
+	abort    chan struct{}
+	inflight sync.WaitGroup
+	// delay is the mechanism chosen for delayed publishing when declaring
+	delay DelayMechanism
+	// admin rate limits admin notifications
+	admin adminLimiter
+	// lag holds the recent lags of consumed messages for LagWatchdog
+	lag lagTracker
+	// pressure holds the depths of the queues published to for Backpressure
+	pressure pressure
+	// abandoned counts the handlers which timed out but are still running
+	abandoned abandonedHandlers
+	// url is the broker's url, used to re-dial it
+	url string
+	// consumers holds the tags of our running consumers, which are false once
+	// cancelled
+	consumers map[string]bool
+}
+
+// QueueOptions is used to control how a Manager declares its queue
+type QueueOptions struct {
+	// Transient queues are lost when the broker restarts, while queues are
+	// durable by default so that our messages survive
+	Transient bool
+	// AutoDelete queues are deleted by the broker once their last consumer is
+	// cancelled, and auto-delete exchanges once their last queue is unbound
+	AutoDelete bool
+	// Exclusive queues may only be used by the connection declaring them, and are
+	// deleted when it closes, making them suitable for transient reply queues. As
+	// they don't outlive their connection, exclusive queues must be transient.
+	Exclusive bool
+	// ExchangeType is the type of exchange the manager's exchange is declared as,
+	// such as amqp.ExchangeDirect, amqp.ExchangeFanout or amqp.ExchangeTopic. The
+	// exchange is assumed to exist when unset, and is declared durable unless the
+	// queue is transient. Queues are bound with an empty routing key, except with
+	// NetworkRouting, which always uses a topic exchange.
+	ExchangeType string
+	// DeadLetter enables a <queue>-dlx dead letter queue which messages that fail
+	// processing are moved to, rather than being acknowledged and lost
+	DeadLetter bool
+	// NetworkRouting declares the manager's exchange as a topic exchange which
+	// messages are routed through by their network name
+	NetworkRouting bool
+	// Networks are the networks whose messages are routed to the queue when
+	// NetworkRouting is enabled
+	Networks []string
+	// AlternateExchange declares the manager's exchange with an alternate exchange,
+	// capturing the messages it can't route to any queue, such as those for a
+	// network without consumers, in the queue named by UnroutableName rather than
+	// the broker dropping them. It requires the exchange to be declared by the
+	// manager, and as the broker refuses to redeclare an exchange with different
+	// arguments, enabling it for an existing exchange requires it to be deleted first.
+	AlternateExchange bool
+	// Delay enables delayed publishing with the given mechanism
+	Delay DelayMechanism
+	// MaxPriority declares the queue as a priority queue supporting priorities
+	// up to the given value, with 0 declaring a regular queue. Priorities set
+	// when publishing are ignored by regular queues, and as the broker refuses
+	// to redeclare a queue with a different max priority, changing it requires
+	// the queue to be deleted and recreated.
+	MaxPriority uint8
+}
+
+// UserNamed is implemented by queue messages that belong to a single user,
+// allowing generic code to read the user without knowing the message type
+type UserNamed interface {
+	GetUserName() string
+}
+
+// NetworkNamed is implemented by queue messages that belong to a single network,
+// allowing them to be routed by network
+type NetworkNamed interface {
+	GetNetworkName() string
 }
 
 // Queue Messages - These are used to format messages to send through rabbitmq
 
+// The key types which may be created
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeED25519 = "ed25519"
+)
+
+// MinRSAKeySize is the smallest size, in bits, of rsa keys which may be created
+var MinRSAKeySize = 2048
+
 // IPFSKeyCreation is a message used for processing key creation
-// only supported for the public IPFS network at the moment
+// only supported for the public IPFS network at the moment. Size
+// is required for rsa keys, and must be unset for ed25519 keys
 type IPFSKeyCreation struct {
 	UserName    string  `json:"user_name"`
 	Name        string  `json:"name"`
@@ -85,6 +329,14 @@ type IPFSKeyCreation struct {
 	CreditCost  float64 `json:"credit_cost"`
 }
 
+// IPFSKeyDeletion is a message used for deleting a key, which like key
+// creation is only supported for the public IPFS network at the moment
+type IPFSKeyDeletion struct {
+	UserName    string `json:"user_name"`
+	Name        string `json:"name"`
+	NetworkName string `json:"network_name"`
+}
+
 // IPFSPin is a struct used when sending pin request
 type IPFSPin struct {
 	CID              string  `json:"cid"`
@@ -94,6 +346,22 @@ type IPFSPin struct {
 	CreditCost       float64 `json:"credit_cost"`
 }
 
+// MaxBulkPinSize is the largest number of cids which may be pinned by a single
+// bulk pin message
+var MaxBulkPinSize = 1000
+
+// IPFSBulkPin is a message used to pin many cids at once, such as when re-pinning
+// a user's account, sharing the network, user and hold time between them.
+// CreditCost is the cost of the whole batch, with failed pins being refunded
+// their share of it
+type IPFSBulkPin struct {
+	CIDs             []string `json:"cids"`
+	NetworkName      string   `json:"network_name"`
+	UserName         string   `json:"user_name"`
+	HoldTimeInMonths int64    `json:"hold_time_in_months"`
+	CreditCost       float64  `json:"credit_cost"`
+}
+
 // IPFSFile is our message for the ipfs file queue
 type IPFSFile struct {
 	// MinioHostIP is the ip address of the minio host this object is stored on
@@ -104,7 +372,7 @@ type IPFSFile struct {
 	ObjectName       string  `json:"object_name"`
 	UserName         string  `json:"user_name"`
 	NetworkName      string  `json:"network_name"`
-	HoldTimeInMonths string  `json:"hold_time_in_months"`
+	HoldTimeInMonths int64   `json:"hold_time_in_months"`
 	CreditCost       float64 `json:"credit_cost"`
 	Encrypted        bool    `json:"encrypted"`
 }
@@ -118,6 +386,30 @@ type IPFSClusterPin struct {
 	CreditCost       float64 `json:"credit_cost"`
 }
 
+// PinStatusRequest is used to ask the cluster consumer for the replication status
+// of a pin, which replies with a PinStatusResponse
+type PinStatusRequest struct {
+	CID         string `json:"cid"`
+	NetworkName string `json:"network_name"`
+}
+
+// PinStatusResponse is the cluster consumer's reply to a PinStatusRequest
+type PinStatusResponse struct {
+	CID   string          `json:"cid"`
+	Peers []PeerPinStatus `json:"peers"`
+	// Error is set when the status couldn't be retrieved
+	Error string `json:"error,omitempty"`
+}
+
+// PeerPinStatus is the state of a pin on a single cluster peer, such as pinned,
+// pinning, pin_error or unpinned
+type PeerPinStatus struct {
+	PeerID   string `json:"peer_id"`
+	PeerName string `json:"peer_name,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
 // DatabaseFileAdd is a struct used when sending data to rabbitmq
 type DatabaseFileAdd struct {
 	Hash             string  `json:"hash"`
@@ -129,15 +421,15 @@ type DatabaseFileAdd struct {
 
 // IPNSUpdate is our message for the ipns update queue
 type IPNSUpdate struct {
-	CID         string  `json:"content_hash"`
-	IPNSHash    string  `json:"ipns_hash"`
-	LifeTime    string  `json:"life_time"`
-	TTL         string  `json:"ttl"`
-	Key         string  `json:"key"`
-	Resolve     bool    `json:"resolve"`
-	UserName    string  `json:"user_name"`
-	NetworkName string  `json:"network_name"`
-	CreditCost  float64 `json:"credit_cost"`
+	CID         string   `json:"content_hash"`
+	IPNSHash    string   `json:"ipns_hash"`
+	LifeTime    Duration `json:"life_time"`
+	TTL         Duration `json:"ttl"`
+	Key         string   `json:"key"`
+	Resolve     bool     `json:"resolve"`
+	UserName    string   `json:"user_name"`
+	NetworkName string   `json:"network_name"`
+	CreditCost  float64  `json:"credit_cost"`
 }
 
 // EmailSend is a helper struct used to contained formatted content ot send as an email
@@ -147,18 +439,31 @@ type EmailSend struct {
 	ContentType string   `json:"content_type"`
 	UserNames   []string `json:"user_names"`
 	Emails      []string `json:"emails,omitempty"`
+	// Attachments are optional files attached to the email
+	Attachments []EmailAttachment `json:"attachments,omitempty"`
+	// TemplateName optionally names the template the email is rendered with,
+	// using TemplateData, in which case Content may be omitted
+	TemplateName string                 `json:"template_name,omitempty"`
+	TemplateData map[string]interface{} `json:"template_data,omitempty"`
+}
+
+// EmailAttachment is a file attached to an email, with its content base64 encoded
+type EmailAttachment struct {
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Content  string `json:"content"`
 }
 
 // IPNSEntry is used to hold relevant information needed to process IPNS entry creation requests
 type IPNSEntry struct {
-	CID         string        `json:"cid"`
-	LifeTime    time.Duration `json:"life_time"`
-	TTL         time.Duration `json:"ttl"`
-	Resolve     bool          `json:"resolve"`
-	Key         string        `json:"key"`
-	UserName    string        `json:"user_name"`
-	NetworkName string        `json:"network_name"`
-	CreditCost  float64       `json:"credit_cost"`
+	CID         string   `json:"cid"`
+	LifeTime    Duration `json:"life_time"`
+	TTL         Duration `json:"ttl"`
+	Resolve     bool     `json:"resolve"`
+	Key         string   `json:"key"`
+	UserName    string   `json:"user_name"`
+	NetworkName string   `json:"network_name"`
+	CreditCost  float64  `json:"credit_cost"`
 }
 
 // PaymentCreation is for the payment creation queue
@@ -166,26 +471,65 @@ type PaymentCreation struct {
 	TxHash     string `json:"tx_hash"`
 	Blockchain string `json:"blockchain"`
 	UserName   string `json:"user_name"`
+	// IdempotencyKey optionally identifies the payment, so that it is only
+	// processed once. When unset, the message contents identify it
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+// CreditRefund is used to credit a user back for an operation which was paid for
+// but abandoned after failing
+type CreditRefund struct {
+	UserName string  `json:"user_name"`
+	Amount   float64 `json:"amount"`
+	Reason   string  `json:"reason"`
+	// OriginalQueue is the queue of the abandoned operation's message
+	OriginalQueue string `json:"original_queue"`
+	// IdempotencyKey identifies the abandoned operation's message, so that
+	// it is only refunded once
+	IdempotencyKey string `json:"idempotency_key"`
 }
 
-// DashPaymenConfirmation is a message used to signal processing of a dash payment
-type DashPaymenConfirmation struct {
+// DashPaymentConfirmation is a message used to signal processing of a dash payment.
+// It remains a specialization of PaymentConfirmation, as dash payments are
+// confirmed through their payment forward rather than by transaction
+type DashPaymentConfirmation struct {
 	UserName         string `json:"user_name"`
 	PaymentForwardID string `json:"payment_forward_id"`
 	PaymentNumber    int64  `json:"payment_number"`
+	// IdempotencyKey optionally identifies the payment, so that it is only
+	// processed once. When unset, the message contents identify it
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
+// DashPaymenConfirmation is the original, misspelled, name of DashPaymentConfirmation.
+//
+// Deprecated: use DashPaymentConfirmation instead.
+type DashPaymenConfirmation = DashPaymentConfirmation
+
 // PaymentConfirmation is a message used to confirm a payment
 type PaymentConfirmation struct {
 	UserName      string `json:"user_name"`
 	PaymentNumber int64  `json:"payment_number"`
+	// Blockchain and TxHash identify the payment's transaction, which
+	// must have ConfirmationsRequired confirmations before the payment is
+	// finalized. They may be omitted when no confirmations are required
+	Blockchain            string `json:"blockchain,omitempty"`
+	TxHash                string `json:"tx_hash,omitempty"`
+	ConfirmationsRequired int64  `json:"confirmations_required,omitempty"`
+	// IdempotencyKey optionally identifies the payment, so that it is only
+	// processed once. When unset, the message contents identify it
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 // MongoUpdate is an update used to trigger
 type MongoUpdate struct {
-	DatabaseName   string            `json:"database_name"`
-	CollectionName string            `json:"collection_name"`
-	Fields         map[string]string `json:"fields"`
+	DatabaseName   string `json:"database_name"`
+	CollectionName string `json:"collection_name"`
+	// Fields are string fields set by the update, as with a $set operation
+	Fields map[string]string `json:"fields,omitempty"`
+	// Operations are optional typed operations applied along with Fields, such as
+	// incrementing a balance atomically, see MongoUpdate.Document
+	Operations []MongoOperation `json:"operations,omitempty"`
 }
 
 // ZoneCreation is used for creating tns zones
@@ -203,4 +547,182 @@ type RecordCreation struct {
 	RecordKeyName string                 `json:"record_key_name"`
 	MetaData      map[string]interface{} `json:"meta_data"`
 	UserName      string                 `json:"user_name"`
+	// RecordType is one of the tns record types, and may be empty
+	RecordType string `json:"record_type,omitempty"`
+	// Value is what the record points to, such as an ipfs path for DNSLINK
+	// records, and is required by DNSLINK and IPNS records
+	Value string `json:"value,omitempty"`
+	// TTL is how long resolvers may cache the record for, defaulting
+	// to tns.DefaultRecordTTL when unset
+	TTL Duration `json:"ttl,omitempty"`
+	// Replace overwrites an existing record of the same name and type, which is
+	// otherwise added to, forming a record set of the values of both
+	Replace bool `json:"replace,omitempty"`
+}
+
+// RecordDeletion is a message used when deleting a record. RecordKeyName is
+// optional, and when set must match the key of the record being deleted
+type RecordDeletion struct {
+	ZoneName      string `json:"zone_name"`
+	RecordName    string `json:"record_name"`
+	RecordKeyName string `json:"record_key_name,omitempty"`
+	UserName      string `json:"user_name"`
+	// RecordType is the type of the record set deleted, as a name holds a record
+	// set of each type, and is empty for records without a type
+	RecordType string `json:"record_type,omitempty"`
+}
+
+// ZoneExport is a message used to request a signed export of a zone and its records
+type ZoneExport struct {
+	ZoneName string `json:"zone_name"`
+	UserName string `json:"user_name"`
+}
+
+// ZoneExportResponse is the reply to a ZoneExport request, with Error being set
+// instead of Document when the zone couldn't be exported
+type ZoneExportResponse struct {
+	Document *tns.ZoneDocument `json:"document,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// ZoneImport is a message used to restore a zone for a user from an export. The
+// zone is rejected if it already exists, or the document fails verification
+type ZoneImport struct {
+	UserName string           `json:"user_name"`
+	Document tns.ZoneDocument `json:"document"`
+}
+
+// GetUserName returns the user the message belongs to
+func (i IPFSKeyCreation) GetUserName() string {
+	return i.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (i IPFSKeyDeletion) GetUserName() string {
+	return i.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (i IPFSPin) GetUserName() string {
+	return i.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (i IPFSBulkPin) GetUserName() string {
+	return i.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (i IPFSFile) GetUserName() string {
+	return i.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (i IPFSClusterPin) GetUserName() string {
+	return i.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (d DatabaseFileAdd) GetUserName() string {
+	return d.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (i IPNSUpdate) GetUserName() string {
+	return i.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (i IPNSEntry) GetUserName() string {
+	return i.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (p PaymentCreation) GetUserName() string {
+	return p.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (d DashPaymentConfirmation) GetUserName() string {
+	return d.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (p PaymentConfirmation) GetUserName() string {
+	return p.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (z ZoneCreation) GetUserName() string {
+	return z.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (r RecordCreation) GetUserName() string {
+	return r.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (r RecordDeletion) GetUserName() string {
+	return r.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (z ZoneExport) GetUserName() string {
+	return z.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (z ZoneImport) GetUserName() string {
+	return z.UserName
+}
+
+// GetUserName returns the user the message belongs to
+func (c CreditRefund) GetUserName() string {
+	return c.UserName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (i IPFSKeyCreation) GetNetworkName() string {
+	return i.NetworkName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (i IPFSKeyDeletion) GetNetworkName() string {
+	return i.NetworkName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (i IPFSPin) GetNetworkName() string {
+	return i.NetworkName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (i IPFSBulkPin) GetNetworkName() string {
+	return i.NetworkName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (i IPFSFile) GetNetworkName() string {
+	return i.NetworkName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (i IPFSClusterPin) GetNetworkName() string {
+	return i.NetworkName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (d DatabaseFileAdd) GetNetworkName() string {
+	return d.NetworkName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (i IPNSUpdate) GetNetworkName() string {
+	return i.NetworkName
+}
+
+// GetNetworkName returns the network the message belongs to
+func (i IPNSEntry) GetNetworkName() string {
+	return i.NetworkName
 }
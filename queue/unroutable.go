@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// UnroutableName is used to get the name of the alternate exchange, and the queue
+// bound to it, which capture the messages an exchange can't route to any queue
+func UnroutableName(exchangeName string) string {
+	return exchangeName + "-unroutable"
+}
+
+// exchangeArgs is used to get the arguments the manager's exchange is declared with
+// according to its options
+func exchangeArgs(exchangeName string, opts QueueOptions) amqp.Table {
+	if !opts.AlternateExchange {
+		return nil
+	}
+	return amqp.Table{"alternate-exchange": UnroutableName(exchangeName)}
+}
+
+// declareFanoutQueue is used to declare a durable fanout exchange along with a queue
+// of the same name bound to it, capturing every message published to the exchange
+// regardless of its routing key, which the broker preserves
+func declareFanoutQueue(ch *amqp.Channel, name string) error {
+	if err := ch.ExchangeDeclare(
+		name,     // name
+		"fanout", // type
+		true,     // durable
+		false,    // auto-delete
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
+	); err != nil {
+		return err
+	}
+	if _, err := ch.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	); err != nil {
+		return err
+	}
+	return ch.QueueBind(
+		name,  // name of the queue
+		"",    // routing key
+		name,  // exchange
+		false, // no-wait
+		nil,   // arguments
+	)
+}
+
+// watchReturns is used to handle the messages the broker returns to us on ch, being
+// those published as mandatory which couldn't be routed to any queue. The broker's
+// returns must be drained, as they are delivered by the connection's reader, so each
+// is handled in the background. Watching stops once ch is closed.
+func (qm *Manager) watchReturns(ch *amqp.Channel) {
+	if !qm.Mandatory || ch == nil {
+		return
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 10))
+	go func() {
+		for ret := range returns {
+			go qm.handleReturn(ret)
+		}
+	}()
+}
+
+// handleReturn is used to log a message the broker couldn't route, and to move it to
+// the dead letter queue when the queue has one, recording why it was returned
+func (qm *Manager) handleReturn(ret amqp.Return) {
+	ctx := context.Background()
+	if id, ok := ret.Headers[HeaderCorrelationID].(string); ok && id != "" {
+		ctx = WithCorrelationID(ctx, id)
+	}
+	reason := fmt.Errorf("message returned as unroutable: %v %s", ret.ReplyCode, ret.ReplyText)
+	qm.LogEntry(ctx).WithFields(log.Fields{
+		"exchange":    ret.Exchange,
+		"routing_key": ret.RoutingKey,
+		"type":        ret.Type,
+		"reason":      reason.Error(),
+	}).Warn("message could not be routed to any queue")
+	if !qm.Options.DeadLetter || qm.QueueName == "" {
+		return
+	}
+	headers := amqp.Table{}
+	for k, v := range ret.Headers {
+		headers[k] = v
+	}
+	headers[HeaderFailureReason] = reason.Error()
+	headers[HeaderOriginalRoutingKey] = ret.RoutingKey
+	if err := qm.send(ctx, qm.channel(), DeadLetterName(qm.QueueName), ret.RoutingKey, amqp.Publishing{
+		Headers:         headers,
+		DeliveryMode:    amqp.Persistent,
+		ContentType:     ret.ContentType,
+		ContentEncoding: ret.ContentEncoding,
+		Type:            ret.Type,
+		MessageId:       ret.MessageId,
+		Timestamp:       ret.Timestamp,
+		Body:            ret.Body,
+	}); err != nil {
+		qm.logError(ctx, err, "failed to publish returned message to dead letter queue")
+	}
+}
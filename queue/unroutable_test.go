@@ -0,0 +1,89 @@
+package queue_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/streadway/amqp"
+)
+
+func TestUnroutableName(t *testing.T) {
+	if got := queue.UnroutableName("ipfs-pin"); got != "ipfs-pin-unroutable" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+// messages for a network without consumers are captured by the alternate exchange
+func TestPublishToNetwork_AlternateExchange(t *testing.T) {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	exchange := "alternate-test"
+	qm, err := queue.NewManager(url, queue.WithNetworkRouting(exchange), queue.WithAlternateExchange())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qm.Close(context.Background())
+	if _, err = qm.Channel.QueuePurge(queue.UnroutableName(exchange), false); err != nil {
+		t.Fatal(err)
+	}
+	pin := queue.IPFSPin{CID: testCID, NetworkName: "private", UserName: "user", HoldTimeInMonths: 1}
+	if err = qm.PublishToNetwork(context.Background(), pin); err != nil {
+		t.Fatal(err)
+	}
+	d := waitForMessage(t, qm, queue.UnroutableName(exchange))
+	if d.RoutingKey != "private" {
+		t.Fatalf("got routing key %s", d.RoutingKey)
+	}
+}
+
+// mandatory messages the broker returns are moved to the dead letter queue
+func TestPublishMessageContext_Mandatory(t *testing.T) {
+	url := os.Getenv("RABBITMQ_URL")
+	if url == "" {
+		t.Skip("RABBITMQ_URL not set")
+	}
+	qm, err := queue.NewManager(url, queue.WithQueue("mandatory-test"), queue.WithDeadLetter(), queue.WithMandatory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qm.Close(context.Background())
+	dlq := queue.DeadLetterName(qm.QueueName)
+	if _, err = qm.Channel.QueuePurge(dlq, false); err != nil {
+		t.Fatal(err)
+	}
+	// with the queue gone its messages can't be routed
+	if _, err = qm.Channel.QueueDelete(qm.QueueName, false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = qm.PublishMessageContext(context.Background(), queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}); err != nil {
+		t.Fatal(err)
+	}
+	d := waitForMessage(t, qm, dlq)
+	if d.Headers[queue.HeaderFailureReason] == nil {
+		t.Fatal("expected the failure reason to be recorded")
+	}
+}
+
+// waitForMessage is used to get a message from a queue, failing the test if none
+// arrives in time
+func waitForMessage(t *testing.T, qm *queue.Manager, queueName string) amqp.Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		d, ok, err := qm.Channel.Get(queueName, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			return d
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("no message arrived in %s", queueName)
+	return amqp.Delivery{}
+}
@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"errors"
+
+	"github.com/RTradeLtd/database/models"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// uploadsUniqueIndex is the index making uploads unique per hash, user and network
+const uploadsUniqueIndex = "uploads_hash_user_name_network_name_key"
+
+// dbUploadStore is an UploadStore backed by our database
+type dbUploadStore struct {
+	db *gorm.DB
+}
+
+// NewUploadStore is used to create an UploadStore recording uploads in db. Uploads
+// are locked while being extended, while creating them relies on the unique index
+// added by MigrateUploads, with the second of two concurrent creations violating it
+// and being recorded again, which finds and locks the upload the first created.
+func NewUploadStore(db *gorm.DB) UploadStore {
+	return &dbUploadStore{db: db}
+}
+
+// MigrateUploads is used to add the unique index of the uploads table which
+// NewUploadStore relies on, ignoring soft deleted uploads. It fails while the table
+// holds duplicate uploads, which need merging first
+func MigrateUploads(db *gorm.DB) error {
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + uploadsUniqueIndex +
+		" ON uploads (hash, user_name, network_name) WHERE deleted_at IS NULL").Error
+}
+
+// RecordUpload is used to record an upload, recording it again should another
+// creation of the upload have won the race to create it
+func (s *dbUploadStore) RecordUpload(upload Upload) (UploadOutcome, error) {
+	outcome, err := s.recordUpload(upload)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == uploadsUniqueIndex {
+		outcome, err = s.recordUpload(upload)
+	}
+	return outcome, err
+}
+
+// recordUpload is used to record an upload within a transaction
+func (s *dbUploadStore) recordUpload(upload Upload) (UploadOutcome, error) {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	outcome := UploadUnchanged
+	var existing models.Upload
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Where(
+		"hash = ? AND user_name = ? AND network_name = ?",
+		upload.Hash, upload.UserName, upload.NetworkName,
+	).First(&existing).Error
+	switch {
+	case gorm.IsRecordNotFoundError(err):
+		outcome = UploadCreated
+		err = tx.Create(&models.Upload{
+			Hash:               upload.Hash,
+			Type:               "file",
+			NetworkName:        upload.NetworkName,
+			HoldTimeInMonths:   upload.HoldTimeInMonths,
+			UserName:           upload.UserName,
+			GarbageCollectDate: upload.Expiry,
+		}).Error
+	case err != nil:
+	case upload.Expiry.After(existing.GarbageCollectDate):
+		outcome = UploadExtended
+		err = tx.Model(&existing).Updates(map[string]interface{}{
+			"hold_time_in_months":  upload.HoldTimeInMonths,
+			"garbage_collect_date": upload.Expiry,
+		}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return outcome, tx.Commit().Error
+}
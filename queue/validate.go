@@ -0,0 +1,628 @@
+package queue
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/RTradeLtd/Temporal/tns"
+	cid "github.com/ipfs/go-cid"
+)
+
+// Limits applied to record meta data, which may be adjusted at startup
+var (
+	// MaxMetaDataSize is the largest size, in bytes, of serialized meta data
+	MaxMetaDataSize = 16 << 10
+	// MaxMetaDataKeys is the largest number of top level meta data keys
+	MaxMetaDataKeys = 64
+	// MaxMetaDataDepth is how deeply meta data values may be nested, with
+	// the meta data itself counting as the first level
+	MaxMetaDataDepth = 8
+)
+
+// Limits applied to email attachments, which may be adjusted at startup
+var (
+	// MaxAttachmentSize is the largest combined size, in bytes, of the decoded
+	// attachments of an email
+	MaxAttachmentSize = 5 << 20
+	// AttachmentTypes are the mime types emails may have attached
+	AttachmentTypes = []string{
+		"text/plain",
+		"text/csv",
+		"application/json",
+		"application/pdf",
+		"image/png",
+		"image/jpeg",
+	}
+)
+
+// validator is implemented by queue messages which are able to validate themselves
+type validator interface {
+	Validate() error
+}
+
+// Validate is used to validate an ipfs key creation message
+func (i IPFSKeyCreation) Validate() error {
+	if err := requireFields(
+		"user_name", i.UserName,
+		"name", i.Name,
+		"type", i.Type,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(i.NetworkName); err != nil {
+		return err
+	}
+	if err := validateKeyType(i.Type, i.Size); err != nil {
+		return err
+	}
+	// private networks don't yet have keystores of their own
+	if i.NetworkName != PublicNetwork {
+		return fmt.Errorf("keys can only be created on the %s network", PublicNetwork)
+	}
+	return validateCreditCost(i.CreditCost)
+}
+
+// Validate is used to validate a bulk pin message, checking the format of each cid
+func (i IPFSBulkPin) Validate() error {
+	if err := requireFields(
+		"user_name", i.UserName,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(i.NetworkName); err != nil {
+		return err
+	}
+	if len(i.CIDs) == 0 {
+		return errors.New("cids is required")
+	}
+	if len(i.CIDs) > MaxBulkPinSize {
+		return fmt.Errorf("%v cids exceeds the limit of %v", len(i.CIDs), MaxBulkPinSize)
+	}
+	for _, c := range i.CIDs {
+		if err := validateCID(c); err != nil {
+			return err
+		}
+	}
+	if err := validateHoldTime(i.HoldTimeInMonths); err != nil {
+		return err
+	}
+	return validateCreditCost(i.CreditCost)
+}
+
+// validateCID is used to check that a cid is well formed, accepting both v0 and
+// v1 cids, so that malformed cids are refused when published rather than failing
+// once they reach ipfs
+func validateCID(c string) error {
+	if _, err := cid.Decode(c); err != nil {
+		return fmt.Errorf("invalid cid %q: %w", c, err)
+	}
+	return nil
+}
+
+// Validate is used to validate a key deletion message
+func (i IPFSKeyDeletion) Validate() error {
+	if err := requireFields(
+		"user_name", i.UserName,
+		"name", i.Name,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(i.NetworkName); err != nil {
+		return err
+	}
+	if i.NetworkName != PublicNetwork {
+		return fmt.Errorf("keys can only be deleted on the %s network", PublicNetwork)
+	}
+	return nil
+}
+
+// validateKeyType is used to check that a key type is supported, and that its size
+// is appropriate for the type
+func validateKeyType(keyType string, size int) error {
+	switch keyType {
+	case KeyTypeRSA:
+		if size < MinRSAKeySize {
+			return fmt.Errorf("rsa keys must be at least %v bits", MinRSAKeySize)
+		}
+	case KeyTypeED25519:
+		// ed25519 keys are always 256 bits
+		if size != 0 {
+			return errors.New("size can't be set for ed25519 keys")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %q, must be one of %v", keyType, []string{KeyTypeRSA, KeyTypeED25519})
+	}
+	return nil
+}
+
+// Validate is used to validate an ipfs pin message
+func (i IPFSPin) Validate() error {
+	if err := requireFields(
+		"cid", i.CID,
+		"user_name", i.UserName,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(i.NetworkName); err != nil {
+		return err
+	}
+	if err := validateCID(i.CID); err != nil {
+		return err
+	}
+	if err := validateHoldTime(i.HoldTimeInMonths); err != nil {
+		return err
+	}
+	return validateCreditCost(i.CreditCost)
+}
+
+// Validate is used to validate an ipfs file message
+func (i IPFSFile) Validate() error {
+	if err := requireFields(
+		"minio_host_ip", i.MinioHostIP,
+		"bucket_name", i.BucketName,
+		"object_name", i.ObjectName,
+		"user_name", i.UserName,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(i.NetworkName); err != nil {
+		return err
+	}
+	if err := validateHoldTime(i.HoldTimeInMonths); err != nil {
+		return err
+	}
+	return validateCreditCost(i.CreditCost)
+}
+
+// Validate is used to validate an ipfs cluster pin message
+func (i IPFSClusterPin) Validate() error {
+	if err := requireFields(
+		"cid", i.CID,
+		"user_name", i.UserName,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(i.NetworkName); err != nil {
+		return err
+	}
+	if err := validateCID(i.CID); err != nil {
+		return err
+	}
+	if err := validateHoldTime(i.HoldTimeInMonths); err != nil {
+		return err
+	}
+	return validateCreditCost(i.CreditCost)
+}
+
+// Validate is used to validate a pin status request
+func (p PinStatusRequest) Validate() error {
+	if err := requireFields("cid", p.CID); err != nil {
+		return err
+	}
+	return validateNetworkName(p.NetworkName)
+}
+
+// Validate is used to validate a credit refund message
+func (c CreditRefund) Validate() error {
+	if err := requireFields(
+		"user_name", c.UserName,
+		"idempotency_key", c.IdempotencyKey,
+	); err != nil {
+		return err
+	}
+	if c.Amount <= 0 {
+		return errors.New("amount must be greater than 0")
+	}
+	return nil
+}
+
+// Validate is used to validate a database file add message
+func (d DatabaseFileAdd) Validate() error {
+	if err := requireFields(
+		"hash", d.Hash,
+		"user_name", d.UserName,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(d.NetworkName); err != nil {
+		return err
+	}
+	if err := validateCID(d.Hash); err != nil {
+		return err
+	}
+	if err := validateHoldTime(d.HoldTimeInMonths); err != nil {
+		return err
+	}
+	return validateCreditCost(d.CreditCost)
+}
+
+// Validate is used to validate an ipns update message
+func (i IPNSUpdate) Validate() error {
+	if err := requireFields(
+		"content_hash", i.CID,
+		"key", i.Key,
+		"user_name", i.UserName,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(i.NetworkName); err != nil {
+		return err
+	}
+	if err := validateCID(i.CID); err != nil {
+		return err
+	}
+	return validateCreditCost(i.CreditCost)
+}
+
+// Validate is used to validate an email send message
+func (e EmailSend) Validate() error {
+	if err := requireFields("subject", e.Subject); err != nil {
+		return err
+	}
+	if e.TemplateName == "" {
+		if err := requireFields("content", e.Content); err != nil {
+			return err
+		}
+	} else if _, ok := emailTemplates[e.TemplateName]; !ok {
+		return fmt.Errorf("unknown email template %s", e.TemplateName)
+	}
+	if len(e.UserNames) == 0 && len(e.Emails) == 0 {
+		return errors.New("at least one of user_names or emails is required")
+	}
+	return validateAttachments(e.Attachments)
+}
+
+// Validate is used to validate an ipns entry message
+func (i IPNSEntry) Validate() error {
+	if err := requireFields(
+		"cid", i.CID,
+		"key", i.Key,
+		"user_name", i.UserName,
+	); err != nil {
+		return err
+	}
+	if err := validateNetworkName(i.NetworkName); err != nil {
+		return err
+	}
+	if err := validateCID(i.CID); err != nil {
+		return err
+	}
+	if i.LifeTime <= 0 {
+		return errors.New("life_time must be greater than 0")
+	}
+	if i.TTL < 0 {
+		return errors.New("ttl can't be negative")
+	}
+	return validateCreditCost(i.CreditCost)
+}
+
+// Validate is used to validate a payment creation message
+func (p PaymentCreation) Validate() error {
+	return requireFields(
+		"tx_hash", p.TxHash,
+		"blockchain", p.Blockchain,
+		"user_name", p.UserName,
+	)
+}
+
+// Validate is used to validate a dash payment confirmation message
+func (d DashPaymentConfirmation) Validate() error {
+	if err := requireFields(
+		"user_name", d.UserName,
+		"payment_forward_id", d.PaymentForwardID,
+	); err != nil {
+		return err
+	}
+	if d.PaymentNumber < 0 {
+		return errors.New("payment_number can't be negative")
+	}
+	return nil
+}
+
+// Validate is used to validate a payment confirmation message
+func (p PaymentConfirmation) Validate() error {
+	if err := requireFields("user_name", p.UserName); err != nil {
+		return err
+	}
+	if p.PaymentNumber < 0 {
+		return errors.New("payment_number can't be negative")
+	}
+	if p.ConfirmationsRequired < 0 {
+		return errors.New("confirmations_required can't be negative")
+	}
+	if p.ConfirmationsRequired > 0 {
+		return requireFields(
+			"blockchain", p.Blockchain,
+			"tx_hash", p.TxHash,
+		)
+	}
+	return nil
+}
+
+// Validate is used to validate a mongo update message
+func (m MongoUpdate) Validate() error {
+	if err := requireFields(
+		"database_name", m.DatabaseName,
+		"collection_name", m.CollectionName,
+	); err != nil {
+		return err
+	}
+	if len(m.Fields) == 0 && len(m.Operations) == 0 {
+		return errors.New("fields or operations is required")
+	}
+	// mongo refuses updates operating on a field more than once
+	seen := make(map[string]bool, len(m.Fields)+len(m.Operations))
+	for field := range m.Fields {
+		seen[field] = true
+	}
+	for i, op := range m.Operations {
+		if err := op.validate(); err != nil {
+			return fmt.Errorf("operations[%v]: %w", i, err)
+		}
+		if seen[op.Field] {
+			return fmt.Errorf("operations[%v]: field %s is updated more than once", i, op.Field)
+		}
+		seen[op.Field] = true
+	}
+	return nil
+}
+
+// Validate is used to validate a zone creation message
+func (z ZoneCreation) Validate() error {
+	if err := requireFields(
+		"name", z.Name,
+		"manager_key_name", z.ManagerKeyName,
+		"zone_key_name", z.ZoneKeyName,
+		"user_name", z.UserName,
+	); err != nil {
+		return err
+	}
+	return ValidateZoneName(z.Name)
+}
+
+// normalizeNames is used to return a copy of a tns message with the names it looks
+// up given by tns.NormalizeName, so that every message type treats case the same
+// way. Names of records being created are left as given, as consumers keep them for
+// display
+func normalizeNames(body interface{}) interface{} {
+	switch msg := deref(body).(type) {
+	case ZoneCreation:
+		msg.Name = tns.NormalizeName(msg.Name)
+		return msg
+	case RecordCreation:
+		msg.ZoneName = tns.NormalizeName(msg.ZoneName)
+		return msg
+	case RecordDeletion:
+		msg.ZoneName, msg.RecordName = tns.NormalizeName(msg.ZoneName), tns.NormalizeName(msg.RecordName)
+		return msg
+	case ZoneExport:
+		msg.ZoneName = tns.NormalizeName(msg.ZoneName)
+		return msg
+	}
+	return body
+}
+
+// ValidateZoneName is used to check that a zone name is a valid dns name, following
+// RFC 1035 with the exception that labels may start with a digit. Names must be
+// given in lowercase, and a single trailing dot is permitted.
+func ValidateZoneName(name string) error {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return errors.New("zone name is empty")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("zone name is %v characters long, exceeding the limit of 253", len(name))
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 {
+			return fmt.Errorf("zone name %q contains an empty label", name)
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q is %v characters long, exceeding the limit of 63", label, len(label))
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q can't start or end with a hyphen", label)
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-':
+			case c >= 'A' && c <= 'Z':
+				return fmt.Errorf("label %q must be lowercase", label)
+			default:
+				return fmt.Errorf("label %q contains invalid character %q", label, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate is used to validate a record creation message
+func (r RecordCreation) Validate() error {
+	if err := requireFields(
+		"zone_name", r.ZoneName,
+		"record_name", r.RecordName,
+		"record_key_name", r.RecordKeyName,
+		"user_name", r.UserName,
+	); err != nil {
+		return err
+	}
+	if err := tns.ValidateRecordName(r.RecordName); err != nil {
+		return err
+	}
+	if r.TTL < 0 {
+		return errors.New("ttl can't be negative")
+	}
+	if err := validateMetaData(r.MetaData); err != nil {
+		return err
+	}
+	switch r.RecordType {
+	case tns.RecordTypeDNSLink, tns.RecordTypeIPNS:
+		if r.Value == "" {
+			return fmt.Errorf("value is required for %s records", r.RecordType)
+		}
+	case tns.RecordTypeCNAME:
+		// aliases point at the full TNS name of another record
+		if err := ValidateZoneName(r.Value); err != nil {
+			return fmt.Errorf("invalid alias target: %w", err)
+		}
+	}
+	return tns.ValidateRecordType(r.RecordType)
+}
+
+// Validate is used to validate a record deletion message
+func (r RecordDeletion) Validate() error {
+	if err := requireFields(
+		"zone_name", r.ZoneName,
+		"record_name", r.RecordName,
+		"user_name", r.UserName,
+	); err != nil {
+		return err
+	}
+	return tns.ValidateRecordType(r.RecordType)
+}
+
+// Validate is used to validate a zone export message
+func (z ZoneExport) Validate() error {
+	return requireFields(
+		"zone_name", z.ZoneName,
+		"user_name", z.UserName,
+	)
+}
+
+// Validate is used to validate a zone import message. The document's signature
+// is checked by the consumer, as it requires the zone's key
+func (z ZoneImport) Validate() error {
+	if err := requireFields(
+		"user_name", z.UserName,
+		"manager_key_name", z.Document.ManagerKeyName,
+		"zone_key_name", z.Document.ZoneKeyName,
+	); err != nil {
+		return err
+	}
+	if z.Document.Version < 1 || z.Document.Version > tns.ZoneDocumentVersion {
+		return tns.ErrUnsupportedVersion
+	}
+	return ValidateZoneName(z.Document.Zone.Name)
+}
+
+// requireFields is used to check that required fields are set, taking pairs
+// of field names and values and returning an error naming the first missing field
+func requireFields(fields ...string) error {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] == "" {
+			return fmt.Errorf("%s is required", fields[i])
+		}
+	}
+	return nil
+}
+
+// validateNetworkName is used to check that a network scoped message names its
+// network, as consumers would otherwise have to guess which was meant. Producers
+// may have a default applied with PublishPolicy.DefaultNetwork
+func validateNetworkName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("network_name is required")
+	}
+	if strings.TrimSpace(name) != name {
+		return fmt.Errorf("network_name %q can't have surrounding whitespace", name)
+	}
+	return nil
+}
+
+// validateHoldTime is used to check that a hold time is sensible
+func validateHoldTime(months int64) error {
+	if months <= 0 {
+		return errors.New("hold_time_in_months must be greater than 0")
+	}
+	return nil
+}
+
+// validateCreditCost is used to check that a credit cost is sensible
+func validateCreditCost(cost float64) error {
+	if cost < 0 {
+		return errors.New("credit_cost can't be negative")
+	}
+	return nil
+}
+
+// validateMetaData is used to check that record meta data is within our limits
+func validateMetaData(metaData map[string]interface{}) error {
+	if len(metaData) > MaxMetaDataKeys {
+		return fmt.Errorf("meta_data has %v keys, exceeding the limit of %v", len(metaData), MaxMetaDataKeys)
+	}
+	if nestedBeyond(metaData, MaxMetaDataDepth) {
+		return fmt.Errorf("meta_data is nested more than %v levels deep", MaxMetaDataDepth)
+	}
+	marshaled, err := json.Marshal(metaData)
+	if err != nil {
+		return fmt.Errorf("meta_data can't be serialized: %w", err)
+	}
+	if len(marshaled) > MaxMetaDataSize {
+		return fmt.Errorf("meta_data is %v bytes, exceeding the limit of %v", len(marshaled), MaxMetaDataSize)
+	}
+	return nil
+}
+
+// nestedBeyond is used to check whether a meta data value has more than the given
+// number of levels of objects and arrays, without descending past that limit
+func nestedBeyond(v interface{}, levels int) bool {
+	var children []interface{}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			children = append(children, child)
+		}
+	case []interface{}:
+		children = v
+	default:
+		return false
+	}
+	if levels == 0 {
+		return true
+	}
+	for _, child := range children {
+		if nestedBeyond(child, levels-1) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAttachments is used to check that email attachments are well formed,
+// of a supported type, and within our size limit
+func validateAttachments(attachments []EmailAttachment) error {
+	total := 0
+	for i, a := range attachments {
+		if err := requireFields(
+			"file_name", a.FileName,
+			"mime_type", a.MimeType,
+			"content", a.Content,
+		); err != nil {
+			return fmt.Errorf("attachment %v: %w", i, err)
+		}
+		if !supportedAttachmentType(a.MimeType) {
+			return fmt.Errorf("attachment %s has unsupported mime type %s", a.FileName, a.MimeType)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(a.Content)
+		if err != nil {
+			return fmt.Errorf("attachment %s is not base64 encoded: %w", a.FileName, err)
+		}
+		if total += len(decoded); total > MaxAttachmentSize {
+			return fmt.Errorf("attachments exceed the limit of %v bytes", MaxAttachmentSize)
+		}
+	}
+	return nil
+}
+
+// supportedAttachmentType is used to check whether a mime type may be attached
+func supportedAttachmentType(mimeType string) bool {
+	for _, t := range AttachmentTypes {
+		if mimeType == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,340 @@
+package queue_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/tns"
+)
+
+const (
+	testCID   = "QmNZiPk974vDsPmQii3YbrMKfi12KTSNM7XMiYyiea4VYZ"
+	testCIDv1 = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+)
+
+type validator interface {
+	Validate() error
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     validator
+		wantErr bool
+	}{
+		{"IPFSKeyCreation-Valid", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "rsa", Size: 2048, NetworkName: "public"}, false},
+		{"IPFSKeyCreation-NoUserName", queue.IPFSKeyCreation{Name: "key", Type: "rsa", Size: 2048, NetworkName: "public"}, true},
+		{"IPFSKeyCreation-NoName", queue.IPFSKeyCreation{UserName: "user", Type: "rsa", Size: 2048, NetworkName: "public"}, true},
+		{"IPFSKeyCreation-NoType", queue.IPFSKeyCreation{UserName: "user", Name: "key", Size: 2048, NetworkName: "public"}, true},
+		{"IPFSKeyCreation-NoNetwork", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "rsa", Size: 2048}, true},
+		{"IPFSKeyCreation-ED25519", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "ed25519", NetworkName: "public"}, false},
+		{"IPFSKeyCreation-ED25519Size", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "ed25519", Size: 256, NetworkName: "public"}, true},
+		{"IPFSKeyCreation-SmallRSA", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "rsa", Size: 1024, NetworkName: "public"}, true},
+		{"IPFSKeyCreation-NoSize", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "rsa", NetworkName: "public"}, true},
+		{"IPFSKeyCreation-BadType", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "secp256k1", NetworkName: "public"}, true},
+		{"IPFSKeyCreation-PrivateNetwork", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "rsa", Size: 2048, NetworkName: "private"}, true},
+		{"IPFSKeyDeletion-Valid", queue.IPFSKeyDeletion{UserName: "user", Name: "key", NetworkName: "public"}, false},
+		{"IPFSKeyDeletion-NoName", queue.IPFSKeyDeletion{UserName: "user", NetworkName: "public"}, true},
+		{"IPFSKeyDeletion-PrivateNetwork", queue.IPFSKeyDeletion{UserName: "user", Name: "key", NetworkName: "private"}, true},
+		{"IPFSKeyCreation-NegativeCost", queue.IPFSKeyCreation{UserName: "user", Name: "key", Type: "rsa", Size: 2048, NetworkName: "public", CreditCost: -1}, true},
+
+		{"IPFSPin-Valid", queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}, false},
+		{"IPFSPin-CIDv1", queue.IPFSPin{CID: testCIDv1, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}, false},
+		{"IPFSPin-BadCID", queue.IPFSPin{CID: "QmTypo", NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}, true},
+		{"IPFSPin-NoCID", queue.IPFSPin{NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}, true},
+		{"IPFSPin-NoNetwork", queue.IPFSPin{CID: testCID, UserName: "user", HoldTimeInMonths: 1}, true},
+		{"IPFSPin-NoUserName", queue.IPFSPin{CID: testCID, NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"IPFSPin-NoHoldTime", queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user"}, true},
+		{"IPFSPin-NegativeCost", queue.IPFSPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1, CreditCost: -1}, true},
+
+		{"IPFSFile-Valid", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", NetworkName: "public", HoldTimeInMonths: 1}, false},
+		{"IPFSFile-NoMinioHost", queue.IPFSFile{BucketName: "bucket", ObjectName: "object", UserName: "user", NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"IPFSFile-NoBucket", queue.IPFSFile{MinioHostIP: "127.0.0.1", ObjectName: "object", UserName: "user", NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"IPFSFile-NoObject", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", UserName: "user", NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"IPFSFile-NoUserName", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"IPFSFile-NoNetwork", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", HoldTimeInMonths: 1}, true},
+		{"IPFSFile-BlankNetwork", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", NetworkName: "  ", HoldTimeInMonths: 1}, true},
+		{"IPFSFile-PaddedNetwork", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", NetworkName: " public", HoldTimeInMonths: 1}, true},
+		{"IPFSFile-NoNetwork", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", HoldTimeInMonths: 1}, true},
+		{"IPFSFile-ZeroHoldTime", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", NetworkName: "public", HoldTimeInMonths: 0}, true},
+		{"IPFSFile-NegativeHoldTime", queue.IPFSFile{MinioHostIP: "127.0.0.1", BucketName: "bucket", ObjectName: "object", UserName: "user", NetworkName: "public", HoldTimeInMonths: -1}, true},
+
+		{"IPFSClusterPin-Valid", queue.IPFSClusterPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}, false},
+		{"IPFSClusterPin-BadCID", queue.IPFSClusterPin{CID: "not a cid", NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}, true},
+		{"IPFSClusterPin-NoCID", queue.IPFSClusterPin{NetworkName: "public", UserName: "user", HoldTimeInMonths: 1}, true},
+		{"IPFSClusterPin-NoNetwork", queue.IPFSClusterPin{CID: testCID, UserName: "user", HoldTimeInMonths: 1}, true},
+		{"IPFSClusterPin-NoUserName", queue.IPFSClusterPin{CID: testCID, NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"IPFSClusterPin-NegativeHoldTime", queue.IPFSClusterPin{CID: testCID, NetworkName: "public", UserName: "user", HoldTimeInMonths: -1}, true},
+
+		{"PinStatusRequest-Valid", queue.PinStatusRequest{CID: testCID, NetworkName: "public"}, false},
+		{"PinStatusRequest-NoCID", queue.PinStatusRequest{NetworkName: "public"}, true},
+		{"PinStatusRequest-NoNetwork", queue.PinStatusRequest{CID: testCID}, true},
+
+		{"CreditRefund-Valid", queue.CreditRefund{UserName: "user", Amount: 1.5, IdempotencyKey: "key"}, false},
+		{"CreditRefund-NoUserName", queue.CreditRefund{Amount: 1.5, IdempotencyKey: "key"}, true},
+		{"CreditRefund-NoKey", queue.CreditRefund{UserName: "user", Amount: 1.5}, true},
+		{"CreditRefund-NoAmount", queue.CreditRefund{UserName: "user", IdempotencyKey: "key"}, true},
+
+		{"DatabaseFileAdd-Valid", queue.DatabaseFileAdd{Hash: testCID, UserName: "user", NetworkName: "public", HoldTimeInMonths: 1}, false},
+		{"DatabaseFileAdd-BadHash", queue.DatabaseFileAdd{Hash: testCID[:20], UserName: "user", NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"DatabaseFileAdd-NoHash", queue.DatabaseFileAdd{UserName: "user", NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"DatabaseFileAdd-NoUserName", queue.DatabaseFileAdd{Hash: testCID, NetworkName: "public", HoldTimeInMonths: 1}, true},
+		{"DatabaseFileAdd-NoNetwork", queue.DatabaseFileAdd{Hash: testCID, UserName: "user", HoldTimeInMonths: 1}, true},
+		{"DatabaseFileAdd-NoHoldTime", queue.DatabaseFileAdd{Hash: testCID, UserName: "user", NetworkName: "public"}, true},
+
+		{"IPNSUpdate-Valid", queue.IPNSUpdate{CID: testCID, Key: "key", UserName: "user", NetworkName: "public"}, false},
+		{"IPNSUpdate-BadCID", queue.IPNSUpdate{CID: "/ipfs/" + testCID, Key: "key", UserName: "user", NetworkName: "public"}, true},
+		{"IPNSUpdate-NoCID", queue.IPNSUpdate{Key: "key", UserName: "user", NetworkName: "public"}, true},
+		{"IPNSUpdate-NoKey", queue.IPNSUpdate{CID: testCID, UserName: "user", NetworkName: "public"}, true},
+		{"IPNSUpdate-NoUserName", queue.IPNSUpdate{CID: testCID, Key: "key", NetworkName: "public"}, true},
+		{"IPNSUpdate-NoNetwork", queue.IPNSUpdate{CID: testCID, Key: "key", UserName: "user"}, true},
+
+		{"EmailSend-Valid", queue.EmailSend{Subject: "subject", Content: "content", UserNames: []string{"user"}}, false},
+		{"EmailSend-ValidEmails", queue.EmailSend{Subject: "subject", Content: "content", Emails: []string{"user@example.org"}}, false},
+		{"EmailSend-NoSubject", queue.EmailSend{Content: "content", UserNames: []string{"user"}}, true},
+		{"EmailSend-NoContent", queue.EmailSend{Subject: "subject", UserNames: []string{"user"}}, true},
+		{"EmailSend-NoRecipients", queue.EmailSend{Subject: "subject", Content: "content"}, true},
+		{"EmailSend-Template", queue.EmailSend{Subject: "subject", TemplateName: queue.TemplatePinFailed, UserNames: []string{"user"}}, false},
+		{"EmailSend-UnknownTemplate", queue.EmailSend{Subject: "subject", TemplateName: "unknown", UserNames: []string{"user"}}, true},
+
+		{"IPNSEntry-Valid", queue.IPNSEntry{CID: testCID, LifeTime: queue.Duration(time.Hour), TTL: queue.Duration(time.Minute), Key: "key", UserName: "user", NetworkName: "public"}, false},
+		{"IPNSEntry-BadCID", queue.IPNSEntry{CID: "QmTypo", LifeTime: queue.Duration(time.Hour), Key: "key", UserName: "user", NetworkName: "public"}, true},
+		{"IPNSEntry-NoCID", queue.IPNSEntry{LifeTime: queue.Duration(time.Hour), Key: "key", UserName: "user", NetworkName: "public"}, true},
+		{"IPNSEntry-NoLifeTime", queue.IPNSEntry{CID: testCID, Key: "key", UserName: "user", NetworkName: "public"}, true},
+		{"IPNSEntry-NegativeTTL", queue.IPNSEntry{CID: testCID, LifeTime: queue.Duration(time.Hour), TTL: queue.Duration(-time.Minute), Key: "key", UserName: "user", NetworkName: "public"}, true},
+		{"IPNSEntry-NoKey", queue.IPNSEntry{CID: testCID, LifeTime: queue.Duration(time.Hour), UserName: "user", NetworkName: "public"}, true},
+		{"IPNSEntry-NoUserName", queue.IPNSEntry{CID: testCID, LifeTime: queue.Duration(time.Hour), Key: "key", NetworkName: "public"}, true},
+		{"IPNSEntry-NoNetwork", queue.IPNSEntry{CID: testCID, LifeTime: queue.Duration(time.Hour), Key: "key", UserName: "user"}, true},
+
+		{"PaymentCreation-Valid", queue.PaymentCreation{TxHash: "0x0", Blockchain: "ethereum", UserName: "user"}, false},
+		{"PaymentCreation-NoTxHash", queue.PaymentCreation{Blockchain: "ethereum", UserName: "user"}, true},
+		{"PaymentCreation-NoBlockchain", queue.PaymentCreation{TxHash: "0x0", UserName: "user"}, true},
+		{"PaymentCreation-NoUserName", queue.PaymentCreation{TxHash: "0x0", Blockchain: "ethereum"}, true},
+
+		{"DashPaymentConfirmation-Valid", queue.DashPaymentConfirmation{UserName: "user", PaymentForwardID: "id", PaymentNumber: 1}, false},
+		{"DashPaymentConfirmation-NoUserName", queue.DashPaymentConfirmation{PaymentForwardID: "id", PaymentNumber: 1}, true},
+		{"DashPaymentConfirmation-NoForwardID", queue.DashPaymentConfirmation{UserName: "user", PaymentNumber: 1}, true},
+		{"DashPaymentConfirmation-NegativeNumber", queue.DashPaymentConfirmation{UserName: "user", PaymentForwardID: "id", PaymentNumber: -1}, true},
+
+		{"PaymentConfirmation-Valid", queue.PaymentConfirmation{UserName: "user", PaymentNumber: 1}, false},
+		{"PaymentConfirmation-NoUserName", queue.PaymentConfirmation{PaymentNumber: 1}, true},
+		{"PaymentConfirmation-NegativeNumber", queue.PaymentConfirmation{UserName: "user", PaymentNumber: -1}, true},
+		{"PaymentConfirmation-ValidConfirmations", queue.PaymentConfirmation{UserName: "user", PaymentNumber: 1, Blockchain: "ethereum", TxHash: "0x0", ConfirmationsRequired: 12}, false},
+		{"PaymentConfirmation-NegativeConfirmations", queue.PaymentConfirmation{UserName: "user", PaymentNumber: 1, ConfirmationsRequired: -1}, true},
+		{"PaymentConfirmation-ConfirmationsNoBlockchain", queue.PaymentConfirmation{UserName: "user", PaymentNumber: 1, TxHash: "0x0", ConfirmationsRequired: 12}, true},
+		{"PaymentConfirmation-ConfirmationsNoTxHash", queue.PaymentConfirmation{UserName: "user", PaymentNumber: 1, Blockchain: "ethereum", ConfirmationsRequired: 12}, true},
+
+		{"MongoUpdate-Valid", queue.MongoUpdate{DatabaseName: "db", CollectionName: "collection", Fields: map[string]string{"a": "b"}}, false},
+		{"MongoUpdate-NoDatabase", queue.MongoUpdate{CollectionName: "collection", Fields: map[string]string{"a": "b"}}, true},
+		{"MongoUpdate-NoCollection", queue.MongoUpdate{DatabaseName: "db", Fields: map[string]string{"a": "b"}}, true},
+		{"MongoUpdate-NoFields", queue.MongoUpdate{DatabaseName: "db", CollectionName: "collection"}, true},
+		{"MongoUpdate-ValidOperations", queue.MongoUpdate{DatabaseName: "db", CollectionName: "collection", Operations: []queue.MongoOperation{{Operator: queue.MongoInc, Field: "credits", Value: -5.5}}}, false},
+		{"MongoUpdate-IncNotNumber", queue.MongoUpdate{DatabaseName: "db", CollectionName: "collection", Operations: []queue.MongoOperation{{Operator: queue.MongoInc, Field: "credits", Value: "5"}}}, true},
+		{"MongoUpdate-UnknownOperator", queue.MongoUpdate{DatabaseName: "db", CollectionName: "collection", Operations: []queue.MongoOperation{{Operator: "$rename", Field: "a", Value: "b"}}}, true},
+		{"MongoUpdate-OperationNoField", queue.MongoUpdate{DatabaseName: "db", CollectionName: "collection", Operations: []queue.MongoOperation{{Operator: queue.MongoUnset}}}, true},
+		{"MongoUpdate-FieldUpdatedTwice", queue.MongoUpdate{DatabaseName: "db", CollectionName: "collection", Fields: map[string]string{"a": "b"}, Operations: []queue.MongoOperation{{Operator: queue.MongoUnset, Field: "a"}}}, true},
+
+		{"ZoneCreation-Valid", queue.ZoneCreation{Name: "example.org", ManagerKeyName: "manager", ZoneKeyName: "zone", UserName: "user"}, false},
+		{"ZoneCreation-NoName", queue.ZoneCreation{ManagerKeyName: "manager", ZoneKeyName: "zone", UserName: "user"}, true},
+		{"ZoneCreation-NoManagerKey", queue.ZoneCreation{Name: "example.org", ZoneKeyName: "zone", UserName: "user"}, true},
+		{"ZoneCreation-NoZoneKey", queue.ZoneCreation{Name: "example.org", ManagerKeyName: "manager", UserName: "user"}, true},
+		{"ZoneCreation-NoUserName", queue.ZoneCreation{Name: "example.org", ManagerKeyName: "manager", ZoneKeyName: "zone"}, true},
+
+		{"RecordCreation-Valid", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user"}, false},
+		{"RecordCreation-NoZone", queue.RecordCreation{RecordName: "www", RecordKeyName: "record", UserName: "user"}, true},
+		{"RecordCreation-NoRecord", queue.RecordCreation{ZoneName: "example.org", RecordKeyName: "record", UserName: "user"}, true},
+		{"RecordCreation-Alias", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user", RecordType: "CNAME", Value: "www.example.com."}, false},
+		{"RecordCreation-AliasNoTarget", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user", RecordType: "CNAME"}, true},
+		{"RecordCreation-AliasBadTarget", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user", RecordType: "CNAME", Value: "/ipfs/" + testCID}, true},
+		{"RecordCreation-Wildcard", queue.RecordCreation{ZoneName: "example.org", RecordName: "*.sub", RecordKeyName: "record", UserName: "user"}, false},
+		{"RecordCreation-InnerWildcard", queue.RecordCreation{ZoneName: "example.org", RecordName: "www.*", RecordKeyName: "record", UserName: "user"}, true},
+		{"RecordCreation-NoRecordKey", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", UserName: "user"}, true},
+		{"RecordCreation-NoUserName", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record"}, true},
+		{"RecordCreation-ValidType", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user", RecordType: "DNSLINK", Value: "/ipfs/" + testCID, TTL: queue.Duration(time.Minute)}, false},
+		{"RecordCreation-NoValue", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user", RecordType: "IPNS"}, true},
+		{"RecordCreation-BadType", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user", RecordType: "MX"}, true},
+		{"RecordCreation-NegativeTTL", queue.RecordCreation{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user", TTL: queue.Duration(-time.Minute)}, true},
+
+		{"RecordDeletion-Valid", queue.RecordDeletion{ZoneName: "example.org", RecordName: "www", UserName: "user"}, false},
+		{"RecordDeletion-ValidKey", queue.RecordDeletion{ZoneName: "example.org", RecordName: "www", RecordKeyName: "record", UserName: "user"}, false},
+		{"RecordDeletion-ValidType", queue.RecordDeletion{ZoneName: "example.org", RecordName: "www", RecordType: tns.RecordTypeA, UserName: "user"}, false},
+		{"RecordDeletion-BadType", queue.RecordDeletion{ZoneName: "example.org", RecordName: "www", RecordType: "MX", UserName: "user"}, true},
+		{"RecordDeletion-NoZone", queue.RecordDeletion{RecordName: "www", UserName: "user"}, true},
+		{"RecordDeletion-NoRecord", queue.RecordDeletion{ZoneName: "example.org", UserName: "user"}, true},
+		{"RecordDeletion-NoUserName", queue.RecordDeletion{ZoneName: "example.org", RecordName: "www"}, true},
+
+		{"ZoneExport-Valid", queue.ZoneExport{ZoneName: "example.org", UserName: "user"}, false},
+		{"ZoneExport-NoZone", queue.ZoneExport{UserName: "user"}, true},
+		{"ZoneExport-NoUserName", queue.ZoneExport{ZoneName: "example.org"}, true},
+
+		{"ZoneImport-Valid", queue.ZoneImport{UserName: "user", Document: testZoneDocument(tns.ZoneDocumentVersion, "example.org")}, false},
+		{"ZoneImport-NoUserName", queue.ZoneImport{Document: testZoneDocument(tns.ZoneDocumentVersion, "example.org")}, true},
+		{"ZoneImport-NoVersion", queue.ZoneImport{UserName: "user", Document: testZoneDocument(0, "example.org")}, true},
+		{"ZoneImport-FutureVersion", queue.ZoneImport{UserName: "user", Document: testZoneDocument(tns.ZoneDocumentVersion+1, "example.org")}, true},
+		{"ZoneImport-BadZoneName", queue.ZoneImport{UserName: "user", Document: testZoneDocument(tns.ZoneDocumentVersion, "not a zone")}, true},
+		{"ZoneImport-NoKeys", queue.ZoneImport{UserName: "user", Document: tns.ZoneDocument{Version: tns.ZoneDocumentVersion, Zone: tns.Zone{Name: "example.org"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.msg.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func testZoneDocument(version int, zoneName string) tns.ZoneDocument {
+	return tns.ZoneDocument{
+		Version:        version,
+		Zone:           tns.Zone{Name: zoneName},
+		ManagerKeyName: "manager",
+		ZoneKeyName:    "zone",
+	}
+}
+
+func TestValidateZoneName(t *testing.T) {
+	tests := []struct {
+		name     string
+		zoneName string
+		wantErr  bool
+	}{
+		{"Valid", "example.org", false},
+		{"ValidSingleLabel", "example", false},
+		{"ValidTrailingDot", "example.org.", false},
+		{"ValidHyphen", "my-zone.example.org", false},
+		{"ValidDigits", "0x1.example.org", false},
+		{"ValidMaxLabel", strings.Repeat("a", 63) + ".org", false},
+		{"Empty", "", true},
+		{"OnlyDot", ".", true},
+		{"EmptyLabel", "example..org", true},
+		{"LeadingDot", ".example.org", true},
+		{"LongLabel", strings.Repeat("a", 64) + ".org", true},
+		{"LongName", strings.Repeat(strings.Repeat("a", 63)+".", 4) + "org", true},
+		{"LeadingHyphen", "-example.org", true},
+		{"TrailingHyphen", "example-.org", true},
+		{"Uppercase", "Example.org", true},
+		{"Space", "my zone.org", true},
+		{"Underscore", "my_zone.org", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := queue.ValidateZoneName(tt.zoneName); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateZoneName() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordCreation_MetaDataLimits(t *testing.T) {
+	// nested is used to build meta data nested the given number of levels deep
+	nested := func(levels int) map[string]interface{} {
+		var v interface{} = "value"
+		for i := 1; i < levels; i++ {
+			v = map[string]interface{}{"nested": v}
+		}
+		return map[string]interface{}{"nested": v}
+	}
+	// keys is used to build meta data with the given number of keys
+	keys := func(n int) map[string]interface{} {
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			m[fmt.Sprintf("key-%v", i)] = i
+		}
+		return m
+	}
+	// sized is used to build meta data serializing to exactly the given size,
+	// which includes the 8 bytes of {"k":""}
+	sized := func(size int) map[string]interface{} {
+		return map[string]interface{}{"k": strings.Repeat("a", size-8)}
+	}
+	tests := []struct {
+		name     string
+		metaData map[string]interface{}
+		wantErr  bool
+	}{
+		{"Nil", nil, false},
+		{"SizeAtLimit", sized(queue.MaxMetaDataSize), false},
+		{"SizeOverLimit", sized(queue.MaxMetaDataSize + 1), true},
+		{"KeysAtLimit", keys(queue.MaxMetaDataKeys), false},
+		{"KeysOverLimit", keys(queue.MaxMetaDataKeys + 1), true},
+		{"DepthAtLimit", nested(queue.MaxMetaDataDepth), false},
+		{"DepthOverLimit", nested(queue.MaxMetaDataDepth + 1), true},
+		{"DepthOverLimitArray", map[string]interface{}{"a": []interface{}{nested(queue.MaxMetaDataDepth)}}, true},
+		{"Unserializable", map[string]interface{}{"f": func() {}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := queue.RecordCreation{
+				ZoneName:      "example.org",
+				RecordName:    "www",
+				RecordKeyName: "record",
+				UserName:      "user",
+				MetaData:      tt.metaData,
+			}
+			if err := r.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmailSend_Attachments(t *testing.T) {
+	// attachment is used to build an attachment of the given decoded size
+	attachment := func(mimeType string, size int) queue.EmailAttachment {
+		return queue.NewEmailAttachment("file", mimeType, make([]byte, size))
+	}
+	tests := []struct {
+		name        string
+		attachments []queue.EmailAttachment
+		wantErr     bool
+	}{
+		{"None", nil, false},
+		{"Valid", []queue.EmailAttachment{attachment("text/plain", 10)}, false},
+		{"SizeAtLimit", []queue.EmailAttachment{attachment("text/plain", queue.MaxAttachmentSize)}, false},
+		{"SizeOverLimit", []queue.EmailAttachment{attachment("text/plain", queue.MaxAttachmentSize+1)}, true},
+		{"CombinedSizeOverLimit", []queue.EmailAttachment{
+			attachment("text/plain", queue.MaxAttachmentSize/2+1),
+			attachment("application/json", queue.MaxAttachmentSize/2),
+		}, true},
+		{"UnsupportedType", []queue.EmailAttachment{attachment("application/x-msdownload", 10)}, true},
+		{"NoFileName", []queue.EmailAttachment{{MimeType: "text/plain", Content: "aGk="}}, true},
+		{"NotBase64", []queue.EmailAttachment{{FileName: "file", MimeType: "text/plain", Content: "not base64!"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := queue.EmailSend{
+				Subject:     "subject",
+				Content:     "content",
+				UserNames:   []string{"user"},
+				Attachments: tt.attachments,
+			}
+			if err := e.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDashPaymenConfirmation_Alias(t *testing.T) {
+	msg := queue.DashPaymentConfirmation{UserName: "user", PaymentForwardID: "id", PaymentNumber: 1}
+	want := `{"user_name":"user","payment_forward_id":"id","payment_number":1}`
+	got, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("Marshal() = %s, want %s", got, want)
+	}
+	// the deprecated name must remain interchangeable with the new one
+	var old queue.DashPaymenConfirmation
+	if err = json.Unmarshal(got, &old); err != nil {
+		t.Fatal(err)
+	}
+	if msg != old {
+		t.Fatalf("Unmarshal() = %+v, want %+v", old, msg)
+	}
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/tns"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// DefaultZoneCreationTimeout is how long a zone is marked as being created for,
+	// after which a consumer which crashed part way through creating it is assumed
+	// to have, letting its redelivered message finish the job
+	DefaultZoneCreationTimeout = 10 * time.Minute
+	// DefaultZoneCreationDelay is how long messages of a zone another consumer is
+	// creating are postponed for
+	DefaultZoneCreationDelay = 30 * time.Second
+)
+
+// ZoneStore is used by the zone creation consumer to find and update zones, which
+// are added to the database before their creation is requested
+type ZoneStore interface {
+	// ZonePublished reports whether the user's zone has been published to ipfs,
+	// returning tns.ErrZoneNotFound if the user has no such zone
+	ZonePublished(name, userName string) (bool, error)
+	// SetZoneHash is used to record the hash the user's zone was published with
+	SetZoneHash(name, userName, hash string) error
+}
+
+// ZonePublisher is used by the zone creation consumer to get the zone's keys and
+// publish it to ipfs
+type ZonePublisher interface {
+	// EnsureKey is used to get the peer id of the named key, generating the key if
+	// it doesn't exist. Existing keys are never regenerated, so that re-running it
+	// after a crash returns the same id
+	EnsureKey(name string) (string, error)
+	// PutZone is used to store a zone in ipfs, returning its hash. As zones are
+	// content addressed, storing the same zone again returns the same hash
+	PutZone(zone tns.Zone) (string, error)
+}
+
+// ZoneCreationOpts is used to control how concurrent requests to create the same
+// zone are handled
+type ZoneCreationOpts struct {
+	// InProgress holds markers of the zones being created, so that duplicate
+	// messages processed concurrently don't both create the zone. It must be shared
+	// by every consumer, and zones aren't marked when it's nil
+	InProgress IdempotencyStore
+	// Timeout is how long a zone is marked for, defaulting to
+	// DefaultZoneCreationTimeout
+	Timeout time.Duration
+	// Delay is how long messages of a zone being created are postponed for,
+	// defaulting to DefaultZoneCreationDelay
+	Delay time.Duration
+}
+
+// ZoneCreationHandler is used to create the zones requested through
+// ZoneCreationQueue, publishing them to ipfs with their keys and recording their
+// hash. Creation is idempotent, so that a message redelivered after a crash finishes
+// creating its zone, while messages of zones which were already created are simply
+// acknowledged. Messages of a zone another consumer is creating are postponed, and
+// those of zones which don't exist are dropped.
+func (qm *Manager) ZoneCreationHandler(zones ZoneStore, ipfs ZonePublisher, opts ZoneCreationOpts) Handler {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultZoneCreationTimeout
+	}
+	if opts.Delay <= 0 {
+		opts.Delay = DefaultZoneCreationDelay
+	}
+	return func(ctx context.Context, d amqp.Delivery) error {
+		req, err := Decode[ZoneCreation](d.Body)
+		if err != nil {
+			return Drop(err)
+		}
+		req.Name = tns.NormalizeName(req.Name)
+		entry := qm.LogEntry(ctx).WithField("zone", req.Name)
+		if opts.InProgress != nil {
+			key := "zone-creation:" + req.UserName + ":" + req.Name
+			claimed, err := opts.InProgress.Claim(key, opts.Timeout)
+			if err != nil {
+				return fmt.Errorf("failed to mark zone creation in progress: %w", err)
+			}
+			if !claimed {
+				entry.Info("postponing message of zone being created")
+				if err = qm.postpone(ctx, d, opts.Delay); err != nil {
+					return RetryLater(err)
+				}
+				return nil
+			}
+			// a crashed consumer's marker expires instead
+			defer opts.InProgress.Release(key)
+		}
+		published, err := zones.ZonePublished(req.Name, req.UserName)
+		if errors.Is(err, tns.ErrZoneNotFound) {
+			return Drop(err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to search for zone: %w", err)
+		}
+		if published {
+			entry.Info("zone already created")
+			return nil
+		}
+		managerID, err := ipfs.EnsureKey(req.ManagerKeyName)
+		if err != nil {
+			return fmt.Errorf("failed to get zone manager key: %w", err)
+		}
+		zoneID, err := ipfs.EnsureKey(req.ZoneKeyName)
+		if err != nil {
+			return fmt.Errorf("failed to get zone key: %w", err)
+		}
+		hash, err := ipfs.PutZone(tns.Zone{
+			PublicKey: zoneID,
+			Manager:   &tns.ZoneManager{PublicKey: managerID},
+			Name:      req.Name,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to put zone in ipfs: %w", err)
+		}
+		if err = zones.SetZoneHash(req.Name, req.UserName, hash); err != nil {
+			return fmt.Errorf("failed to update zone in database: %w", err)
+		}
+		entry.Info("zone published and database updated")
+		return nil
+	}
+}
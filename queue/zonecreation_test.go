@@ -0,0 +1,159 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/queue"
+	"github.com/RTradeLtd/Temporal/tns"
+	"github.com/streadway/amqp"
+)
+
+// fakeZoneStore is an in memory ZoneStore, holding the hash of each zone
+type fakeZoneStore struct {
+	hashes map[string]string
+}
+
+func (f *fakeZoneStore) ZonePublished(name, userName string) (bool, error) {
+	hash, ok := f.hashes[userName+":"+name]
+	if !ok {
+		return false, tns.ErrZoneNotFound
+	}
+	return hash != "", nil
+}
+
+func (f *fakeZoneStore) SetZoneHash(name, userName, hash string) error {
+	f.hashes[userName+":"+name] = hash
+	return nil
+}
+
+// fakeZonePublisher is an in memory ZonePublisher, which fails to put zones while
+// down is set, as though the consumer crashed part way through
+type fakeZonePublisher struct {
+	keys      map[string]string
+	generated int
+	puts      int
+	down      bool
+}
+
+func (f *fakeZonePublisher) EnsureKey(name string) (string, error) {
+	if id, ok := f.keys[name]; ok {
+		return id, nil
+	}
+	f.generated++
+	f.keys[name] = "id-" + name
+	return f.keys[name], nil
+}
+
+func (f *fakeZonePublisher) PutZone(zone tns.Zone) (string, error) {
+	if f.down {
+		return "", errors.New("consumer crashed")
+	}
+	f.puts++
+	return "hash-" + zone.Name + "-" + zone.PublicKey, nil
+}
+
+// zoneCreation is used to publish a request to create a zone, returning its delivery
+func zoneCreation(t *testing.T, qm *queue.Manager, broker *queue.MemoryBroker, name string) amqp.Delivery {
+	t.Helper()
+	req := queue.ZoneCreation{Name: name, ManagerKeyName: "manager", ZoneKeyName: "zone", UserName: "user"}
+	if err := qm.PublishMessageContext(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := broker.Get(queue.ZoneCreationQueue)
+	if !ok {
+		t.Fatal("expected the request to be published")
+	}
+	return d
+}
+
+func TestZoneCreationHandler_Redelivered(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.ZoneCreationQueue))
+	zones := &fakeZoneStore{hashes: map[string]string{"user:example.org": ""}}
+	ipfs := &fakeZonePublisher{keys: map[string]string{}, down: true}
+	handler := qm.ZoneCreationHandler(zones, ipfs, queue.ZoneCreationOpts{InProgress: queue.NewMemoryStore()})
+	d := zoneCreation(t, qm, broker, "example.org")
+	ctx := context.Background()
+	// the keys are generated before the crash
+	if err := handler(ctx, d); err == nil {
+		t.Fatal("expected the crash to fail the message")
+	}
+	if ipfs.generated != 2 || zones.hashes["user:example.org"] != "" {
+		t.Fatalf("expected the zone's keys to be generated and the zone left unpublished, got %v keys", ipfs.generated)
+	}
+	// and reused once redelivered
+	ipfs.down = false
+	d.Redelivered = true
+	if err := handler(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if ipfs.generated != 2 {
+		t.Fatalf("expected the keys not to be regenerated, got %v keys", ipfs.generated)
+	}
+	if hash := zones.hashes["user:example.org"]; hash != "hash-example.org-id-zone" {
+		t.Fatalf("unexpected zone hash %q", hash)
+	}
+	// once created, further redeliveries are acknowledged without recreating it
+	if err := handler(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	if ipfs.puts != 1 {
+		t.Fatalf("expected the zone to be published once, got %v", ipfs.puts)
+	}
+}
+
+func TestZoneCreationHandler_InProgress(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.ZoneCreationQueue))
+	zones := &fakeZoneStore{hashes: map[string]string{"user:example.org": ""}}
+	ipfs := &fakeZonePublisher{keys: map[string]string{}}
+	inProgress := queue.NewMemoryStore()
+	handler := qm.ZoneCreationHandler(zones, ipfs, queue.ZoneCreationOpts{
+		InProgress: inProgress,
+		Timeout:    50 * time.Millisecond,
+		Delay:      time.Millisecond,
+	})
+	d := zoneCreation(t, qm, broker, "example.org")
+	// another consumer is creating the zone, or crashed while doing so
+	if _, err := inProgress.Claim("zone-creation:user:example.org", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := handler(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+	if ipfs.puts != 0 || ipfs.generated != 0 {
+		t.Fatal("expected the duplicate not to proceed")
+	}
+	postponed, ok := broker.Get(queue.ZoneCreationQueue)
+	if !ok {
+		t.Fatal("expected the duplicate to be postponed")
+	}
+	// the crashed consumer's marker expires, letting the zone be created
+	time.Sleep(60 * time.Millisecond)
+	if err := handler(context.Background(), postponed); err != nil {
+		t.Fatal(err)
+	}
+	if ipfs.puts != 1 || zones.hashes["user:example.org"] == "" {
+		t.Fatal("expected the zone to be created once the marker expired")
+	}
+}
+
+func TestZoneCreationHandler_NotFound(t *testing.T) {
+	broker := queue.NewMemoryBroker()
+	defer broker.Close()
+	qm := newMemoryManager(t, broker, queue.WithQueue(queue.ZoneCreationQueue))
+	ipfs := &fakeZonePublisher{keys: map[string]string{}}
+	handler := qm.ZoneCreationHandler(&fakeZoneStore{hashes: map[string]string{}}, ipfs, queue.ZoneCreationOpts{})
+	err := handler(context.Background(), zoneCreation(t, qm, broker, "example.org"))
+	if !errors.Is(err, queue.ErrDrop) || !errors.Is(err, tns.ErrZoneNotFound) {
+		t.Fatalf("expected the request to be dropped, got %v", err)
+	}
+	if ipfs.generated != 0 {
+		t.Fatal("expected no keys to be generated for a missing zone")
+	}
+}
@@ -0,0 +1,52 @@
+package queue
+
+import (
+	"context"
+	"errors"
+
+	"github.com/RTradeLtd/Temporal/tns"
+	"github.com/streadway/amqp"
+)
+
+// ZoneExportFunc is used by ZoneExportHandler to produce a signed export of a zone
+type ZoneExportFunc func(ctx context.Context, req ZoneExport) (*tns.ZoneDocument, error)
+
+// ExportZone is used to ask the zone consumer for a signed export of a zone and all
+// of its records, waiting until ctx is done or DefaultRPCTimeout elapses for its
+// reply. The document can be restored with ImportZone
+func (qm *Manager) ExportZone(ctx context.Context, req ZoneExport) (*tns.ZoneDocument, error) {
+	var resp ZoneExportResponse
+	if err := qm.CallContext(ctx, ZoneExportQueue, req, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	if resp.Document == nil {
+		return nil, errors.New("zone export reply has no document")
+	}
+	return resp.Document, nil
+}
+
+// ImportZone is used to ask the zone consumer to restore a zone for the user from
+// a document produced by ExportZone
+func (qm *Manager) ImportZone(ctx context.Context, userName string, doc tns.ZoneDocument) error {
+	return qm.publish(ctx, qm.channel(), "", ZoneImportQueue, ZoneImport{UserName: userName, Document: doc})
+}
+
+// ZoneExportHandler is used by the zone consumer to answer export requests consumed
+// from ZoneExportQueue using fn. Failures to export the zone are returned to the
+// requester, so the request is acknowledged regardless.
+func (qm *Manager) ZoneExportHandler(fn ZoneExportFunc) Handler {
+	return func(ctx context.Context, d amqp.Delivery) error {
+		req, err := DecodeDelivery[ZoneExport](d)
+		var resp ZoneExportResponse
+		if err == nil {
+			resp.Document, err = fn(ctx, req)
+		}
+		if err != nil {
+			resp = ZoneExportResponse{Error: err.Error()}
+		}
+		return qm.Reply(ctx, d, resp)
+	}
+}
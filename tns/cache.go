@@ -0,0 +1,282 @@
+package tns
+
+import (
+	"container/list"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultCacheSize is the number of names a resolver cache holds when no size
+	// is configured
+	DefaultCacheSize = 1024
+	// DefaultCacheTTL is how long resolved names are cached for when none of the
+	// records followed to resolve them have a ttl, and no default is configured
+	DefaultCacheTTL = time.Minute
+	// DefaultNegativeCacheSize is the number of names which don't exist a resolver
+	// cache holds when negative caching is enabled without a size
+	DefaultNegativeCacheSize = 1024
+	// DefaultNegativeCacheTTL is how long names which don't exist are cached for when
+	// negative caching is enabled without a ttl
+	DefaultNegativeCacheTTL = 10 * time.Second
+)
+
+// ResolverCache is an in-memory LRU cache of resolved names, used by a Resolver with
+// its Cache set. Names are cached for the smallest ttl of the records followed to
+// resolve them, and are invalidated by Invalidate when any of those records change.
+// Names which don't exist may also be cached, see EnableNegativeCaching.
+// It is a prometheus.Collector exposing its hits and misses, and is safe for
+// concurrent use.
+type ResolverCache struct {
+	mu       sync.Mutex
+	positive cacheList
+	negative cacheList
+	// gen is incremented by every invalidation, so that names resolved while an
+	// invalidation happened aren't cached from what may be stale records
+	gen          uint64
+	hits         prometheus.Counter
+	negativeHits prometheus.Counter
+	misses       prometheus.Counter
+}
+
+// cacheEntry is a name cached by a ResolverCache, which resolved to cid or failed
+// to resolve with err
+type cacheEntry struct {
+	name    string
+	cid     string
+	err     error
+	expires time.Time
+	// names are the names followed to resolve the entry, whose records changing
+	// invalidates it
+	names []string
+}
+
+// cacheList is an LRU list of cached names, which caches nothing when its size is 0
+type cacheList struct {
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// NewResolverCache is used to create a cache holding up to size names, cached for
+// defaultTTL when none of the records they resolve through have a ttl. A size or
+// ttl of 0 or less uses DefaultCacheSize or DefaultCacheTTL.
+func NewResolverCache(size int, defaultTTL time.Duration) *ResolverCache {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	if defaultTTL <= 0 {
+		defaultTTL = DefaultCacheTTL
+	}
+	return &ResolverCache{
+		positive: newCacheList(size, defaultTTL),
+		negative: newCacheList(0, 0),
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "tns",
+			Name:      "resolver_cache_hits_total",
+			Help:      "Number of names resolved from the resolver cache",
+		}),
+		negativeHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "tns",
+			Name:      "resolver_cache_negative_hits_total",
+			Help:      "Number of names found not to exist from the resolver cache",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "temporal",
+			Subsystem: "tns",
+			Name:      "resolver_cache_misses_total",
+			Help:      "Number of names which weren't cached, and were resolved from their records",
+		}),
+	}
+}
+
+// EnableNegativeCaching is used to also cache names which don't exist, failing with
+// ErrZoneNotFound or ErrRecordNotFound, so that clients retrying them don't each
+// cause lookups. Up to size such names are cached for ttl, separately from names
+// which resolved, with a size or ttl of 0 or less using DefaultNegativeCacheSize or
+// DefaultNegativeCacheTTL. The ttl should be short, as while names are invalidated
+// when created through Invalidate and InvalidateZone, names created elsewhere are
+// only seen once it passes.
+func (c *ResolverCache) EnableNegativeCaching(size int, ttl time.Duration) {
+	if size <= 0 {
+		size = DefaultNegativeCacheSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultNegativeCacheTTL
+	}
+	c.mu.Lock()
+	c.negative = newCacheList(size, ttl)
+	c.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector
+func (c *ResolverCache) Describe(ch chan<- *prometheus.Desc) {
+	c.hits.Describe(ch)
+	c.negativeHits.Describe(ch)
+	c.misses.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (c *ResolverCache) Collect(ch chan<- prometheus.Metric) {
+	c.hits.Collect(ch)
+	c.negativeHits.Collect(ch)
+	c.misses.Collect(ch)
+}
+
+// Len is used to get the number of names cached, including names which don't exist
+// and expired names which have yet to be evicted
+func (c *ResolverCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.positive.lru.Len() + c.negative.lru.Len()
+}
+
+// Invalidate is used to remove every cached name which was resolved through the
+// record with the given name, such as www.example.org, once it has been created,
+// changed or deleted. Wildcard records, such as *.example.org, invalidate every
+// name they may match. Each cached name is checked, so invalidations are expected
+// to be far rarer than resolutions.
+func (c *ResolverCache) Invalidate(name string) {
+	name = cacheKey(name)
+	c.invalidate(func(followed string) bool {
+		if suffix := strings.TrimPrefix(name, Wildcard); suffix != name {
+			return strings.HasSuffix(followed, suffix)
+		}
+		return followed == name
+	})
+}
+
+// InvalidateZone is used to remove every cached name within a zone, such as once it
+// has been created, as names resolve through the longest zone matching them
+func (c *ResolverCache) InvalidateZone(zoneName string) {
+	suffix := "." + cacheKey(zoneName)
+	c.invalidate(func(followed string) bool {
+		return strings.HasSuffix(followed, suffix)
+	})
+}
+
+// invalidate is used to remove every cached name which followed a name matching
+func (c *ResolverCache) invalidate(match func(followed string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.positive.removeMatching(match)
+	c.negative.removeMatching(match)
+}
+
+// get is used to get the cid a name resolved to, or the error it failed to resolve
+// with, if it's cached and unexpired, along with the generation to add the name's
+// resolution with
+func (c *ResolverCache) get(name string) (string, error, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.positive.get(name); ok {
+		c.hits.Inc()
+		return entry.cid, nil, c.gen, true
+	}
+	if entry, ok := c.negative.get(name); ok {
+		c.negativeHits.Inc()
+		return "", entry.err, c.gen, true
+	}
+	c.misses.Inc()
+	return "", nil, c.gen, false
+}
+
+// add is used to cache what a name resolved to, through the given names, for ttl or
+// the default ttl when 0. names which failed to resolve are only cached when they
+// don't exist, and negative caching is enabled. names resolved before the cache
+// was invalidated since gen aren't cached, as they may have been resolved from
+// stale records, such as a name which was created while we found it didn't exist
+func (c *ResolverCache) add(name, cid string, err error, ttl time.Duration, names []string, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	entry := &cacheEntry{name: name, cid: cid, err: err, names: names}
+	switch {
+	case err == nil:
+		if ttl <= 0 {
+			ttl = c.positive.ttl
+		}
+		entry.expires = time.Now().Add(ttl)
+		c.negative.removeName(name)
+		c.positive.add(entry)
+	case errors.Is(err, ErrZoneNotFound) || errors.Is(err, ErrRecordNotFound):
+		entry.expires = time.Now().Add(c.negative.ttl)
+		c.positive.removeName(name)
+		c.negative.add(entry)
+	}
+}
+
+// newCacheList is used to create an empty list holding up to size names
+func newCacheList(size int, ttl time.Duration) cacheList {
+	return cacheList{size: size, ttl: ttl, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// get is used to get a name's unexpired entry, evicting it if it has expired
+func (l *cacheList) get(name string) (*cacheEntry, bool) {
+	e, ok := l.entries[name]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expires) {
+		l.remove(e)
+		return nil, false
+	}
+	l.lru.MoveToFront(e)
+	return entry, true
+}
+
+// add is used to add an entry, evicting the least recently used entries once full
+func (l *cacheList) add(entry *cacheEntry) {
+	if l.size <= 0 {
+		return
+	}
+	l.removeName(entry.name)
+	l.entries[entry.name] = l.lru.PushFront(entry)
+	for l.lru.Len() > l.size {
+		l.remove(l.lru.Back())
+	}
+}
+
+// removeMatching is used to remove every entry which followed a name matching
+func (l *cacheList) removeMatching(match func(followed string) bool) {
+	for e := l.lru.Front(); e != nil; {
+		next := e.Next()
+		for _, followed := range e.Value.(*cacheEntry).names {
+			if match(followed) {
+				l.remove(e)
+				break
+			}
+		}
+		e = next
+	}
+}
+
+// removeName is used to remove a name's entry, if it has one
+func (l *cacheList) removeName(name string) {
+	if e, ok := l.entries[name]; ok {
+		l.remove(e)
+	}
+}
+
+// remove is used to remove an entry
+func (l *cacheList) remove(e *list.Element) {
+	l.lru.Remove(e)
+	delete(l.entries, e.Value.(*cacheEntry).name)
+}
+
+// cacheKey is used to get the key a name is cached by, which is its normalized form
+// so that names differing only by case share an entry
+func cacheKey(name string) string {
+	return NormalizeName(name)
+}
@@ -0,0 +1,225 @@
+package tns_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/tns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countingRecords is a RecordFinder counting its lookups, calling onLookup after
+// each one when set
+type countingRecords struct {
+	fakeRecords
+	lookups  int
+	onLookup func()
+}
+
+func (c *countingRecords) FindRecord(zoneName, recordName, recordType string) (*tns.Record, error) {
+	c.lookups++
+	record, err := c.fakeRecords.FindRecord(zoneName, recordName, recordType)
+	if c.onLookup != nil {
+		c.onLookup()
+	}
+	return record, err
+}
+
+func newCachedResolver(size int, ttl time.Duration) (*tns.Resolver, *countingRecords) {
+	records := &countingRecords{fakeRecords: fakeRecords{
+		"example.org": {
+			"www":   {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID},
+			"short": {Name: "short", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID, TTL: 1},
+			"alias": {Name: "alias", Type: tns.RecordTypeCNAME, Value: "www.example.org"},
+			"*":     {Name: "*", Type: tns.RecordTypeDNSLink, Value: "/ipfs/QmAny"},
+		},
+	}}
+	resolver := tns.NewResolver(records, nil)
+	resolver.Cache = tns.NewResolverCache(size, ttl)
+	return resolver, records
+}
+
+func TestResolverCache(t *testing.T) {
+	resolver, records := newCachedResolver(0, 0)
+	for i := 0; i < 3; i++ {
+		cid, err := resolver.Resolve("alias.example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cid != testResolveCID {
+			t.Fatalf("unexpected cid %s", cid)
+		}
+	}
+	// the alias is found as a CNAME, while its target takes a lookup for the CNAME
+	// it doesn't have before its DNSLINK
+	if records.lookups != 3 {
+		t.Fatalf("expected the alias and its target to be looked up once, got %v lookups", records.lookups)
+	}
+	if hits := cacheMetric(t, resolver.Cache, "temporal_tns_resolver_cache_hits_total"); hits != 2 {
+		t.Fatalf("expected 2 hits, got %v", hits)
+	}
+	if misses := cacheMetric(t, resolver.Cache, "temporal_tns_resolver_cache_misses_total"); misses != 1 {
+		t.Fatalf("expected 1 miss, got %v", misses)
+	}
+	// changing the alias's target invalidates the alias
+	records.fakeRecords["example.org"]["www"] = &tns.Record{Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/QmNew"}
+	resolver.Cache.Invalidate("www.example.org")
+	if cid, err := resolver.Resolve("alias.example.org"); err != nil || cid != "QmNew" {
+		t.Fatalf("expected the alias to resolve to its new target, got %s, %v", cid, err)
+	}
+	// wildcards invalidate every name they match
+	if _, err := resolver.Resolve("other.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	resolver.Cache.Invalidate("*.example.org")
+	if resolver.Cache.Len() != 0 {
+		t.Fatalf("expected the wildcard to invalidate every name, %v remain", resolver.Cache.Len())
+	}
+}
+
+func TestResolverCache_TTL(t *testing.T) {
+	resolver, records := newCachedResolver(0, time.Hour)
+	for i := 0; i < 2; i++ {
+		if _, err := resolver.Resolve("short.example.org"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each resolution looks up the CNAME the name doesn't have before its DNSLINK
+	if records.lookups != 2 {
+		t.Fatalf("expected a cached lookup, got %v lookups", records.lookups)
+	}
+	// the record's ttl of a second overrides the default of an hour
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := resolver.Resolve("short.example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if records.lookups != 4 {
+		t.Fatalf("expected the expired name to be looked up again, got %v lookups", records.lookups)
+	}
+}
+
+func TestResolverCache_Evicts(t *testing.T) {
+	resolver, records := newCachedResolver(2, 0)
+	for _, name := range []string{"a.example.org", "b.example.org", "a.example.org", "c.example.org", "a.example.org"} {
+		if _, err := resolver.Resolve(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b was least recently used, so c evicted it while a stayed cached, with each
+	// name taking a lookup for each type of its own record, and two to match the
+	// wildcard's DNSLINK
+	if records.lookups != 27 || resolver.Cache.Len() != 2 {
+		t.Fatalf("unexpected lookups %v with %v cached", records.lookups, resolver.Cache.Len())
+	}
+}
+
+func TestResolverCache_Negative(t *testing.T) {
+	resolver, records := newCachedResolver(0, 0)
+	// without negative caching, names which don't exist are looked up every time,
+	// taking a lookup for each zone they may be within
+	for i := 0; i < 2; i++ {
+		if _, err := resolver.Resolve("www.missing.org"); !errors.Is(err, tns.ErrZoneNotFound) {
+			t.Fatalf("expected %v, got %v", tns.ErrZoneNotFound, err)
+		}
+	}
+	if records.lookups != 4 || resolver.Cache.Len() != 0 {
+		t.Fatalf("unexpected lookups %v with %v cached", records.lookups, resolver.Cache.Len())
+	}
+	resolver.Cache.EnableNegativeCaching(1, time.Hour)
+	records.lookups = 0
+	for i := 0; i < 3; i++ {
+		if _, err := resolver.Resolve("www.missing.org"); !errors.Is(err, tns.ErrZoneNotFound) {
+			t.Fatalf("expected %v, got %v", tns.ErrZoneNotFound, err)
+		}
+	}
+	if records.lookups != 2 {
+		t.Fatalf("expected the missing name to be looked up once, got %v lookups", records.lookups)
+	}
+	if hits := cacheMetric(t, resolver.Cache, "temporal_tns_resolver_cache_negative_hits_total"); hits != 2 {
+		t.Fatalf("expected 2 negative hits, got %v", hits)
+	}
+	// creating the zone invalidates names within it
+	records.fakeRecords["missing.org"] = map[string]*tns.Record{
+		"www": {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID},
+	}
+	resolver.Cache.InvalidateZone("missing.org")
+	if cid, err := resolver.Resolve("www.missing.org"); err != nil || cid != testResolveCID {
+		t.Fatalf("expected the created name to resolve, got %s, %v", cid, err)
+	}
+	// the negative cache is capped separately, so the missing name evicts nothing
+	// resolved, while evicting the previous missing name
+	delete(records.fakeRecords, "example.org")
+	for _, name := range []string{"a.example.org", "b.example.org"} {
+		if _, err := resolver.Resolve(name); !errors.Is(err, tns.ErrZoneNotFound) {
+			t.Fatalf("expected %v, got %v", tns.ErrZoneNotFound, err)
+		}
+	}
+	if resolver.Cache.Len() != 2 {
+		t.Fatalf("expected a resolved and a missing name to be cached, got %v", resolver.Cache.Len())
+	}
+	// creating a record invalidates the name
+	records.fakeRecords["example.org"] = map[string]*tns.Record{
+		"b": {Name: "b", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID},
+	}
+	resolver.Cache.Invalidate("b.example.org")
+	if cid, err := resolver.Resolve("b.example.org"); err != nil || cid != testResolveCID {
+		t.Fatalf("expected the created record to resolve, got %s, %v", cid, err)
+	}
+}
+
+func TestResolverCache_NegativeRace(t *testing.T) {
+	resolver, records := newCachedResolver(0, 0)
+	resolver.Cache.EnableNegativeCaching(0, time.Hour)
+	// the record is created, and the cache invalidated, after we found it missing
+	// but before its absence is cached
+	records.onLookup = func() {
+		records.onLookup = nil
+		records.fakeRecords["created.org"] = map[string]*tns.Record{
+			"www": {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID},
+		}
+		resolver.Cache.InvalidateZone("created.org")
+	}
+	if _, err := resolver.Resolve("www.created.org"); !errors.Is(err, tns.ErrZoneNotFound) {
+		t.Fatalf("expected %v, got %v", tns.ErrZoneNotFound, err)
+	}
+	if cid, err := resolver.Resolve("www.created.org"); err != nil || cid != testResolveCID {
+		t.Fatalf("expected the created name not to be cached as missing, got %s, %v", cid, err)
+	}
+}
+
+// cacheMetric is used to get the value of one of a cache's counters
+func cacheMetric(t *testing.T, cache *tns.ResolverCache, name string) float64 {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(cache); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("no metric named %s", name)
+	return 0
+}
+
+func TestResolverCache_MixedCase(t *testing.T) {
+	resolver, records := newCachedResolver(0, 0)
+	for _, name := range []string{"www.example.org", "WWW.Example.org", "www.EXAMPLE.org."} {
+		if _, err := resolver.Resolve(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if records.lookups != 2 {
+		t.Fatalf("expected names differing by case to share a cache entry, got %v lookups", records.lookups)
+	}
+	resolver.Cache.Invalidate("WWW.EXAMPLE.ORG")
+	if resolver.Cache.Len() != 0 {
+		t.Fatal("expected invalidation to be case insensitive")
+	}
+}
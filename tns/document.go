@@ -0,0 +1,116 @@
+package tns
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	ci "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ZoneDocumentVersion is the version of zone documents produced by this package.
+// It is bumped whenever the document format changes incompatibly
+const ZoneDocumentVersion = 1
+
+var (
+	// ErrUnsupportedVersion is returned when a zone document's version isn't one we understand
+	ErrUnsupportedVersion = errors.New("unsupported zone document version")
+	// ErrInvalidSignature is returned when a zone document isn't signed by its zone's key
+	ErrInvalidSignature = errors.New("invalid zone document signature")
+)
+
+// ZoneDocument is a portable export of a zone and all of its records, used to back
+// up and restore zones, or to migrate them between networks, much like a dns zone
+// transfer. Documents are signed with the zone's private key, so that they can be
+// verified by anyone knowing the zone's public key
+type ZoneDocument struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	// The zone, including its records
+	Zone Zone `json:"zone"`
+	// The names of the keys the zone is managed with, which must be present in the
+	// keystore of wherever the zone is imported
+	ManagerKeyName string `json:"manager_key_name"`
+	ZoneKeyName    string `json:"zone_key_name"`
+	// The key name of each record, by RecordKey
+	RecordKeyNames map[string]string `json:"record_key_names"`
+	// The ipfs hash of the latest version of each record, by RecordKey
+	RecordHashes map[string]string `json:"record_hashes"`
+	// The zone key's signature over the rest of the document
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// SigningBytes is used to get the bytes of the document which are signed, being
+// the document's json encoding without its signature
+func (d ZoneDocument) SigningBytes() ([]byte, error) {
+	d.Signature = nil
+	return json.Marshal(d)
+}
+
+// Sign is used to sign the document with the zone's private key
+func (d *ZoneDocument) Sign(zonePK ci.PrivKey) error {
+	data, err := d.SigningBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := zonePK.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign zone document: %w", err)
+	}
+	d.Signature = sig
+	return nil
+}
+
+// Verify is used to check that the document is of a version we support, and was
+// signed by the key the zone's public key identifies. ErrUnsupportedVersion or
+// ErrInvalidSignature is returned otherwise
+func (d *ZoneDocument) Verify() error {
+	if d.Version < 1 || d.Version > ZoneDocumentVersion {
+		return ErrUnsupportedVersion
+	}
+	if len(d.Signature) == 0 {
+		return ErrInvalidSignature
+	}
+	pub, err := d.Zone.Key()
+	if err != nil {
+		return err
+	}
+	data, err := d.SigningBytes()
+	if err != nil {
+		return err
+	}
+	if ok, err := pub.Verify(data, d.Signature); err != nil || !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Key is used to get the zone's public key from the id it's identified by, for
+// verifying documents and records signed by the zone
+func (z *Zone) Key() (ci.PubKey, error) {
+	id, err := peer.IDB58Decode(z.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zone public key: %w", err)
+	}
+	// only keys small enough to be inlined, such as ed25519 keys, can be extracted
+	pub, err := id.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract zone public key: %w", err)
+	}
+	if pub == nil {
+		return nil, errors.New("zone public key can't be extracted from its id")
+	}
+	return pub, nil
+}
+
+// VerifyRecord is used to check that a record was signed by the zone's key,
+// returning ErrRecordUnsigned or ErrInvalidRecordSignature if it wasn't
+func (z *Zone) VerifyRecord(record *Record) error {
+	pub, err := z.Key()
+	if err != nil {
+		return err
+	}
+	return record.Verify(pub)
+}
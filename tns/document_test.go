@@ -0,0 +1,78 @@
+package tns_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RTradeLtd/Temporal/tns"
+	ci "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func newZoneDocument(t *testing.T) (*tns.ZoneDocument, ci.PrivKey) {
+	zonePK, _, err := ci.GenerateKeyPair(ci.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(zonePK.GetPublic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tns.ZoneDocument{
+		Version:    tns.ZoneDocumentVersion,
+		ExportedAt: time.Now().UTC(),
+		Zone: tns.Zone{
+			Name:      "example.org",
+			PublicKey: id.Pretty(),
+			Records:   map[string]*tns.Record{"www": {Name: "www", PublicKey: "record"}},
+		},
+		ManagerKeyName: "manager",
+		ZoneKeyName:    "zone",
+		RecordKeyNames: map[string]string{"www": "record"},
+		RecordHashes:   map[string]string{"www": testResolveCID},
+	}, zonePK
+}
+
+func TestZoneDocument_Verify(t *testing.T) {
+	doc, zonePK := newZoneDocument(t)
+	if err := doc.Verify(); err != tns.ErrInvalidSignature {
+		t.Fatalf("expected unsigned document to be rejected, got %v", err)
+	}
+	if err := doc.Sign(zonePK); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	// tampering with any part of the document invalidates its signature
+	tampered := *doc
+	tampered.RecordHashes = map[string]string{"www": "QmTampered"}
+	if err := tampered.Verify(); err != tns.ErrInvalidSignature {
+		t.Fatalf("expected tampered document to be rejected, got %v", err)
+	}
+	// as does signing with a key other than the zone's
+	otherPK, _, err := ci.GenerateKeyPair(ci.Ed25519, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := *doc
+	if err = forged.Sign(otherPK); err != nil {
+		t.Fatal(err)
+	}
+	if err = forged.Verify(); err != tns.ErrInvalidSignature {
+		t.Fatalf("expected forged document to be rejected, got %v", err)
+	}
+}
+
+func TestZoneDocument_Version(t *testing.T) {
+	for _, version := range []int{0, tns.ZoneDocumentVersion + 1} {
+		doc, zonePK := newZoneDocument(t)
+		doc.Version = version
+		if err := doc.Sign(zonePK); err != nil {
+			t.Fatal(err)
+		}
+		if err := doc.Verify(); err != tns.ErrUnsupportedVersion {
+			t.Fatalf("expected version %v to be rejected, got %v", version, err)
+		}
+	}
+}
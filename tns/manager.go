@@ -87,7 +87,7 @@ func GenerateTNSManager(opts *ManagerOpts, db *gorm.DB) (*Manager, error) {
 	// open log file
 	logfile, err := os.OpenFile(opts.LogFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0640)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %s", err)
+		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
 	logger.Out = logfile
 	logger.Info("logger initialized")
@@ -165,7 +165,7 @@ func (m *Manager) HandleQuery(s net.Stream, cmd string) error {
 		}
 		// search for the record  in the database
 		// this is temporary, and will be expanded to allow the client to specify the source of information
-		r, err := m.RM.FindRecordByNameAndUser(req.UserName, req.RecordName)
+		r, err := m.RM.FindRecordByNameAndUser(req.UserName, RecordKey(req.RecordName, req.RecordType))
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,155 @@
+package tns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// RecordTypeA is a record resolving to an ipv4 address
+	RecordTypeA = "A"
+	// RecordTypeAAAA is a record resolving to an ipv6 address
+	RecordTypeAAAA = "AAAA"
+	// RecordTypeTXT is a record holding arbitrary text
+	RecordTypeTXT = "TXT"
+	// RecordTypeDNSLink is a record resolving to an ipfs path, as with dnslink
+	RecordTypeDNSLink = "DNSLINK"
+	// RecordTypeIPNS is a record resolving to the content an ipns name points to
+	RecordTypeIPNS = "IPNS"
+	// RecordTypeCNAME is a record aliasing another TNS name, which may be in another
+	// zone. As with dns, an alias can't share its name with any other record
+	RecordTypeCNAME = "CNAME"
+)
+
+// Wildcard is the label of wildcard records, which match any otherwise unmatched
+// name in their place. A record named * matches every name in its zone without
+// a record of its own, while *.sub matches those beneath sub
+const Wildcard = "*"
+
+// DefaultRecordTTL is the ttl given to records which don't specify one
+const DefaultRecordTTL = time.Hour
+
+var (
+	// RecordTypes are all the record types that TNS supports
+	RecordTypes = []string{RecordTypeA, RecordTypeAAAA, RecordTypeTXT, RecordTypeDNSLink, RecordTypeIPNS, RecordTypeCNAME}
+)
+
+// NormalizeName is used to get the canonical form of a TNS name, such as a zone or
+// record name, as like dns names they're case insensitive. Names are stored and
+// looked up in lowercase, without surrounding whitespace or a trailing dot, so that
+// WWW.Example.org and www.example.org are the same name.
+func NormalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+}
+
+// ValidateRecordName is used to check that a record name is made up of non-empty
+// labels, with the wildcard label only appearing as the leftmost label. Names can't
+// contain a slash, which separates the name from the type in a RecordKey
+func ValidateRecordName(name string) error {
+	if name == "" {
+		return errors.New("record name is empty")
+	}
+	for i, label := range strings.Split(name, ".") {
+		if label == "" {
+			return fmt.Errorf("record name %q contains an empty label", name)
+		}
+		if i > 0 && label == Wildcard {
+			return fmt.Errorf("record name %q may only have a wildcard as its leftmost label", name)
+		}
+		if label != Wildcard && strings.Contains(label, Wildcard) {
+			return fmt.Errorf("label %q can't contain a partial wildcard", label)
+		}
+		if strings.Contains(label, "/") {
+			return fmt.Errorf("label %q can't contain a slash", label)
+		}
+	}
+	return nil
+}
+
+// ValidateRecordType is used to check that a record type is supported. An empty
+// type is permitted, for records which only carry meta data
+func ValidateRecordType(recordType string) error {
+	if recordType == "" {
+		return nil
+	}
+	for _, t := range RecordTypes {
+		if recordType == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported record type %q, must be one of %v", recordType, RecordTypes)
+}
+
+// AllowsSets is used to check whether records of a type may form record sets, which
+// every type but CNAME does, as an alias can only point at one name
+func AllowsSets(recordType string) bool {
+	return recordType != "" && recordType != RecordTypeCNAME
+}
+
+// RecordKey is used to get the key a record is stored under within its zone, as a
+// name holds a record set of each type, so that www/A and www/TXT are both records
+// of www. Records without a type are keyed by their name alone
+func RecordKey(name, recordType string) string {
+	if recordType == "" {
+		return name
+	}
+	return name + "/" + recordType
+}
+
+// ParseRecordKey is used to get the name and type of a record from its RecordKey
+func ParseRecordKey(key string) (name, recordType string) {
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return key, ""
+}
+
+// CanShareName is used to check whether records of two different types may exist
+// under the same name, which they can unless either is a CNAME, as an alias can't
+// share its name with any other record
+func CanShareName(recordType, otherType string) bool {
+	return recordType != RecordTypeCNAME && otherType != RecordTypeCNAME
+}
+
+// AllValues is used to get the values of the record, which hold more than one value
+// when the record is a record set
+func (r *Record) AllValues() []string {
+	if len(r.Values) > 0 {
+		return r.Values
+	}
+	if r.Value == "" {
+		return nil
+	}
+	return []string{r.Value}
+}
+
+// AddToSet is used to add the value of record to the set of existing, a record of
+// the same name, returning the resulting record set. The new record's key, ttl and
+// meta data are used, and values already in the set aren't added again. An error is
+// returned when the records differ in type, or their type can't form sets
+func AddToSet(existing, record *Record) (*Record, error) {
+	if existing.Type != record.Type {
+		return nil, fmt.Errorf("record %s already has type %s", existing.Name, existing.Type)
+	}
+	if !AllowsSets(record.Type) {
+		return nil, fmt.Errorf("%s records can't form record sets", record.Type)
+	}
+	values := append([]string{}, existing.AllValues()...)
+	for _, value := range record.AllValues() {
+		present := false
+		for _, v := range values {
+			present = present || v == value
+		}
+		if !present {
+			values = append(values, value)
+		}
+	}
+	set := *record
+	set.Values = values
+	if len(values) > 0 {
+		set.Value = values[0]
+	}
+	return &set, nil
+}
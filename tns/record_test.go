@@ -0,0 +1,95 @@
+package tns_test
+
+import (
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/tns"
+)
+
+func TestValidateRecordName(t *testing.T) {
+	tests := []struct {
+		name       string
+		recordName string
+		wantErr    bool
+	}{
+		{"Simple", "www", false},
+		{"MultiLabel", "www.sub", false},
+		{"Wildcard", "*", false},
+		{"SubWildcard", "*.sub", false},
+		{"Empty", "", true},
+		{"EmptyLabel", "www..sub", true},
+		{"InnerWildcard", "www.*", true},
+		{"NestedWildcard", "*.*", true},
+		{"PartialWildcard", "w*w", true},
+		{"Slash", "www/A", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tns.ValidateRecordName(tt.recordName); (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateRecordName(%q) err = %v, wantErr %v", tt.recordName, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordKey(t *testing.T) {
+	for _, tt := range []struct{ name, recordType, key string }{
+		{"www", tns.RecordTypeA, "www/A"},
+		{"*.sub", tns.RecordTypeTXT, "*.sub/TXT"},
+		{"meta", "", "meta"},
+	} {
+		key := tns.RecordKey(tt.name, tt.recordType)
+		if key != tt.key {
+			t.Fatalf("RecordKey(%q, %q) = %q, want %q", tt.name, tt.recordType, key, tt.key)
+		}
+		if name, recordType := tns.ParseRecordKey(key); name != tt.name || recordType != tt.recordType {
+			t.Fatalf("ParseRecordKey(%q) = %q, %q", key, name, recordType)
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"www", "www"},
+		{"WWW", "www"},
+		{"Www.Example.ORG", "www.example.org"},
+		{"www.example.org.", "www.example.org"},
+		{" *.Sub ", "*.sub"},
+	}
+	for _, tt := range tests {
+		if got := tns.NormalizeName(tt.name); got != tt.want {
+			t.Errorf("NormalizeName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddToSet(t *testing.T) {
+	existing := &tns.Record{Name: "www", Type: tns.RecordTypeA, Value: "10.0.0.1", TTL: 60}
+	set, err := tns.AddToSet(existing, &tns.Record{Name: "www", Type: tns.RecordTypeA, Value: "10.0.0.2", TTL: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Values) != 2 || set.Value != "10.0.0.1" || set.Values[1] != "10.0.0.2" || set.TTL != 120 {
+		t.Fatalf("unexpected record set %+v", set)
+	}
+	// values already in the set aren't repeated
+	if set, err = tns.AddToSet(set, &tns.Record{Name: "www", Type: tns.RecordTypeA, Value: "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.AllValues()) != 2 {
+		t.Fatalf("expected a duplicate value to be ignored, got %v", set.AllValues())
+	}
+	if len(existing.AllValues()) != 1 {
+		t.Fatal("expected the existing record to be unchanged")
+	}
+	if _, err = tns.AddToSet(existing, &tns.Record{Name: "www", Type: tns.RecordTypeTXT, Value: "hello"}); err == nil {
+		t.Fatal("expected records of different types to be refused")
+	}
+	alias := &tns.Record{Name: "www", Type: tns.RecordTypeCNAME, Value: "a.example.org"}
+	if _, err = tns.AddToSet(alias, &tns.Record{Name: "www", Type: tns.RecordTypeCNAME, Value: "b.example.org"}); err == nil {
+		t.Fatal("expected aliases to be refused from forming sets")
+	}
+}
@@ -0,0 +1,329 @@
+package tns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrZoneNotFound is returned when no zone matches the name being resolved
+	ErrZoneNotFound = errors.New("zone not found")
+	// ErrRecordNotFound is returned when the zone has no record matching the name being resolved
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrNoTarget is returned when a record doesn't point at any content
+	ErrNoTarget = errors.New("record has no resolvable target")
+	// ErrResolutionLoop is returned when records point at each other in a loop
+	ErrResolutionLoop = errors.New("record resolution loop detected")
+)
+
+// defaultMaxDepth is the number of records followed before giving up, when unset
+const defaultMaxDepth = 8
+
+// RecordFinder is used to look up the records of a zone, whose names are given
+// normalized by NormalizeName. FindRecord returns the record set of the name with
+// the given type, which is empty for records without a type. It returns
+// ErrZoneNotFound when the zone doesn't exist, and ErrRecordNotFound when the
+// zone exists without the record, or errors wrapping them.
+type RecordFinder interface {
+	FindRecord(zoneName, recordName, recordType string) (*Record, error)
+}
+
+// targetTypes are the record types looked up when resolving a name to content, in
+// order of precedence. Aliases come first, as they can't share their name with other
+// records, while records which can't point at content come last, so that resolving
+// a name only holding them returns ErrNoTarget
+var targetTypes = []string{
+	RecordTypeCNAME, RecordTypeDNSLink, RecordTypeIPNS,
+	RecordTypeA, RecordTypeAAAA, RecordTypeTXT, "",
+}
+
+// IPNSResolver is used to resolve an ipns name to the path it points to
+type IPNSResolver interface {
+	Resolve(name string) (string, error)
+}
+
+// Resolver is used to resolve TNS names, such as www.example.org, to the ipfs
+// content they point to
+type Resolver struct {
+	Records RecordFinder
+	IPNS    IPNSResolver
+	// MaxDepth is the number of records followed before giving up, defaulting to 8
+	MaxDepth int
+	// Cache is optionally used to cache the names resolved by Resolve
+	Cache *ResolverCache
+}
+
+// NewResolver is used to create a resolver looking up records with records, and
+// resolving ipns names with ipns
+func NewResolver(records RecordFinder, ipns IPNSResolver) *Resolver {
+	return &Resolver{Records: records, IPNS: ipns}
+}
+
+// Resolve is used to resolve a name to the cid of the content it points to. The name
+// is split into a record name and a zone name, with the longest existing zone being
+// used, so that www.example.org resolves the www record of the example.org zone.
+// Names are case insensitive, and looked up in the form given by NormalizeName.
+// Names without a record of their own match the zone's wildcard records, such as *
+// or *.sub, with the most specific wildcard being used. As a name may hold records
+// of several types, CNAME records are used first, followed by DNSLINK and IPNS.
+// DNSLINK records pointing at /ipns/ paths, and IPNS records, are followed until we
+// reach an ipfs path, with CNAME records and others pointing at TNS names being
+// followed too, across zones if need be.
+// ErrZoneNotFound, ErrRecordNotFound, ErrNoTarget or ErrResolutionLoop is returned
+// when the name can't be resolved, possibly wrapped, so should be checked for with
+// errors.Is. Errors from the IPNSResolver are returned wrapped.
+// With a Cache, names are resolved from it while cached, with names which fail to
+// resolve only being cached when they don't exist and negative caching is enabled.
+func (r *Resolver) Resolve(name string) (string, error) {
+	if r.Cache == nil {
+		cid, _, _, err := r.resolve(name, nil)
+		return cid, err
+	}
+	key := cacheKey(name)
+	cid, err, gen, ok := r.Cache.get(key)
+	if ok {
+		return cid, err
+	}
+	cid, ttl, names, err := r.resolve(name, nil)
+	r.Cache.add(key, cid, err, ttl, names, gen)
+	return cid, err
+}
+
+// Resolution is the result of resolving a name with Lookup
+type Resolution struct {
+	// CID is the cid of the content the name points to
+	CID string `json:"cid"`
+	// Records are the records followed to reach the content, starting with the
+	// record of the name itself, each carrying its zone's signature
+	Records []*Record `json:"records"`
+}
+
+// Lookup is used to resolve a name as Resolve does, along with the records followed
+// to resolve it, so that clients can verify each record with its zone's key, such
+// as with Zone.VerifyRecord. Lookups are never cached, returning the same errors as
+// Resolve.
+func (r *Resolver) Lookup(name string) (*Resolution, error) {
+	var records []*Record
+	cid, _, _, err := r.resolve(name, &records)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolution{CID: cid, Records: records}, nil
+}
+
+// resolve is used to resolve a name to a cid, along with the smallest ttl of the
+// records followed, which is 0 if none have one, and the names followed, which are
+// also returned when a name followed doesn't exist. When records isn't nil, each
+// record followed is appended to it
+func (r *Resolver) resolve(name string, records *[]*Record) (string, time.Duration, []string, error) {
+	maxDepth := r.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDepth
+	}
+	var (
+		ttl   time.Duration
+		names []string
+	)
+	visited := make(map[string]bool)
+	for {
+		name = NormalizeName(name)
+		if visited[name] || len(visited) >= maxDepth {
+			return "", 0, nil, ErrResolutionLoop
+		}
+		visited[name] = true
+		names = append(names, cacheKey(name))
+		record, err := r.findRecord(name, targetTypes)
+		if err != nil {
+			// names which don't exist are invalidated through the names followed
+			return "", 0, names, err
+		}
+		if records != nil {
+			*records = append(*records, record)
+		}
+		if recordTTL := time.Duration(record.TTL) * time.Second; recordTTL > 0 && (ttl == 0 || recordTTL < ttl) {
+			ttl = recordTTL
+		}
+		path, err := r.target(record)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		switch {
+		case strings.HasPrefix(path, "/ipfs/"):
+			cid := strings.SplitN(strings.TrimPrefix(path, "/ipfs/"), "/", 2)[0]
+			if cid == "" {
+				return "", 0, nil, ErrNoTarget
+			}
+			return cid, ttl, names, nil
+		case strings.HasPrefix(path, "/tns/"):
+			// continue with the TNS name the record points at
+			name = strings.TrimPrefix(path, "/tns/")
+		default:
+			return "", 0, nil, ErrNoTarget
+		}
+	}
+}
+
+// ResolveValues is used to resolve a name to every value of its record set of the
+// given type, following aliases as Resolve does unless CNAME records are asked for.
+// The values of DNSLINK and IPNS records are resolved to the cids of the content they
+// point to, while the values of A, AAAA, TXT and CNAME records are returned as they
+// are, such as every address of a name for round robin. The same errors are
+// returned as by Resolve.
+func (r *Resolver) ResolveValues(name, recordType string) ([]string, error) {
+	types := []string{RecordTypeCNAME}
+	if recordType != RecordTypeCNAME {
+		types = append(types, recordType)
+	}
+	maxDepth := r.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxDepth
+	}
+	visited := make(map[string]bool)
+	for {
+		name = NormalizeName(name)
+		if visited[name] || len(visited) >= maxDepth {
+			return nil, ErrResolutionLoop
+		}
+		visited[name] = true
+		record, err := r.findRecord(name, types)
+		if err != nil {
+			return nil, err
+		}
+		values := record.AllValues()
+		if len(values) == 0 {
+			return nil, ErrNoTarget
+		}
+		switch {
+		case record.Type == RecordTypeCNAME && recordType != RecordTypeCNAME:
+			name = strings.TrimSuffix(strings.TrimSpace(record.Value), ".")
+			continue
+		case record.Type == RecordTypeA, record.Type == RecordTypeAAAA,
+			record.Type == RecordTypeTXT, record.Type == RecordTypeCNAME:
+			return values, nil
+		}
+		cids := make([]string, 0, len(values))
+		for _, value := range values {
+			member := *record
+			member.Value, member.Values = value, nil
+			cid, err := r.resolveMember(&member)
+			if err != nil {
+				return nil, err
+			}
+			cids = append(cids, cid)
+		}
+		return cids, nil
+	}
+}
+
+// resolveMember is used to resolve a single member of a record set to a cid,
+// following it with Resolve should it point at another TNS name
+func (r *Resolver) resolveMember(record *Record) (string, error) {
+	path, err := r.target(record)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case strings.HasPrefix(path, "/ipfs/"):
+		cid := strings.SplitN(strings.TrimPrefix(path, "/ipfs/"), "/", 2)[0]
+		if cid == "" {
+			return "", ErrNoTarget
+		}
+		return cid, nil
+	case strings.HasPrefix(path, "/tns/"):
+		return r.Resolve(strings.TrimPrefix(path, "/tns/"))
+	}
+	return "", ErrNoTarget
+}
+
+// findRecord is used to find the record for a name with the first of types it
+// holds, trying the longest zone first. Within a zone an exact match takes
+// precedence, followed by wildcard records from the most to least specific, so that
+// a.b.example.org matches *.b before *
+func (r *Resolver) findRecord(name string, types []string) (*Record, error) {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i := 1; i < len(labels); i++ {
+		zoneName := strings.Join(labels[i:], ".")
+		record, err := r.findTyped(zoneName, strings.Join(labels[:i], "."), types)
+		switch {
+		case err == nil:
+			return record, nil
+		case errors.Is(err, ErrZoneNotFound):
+			continue
+		case errors.Is(err, ErrRecordNotFound):
+			return r.findWildcard(zoneName, labels[:i], types)
+		default:
+			return nil, err
+		}
+	}
+	return nil, ErrZoneNotFound
+}
+
+// findWildcard is used to find the most specific wildcard record of a zone
+// matching a record name, given as its labels
+func (r *Resolver) findWildcard(zoneName string, labels []string, types []string) (*Record, error) {
+	for i := 1; i <= len(labels); i++ {
+		record, err := r.findTyped(zoneName, strings.Join(append([]string{Wildcard}, labels[i:]...), "."), types)
+		if !errors.Is(err, ErrRecordNotFound) {
+			return record, err
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// findTyped is used to find the record of a name with the first of types it holds
+func (r *Resolver) findTyped(zoneName, recordName string, types []string) (*Record, error) {
+	for _, recordType := range types {
+		record, err := r.Records.FindRecord(zoneName, recordName, recordType)
+		if !errors.Is(err, ErrRecordNotFound) {
+			return record, err
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// target is used to get the path a record points at, resolving ipns names. TNS
+// names are given as /tns/ paths, so that they can be followed
+func (r *Resolver) target(record *Record) (string, error) {
+	value := strings.TrimSpace(record.Value)
+	if value == "" {
+		return "", ErrNoTarget
+	}
+	switch record.Type {
+	case RecordTypeDNSLink:
+		// dnslink values may be given with their dnslink= prefix
+		value = strings.TrimPrefix(value, "dnslink=")
+		if !strings.HasPrefix(value, "/ipns/") {
+			return value, nil
+		}
+		return r.resolveIPNS(strings.TrimPrefix(value, "/ipns/"))
+	case RecordTypeIPNS:
+		return r.resolveIPNS(strings.TrimPrefix(value, "/ipns/"))
+	case RecordTypeCNAME:
+		return "/tns/" + strings.TrimSuffix(value, "."), nil
+	default:
+		return "", ErrNoTarget
+	}
+}
+
+// resolveIPNS is used to resolve an ipns name. As with dnslink, names containing
+// a dot are treated as domain names, which we resolve as TNS names
+func (r *Resolver) resolveIPNS(name string) (string, error) {
+	name = strings.SplitN(name, "/", 2)[0]
+	if strings.Contains(name, ".") {
+		return "/tns/" + name, nil
+	}
+	if r.IPNS == nil {
+		return "", errors.New("no ipns resolver configured")
+	}
+	path, err := r.IPNS.Resolve(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve ipns name %s: %w", name, err)
+	}
+	if !strings.HasPrefix(path, "/") {
+		// resolvers may return bare cids
+		path = "/ipfs/" + path
+	}
+	return path, nil
+}
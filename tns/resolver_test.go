@@ -0,0 +1,277 @@
+package tns_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/tns"
+)
+
+const testResolveCID = "QmNZiPk974vDsPmQii3YbrMKfi12KTSNM7XMiYyiea4VYZ"
+
+// fakeRecords is an in memory RecordFinder, keyed by zone then by record name, or by
+// tns.RecordKey where a name holds records of several types
+type fakeRecords map[string]map[string]*tns.Record
+
+func (f fakeRecords) FindRecord(zoneName, recordName, recordType string) (*tns.Record, error) {
+	zone, ok := f[zoneName]
+	if !ok {
+		return nil, tns.ErrZoneNotFound
+	}
+	if record, ok := zone[tns.RecordKey(recordName, recordType)]; ok {
+		return record, nil
+	}
+	if record, ok := zone[recordName]; ok && record.Type == recordType {
+		return record, nil
+	}
+	return nil, tns.ErrRecordNotFound
+}
+
+// fakeIPNS is an in memory IPNSResolver
+type fakeIPNS map[string]string
+
+func (f fakeIPNS) Resolve(name string) (string, error) {
+	path, ok := f[name]
+	if !ok {
+		return "", errors.New("ipns name not found")
+	}
+	return path, nil
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	records := fakeRecords{
+		"example.org": {
+			"www":      {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID},
+			"prefixed": {Name: "prefixed", Type: tns.RecordTypeDNSLink, Value: "dnslink=/ipfs/" + testResolveCID + "/index.html"},
+			"ipns":     {Name: "ipns", Type: tns.RecordTypeIPNS, Value: "QmKey"},
+			"linked":   {Name: "linked", Type: tns.RecordTypeDNSLink, Value: "/ipns/QmKey"},
+			"alias":    {Name: "alias", Type: tns.RecordTypeDNSLink, Value: "/ipns/www.example.org"},
+			"self":     {Name: "self", Type: tns.RecordTypeDNSLink, Value: "/ipns/self.example.org"},
+			"ping":     {Name: "ping", Type: tns.RecordTypeDNSLink, Value: "/ipns/pong.example.org"},
+			"pong":     {Name: "pong", Type: tns.RecordTypeDNSLink, Value: "/ipns/ping.example.org"},
+			"text":     {Name: "text", Type: tns.RecordTypeTXT, Value: "hello"},
+			"empty":    {Name: "empty", Type: tns.RecordTypeDNSLink},
+			"dangling": {Name: "dangling", Type: tns.RecordTypeIPNS, Value: "QmMissing"},
+		},
+		"sub.example.org": {
+			"www": {Name: "www", Type: tns.RecordTypeIPNS, Value: "/ipns/QmKey"},
+		},
+		"wild.org": {
+			"*":       {Name: "*", Type: tns.RecordTypeDNSLink, Value: "/ipfs/QmAny"},
+			"*.sub":   {Name: "*.sub", Type: tns.RecordTypeDNSLink, Value: "/ipfs/QmSub"},
+			"www.sub": {Name: "www.sub", Type: tns.RecordTypeDNSLink, Value: "/ipfs/QmExact"},
+			"www":     {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID},
+		},
+		"alias.org": {
+			"www":    {Name: "www", Type: tns.RecordTypeCNAME, Value: "www.example.org."},
+			"chain":  {Name: "chain", Type: tns.RecordTypeCNAME, Value: "www.alias.org"},
+			"a":      {Name: "a", Type: tns.RecordTypeCNAME, Value: "b.alias.org"},
+			"b":      {Name: "b", Type: tns.RecordTypeCNAME, Value: "a.alias.org"},
+			"self":   {Name: "self", Type: tns.RecordTypeCNAME, Value: "self.alias.org"},
+			"broken": {Name: "broken", Type: tns.RecordTypeCNAME, Value: "missing.example.org"},
+		},
+	}
+	resolver := tns.NewResolver(records, fakeIPNS{"QmKey": "/ipfs/" + testResolveCID})
+	tests := []struct {
+		name    string
+		resolve string
+		want    string
+		wantErr error
+	}{
+		{"DNSLink", "www.example.org", testResolveCID, nil},
+		{"DNSLinkPrefixed", "prefixed.example.org", testResolveCID, nil},
+		{"IPNS", "ipns.example.org", testResolveCID, nil},
+		{"DNSLinkToIPNS", "linked.example.org", testResolveCID, nil},
+		{"TrailingDot", "www.example.org.", testResolveCID, nil},
+		{"LongestZone", "www.sub.example.org", testResolveCID, nil},
+		{"FollowsTNSNames", "alias.example.org", testResolveCID, nil},
+		{"NoZone", "www.example.com", "", tns.ErrZoneNotFound},
+		{"NoRecord", "missing.example.org", "", tns.ErrRecordNotFound},
+		{"SelfLoop", "self.example.org", "", tns.ErrResolutionLoop},
+		{"Loop", "ping.example.org", "", tns.ErrResolutionLoop},
+		{"Text", "text.example.org", "", tns.ErrNoTarget},
+		{"NoValue", "empty.example.org", "", tns.ErrNoTarget},
+		{"Alias", "www.alias.org", testResolveCID, nil},
+		{"AliasChain", "chain.alias.org", testResolveCID, nil},
+		{"AliasLoop", "a.alias.org", "", tns.ErrResolutionLoop},
+		{"AliasSelfLoop", "self.alias.org", "", tns.ErrResolutionLoop},
+		{"AliasToMissingRecord", "broken.alias.org", "", tns.ErrRecordNotFound},
+		{"ExactBeatsWildcard", "www.wild.org", testResolveCID, nil},
+		{"Wildcard", "anything.wild.org", "QmAny", nil},
+		{"WildcardMultiLevel", "a.b.wild.org", "QmAny", nil},
+		{"SubWildcard", "api.sub.wild.org", "QmSub", nil},
+		{"SubWildcardMultiLevel", "a.b.sub.wild.org", "QmSub", nil},
+		{"ExactBeatsSubWildcard", "www.sub.wild.org", "QmExact", nil},
+		{"SubWildcardNeedsLabel", "sub.wild.org", "QmAny", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.Resolve(tt.resolve)
+			if err != tt.wantErr {
+				t.Fatalf("Resolve() err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("Resolve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := resolver.Resolve("dangling.example.org"); err == nil {
+		t.Fatal("expected error resolving unknown ipns name")
+	}
+}
+
+func TestResolver_ResolveValues(t *testing.T) {
+	records := fakeRecords{
+		"example.org": {
+			"www":   {Name: "www", Type: tns.RecordTypeA, Value: "10.0.0.1", Values: []string{"10.0.0.1", "10.0.0.2"}},
+			"txt":   {Name: "txt", Type: tns.RecordTypeTXT, Value: "v=spf1"},
+			"multi": {Name: "multi", Type: tns.RecordTypeDNSLink, Values: []string{"/ipfs/" + testResolveCID, "/ipns/QmKey", "/ipns/txt.example.org"}},
+			"alias": {Name: "alias", Type: tns.RecordTypeCNAME, Value: "www.example.org"},
+			"empty": {Name: "empty", Type: tns.RecordTypeA},
+		},
+	}
+	resolver := tns.NewResolver(records, fakeIPNS{"QmKey": "/ipfs/QmOther"})
+	tests := []struct {
+		name       string
+		resolve    string
+		recordType string
+		want       []string
+		wantErr    error
+	}{
+		{"Addresses", "www.example.org", tns.RecordTypeA, []string{"10.0.0.1", "10.0.0.2"}, nil},
+		{"SingleValue", "txt.example.org", tns.RecordTypeTXT, []string{"v=spf1"}, nil},
+		{"Alias", "alias.example.org", tns.RecordTypeA, []string{"10.0.0.1", "10.0.0.2"}, nil},
+		{"AliasItself", "alias.example.org", tns.RecordTypeCNAME, []string{"www.example.org"}, nil},
+		{"OtherType", "www.example.org", tns.RecordTypeTXT, nil, tns.ErrRecordNotFound},
+		{"Empty", "empty.example.org", tns.RecordTypeA, nil, tns.ErrNoTarget},
+		{"MemberWithoutContent", "multi.example.org", tns.RecordTypeDNSLink, nil, tns.ErrNoTarget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.ResolveValues(tt.resolve, tt.recordType)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ResolveValues(%q) err = %v, want %v", tt.resolve, err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("ResolveValues(%q) = %v, want %v", tt.resolve, got, tt.want)
+			}
+		})
+	}
+	// content record sets resolve each of their members
+	records["example.org"]["multi"].Values = []string{"/ipfs/" + testResolveCID, "/ipns/QmKey"}
+	got, err := resolver.ResolveValues("multi.example.org", tns.RecordTypeDNSLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != testResolveCID+",QmOther" {
+		t.Fatalf("unexpected cids %v", got)
+	}
+}
+
+func TestResolver_TypesSharingName(t *testing.T) {
+	records := fakeRecords{
+		"example.org": {
+			"www/A":       {Name: "www", Type: tns.RecordTypeA, Values: []string{"10.0.0.1", "10.0.0.2"}},
+			"www/TXT":     {Name: "www", Type: tns.RecordTypeTXT, Value: "v=spf1"},
+			"www/DNSLINK": {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID},
+			"mail/A":      {Name: "mail", Type: tns.RecordTypeA, Value: "10.0.0.3"},
+			"mail/TXT":    {Name: "mail", Type: tns.RecordTypeTXT, Value: "hello"},
+		},
+	}
+	resolver := tns.NewResolver(records, nil)
+	// each type of a name is its own record set
+	for recordType, want := range map[string]string{
+		tns.RecordTypeA:   "10.0.0.1,10.0.0.2",
+		tns.RecordTypeTXT: "v=spf1",
+	} {
+		got, err := resolver.ResolveValues("www.example.org", recordType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, ",") != want {
+			t.Fatalf("unexpected %s values %v", recordType, got)
+		}
+	}
+	// content is resolved through the type pointing at it
+	if cid, err := resolver.Resolve("www.example.org"); err != nil || cid != testResolveCID {
+		t.Fatalf("expected www to resolve through its DNSLINK record, got %s, %v", cid, err)
+	}
+	if _, err := resolver.Resolve("mail.example.org"); !errors.Is(err, tns.ErrNoTarget) {
+		t.Fatalf("expected %v, got %v", tns.ErrNoTarget, err)
+	}
+}
+
+func TestResolver_MixedCase(t *testing.T) {
+	records := fakeRecords{}
+	// create stores records as the record creation consumer does, by their
+	// normalized name while keeping the name as given for display
+	create := func(zoneName, recordName string, record tns.Record) {
+		zoneName, record.Name = tns.NormalizeName(zoneName), tns.NormalizeName(recordName)
+		if record.Name != recordName {
+			record.DisplayName = recordName
+		}
+		if records[zoneName] == nil {
+			records[zoneName] = map[string]*tns.Record{}
+		}
+		records[zoneName][record.Name] = &record
+	}
+	create("Example.ORG", "WWW", tns.Record{Type: tns.RecordTypeDNSLink, Value: "/ipfs/QmOld"})
+	create("example.org", "Blog", tns.Record{Type: tns.RecordTypeCNAME, Value: "WWW.Example.org."})
+	// records differing only by case are the same record
+	create("EXAMPLE.org", "www", tns.Record{Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID})
+	if n := len(records["example.org"]); n != 2 {
+		t.Fatalf("expected records differing by case not to be duplicated, got %v records", n)
+	}
+	resolver := tns.NewResolver(records, nil)
+	for _, name := range []string{"www.example.org", "WWW.EXAMPLE.ORG.", "Www.Example.Org", "BLOG.example.org"} {
+		cid, err := resolver.Resolve(name)
+		if err != nil {
+			t.Fatalf("failed to resolve %s: %v", name, err)
+		}
+		if cid != testResolveCID {
+			t.Fatalf("unexpected cid %s for %s", cid, name)
+		}
+	}
+	values, err := resolver.ResolveValues("Blog.Example.Org", tns.RecordTypeDNSLink)
+	if err != nil || len(values) != 1 || values[0] != testResolveCID {
+		t.Fatalf("unexpected values %v, %v", values, err)
+	}
+	if display := records["example.org"]["blog"].DisplayName; display != "Blog" {
+		t.Fatalf("expected the name as given to be kept for display, got %q", display)
+	}
+}
+
+func TestResolver_Lookup(t *testing.T) {
+	key := testSigningKey(t)
+	records := fakeRecords{
+		"example.org": {
+			"www": {Name: "www", Type: tns.RecordTypeDNSLink, Value: "/ipfs/" + testResolveCID},
+		},
+		"alias.org": {
+			"www": {Name: "www", Type: tns.RecordTypeCNAME, Value: "www.example.org"},
+		},
+	}
+	for _, zone := range records {
+		for _, record := range zone {
+			if err := record.Sign(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	resolution, err := tns.NewResolver(records, nil).Lookup("WWW.alias.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution.CID != testResolveCID || len(resolution.Records) != 2 {
+		t.Fatalf("unexpected resolution %+v", resolution)
+	}
+	for _, record := range resolution.Records {
+		if err = record.Verify(key.Public()); err != nil {
+			t.Fatalf("failed to verify record %s: %v", record.Name, err)
+		}
+	}
+	if _, err = tns.NewResolver(records, nil).Lookup("missing.example.org"); !errors.Is(err, tns.ErrRecordNotFound) {
+		t.Fatalf("expected ErrRecordNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package tns
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// RecordSignatureVersion is the version of the serialization records are signed in.
+// It is bumped whenever the serialization changes, so that signatures made with an
+// older serialization are refused rather than verified against the wrong bytes
+const RecordSignatureVersion = 1
+
+var (
+	// ErrRecordUnsigned is returned when verifying a record which has no signature
+	ErrRecordUnsigned = errors.New("record is not signed")
+	// ErrInvalidRecordSignature is returned when a record's signature doesn't match
+	// the record or the key it's verified with
+	ErrInvalidRecordSignature = errors.New("invalid record signature")
+)
+
+// Signer is used to sign records. A zone's libp2p private key (ci.PrivKey) is a
+// Signer, as is an ed25519 key parsed with ParsePEMPrivateKey
+type Signer interface {
+	Sign(data []byte) ([]byte, error)
+}
+
+// Verifier is used to verify the signatures of records. A zone's libp2p public
+// key (ci.PubKey) is a Verifier, as is an ed25519 key parsed with ParsePEMPublicKey
+type Verifier interface {
+	Verify(data, sig []byte) (bool, error)
+}
+
+// signedRecord is the canonical form of a record which is signed. Its fields are
+// marshaled in a fixed order, with empty fields omitted so that records which only
+// differ by nil and empty values, such as after a round trip through ipfs, have
+// the same form, and with map keys sorted by encoding/json
+type signedRecord struct {
+	Version     int                    `json:"version"`
+	PublicKey   string                 `json:"public_key"`
+	Name        string                 `json:"name"`
+	DisplayName string                 `json:"display_name,omitempty"`
+	Type        string                 `json:"type,omitempty"`
+	Value       string                 `json:"value,omitempty"`
+	Values      []string               `json:"values,omitempty"`
+	TTL         int64                  `json:"ttl,omitempty"`
+	MetaData    map[string]interface{} `json:"meta_data,omitempty"`
+}
+
+// SigningBytes is used to get the canonical serialization of the record which is
+// signed, covering every field but its signature, with its name normalized
+func (r *Record) SigningBytes() ([]byte, error) {
+	return json.Marshal(signedRecord{
+		Version:     RecordSignatureVersion,
+		PublicKey:   r.PublicKey,
+		Name:        NormalizeName(r.Name),
+		DisplayName: r.DisplayName,
+		Type:        r.Type,
+		Value:       r.Value,
+		Values:      r.Values,
+		TTL:         r.TTL,
+		MetaData:    r.MetaData,
+	})
+}
+
+// Sign is used to sign the record with its zone's key, which must be done after
+// any change to the record
+func (r *Record) Sign(key Signer) error {
+	data, err := r.SigningBytes()
+	if err != nil {
+		return err
+	}
+	sig, err := key.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign record: %w", err)
+	}
+	r.Signature = sig
+	return nil
+}
+
+// Verify is used to check that the record was signed by the given key, being its
+// zone's public key, returning ErrRecordUnsigned or ErrInvalidRecordSignature if
+// it wasn't
+func (r *Record) Verify(key Verifier) error {
+	if len(r.Signature) == 0 {
+		return ErrRecordUnsigned
+	}
+	data, err := r.SigningBytes()
+	if err != nil {
+		return err
+	}
+	if ok, err := key.Verify(data, r.Signature); err != nil || !ok {
+		return ErrInvalidRecordSignature
+	}
+	return nil
+}
+
+// Ed25519PrivateKey is an ed25519 private key used to sign records
+type Ed25519PrivateKey ed25519.PrivateKey
+
+// Sign is used to sign data with the key
+func (k Ed25519PrivateKey) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(k), data), nil
+}
+
+// Public is used to get the key's public key
+func (k Ed25519PrivateKey) Public() Ed25519PublicKey {
+	return Ed25519PublicKey(ed25519.PrivateKey(k).Public().(ed25519.PublicKey))
+}
+
+// Ed25519PublicKey is an ed25519 public key used to verify the signatures of records
+type Ed25519PublicKey ed25519.PublicKey
+
+// Verify is used to check that sig is the key's signature of data
+func (k Ed25519PublicKey) Verify(data, sig []byte) (bool, error) {
+	return ed25519.Verify(ed25519.PublicKey(k), data, sig), nil
+}
+
+// MarshalPEM is used to encode the key as a PEM encoded PKIX public key, as read by
+// ParsePEMPublicKey, for distributing to clients verifying records
+func (k Ed25519PublicKey) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(ed25519.PublicKey(k))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePEMPrivateKey is used to parse a PEM encoded PKCS #8 private key, such as
+// generated by openssl genpkey -algorithm ed25519, for signing records. Only
+// ed25519 keys are supported
+func ParsePEMPrivateKey(data []byte) (Ed25519PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T, must be ed25519", key)
+	}
+	return Ed25519PrivateKey(edKey), nil
+}
+
+// ParsePEMPublicKey is used to parse a PEM encoded PKIX public key, for verifying
+// the signatures of records. Only ed25519 keys are supported
+func ParsePEMPublicKey(data []byte) (Ed25519PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T, must be ed25519", key)
+	}
+	return Ed25519PublicKey(edKey), nil
+}
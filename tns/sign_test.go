@@ -0,0 +1,97 @@
+package tns_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/RTradeLtd/Temporal/tns"
+)
+
+func testSigningKey(t *testing.T) tns.Ed25519PrivateKey {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tns.Ed25519PrivateKey(priv)
+}
+
+func TestRecord_Sign(t *testing.T) {
+	key := testSigningKey(t)
+	record := &tns.Record{
+		PublicKey: "QmRecord",
+		Name:      "www",
+		Type:      tns.RecordTypeDNSLink,
+		Value:     "/ipfs/" + testResolveCID,
+		TTL:       3600,
+		MetaData:  map[string]interface{}{"b": 1, "a": "x"},
+	}
+	if err := record.Verify(key.Public()); !errors.Is(err, tns.ErrRecordUnsigned) {
+		t.Fatalf("expected ErrRecordUnsigned, got %v", err)
+	}
+	if err := record.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := record.Verify(key.Public()); err != nil {
+		t.Fatal(err)
+	}
+	// the signature survives a round trip, which turns empty values nil
+	marshaled, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded tns.Record
+	if err = json.Unmarshal(marshaled, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	decoded.Values = []string{}
+	if err = decoded.Verify(key.Public()); err != nil {
+		t.Fatalf("expected the decoded record to verify, got %v", err)
+	}
+	// changing the record invalidates its signature
+	decoded.Value = "/ipfs/QmOther"
+	if err = decoded.Verify(key.Public()); !errors.Is(err, tns.ErrInvalidRecordSignature) {
+		t.Fatalf("expected ErrInvalidRecordSignature, got %v", err)
+	}
+	// as does verifying with another key
+	if err = record.Verify(testSigningKey(t).Public()); !errors.Is(err, tns.ErrInvalidRecordSignature) {
+		t.Fatalf("expected ErrInvalidRecordSignature, got %v", err)
+	}
+}
+
+func TestParsePEMKeys(t *testing.T) {
+	key := testSigningKey(t)
+	der, err := x509.MarshalPKCS8PrivateKey(ed25519.PrivateKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := tns.ParsePEMPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM, err := key.Public().MarshalPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := tns.ParsePEMPublicKey(pubPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &tns.Record{Name: "www", Type: tns.RecordTypeTXT, Value: "hello"}
+	if err = record.Sign(priv); err != nil {
+		t.Fatal(err)
+	}
+	if err = record.Verify(pub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tns.ParsePEMPrivateKey(pubPEM); err == nil {
+		t.Fatal("expected a public key to be refused as a private key")
+	}
+	if _, err = tns.ParsePEMPublicKey([]byte("not pem")); err == nil {
+		t.Fatal("expected invalid PEM to be refused")
+	}
+}
@@ -26,6 +26,9 @@ var (
 type RecordRequest struct {
 	RecordName string `json:"record_name"`
 	UserName   string `json:"user_name"`
+	// RecordType is the type of the record set requested, as a name holds a
+	// record set of each type, and is empty for records without a type
+	RecordType string `json:"record_type,omitempty"`
 }
 
 // ZoneRequest is a message sent when requesting a reccord from TNS.
@@ -41,7 +44,7 @@ type Zone struct {
 	PublicKey string       `json:"zone_public_key"`
 	// A human readable name for this zone
 	Name string `json:"name"`
-	// A map of records managed by this zone
+	// A map of records managed by this zone, keyed by RecordKey
 	Records                 map[string]*Record `json:"records"`
 	RecordNamesToPublicKeys map[string]string  `json:"record_names_to_public_keys"`
 }
@@ -49,10 +52,24 @@ type Zone struct {
 // Record is a particular name entry managed by a zone
 type Record struct {
 	PublicKey string `json:"public_key"`
-	// A human readable name for this record
+	// A human readable name for this record, normalized by NormalizeName
 	Name string `json:"name"`
+	// DisplayName is the name as it was given when the record was created, when
+	// it differs from Name by case
+	DisplayName string `json:"display_name,omitempty"`
+	// The type of this record, such as A or DNSLINK
+	Type string `json:"type,omitempty"`
+	// The value of this record, whose meaning depends on its type
+	Value string `json:"value,omitempty"`
+	// Values holds every value of a record set, being several records of the same
+	// name and type such as A records for round robin, with Value being the first
+	Values []string `json:"values,omitempty"`
+	// How long, in seconds, this record may be cached for by resolvers
+	TTL int64 `json:"ttl,omitempty"`
 	// User configurable meta data for this record
 	MetaData map[string]interface{} `json:"meta_data"`
+	// The zone key's signature over the rest of the record, see Record.Sign
+	Signature []byte `json:"signature,omitempty"`
 }
 
 // ZoneManager is the authorized manager of a zone